@@ -0,0 +1,132 @@
+// Package antispam protects the public submission endpoint: token bucket rate
+// limits per client address and per event, a honeypot field, and a
+// self-hosted proof of work (or arithmetic question without JavaScript)
+// instead of a third-party captcha.
+package antispam
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"event-messenger.com/config"
+)
+
+// Rejection reasons, counted in the spam_rejections metric
+const (
+	ReasonRateLimitIP      = "rate_limit_ip"
+	ReasonRateLimitEvent   = "rate_limit_event"
+	ReasonRateLimitUpload  = "rate_limit_upload"
+	ReasonRateLimitAnswer  = "rate_limit_answer"
+	ReasonHoneypot         = "honeypot"
+	ReasonTooFast          = "too_fast"
+	ReasonChallengeMissing = "challenge_missing"
+	ReasonChallengeInvalid = "challenge_invalid"
+	ReasonChallengeExpired = "challenge_expired"
+	ReasonChallengeWrong   = "challenge_wrong"
+	ReasonChallengeReused  = "challenge_reused"
+)
+
+// Form fields rendered by the submission form
+const (
+	HoneypotField = "website" // hidden from people, so only bots fill it in
+	TokenField    = "challenge"
+	NonceField    = "pow_nonce"
+	AnswerField   = "challenge_answer"
+)
+
+// Metrics published on /debug/vars
+var rejections = expvar.NewMap("spam_rejections")
+
+var (
+	ipLimiter     = NewLimiter(5, 30)
+	eventLimiter  = NewLimiter(30, 600)
+	uploadLimiter = NewLimiter(10, 60)
+	answerLimiter = NewLimiter(2, 6)
+)
+
+// Messages shown when CheckForm refuses a submission
+const (
+	msgExpired  = "This form has expired, please reload the page and try again"
+	msgTooFast  = "That was quick! Please wait a few seconds and send your message again"
+	msgRejected = "We couldn't verify your submission, please reload the page and try again"
+	msgAnswers  = "Too many messages have been sent from your network without JavaScript, please turn it on or try again later"
+)
+
+// Configure sets the limits and proof of work difficulty from the app config
+func Configure(cfg config.SpamConfig) {
+	trustedProxies = parseTrustedProxies(cfg.TrustedProxies)
+	ipLimiter = NewLimiter(cfg.SubmitIPBurst, cfg.SubmitIPPerHour)
+	eventLimiter = NewLimiter(cfg.SubmitEventBurst, cfg.SubmitEventPerHour)
+	// Each submission can bring a photo and a recording
+	uploadLimiter = NewLimiter(2*cfg.SubmitIPBurst, 2*cfg.SubmitIPPerHour)
+	// The arithmetic question is much easier for bots than the proof of
+	// work, so answering it is only for the few people without JavaScript
+	answerLimiter = NewLimiter(cfg.SubmitIPBurst/2, cfg.SubmitIPPerHour/5)
+	difficulty = min(max(cfg.PowDifficulty, 0), maxDifficulty)
+}
+
+// CheckRate spends a token from the client's and the event's buckets. When
+// either is empty it returns false and how long the client should wait.
+func CheckRate(r *http.Request, slug string, eventID int) (time.Duration, bool) {
+	if ok, wait := ipLimiter.Allow(ClientKey(r)); !ok {
+		reject(r, slug, ReasonRateLimitIP)
+		return wait, false
+	}
+	if ok, wait := eventLimiter.Allow(strconv.Itoa(eventID)); !ok {
+		reject(r, slug, ReasonRateLimitEvent)
+		return wait, false
+	}
+	return 0, true
+}
+
+// CheckUploadRate spends a token from the client's resumable upload bucket.
+// When it's empty it returns false and how long the client should wait.
+func CheckUploadRate(r *http.Request) (time.Duration, bool) {
+	if ok, wait := uploadLimiter.Allow(ClientKey(r)); !ok {
+		reject(r, "resumable upload", ReasonRateLimitUpload)
+		return wait, false
+	}
+	return 0, true
+}
+
+// CheckForm checks the honeypot and challenge fields of a parsed submission
+// form. When it returns false, the message is meant for the person
+// submitting. When it returns true, the caller must SpendChallenge once the
+// submission is accepted, or ReleaseChallenge if it's refused.
+func CheckForm(r *http.Request, slug string) (string, bool) {
+	if r.FormValue(HoneypotField) != "" {
+		reject(r, slug, ReasonHoneypot)
+		return msgRejected, false
+	}
+
+	nonce, answer := r.FormValue(NonceField), r.FormValue(AnswerField)
+	if nonce == "" && answer != "" {
+		if ok, _ := answerLimiter.Allow(ClientKey(r)); !ok {
+			reject(r, slug, ReasonRateLimitAnswer)
+			return msgAnswers, false
+		}
+	}
+
+	reason := checkChallenge(r.FormValue(TokenField), nonce, answer)
+	if reason == "" {
+		return "", true
+	}
+
+	reject(r, slug, reason)
+	switch reason {
+	case ReasonChallengeExpired:
+		return msgExpired, false
+	case ReasonTooFast:
+		return msgTooFast, false
+	default:
+		return msgRejected, false
+	}
+}
+
+func reject(r *http.Request, slug, reason string) {
+	rejections.Add(reason, 1)
+	log.Printf("Rejected submission for %s from %s: %s", slug, ClientIP(r), reason)
+}
@@ -0,0 +1,203 @@
+package antispam
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	challengeMaxAge = 24 * time.Hour  // how long a form stays valid
+	minFillTime     = 3 * time.Second // people take longer than this to write a message
+	maxDifficulty   = 28
+)
+
+var (
+	// Challenges are signed so the server doesn't have to remember them;
+	// forms issued before a restart have to be reloaded
+	challengeKey = randomBytes(32)
+	difficulty   = 16
+
+	usedMu     sync.Mutex
+	used       = make(map[string]time.Time) // challenge ID -> when it can be forgotten
+	held       = make(map[string]bool)      // challenges passed by forms still being checked
+	lastPurged time.Time
+)
+
+// Challenge is rendered into the submission form. Browsers with JavaScript
+// solve a proof of work over Token; without it, people answer Question.
+type Challenge struct {
+	Token      string
+	Difficulty int
+	Question   string
+}
+
+// NewChallenge issues a signed challenge for one form
+func NewChallenge() Challenge {
+	r := randomBytes(10)
+	a, b := int(r[8]%9)+1, int(r[9]%9)+1
+
+	payload := fmt.Sprintf("%d:%s:%d:%d:%d", time.Now().Unix(), hex.EncodeToString(r[:8]), difficulty, a, b)
+	return Challenge{
+		Token:      payload + ":" + sign(payload),
+		Difficulty: difficulty,
+		Question:   fmt.Sprintf("What is %d plus %d?", a, b),
+	}
+}
+
+type parsedChallenge struct {
+	issued     time.Time
+	id         string
+	difficulty int
+	answer     int
+}
+
+// checkChallenge verifies a solved challenge, returning the rejection reason
+// if it isn't. A wrong solution spends the challenge; a right one is held
+// until the form is accepted or refused, so it can't be replayed meanwhile.
+func checkChallenge(token, nonce, answer string) string {
+	if token == "" || (nonce == "" && answer == "") {
+		return ReasonChallengeMissing
+	}
+
+	c, ok := parseChallenge(token)
+	if !ok {
+		return ReasonChallengeInvalid
+	}
+
+	age := time.Since(c.issued)
+	if age > challengeMaxAge {
+		return ReasonChallengeExpired
+	}
+	if age < minFillTime {
+		return ReasonTooFast
+	}
+
+	// Each challenge gets one attempt, so answers can't simply be guessed
+	if !markUsed(c.id, c.issued.Add(challengeMaxAge)) {
+		return ReasonChallengeReused
+	}
+
+	if nonce != "" {
+		if leadingZeroBits(token, nonce) < c.difficulty {
+			return ReasonChallengeWrong
+		}
+	} else if n, err := strconv.Atoi(strings.TrimSpace(answer)); err != nil || n != c.answer {
+		return ReasonChallengeWrong
+	}
+
+	usedMu.Lock()
+	held[c.id] = true
+	usedMu.Unlock()
+	return ""
+}
+
+// SpendChallenge spends the challenge of a form CheckForm passed, once the
+// submission has been accepted
+func SpendChallenge(r *http.Request) {
+	if c, ok := parseChallenge(r.FormValue(TokenField)); ok {
+		usedMu.Lock()
+		delete(held, c.id)
+		usedMu.Unlock()
+	}
+}
+
+// ReleaseChallenge frees the challenge of a form CheckForm passed but that
+// was refused afterwards, so the person can fix the form and send it again.
+// It does nothing once the challenge has been spent.
+func ReleaseChallenge(r *http.Request) {
+	if c, ok := parseChallenge(r.FormValue(TokenField)); ok {
+		usedMu.Lock()
+		if held[c.id] {
+			delete(held, c.id)
+			delete(used, c.id)
+		}
+		usedMu.Unlock()
+	}
+}
+
+func parseChallenge(token string) (parsedChallenge, bool) {
+	i := strings.LastIndexByte(token, ':')
+	if i < 0 || !hmac.Equal([]byte(token[i+1:]), []byte(sign(token[:i]))) {
+		return parsedChallenge{}, false
+	}
+
+	fields := strings.Split(token[:i], ":")
+	if len(fields) != 5 {
+		return parsedChallenge{}, false
+	}
+	issued, err1 := strconv.ParseInt(fields[0], 10, 64)
+	diff, err2 := strconv.Atoi(fields[2])
+	a, err3 := strconv.Atoi(fields[3])
+	b, err4 := strconv.Atoi(fields[4])
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return parsedChallenge{}, false
+	}
+
+	return parsedChallenge{
+		issued:     time.Unix(issued, 0),
+		id:         fields[1],
+		difficulty: diff,
+		answer:     a + b,
+	}, true
+}
+
+// leadingZeroBits counts the zero bits at the start of SHA-256(token:nonce).
+// static/js/proof-of-work.js searches for a nonce the same way.
+func leadingZeroBits(token, nonce string) int {
+	sum := sha256.Sum256([]byte(token + ":" + nonce))
+	zeros := 0
+	for i := 0; i < len(sum); i += 4 {
+		word := binary.BigEndian.Uint32(sum[i:])
+		zeros += bits.LeadingZeros32(word)
+		if word != 0 {
+			break
+		}
+	}
+	return zeros
+}
+
+// markUsed records a challenge as spent, reporting false if it already was
+func markUsed(id string, forgetAt time.Time) bool {
+	usedMu.Lock()
+	defer usedMu.Unlock()
+
+	now := time.Now()
+	if now.Sub(lastPurged) > time.Hour {
+		for k, t := range used {
+			if now.After(t) {
+				delete(used, k)
+			}
+		}
+		lastPurged = now
+	}
+
+	if _, ok := used[id]; ok {
+		return false
+	}
+	used[id] = forgetAt
+	return true
+}
+
+func sign(payload string) string {
+	mac := hmac.New(sha256.New, challengeKey)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,123 @@
+package antispam
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// issueChallenge returns a challenge for a + b issued age ago
+func issueChallenge(id string, age time.Duration, a, b int) string {
+	payload := fmt.Sprintf("%d:%s:%d:%d:%d", time.Now().Add(-age).Unix(), id, 4, a, b)
+	return payload + ":" + sign(payload)
+}
+
+// solve finds a proof of work nonce for token
+func solve(token string) string {
+	c, _ := parseChallenge(token)
+	for nonce := 0; ; nonce++ {
+		if leadingZeroBits(token, fmt.Sprint(nonce)) >= c.difficulty {
+			return fmt.Sprint(nonce)
+		}
+	}
+}
+
+// formRequest returns a submission form posted from addr
+func formRequest(addr string, fields url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/events/test/submit", strings.NewReader(fields.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = addr + ":1234"
+	return r
+}
+
+func TestCheckChallenge(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		nonce  string
+		answer string
+		want   string
+	}{
+		{"proof of work", issueChallenge("pow", time.Minute, 2, 3), "solve", "", ""},
+		{"answer", issueChallenge("answer", time.Minute, 2, 3), "", " 5 ", ""},
+		{"wrong answer", issueChallenge("wrong-answer", time.Minute, 2, 3), "", "6", ReasonChallengeWrong},
+		{"wrong nonce", issueChallenge("wrong-nonce", time.Minute, 2, 3), "not-a-solution", "", ReasonChallengeWrong},
+		{"missing", issueChallenge("missing", time.Minute, 2, 3), "", "", ReasonChallengeMissing},
+		{"too fast", issueChallenge("fast", 0, 2, 3), "", "5", ReasonTooFast},
+		{"expired", issueChallenge("expired", challengeMaxAge+time.Minute, 2, 3), "", "5", ReasonChallengeExpired},
+		{"forged", issueChallenge("forged", time.Minute, 2, 3) + "0", "", "5", ReasonChallengeInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nonce := tt.nonce
+			if nonce == "solve" {
+				nonce = solve(tt.token)
+			}
+			if got := checkChallenge(tt.token, nonce, tt.answer); got != tt.want {
+				t.Errorf("checkChallenge = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChallengeSpentOnlyWhenAccepted(t *testing.T) {
+	token := issueChallenge("resend", time.Minute, 1, 1)
+	fields := url.Values{TokenField: {token}, NonceField: {solve(token)}}
+
+	// A refused form can be sent again
+	if _, ok := CheckForm(formRequest("192.0.2.1", fields), "test"); !ok {
+		t.Fatal("CheckForm refused a solved challenge")
+	}
+	ReleaseChallenge(formRequest("192.0.2.1", fields))
+
+	// but not while it's being checked, nor once it's accepted
+	r := formRequest("192.0.2.1", fields)
+	if _, ok := CheckForm(r, "test"); !ok {
+		t.Fatal("CheckForm refused a released challenge")
+	}
+	if got := checkChallenge(token, fields.Get(NonceField), ""); got != ReasonChallengeReused {
+		t.Errorf("challenge being checked: %q, want %q", got, ReasonChallengeReused)
+	}
+	SpendChallenge(r)
+	ReleaseChallenge(r)
+	if got := checkChallenge(token, fields.Get(NonceField), ""); got != ReasonChallengeReused {
+		t.Errorf("accepted challenge: %q, want %q", got, ReasonChallengeReused)
+	}
+
+	// A wrong answer spends the challenge, so answers can't be guessed
+	token = issueChallenge("guess", time.Minute, 1, 1)
+	if got := checkChallenge(token, "", "3"); got != ReasonChallengeWrong {
+		t.Fatalf("wrong answer: %q", got)
+	}
+	ReleaseChallenge(formRequest("192.0.2.1", url.Values{TokenField: {token}}))
+	if got := checkChallenge(token, "", "2"); got != ReasonChallengeReused {
+		t.Errorf("guess after a wrong answer: %q, want %q", got, ReasonChallengeReused)
+	}
+}
+
+func TestAnswersAreRateLimited(t *testing.T) {
+	saved := answerLimiter
+	answerLimiter = NewLimiter(2, 6)
+	t.Cleanup(func() { answerLimiter = saved })
+
+	for i := 0; i < 3; i++ {
+		token := issueChallenge(fmt.Sprint("answer-limit-", i), time.Minute, 1, 1)
+		msg, ok := CheckForm(formRequest("192.0.2.2", url.Values{TokenField: {token}, AnswerField: {"2"}}), "test")
+		if want := i < 2; ok != want {
+			t.Fatalf("answer %d: CheckForm = %v (%s), want %v", i+1, ok, msg, want)
+		}
+		if !ok && msg != msgAnswers {
+			t.Errorf("answer %d: message %q", i+1, msg)
+		}
+	}
+
+	// Proof of work from the same address still gets through
+	token := issueChallenge("answer-limit-pow", time.Minute, 1, 1)
+	if msg, ok := CheckForm(formRequest("192.0.2.2", url.Values{TokenField: {token}, NonceField: {solve(token)}}), "test"); !ok {
+		t.Errorf("proof of work after the answer limit: %s", msg)
+	}
+}
@@ -0,0 +1,186 @@
+// Package api serves the versioned JSON API under /api/v1 for creating and
+// managing events from other systems. Every request needs one of the keys in
+// API_KEYS, sent as "Authorization: Bearer <key>" or "X-API-Key: <key>".
+// The routes table also generates the OpenAPI document at /api/openapi.json.
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"event-messenger.com/config"
+)
+
+// Prefix is where version 1 of the API is mounted
+const Prefix = "/api/v1"
+
+// route is one endpoint of the API, along with what the OpenAPI document
+// says about it
+type route struct {
+	Method   string
+	Path     string // http.ServeMux pattern, e.g. /api/v1/events/{slug}
+	Summary  string
+	Query    []string // query parameters, from queryParams
+	Request  any      // JSON body type, if any
+	Form     any      // multipart body type, if any
+	Response any      // body type of the success response; nil for none
+	Status   int      // success status
+	Errors   []int    // error statuses besides 401 and 500
+	handler  http.HandlerFunc
+}
+
+// routes lists every endpoint, grouped by path in the order they're documented
+var routes = []route{
+	{
+		Method: http.MethodGet, Path: Prefix + "/events", Summary: "List events",
+		Query: []string{"limit", "offset"}, Response: list[eventJSON]{}, Status: http.StatusOK,
+		Errors: []int{http.StatusBadRequest}, handler: listEvents,
+	},
+	{
+		Method: http.MethodPost, Path: Prefix + "/events", Summary: "Create an event",
+		Request: eventInput{}, Response: eventJSON{}, Status: http.StatusCreated,
+		Errors: []int{http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity}, handler: createEvent,
+	},
+	{
+		Method: http.MethodGet, Path: Prefix + "/events/{slug}", Summary: "Get an event",
+		Response: eventJSON{}, Status: http.StatusOK,
+		Errors: []int{http.StatusNotFound}, handler: getEvent,
+	},
+	{
+		Method: http.MethodPatch, Path: Prefix + "/events/{slug}", Summary: "Update an event",
+		Request: eventInput{}, Response: eventJSON{}, Status: http.StatusOK,
+		Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity}, handler: updateEvent,
+	},
+	{
+		Method: http.MethodDelete, Path: Prefix + "/events/{slug}", Summary: "Delete an event and its submissions",
+		Status: http.StatusNoContent,
+		Errors: []int{http.StatusNotFound}, handler: deleteEvent,
+	},
+	{
+		Method: http.MethodGet, Path: Prefix + "/events/{slug}/submissions", Summary: "List an event's submissions",
+		Query: []string{"limit", "offset", "moderation"}, Response: list[submissionJSON]{}, Status: http.StatusOK,
+		Errors: []int{http.StatusBadRequest, http.StatusNotFound}, handler: listSubmissions,
+	},
+	{
+		Method: http.MethodPost, Path: Prefix + "/events/{slug}/submissions", Summary: "Add a submission to an event",
+		Request: submissionInput{}, Form: submissionForm{}, Response: submissionJSON{}, Status: http.StatusCreated,
+		Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusRequestEntityTooLarge,
+			http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity, http.StatusServiceUnavailable},
+		handler: createSubmission,
+	},
+	{
+		Method: http.MethodGet, Path: Prefix + "/events/{slug}/delivery", Summary: "Get an event's delivery status",
+		Response: delivery{}, Status: http.StatusOK,
+		Errors: []int{http.StatusNotFound}, handler: getDelivery,
+	},
+}
+
+// apiKey is an accepted key and the name it's logged under
+type apiKey struct {
+	name string
+	key  string
+}
+
+// parseKeys reads API_KEYS: comma-separated keys, each optionally prefixed
+// with a name and a colon
+func parseKeys(list string) []apiKey {
+	var keys []apiKey
+	for i, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(entry, ":")
+		if !ok {
+			name, key = fmt.Sprintf("key %d", i+1), entry
+		}
+		keys = append(keys, apiKey{name: name, key: key})
+	}
+	return keys
+}
+
+type clientKey struct{}
+
+// clientName is the name of the key a request was made with, for logging
+func clientName(r *http.Request) string {
+	name, _ := r.Context().Value(clientKey{}).(string)
+	return name
+}
+
+// Handler returns the API. Each path answers its own methods, with a JSON
+// error for anything else. The OpenAPI document at /api/openapi.json needs
+// no key.
+func Handler() http.Handler {
+	keys := parseKeys(config.App.APIKeys)
+
+	byPath := map[string]map[string]http.Handler{}
+	for _, rt := range routes {
+		if byPath[rt.Path] == nil {
+			byPath[rt.Path] = map[string]http.Handler{}
+		}
+		var h http.Handler = rt.handler
+		if CheckResponses {
+			h = checkResponse(rt, h)
+		}
+		byPath[rt.Path][rt.Method] = h
+	}
+
+	mux := http.NewServeMux()
+	for path, methods := range byPath {
+		mux.Handle(path, requireKey(keys, dispatch(methods)))
+	}
+	mux.Handle("/api/openapi.json", dispatch(map[string]http.Handler{http.MethodGet: http.HandlerFunc(serveSpec)}))
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "No such endpoint")
+	})
+	return mux
+}
+
+// dispatch picks the handler for the request method
+func dispatch(methods map[string]http.Handler) http.HandlerFunc {
+	var allowed []string
+	for method := range methods {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler, ok := methods[r.Method]
+		if !ok {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}
+}
+
+// requireKey refuses requests without a valid API key
+func requireKey(keys []apiKey, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(keys) == 0 {
+			writeError(w, http.StatusUnauthorized, "The API is disabled; set API_KEYS to enable it")
+			return
+		}
+
+		sent := r.Header.Get("X-API-Key")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			sent = strings.TrimSpace(bearer)
+		}
+
+		for _, k := range keys {
+			if sent != "" && subtle.ConstantTimeCompare([]byte(sent), []byte(k.key)) == 1 {
+				ctx := context.WithValue(r.Context(), clientKey{}, k.name)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+		}
+
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		writeError(w, http.StatusUnauthorized, "Missing or invalid API key")
+	})
+}
@@ -0,0 +1,322 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"event-messenger.com/config"
+	"event-messenger.com/internal/testutil"
+	"event-messenger.com/media"
+	"event-messenger.com/models"
+)
+
+const testKey = "test-key"
+
+// setupAPI points the API at a fresh database and image directory
+func setupAPI(t *testing.T) http.Handler {
+	t.Helper()
+	testutil.Setup(t)
+	config.App.APIKeys = "test:" + testKey
+
+	// No workers: queued images wait, which is all a submission needs
+	dir := t.TempDir()
+	media.StartWorkers(0, 16, filepath.Join(dir, "pending"), filepath.Join(dir, "uploads"))
+
+	return Handler()
+}
+
+// deliveredEvent saves an event whose messages have gone out, so it has a
+// delivery history and sent recipients
+func deliveredEvent(t *testing.T) *models.Event {
+	t.Helper()
+	event := models.NewEvent("Delivered", "delivered", time.Now().AddDate(0, 0, -1))
+	if err := event.SaveEvent(); err != nil {
+		t.Fatal(err)
+	}
+	err := event.SaveRecipients([]models.Recipient{
+		{Name: "Sam", Email: "sam@example.com", Role: models.RoleTo},
+		{Email: "cc@example.com", Role: models.RoleCC},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients, err := models.GetRecipients(event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients[0].MarkSent()
+	recipients[1].MarkFailed(errors.New("mailbox full"))
+
+	for _, d := range []models.Delivery{
+		{EventID: event.ID, Trigger: models.DeliveryScheduled, Channel: models.NotifyEmail, Status: models.DeliveryFailed, Error: "timeout"},
+		{EventID: event.ID, Trigger: models.DeliveryResend, Channel: models.NotifyEmail, SentTo: "sam@example.com", Status: models.DeliverySucceeded},
+	} {
+		if err := models.RecordDelivery(&d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := event.MarkEmailSent(); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+// testPNG returns a small image to attach to submissions
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 16))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// apiCase is one request to a route
+type apiCase struct {
+	name    string
+	method  string
+	route   string // the route's path pattern, relative to Prefix
+	slug    string // fills in {slug}; the event created earlier if empty
+	query   string
+	json    any               // JSON body
+	form    map[string]string // multipart body
+	files   map[string][]byte // multipart files
+	raw     string            // any other body
+	rawType string            // the raw body's Content-Type; text/plain if empty
+	noKey   bool
+	invalid bool // the body deliberately doesn't match the document
+	want    int
+}
+
+// TestRoutesMatchOpenAPI sends requests to every route and checks each
+// request body and every response against the OpenAPI document
+func TestRoutesMatchOpenAPI(t *testing.T) {
+	h := setupAPI(t)
+	delivered := deliveredEvent(t)
+	img := testPNG(t)
+
+	cases := []apiCase{
+		{name: "no key", method: "GET", route: "/events", noKey: true, want: 401},
+		{name: "list", method: "GET", route: "/events", want: 200},
+		{name: "bad page", method: "GET", route: "/events", query: "limit=0", want: 400},
+		{name: "create", method: "POST", route: "/events", want: 201, json: map[string]any{
+			"name":                "Retirement of Alex",
+			"description":         "Thirty years",
+			"event_date":          time.Now().AddDate(0, 0, 14).Format(dateLayout),
+			"recipients":          []any{map[string]any{"name": "Alex", "email": "alex@example.com"}, map[string]any{"email": "boss@example.com", "role": "cc"}},
+			"coordinator":         "Jo",
+			"coordinator_contact": "555 0100",
+			"moderated":           true,
+			"filter_action":       "mask",
+			"filter_words":        "broccoli",
+			"digest_days":         []any{3, 1},
+		}},
+		{name: "create without date", method: "POST", route: "/events", json: map[string]any{"name": "No date"}, want: 422},
+		{name: "create with unknown field", method: "POST", route: "/events", json: map[string]any{"title": "x"}, invalid: true, want: 400},
+		{name: "create with bad channel", method: "POST", route: "/events", invalid: true, want: 422, json: map[string]any{
+			"name": "Bad channel", "event_date": time.Now().AddDate(0, 0, 14).Format(dateLayout),
+			"recipient_name": "Alex", "recipient_email": "alex@example.com", "notify_channel": "pigeon",
+		}},
+		{name: "create too large", method: "POST", route: "/events", json: map[string]any{"description": strings.Repeat("x", maxEventBody)}, want: 413},
+		{name: "get", method: "GET", route: "/events/{slug}", want: 200},
+		{name: "get delivered", method: "GET", route: "/events/{slug}", slug: delivered.Slug, want: 200},
+		{name: "get missing", method: "GET", route: "/events/{slug}", slug: "missing", want: 404},
+		{name: "update", method: "PATCH", route: "/events/{slug}", json: map[string]any{"moderated": false, "digest_days": []any{}}, want: 200},
+		{name: "update bad json", method: "PATCH", route: "/events/{slug}", raw: "{", rawType: "application/json", invalid: true, want: 400},
+		{name: "update delivered", method: "PATCH", route: "/events/{slug}", slug: delivered.Slug, json: map[string]any{"name": "Too late"}, want: 409},
+		{name: "update bad date", method: "PATCH", route: "/events/{slug}", json: map[string]any{"event_date": "2000-01-01"}, want: 422},
+		{name: "submit json", method: "POST", route: "/events/{slug}/submissions", want: 201, json: map[string]any{
+			"name": "Riley", "message": "Congratulations!", "image_base64": base64.StdEncoding.EncodeToString(img), "image_filename": "riley.png",
+		}},
+		{name: "submit multipart", method: "POST", route: "/events/{slug}/submissions", want: 201,
+			form: map[string]string{"name": "Casey", "message": "Enjoy it", "email": "casey@example.com"}, files: map[string][]byte{"image": img}},
+		{name: "submit without image", method: "POST", route: "/events/{slug}/submissions", json: map[string]any{"name": "Nobody", "message": "Hi"}, want: 400},
+		{name: "submit text", method: "POST", route: "/events/{slug}/submissions", raw: "hello", invalid: true, want: 415},
+		{name: "submit to missing", method: "POST", route: "/events/{slug}/submissions", slug: "missing", json: map[string]any{"name": "x"}, want: 404},
+		{name: "list submissions", method: "GET", route: "/events/{slug}/submissions", want: 200},
+		{name: "list pending", method: "GET", route: "/events/{slug}/submissions", query: "moderation=pending&limit=1", want: 200},
+		{name: "list bad moderation", method: "GET", route: "/events/{slug}/submissions", query: "moderation=maybe", want: 400},
+		{name: "delivery", method: "GET", route: "/events/{slug}/delivery", want: 200},
+		{name: "delivery delivered", method: "GET", route: "/events/{slug}/delivery", slug: delivered.Slug, want: 200},
+		{name: "delivery missing", method: "GET", route: "/events/{slug}/delivery", slug: "missing", want: 404},
+		{name: "delete", method: "DELETE", route: "/events/{slug}", want: 204},
+		{name: "delete again", method: "DELETE", route: "/events/{slug}", want: 404},
+	}
+
+	created := ""
+	succeeded := map[string]bool{}
+	for _, tc := range cases {
+		rt, ok := findRoute(tc.method, Prefix+tc.route)
+		if !ok {
+			t.Fatalf("%s: no route %s %s", tc.name, tc.method, tc.route)
+		}
+
+		slug := tc.slug
+		if slug == "" {
+			slug = created
+		}
+		target := Prefix + strings.ReplaceAll(tc.route, "{slug}", slug)
+		if tc.query != "" {
+			target += "?" + tc.query
+		}
+
+		req, problems := tc.request(t, rt, target)
+		if tc.invalid && len(problems) == 0 {
+			t.Errorf("%s: request body matches the document, but the case says it doesn't", tc.name)
+		} else if !tc.invalid {
+			for _, p := range problems {
+				t.Errorf("%s: request doesn't match the OpenAPI document: %s", tc.name, p)
+			}
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d: %s", tc.name, rec.Code, tc.want, rec.Body)
+		}
+		for _, p := range specProblems(rt, rec.Code, rec.Body.Bytes()) {
+			t.Errorf("%s: response doesn't match the OpenAPI document: %s", tc.name, p)
+		}
+
+		if rec.Code == rt.Status {
+			succeeded[rt.Method+" "+rt.Path] = true
+		}
+		if tc.name == "create" && rec.Code == http.StatusCreated {
+			var body eventJSON
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			created = body.Slug
+		}
+	}
+
+	for _, rt := range routes {
+		if !succeeded[rt.Method+" "+rt.Path] {
+			t.Errorf("%s %s is never called successfully", rt.Method, rt.Path)
+		}
+	}
+}
+
+// request builds the case's request and lists how its body and query
+// differ from what the document says the route takes
+func (tc apiCase) request(t *testing.T, rt route, target string) (*http.Request, []string) {
+	t.Helper()
+	op := openAPI()["paths"].(map[string]any)[rt.Path].(map[string]any)[strings.ToLower(rt.Method)].(map[string]any)
+
+	var problems []string
+	u, _ := url.Parse(target)
+	for name := range u.Query() {
+		if !contains(rt.Query, name) {
+			problems = append(problems, "query parameter "+name+" isn't documented")
+		}
+	}
+
+	var body bytes.Buffer
+	var contentType string
+	var value any
+	switch {
+	case tc.json != nil:
+		data, err := json.Marshal(tc.json)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body.Write(data)
+		json.Unmarshal(data, &value)
+		contentType = "application/json"
+
+	case tc.form != nil || tc.files != nil:
+		mw := multipart.NewWriter(&body)
+		fields := map[string]any{}
+		for name, v := range tc.form {
+			mw.WriteField(name, v)
+			fields[name] = v
+		}
+		for name, data := range tc.files {
+			fw, err := mw.CreateFormFile(name, name+".png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			fw.Write(data)
+			fields[name] = "binary"
+		}
+		mw.Close()
+		value = fields
+		contentType = mw.FormDataContentType()
+
+	case tc.raw != "":
+		body.WriteString(tc.raw)
+		contentType = tc.rawType
+		if contentType == "" {
+			contentType = "text/plain"
+		}
+		if err := json.Unmarshal([]byte(tc.raw), &value); err != nil {
+			problems = append(problems, "body isn't JSON")
+		}
+	}
+
+	if contentType != "" {
+		mediaType, _, _ := strings.Cut(contentType, ";")
+		requestBody, ok := op["requestBody"].(map[string]any)
+		if !ok {
+			problems = append(problems, "the route takes no body")
+		} else if content, ok := requestBody["content"].(map[string]any)[mediaType].(map[string]any); !ok {
+			problems = append(problems, mediaType+" bodies aren't documented")
+		} else if value != nil {
+			problems = append(problems, validate(value, content["schema"].(map[string]any), "body")...)
+		}
+	}
+
+	req := httptest.NewRequest(tc.method, target, &body)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if !tc.noKey {
+		req.Header.Set("X-API-Key", testKey)
+	}
+	return req, problems
+}
+
+// findRoute looks up the route a case is for
+func findRoute(method, path string) (route, bool) {
+	for _, rt := range routes {
+		if rt.Method == method && rt.Path == path {
+			return rt, true
+		}
+	}
+	return route{}, false
+}
+
+// Every status a route is documented to answer with is listed in the
+// document, with a body schema unless it's 204
+func TestOpenAPIDocumentsRoutes(t *testing.T) {
+	paths := openAPI()["paths"].(map[string]any)
+	for _, rt := range routes {
+		op, ok := paths[rt.Path].(map[string]any)[strings.ToLower(rt.Method)].(map[string]any)
+		if !ok {
+			t.Errorf("%s %s isn't documented", rt.Method, rt.Path)
+			continue
+		}
+		responses := op["responses"].(map[string]any)
+		for _, status := range append([]int{rt.Status, http.StatusUnauthorized, http.StatusInternalServerError}, rt.Errors...) {
+			response, ok := responses[strconv.Itoa(status)].(map[string]any)
+			if !ok {
+				t.Errorf("%s %s: status %d isn't documented", rt.Method, rt.Path, status)
+				continue
+			}
+			if _, hasBody := response["content"]; hasBody == (status == http.StatusNoContent) {
+				t.Errorf("%s %s: status %d body documented: %v", rt.Method, rt.Path, status, hasBody)
+			}
+		}
+	}
+}
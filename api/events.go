@@ -0,0 +1,502 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"event-messenger.com/handlers"
+	"event-messenger.com/models"
+	"event-messenger.com/scheduler"
+	"event-messenger.com/utils"
+	"event-messenger.com/webhooks"
+	"event-messenger.com/wordfilter"
+)
+
+// dateLayout is how event dates are written in requests and responses
+const dateLayout = "2006-01-02"
+
+// maxEventBody limits event create and update requests
+const maxEventBody = 64 << 10
+
+// Delivery states reported for an event
+const (
+	DeliverySent      = "sent"      // the messages have been delivered
+	DeliveryScheduled = "scheduled" // waiting for the event date
+	DeliveryDue       = "due"       // the send time has passed but the messages haven't gone out
+)
+
+// eventJSON is an event as the API returns it
+type eventJSON struct {
+	ID                 int         `json:"id"`
+	Slug               string      `json:"slug"`
+	Name               string      `json:"name"`
+	Description        string      `json:"description"`
+	EventDate          string      `json:"event_date"`
+	RecipientName      string      `json:"recipient_name"`  // the To recipients' names, joined
+	RecipientEmail     string      `json:"recipient_email"` // the first To recipient's address
+	Recipients         []recipient `json:"recipients"`
+	Coordinator        string      `json:"coordinator"`
+	CoordinatorContact string      `json:"coordinator_contact"`
+	Active             bool        `json:"active"`
+	Moderated          bool        `json:"moderated"`
+	FilterAction       string      `json:"filter_action"`
+	FilterWords        string      `json:"filter_words"`
+	MediaMaxSeconds    int         `json:"media_max_seconds"`
+	MediaMaxBytes      int64       `json:"media_max_bytes"`
+	NotifyChannel      string      `json:"notify_channel"`
+	DigestDays         []int       `json:"digest_days"` // days before delivery the coordinator gets a progress digest
+	SubmissionURL      string      `json:"submission_url"`
+	KeepsakeURL        string      `json:"keepsake_url"`
+	ReviewURL          string      `json:"review_url,omitempty"` // only when the event is created
+	Delivery           delivery    `json:"delivery"`
+	CreatedAt          time.Time   `json:"created_at"`
+}
+
+// delivery is when and how an event's messages are, or were, sent to the
+// recipient
+type delivery struct {
+	Status              string      `json:"status"`
+	Channel             string      `json:"channel"`
+	ScheduledFor        time.Time   `json:"scheduled_for"`
+	SentAt              *time.Time  `json:"sent_at"`
+	RecipientEmail      string      `json:"recipient_email"`
+	ApprovedSubmissions *int        `json:"approved_submissions,omitempty"`
+	Recipients          []recipient `json:"recipients,omitempty"` // only from the delivery endpoint
+	History             []attempt   `json:"history,omitempty"`    // only from the delivery endpoint
+}
+
+// attempt is one entry in an event's delivery history
+type attempt struct {
+	Trigger   string    `json:"trigger"`
+	Status    string    `json:"status"`
+	SentTo    string    `json:"sent_to,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// recipient is someone an event's messages go to, and whether they have
+type recipient struct {
+	Name   string     `json:"name"`
+	Email  string     `json:"email"`
+	Role   string     `json:"role"`
+	Status string     `json:"status"`
+	Error  string     `json:"error,omitempty"`
+	SentAt *time.Time `json:"sent_at"`
+}
+
+// recipientInput is a recipient in event create and update requests
+type recipientInput struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email"`
+	Role  string `json:"role,omitempty"` // to by default
+}
+
+func newRecipients(recipients []models.Recipient) []recipient {
+	list := make([]recipient, 0, len(recipients))
+	for _, r := range recipients {
+		item := recipient{Name: r.Name, Email: r.Email, Role: r.Role, Status: r.Status, Error: r.Error}
+		if r.SentAt.Valid {
+			sentAt := r.SentAt.Time
+			item.SentAt = &sentAt
+		}
+		list = append(list, item)
+	}
+	return list
+}
+
+func newHistory(deliveries []models.Delivery) []attempt {
+	list := make([]attempt, 0, len(deliveries))
+	for _, d := range deliveries {
+		list = append(list, attempt{Trigger: d.Trigger, Status: d.Status, SentTo: d.SentTo, Error: d.Error, CreatedAt: d.CreatedAt})
+	}
+	return list
+}
+
+func newDelivery(event *models.Event) delivery {
+	d := delivery{
+		Channel:        event.NotifyChannel,
+		ScheduledFor:   scheduler.DeliveryTime(event.EventDate),
+		RecipientEmail: event.RecipientEmail,
+	}
+	switch {
+	case event.EmailSent:
+		d.Status = DeliverySent
+		if event.EmailSentAt.Valid {
+			sentAt := event.EmailSentAt.Time
+			d.SentAt = &sentAt
+		}
+	case time.Now().Before(d.ScheduledFor):
+		d.Status = DeliveryScheduled
+	default:
+		d.Status = DeliveryDue
+	}
+	return d
+}
+
+func newEventJSON(r *http.Request, event *models.Event, recipients []models.Recipient) eventJSON {
+	baseURL := utils.GetBaseURL(r)
+	return eventJSON{
+		ID:                 event.ID,
+		Slug:               event.Slug,
+		Name:               event.Name,
+		Description:        event.Description,
+		EventDate:          event.EventDate.UTC().Format(dateLayout),
+		RecipientName:      event.RecipientName,
+		RecipientEmail:     event.RecipientEmail,
+		Recipients:         newRecipients(recipients),
+		Coordinator:        event.Coordinator,
+		CoordinatorContact: event.CoordinatorContact,
+		Active:             event.Active,
+		Moderated:          event.Moderated,
+		FilterAction:       event.FilterAction,
+		FilterWords:        event.FilterWords,
+		MediaMaxSeconds:    event.MediaMaxSeconds,
+		MediaMaxBytes:      event.MediaMaxBytes,
+		NotifyChannel:      event.NotifyChannel,
+		DigestDays:         event.DigestSchedule(),
+		SubmissionURL:      baseURL + "/events/" + event.Slug,
+		KeepsakeURL:        baseURL + "/events/" + event.Slug + "/messages",
+		Delivery:           newDelivery(event),
+		CreatedAt:          event.CreatedAt,
+	}
+}
+
+// eventInput is the body of event create and update requests. Fields left
+// out of an update keep their current values; creating an event needs at
+// least name, event_date and either recipients or recipient_name and
+// recipient_email. recipients replaces the whole list, while recipient_name
+// and recipient_email on their own replace just the first To recipient.
+type eventInput struct {
+	Name               *string           `json:"name,omitempty"`
+	Description        *string           `json:"description,omitempty"`
+	EventDate          *string           `json:"event_date,omitempty"`
+	RecipientName      *string           `json:"recipient_name,omitempty"`
+	RecipientEmail     *string           `json:"recipient_email,omitempty"`
+	Recipients         *[]recipientInput `json:"recipients,omitempty"`
+	Coordinator        *string           `json:"coordinator,omitempty"`
+	CoordinatorContact *string           `json:"coordinator_contact,omitempty"`
+	Moderated          *bool             `json:"moderated,omitempty"`
+	FilterAction       *string           `json:"filter_action,omitempty"`
+	FilterWords        *string           `json:"filter_words,omitempty"`
+	MediaMaxSeconds    *int              `json:"media_max_seconds,omitempty"`
+	MediaMaxBytes      *int64            `json:"media_max_bytes,omitempty"`
+	NotifyChannel      *string           `json:"notify_channel,omitempty"`
+	NotifyURL          *string           `json:"notify_url,omitempty"` // write-only, since it's a secret
+	DigestDays         *[]int            `json:"digest_days,omitempty"`
+}
+
+// apply copies the given fields onto event, checking them the same way the
+// event creation form does. If the recipients change it returns the new list
+// for the caller to save; otherwise the list is nil.
+func (in eventInput) apply(event *models.Event, current []models.Recipient) ([]models.Recipient, error) {
+	bad := func(format string, args ...any) error {
+		return &handlers.RequestError{Status: http.StatusUnprocessableEntity, Message: fmt.Sprintf(format, args...)}
+	}
+
+	if in.Name != nil {
+		event.Name = strings.TrimSpace(*in.Name)
+	}
+	if in.Description != nil {
+		event.Description = *in.Description
+	}
+	if in.Coordinator != nil {
+		event.Coordinator = *in.Coordinator
+	}
+	if in.CoordinatorContact != nil {
+		event.CoordinatorContact = *in.CoordinatorContact
+	}
+	if in.Moderated != nil {
+		event.Moderated = *in.Moderated
+	}
+
+	if event.Name == "" {
+		return nil, bad("name is required")
+	}
+
+	var recipients []models.Recipient
+	switch {
+	case in.Recipients != nil:
+		recipients = []models.Recipient{}
+		for _, r := range *in.Recipients {
+			recipients = append(recipients, models.Recipient{Name: r.Name, Email: r.Email, Role: r.Role})
+		}
+	case in.RecipientName != nil || in.RecipientEmail != nil:
+		recipients = append([]models.Recipient{}, current...)
+		if len(recipients) == 0 {
+			recipients = []models.Recipient{{Role: models.RoleTo}}
+		}
+		if in.RecipientName != nil {
+			recipients[0].Name = *in.RecipientName
+		}
+		if in.RecipientEmail != nil {
+			recipients[0].Email = *in.RecipientEmail
+		}
+	case len(current) == 0:
+		return nil, bad("recipients, or recipient_name and recipient_email, are required")
+	}
+	if recipients != nil {
+		var err error
+		recipients, err = handlers.CheckRecipients(recipients)
+		if err != nil {
+			return nil, bad("%v", err)
+		}
+		event.RecipientName = models.RecipientNames(recipients)
+		event.RecipientEmail = recipients[0].Email
+	}
+
+	if in.EventDate != nil {
+		eventDate, err := time.Parse(dateLayout, *in.EventDate)
+		if err != nil {
+			return nil, bad("event_date must be a date like 2026-06-30")
+		}
+		if eventDate.Before(time.Now().Truncate(24 * time.Hour)) {
+			return nil, bad("event_date must be in the future")
+		}
+		event.EventDate = eventDate
+	}
+
+	if in.MediaMaxSeconds != nil {
+		if *in.MediaMaxSeconds < 1 || *in.MediaMaxSeconds > handlers.MaxMediaSeconds {
+			return nil, bad("media_max_seconds must be between 1 and %d", handlers.MaxMediaSeconds)
+		}
+		event.MediaMaxSeconds = *in.MediaMaxSeconds
+	}
+	if in.MediaMaxBytes != nil {
+		if *in.MediaMaxBytes < 1<<20 || *in.MediaMaxBytes > handlers.MaxResumableUploadSize {
+			return nil, bad("media_max_bytes must be between %d and %d", 1<<20, handlers.MaxResumableUploadSize)
+		}
+		event.MediaMaxBytes = *in.MediaMaxBytes
+	}
+
+	if in.FilterAction != nil {
+		if !wordfilter.ValidAction(*in.FilterAction) {
+			return nil, bad("filter_action must be one of %q", wordfilter.Actions)
+		}
+		event.FilterAction = *in.FilterAction
+	}
+	if in.FilterWords != nil {
+		words := strings.TrimSpace(*in.FilterWords)
+		if len(words) > handlers.MaxFilterWordsLength {
+			return nil, bad("filter_words can be at most %d characters", handlers.MaxFilterWordsLength)
+		}
+		event.FilterWords = words
+	}
+
+	if in.DigestDays != nil {
+		days, err := models.FormatDigestDays(*in.DigestDays)
+		if err != nil {
+			return nil, bad("digest_days: %v", err)
+		}
+		event.DigestDays = days
+	}
+
+	if in.NotifyChannel != nil {
+		event.NotifyChannel = *in.NotifyChannel
+	}
+	if in.NotifyURL != nil {
+		event.NotifyURL = strings.TrimSpace(*in.NotifyURL)
+	}
+	if event.NotifyChannel == models.NotifyEmail {
+		event.NotifyURL = ""
+	}
+	if in.NotifyChannel != nil || in.NotifyURL != nil {
+		if err := handlers.CheckNotifySettings(event.NotifyChannel, event.NotifyURL); err != nil {
+			return nil, bad("notify_channel must be one of %q, and chat channels need an http(s) notify_url", models.NotifyChannels)
+		}
+	}
+
+	return recipients, nil
+}
+
+// lookupEvent finds the event named in the path, delivered ones included.
+// It writes the error response itself when it returns nil.
+func lookupEvent(w http.ResponseWriter, r *http.Request) *models.Event {
+	event, err := models.GetEventBySlugIncludingArchived(r.PathValue("slug"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "No event with that slug")
+		return nil
+	}
+	return event
+}
+
+func listEvents(w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := readPage(w, r)
+	if !ok {
+		return
+	}
+
+	events, total, err := models.ListEvents(limit, offset)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	data := make([]eventJSON, 0, len(events))
+	for i := range events {
+		recipients, err := models.GetRecipients(events[i].ID)
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		data = append(data, newEventJSON(r, &events[i], recipients))
+	}
+	writeList(w, data, limit, offset, total)
+}
+
+func createEvent(w http.ResponseWriter, r *http.Request) {
+	var in eventInput
+	if !readJSON(w, r, maxEventBody, &in) {
+		return
+	}
+	if in.EventDate == nil {
+		writeError(w, http.StatusUnprocessableEntity, "event_date is required")
+		return
+	}
+
+	event := models.NewEvent("", "", time.Time{})
+	recipients, err := in.apply(event, nil)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	event.Slug = utils.GenerateSlug(event.Name)
+	event.WebsiteLink = utils.GetEventURL(event.Slug, r)
+
+	err = event.SaveEvent()
+	if err == nil {
+		err = event.SaveRecipients(recipients)
+	}
+	if err != nil {
+		writeErr(w, fmt.Errorf("error saving event: %v", err))
+		return
+	}
+
+	webhooks.PublishEvent(webhooks.EventCreated, event)
+
+	reviewURL := handlers.ReviewLink(utils.GetBaseURL(r), event.Slug, event.ManageToken)
+	go handlers.SendReviewLink(event, reviewURL)
+
+	log.Printf("API client %s created event %s", clientName(r), event.Slug)
+
+	body := newEventJSON(r, event, recipients)
+	body.ReviewURL = reviewURL
+	w.Header().Set("Location", Prefix+"/events/"+event.Slug)
+	writeJSON(w, http.StatusCreated, body)
+}
+
+func getEvent(w http.ResponseWriter, r *http.Request) {
+	event := lookupEvent(w, r)
+	if event == nil {
+		return
+	}
+
+	recipients, err := models.GetRecipients(event.ID)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newEventJSON(r, event, recipients))
+}
+
+func updateEvent(w http.ResponseWriter, r *http.Request) {
+	event := lookupEvent(w, r)
+	if event == nil {
+		return
+	}
+
+	var in eventInput
+	if !readJSON(w, r, maxEventBody, &in) {
+		return
+	}
+
+	if event.EmailSent {
+		writeError(w, http.StatusConflict, "The event has already been delivered")
+		return
+	}
+
+	current, err := models.GetRecipients(event.ID)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	recipients, err := in.apply(event, current)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	err = event.Update()
+	if err == nil && recipients != nil {
+		err = event.SaveRecipients(recipients)
+	}
+	if err != nil {
+		writeErr(w, fmt.Errorf("error updating event %s: %v", event.Slug, err))
+		return
+	}
+	if recipients == nil {
+		recipients = current
+	}
+
+	log.Printf("API client %s updated event %s", clientName(r), event.Slug)
+	writeJSON(w, http.StatusOK, newEventJSON(r, event, recipients))
+}
+
+func deleteEvent(w http.ResponseWriter, r *http.Request) {
+	event := lookupEvent(w, r)
+	if event == nil {
+		return
+	}
+
+	submissions, err := models.GetSubmissionsForReview(event.ID)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	if err := event.Purge(); err != nil {
+		writeErr(w, err)
+		return
+	}
+	for i := range submissions {
+		handlers.RemoveSubmissionFiles(&submissions[i])
+	}
+	webhooks.PublishEvent(webhooks.EventPurged, event)
+
+	log.Printf("API client %s deleted event %s with %d submissions", clientName(r), event.Slug, len(submissions))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func getDelivery(w http.ResponseWriter, r *http.Request) {
+	event := lookupEvent(w, r)
+	if event == nil {
+		return
+	}
+
+	count, err := event.GetSubmissionCount()
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	recipients, err := models.GetRecipients(event.ID)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	deliveries, err := models.GetDeliveries(event.ID)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	d := newDelivery(event)
+	d.ApprovedSubmissions = &count
+	d.Recipients = newRecipients(recipients)
+	d.History = newHistory(deliveries)
+	writeJSON(w, http.StatusOK, d)
+}
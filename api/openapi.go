@@ -0,0 +1,441 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"event-messenger.com/models"
+	"event-messenger.com/utils"
+	"event-messenger.com/wordfilter"
+)
+
+// CheckResponses makes the API check every response it sends against its
+// OpenAPI document and log any differences. It's meant for development, so
+// the handlers and the document can't quietly drift apart; set it before
+// calling Handler.
+var CheckResponses bool
+
+// schemaNames are the types documented as named schemas under
+// components/schemas; other structs are written out where they're used
+var schemaNames = map[reflect.Type]string{
+	reflect.TypeOf(eventJSON{}):       "Event",
+	reflect.TypeOf(eventInput{}):      "EventInput",
+	reflect.TypeOf(delivery{}):        "Delivery",
+	reflect.TypeOf(attempt{}):         "DeliveryAttempt",
+	reflect.TypeOf(recipient{}):       "Recipient",
+	reflect.TypeOf(recipientInput{}):  "RecipientInput",
+	reflect.TypeOf(submissionJSON{}):  "Submission",
+	reflect.TypeOf(submissionInput{}): "SubmissionInput",
+	reflect.TypeOf(submissionForm{}):  "SubmissionForm",
+	reflect.TypeOf(pagination{}):      "Pagination",
+	reflect.TypeOf(errorBody{}):       "Error",
+	reflect.TypeOf(errorDetail{}):     "ErrorDetail",
+}
+
+// enums lists the values a field can take, by schema name and JSON field
+var enums = map[string][]string{
+	"Event.filter_action":       wordfilter.Actions,
+	"EventInput.filter_action":  wordfilter.Actions,
+	"Event.notify_channel":      models.NotifyChannels,
+	"EventInput.notify_channel": models.NotifyChannels,
+	"Delivery.status":           {DeliveryScheduled, DeliveryDue, DeliverySent},
+	"Delivery.channel":          models.NotifyChannels,
+	"DeliveryAttempt.trigger":   {models.DeliveryScheduled, models.DeliveryEarly, models.DeliveryResend},
+	"DeliveryAttempt.status":    {models.DeliverySucceeded, models.DeliveryFailed},
+	"Recipient.role":            models.RecipientRoles,
+	"Recipient.status":          {models.RecipientPending, models.RecipientSent, models.RecipientFailed},
+	"RecipientInput.role":       models.RecipientRoles,
+	"Submission.moderation":     {models.ModerationPending, models.ModerationApproved, models.ModerationRejected},
+	"Submission.image_status":   {models.SubmissionProcessing, models.SubmissionReady, models.SubmissionFailed},
+	"ErrorDetail.code":          errorCodeList(),
+}
+
+func errorCodeList() []string {
+	var codes []string
+	for _, code := range errorCodes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// formats gives string fields a format, by schema name and JSON field
+var formats = map[string]string{
+	"Event.event_date":      "date",
+	"EventInput.event_date": "date",
+	"Event.submission_url":  "uri",
+	"Event.keepsake_url":    "uri",
+	"Event.review_url":      "uri",
+	"EventInput.notify_url": "uri",
+	"Recipient.email":       "email",
+	"RecipientInput.email":  "email",
+	"Submission.image_url":  "uri",
+	"Submission.media_url":  "uri",
+	"Submission.edit_url":   "uri",
+}
+
+// queryParams are the query parameters routes can take
+var queryParams = map[string]map[string]any{
+	"limit": {
+		"name": "limit", "in": "query", "description": "Items per page",
+		"schema": map[string]any{"type": "integer", "minimum": 1, "maximum": MaxPageSize, "default": DefaultPageSize},
+	},
+	"offset": {
+		"name": "offset", "in": "query", "description": "Items to skip",
+		"schema": map[string]any{"type": "integer", "minimum": 0, "default": 0},
+	},
+	"moderation": {
+		"name": "moderation", "in": "query", "description": "Only list submissions in this moderation state",
+		"schema": map[string]any{"type": "string", "enum": []string{models.ModerationPending, models.ModerationApproved, models.ModerationRejected}},
+	},
+}
+
+var pathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// specBuilder collects the named schemas while the document is written
+type specBuilder struct {
+	schemas map[string]any
+}
+
+// buildSpec writes the OpenAPI document for routes
+func buildSpec() map[string]any {
+	b := &specBuilder{schemas: map[string]any{}}
+	errorRef := b.schema(reflect.TypeOf(errorBody{}))
+
+	paths := map[string]any{}
+	for _, rt := range routes {
+		op := map[string]any{
+			"operationId": operationID(rt.handler),
+			"summary":     rt.Summary,
+		}
+
+		var params []any
+		for _, name := range pathParam.FindAllStringSubmatch(rt.Path, -1) {
+			params = append(params, map[string]any{"name": name[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+		}
+		for _, name := range rt.Query {
+			params = append(params, queryParams[name])
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+
+		content := map[string]any{}
+		if rt.Request != nil {
+			content["application/json"] = map[string]any{"schema": b.schema(reflect.TypeOf(rt.Request))}
+		}
+		if rt.Form != nil {
+			content["multipart/form-data"] = map[string]any{"schema": b.schema(reflect.TypeOf(rt.Form))}
+		}
+		if len(content) > 0 {
+			op["requestBody"] = map[string]any{"required": true, "content": content}
+		}
+
+		success := map[string]any{"description": http.StatusText(rt.Status)}
+		if rt.Response != nil {
+			success["content"] = map[string]any{"application/json": map[string]any{"schema": b.schema(reflect.TypeOf(rt.Response))}}
+		}
+		responses := map[string]any{strconv.Itoa(rt.Status): success}
+		for _, status := range append([]int{http.StatusUnauthorized, http.StatusInternalServerError}, rt.Errors...) {
+			responses[strconv.Itoa(status)] = map[string]any{
+				"description": http.StatusText(status),
+				"content":     map[string]any{"application/json": map[string]any{"schema": errorRef}},
+			}
+		}
+		op["responses"] = responses
+
+		if paths[rt.Path] == nil {
+			paths[rt.Path] = map[string]any{}
+		}
+		paths[rt.Path].(map[string]any)[strings.ToLower(rt.Method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Event Messenger API",
+			"version":     "1",
+			"description": "Create and manage events, add submissions and check delivery.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": b.schemas,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+				"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+		"security": []any{
+			map[string]any{"bearer": []string{}},
+			map[string]any{"apiKey": []string{}},
+		},
+	}
+}
+
+// operationID is a route's handler function name, e.g. listEvents
+func operationID(handler http.HandlerFunc) string {
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// schema describes t, adding it to the named schemas if it's one of them
+func (b *specBuilder) schema(t reflect.Type) map[string]any {
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Pointer:
+		elem := b.schema(t.Elem())
+		if _, ok := elem["$ref"]; ok {
+			return map[string]any{"allOf": []any{elem}, "nullable": true}
+		}
+		elem["nullable"] = true
+		return elem
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return map[string]any{"type": "string", "format": "binary"}
+	case t.Kind() == reflect.Slice:
+		return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+	case t.Kind() == reflect.String:
+		return map[string]any{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]any{"type": "number"}
+	case t.Kind() == reflect.Struct:
+		name, named := schemaNames[t]
+		if !named {
+			return b.object(t, "")
+		}
+		if _, done := b.schemas[name]; !done {
+			b.schemas[name] = nil // placeholder, in case t refers to itself
+			b.schemas[name] = b.object(t, name)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	panic("api: no OpenAPI schema for " + t.String())
+}
+
+// object describes a struct from its JSON field tags. Fields without
+// omitempty are always sent, so they're required.
+func (b *specBuilder) object(t reflect.Type, name string) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if tag == "-" || !field.IsExported() {
+			continue
+		}
+		if tag == "" {
+			tag = field.Name
+		}
+
+		prop := b.schema(field.Type)
+		if values, ok := enums[name+"."+tag]; ok {
+			prop["enum"] = values
+		}
+		if format, ok := formats[name+"."+tag]; ok {
+			prop["format"] = format
+		}
+		properties[tag] = prop
+
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, tag)
+		}
+	}
+
+	s := map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+	if required != nil {
+		s["required"] = required
+	}
+	return s
+}
+
+var (
+	specOnce sync.Once
+	spec     map[string]any
+)
+
+// openAPI returns the document, built the first time it's needed
+func openAPI() map[string]any {
+	specOnce.Do(func() { spec = buildSpec() })
+	return spec
+}
+
+// serveSpec serves the OpenAPI document, with this server as its base URL
+func serveSpec(w http.ResponseWriter, r *http.Request) {
+	doc := map[string]any{}
+	for k, v := range openAPI() {
+		doc[k] = v
+	}
+	doc["servers"] = []any{map[string]any{"url": utils.GetBaseURL(r)}}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, http.StatusOK, doc)
+}
+
+// checkResponse wraps a route's handler so its responses are checked against
+// the OpenAPI document
+func checkResponse(rt route, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		for _, problem := range specProblems(rt, rec.status, rec.body.Bytes()) {
+			log.Printf("API response doesn't match the OpenAPI document: %s %s: %s", rt.Method, rt.Path, problem)
+		}
+	})
+}
+
+// responseRecorder keeps a copy of a response while it's written
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
+
+// specProblems lists how a response differs from what the document says
+// the route answers with
+func specProblems(rt route, status int, body []byte) []string {
+	op := openAPI()["paths"].(map[string]any)[rt.Path].(map[string]any)[strings.ToLower(rt.Method)].(map[string]any)
+	response, ok := op["responses"].(map[string]any)[strconv.Itoa(status)].(map[string]any)
+	if !ok {
+		return []string{fmt.Sprintf("status %d isn't documented", status)}
+	}
+
+	content, ok := response["content"].(map[string]any)
+	if !ok {
+		if len(body) > 0 {
+			return []string{fmt.Sprintf("status %d should have no body", status)}
+		}
+		return nil
+	}
+
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return []string{fmt.Sprintf("body isn't JSON: %v", err)}
+	}
+	schema := content["application/json"].(map[string]any)["schema"].(map[string]any)
+	return validate(value, schema, "body")
+}
+
+// validate lists how a decoded JSON value differs from schema. It covers
+// the parts of JSON Schema that buildSpec writes.
+func validate(value any, schema map[string]any, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		schema = openAPI()["components"].(map[string]any)["schemas"].(map[string]any)[name].(map[string]any)
+	}
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		return []string{path + " is null"}
+	}
+	if allOf, ok := schema["allOf"].([]any); ok {
+		var problems []string
+		for _, s := range allOf {
+			problems = append(problems, validate(value, s.(map[string]any), path)...)
+		}
+		return problems
+	}
+
+	var problems []string
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return []string{path + " isn't an object"}
+		}
+		properties := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]string)
+		for _, name := range required {
+			if _, ok := obj[name]; !ok {
+				problems = append(problems, path+"."+name+" is missing")
+			}
+		}
+		var names []string
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := properties[name].(map[string]any)
+			if !ok {
+				problems = append(problems, path+"."+name+" isn't documented")
+				continue
+			}
+			problems = append(problems, validate(obj[name], prop, path+"."+name)...)
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return []string{path + " isn't an array"}
+		}
+		for i, item := range items {
+			problems = append(problems, validate(item, schema["items"].(map[string]any), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return []string{path + " isn't a string"}
+		}
+		if values, ok := schema["enum"].([]string); ok && !contains(values, s) {
+			problems = append(problems, fmt.Sprintf("%s is %q, which isn't one of %q", path, s, values))
+		}
+		switch schema["format"] {
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				problems = append(problems, path+" isn't a date-time")
+			}
+		case "date":
+			if _, err := time.Parse(dateLayout, s); err != nil {
+				problems = append(problems, path+" isn't a date")
+			}
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			problems = append(problems, path+" isn't an integer")
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			problems = append(problems, path+" isn't a number")
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			problems = append(problems, path+" isn't a boolean")
+		}
+	}
+	return problems
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
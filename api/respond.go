@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"event-messenger.com/handlers"
+)
+
+// Pagination limits for list endpoints
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// errorCodes are the machine-readable codes sent with each error status
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusUnprocessableEntity:   "unprocessable",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+// errorBody is the body of every error response
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// list is the body of every list response
+type list[T any] struct {
+	Data       []T        `json:"data"`
+	Pagination pagination `json:"pagination"`
+}
+
+type pagination struct {
+	Limit      int  `json:"limit"`
+	Offset     int  `json:"offset"`
+	Total      int  `json:"total"`
+	NextOffset *int `json:"next_offset"` // null on the last page
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("API response error: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	code, ok := errorCodes[status]
+	if !ok {
+		code = "error"
+	}
+	writeJSON(w, status, errorBody{Error: errorDetail{Code: code, Message: message}})
+}
+
+// writeErr answers with a handlers.RequestError's status and message; any
+// other error is logged and answered with a 500
+func writeErr(w http.ResponseWriter, err error) {
+	var reqErr *handlers.RequestError
+	if errors.As(err, &reqErr) {
+		if reqErr.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(reqErr.RetryAfter))
+		}
+		writeError(w, reqErr.Status, reqErr.Message)
+		return
+	}
+
+	log.Printf("API error: %v", err)
+	writeError(w, http.StatusInternalServerError, "Internal server error")
+}
+
+func writeList[T any](w http.ResponseWriter, data []T, limit, offset, total int) {
+	p := pagination{Limit: limit, Offset: offset, Total: total}
+	if next := offset + limit; next < total {
+		p.NextOffset = &next
+	}
+	writeJSON(w, http.StatusOK, list[T]{Data: data, Pagination: p})
+}
+
+// readPage reads the limit and offset query parameters. It writes the error
+// response itself when ok is false.
+func readPage(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	limit, offset := DefaultPageSize, 0
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > MaxPageSize {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(MaxPageSize))
+			return 0, 0, false
+		}
+		limit = n
+	}
+
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "offset must be zero or more")
+			return 0, 0, false
+		}
+		offset = n
+	}
+
+	return limit, offset, true
+}
+
+// readJSON decodes a JSON request body of at most maxBytes, rejecting
+// unknown fields. It writes the error response itself when it returns false.
+func readJSON(w http.ResponseWriter, r *http.Request, maxBytes int64, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "Request body is too large")
+			return false
+		}
+		writeError(w, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/base64"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"event-messenger.com/handlers"
+	"event-messenger.com/models"
+	"event-messenger.com/utils"
+)
+
+// maxSubmissionBody limits submissions sent as JSON; base64 makes the photo
+// about a third larger than handlers.MaxFileSize
+const maxSubmissionBody = handlers.MaxFileSize*4/3 + 64<<10
+
+// submissionJSON is a submission as the API returns it
+type submissionJSON struct {
+	ID                   int       `json:"id"`
+	Name                 string    `json:"name"`
+	Message              string    `json:"message"`
+	Moderation           string    `json:"moderation"`
+	ImageStatus          string    `json:"image_status"`
+	ImageURL             string    `json:"image_url,omitempty"`
+	MediaURL             string    `json:"media_url,omitempty"`
+	MediaType            string    `json:"media_type,omitempty"`
+	MediaDurationSeconds float64   `json:"media_duration_seconds,omitempty"`
+	ContributorEmail     string    `json:"contributor_email,omitempty"`
+	EditURL              string    `json:"edit_url,omitempty"` // only when the submission is created
+	CreatedAt            time.Time `json:"created_at"`
+}
+
+func newSubmissionJSON(r *http.Request, s *models.Submission) submissionJSON {
+	baseURL := utils.GetBaseURL(r)
+	out := submissionJSON{
+		ID:               s.ID,
+		Name:             s.Name,
+		Message:          s.Message,
+		Moderation:       s.Moderation,
+		ImageStatus:      s.Status,
+		MediaType:        s.MediaType,
+		ContributorEmail: s.ContributorEmail,
+		CreatedAt:        s.CreatedAt,
+	}
+	if s.Status == models.SubmissionReady && s.Filename != "" {
+		out.ImageURL = baseURL + "/uploads/" + s.Filename
+	}
+	if s.MediaFilename != "" {
+		out.MediaURL = baseURL + "/uploads/" + s.MediaFilename
+		out.MediaDurationSeconds = s.MediaDuration.Seconds()
+	}
+	return out
+}
+
+// submissionInput is the body of a submission sent as JSON
+type submissionInput struct {
+	Name             string `json:"name"`
+	Message          string `json:"message,omitempty"`
+	ContributorEmail string `json:"contributor_email,omitempty"`
+	ImageBase64      string `json:"image_base64,omitempty"`
+	ImageFilename    string `json:"image_filename,omitempty"`
+}
+
+// submissionForm documents the multipart fields of a submission, which are
+// the submission form's own
+type submissionForm struct {
+	Name          string `json:"name"`
+	Message       string `json:"message,omitempty"`
+	Email         string `json:"email,omitempty"`
+	Image         []byte `json:"image,omitempty"`
+	Media         []byte `json:"media,omitempty"`
+	UploadID      string `json:"upload_id,omitempty"`       // a finished resumable upload to use as the image
+	MediaUploadID string `json:"media_upload_id,omitempty"` // a finished resumable upload to use as the recording
+}
+
+func listSubmissions(w http.ResponseWriter, r *http.Request) {
+	event := lookupEvent(w, r)
+	if event == nil {
+		return
+	}
+
+	limit, offset, ok := readPage(w, r)
+	if !ok {
+		return
+	}
+
+	moderation := r.URL.Query().Get("moderation")
+	switch moderation {
+	case "", models.ModerationPending, models.ModerationApproved, models.ModerationRejected:
+	default:
+		writeError(w, http.StatusBadRequest, "moderation must be pending, approved or rejected")
+		return
+	}
+
+	submissions, total, err := models.ListSubmissions(event.ID, moderation, limit, offset)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	data := make([]submissionJSON, 0, len(submissions))
+	for i := range submissions {
+		data = append(data, newSubmissionJSON(r, &submissions[i]))
+	}
+	writeList(w, data, limit, offset, total)
+}
+
+// createSubmission accepts the same multipart fields as the submission form,
+// or a JSON body with the photo base64-encoded
+func createSubmission(w http.ResponseWriter, r *http.Request) {
+	event := lookupEvent(w, r)
+	if event == nil {
+		return
+	}
+	if !event.Active {
+		writeError(w, http.StatusConflict, "The event has already been delivered")
+		return
+	}
+
+	var in handlers.NewSubmission
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		r.Body = http.MaxBytesReader(w, r.Body, handlers.MaxResumableUploadSize+handlers.MaxFileSize)
+		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid multipart body")
+			return
+		}
+
+		var err error
+		in.Name, in.Message, in.Email = r.FormValue("name"), r.FormValue("message"), r.FormValue("email")
+		if in.Image, err = handlers.ReadSubmittedFile(r, "image", "upload_id", handlers.MaxFileSize); err != nil {
+			writeErr(w, err)
+			return
+		}
+		if in.Recording, err = handlers.ReadSubmittedFile(r, "media", "media_upload_id", event.MediaMaxBytes); err != nil {
+			writeErr(w, err)
+			return
+		}
+
+	case "application/json":
+		var body submissionInput
+		if !readJSON(w, r, maxSubmissionBody, &body) {
+			return
+		}
+
+		in.Name, in.Message, in.Email = body.Name, body.Message, body.ContributorEmail
+		if body.ImageBase64 != "" {
+			data, err := base64.StdEncoding.DecodeString(body.ImageBase64)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "image_base64 is not valid base64")
+				return
+			}
+			in.Image = handlers.SubmittedFile{Data: data, Filename: body.ImageFilename}
+			if in.Image.Filename == "" {
+				in.Image.Filename = "upload"
+			}
+		}
+
+	default:
+		writeError(w, http.StatusUnsupportedMediaType, "Send multipart/form-data or application/json")
+		return
+	}
+
+	submission, err := handlers.CreateSubmission(event, in, utils.GetBaseURL(r))
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	log.Printf("API client %s added submission %d to event %s", clientName(r), submission.ID, event.Slug)
+
+	body := newSubmissionJSON(r, submission)
+	body.EditURL = handlers.EditLink(utils.GetBaseURL(r), event.Slug, submission.EditToken)
+	writeJSON(w, http.StatusCreated, body)
+}
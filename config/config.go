@@ -2,13 +2,35 @@ package config
 
 import (
 	"os"
+	"runtime"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type AppConfig struct {
 	BaseURL    string
 	ServerPort string
 	DBPath     string
+	ThemeDir   string // optional templates/ and static/ overriding the embedded ones
+	APIKeys    string // comma-separated keys (optionally name:key) for /api/v1; the API is off without one
+	AdminPass  string // password for /admin; the admin pages are off without one
+
+	InviteReminderDays []int // days before delivery that invitees who haven't sent a message are reminded
+}
+
+type ImageConfig struct {
+	ImageWorkers   int // number of goroutines resizing uploads
+	ImageQueueSize int // uploads allowed to wait for a worker before new ones are refused
+}
+
+type SpamConfig struct {
+	TrustedProxies     string // comma-separated IPs/CIDRs whose X-Forwarded-For is believed
+	SubmitIPBurst      int    // submissions one address can make back to back
+	SubmitIPPerHour    int    // rate one address's allowance refills at
+	SubmitEventBurst   int    // submissions one event can take back to back
+	SubmitEventPerHour int    // rate one event's allowance refills at
+	PowDifficulty      int    // leading zero bits the submission form's proof of work needs
 }
 
 type EmailConfig struct {
@@ -17,11 +39,28 @@ type EmailConfig struct {
 	SMTPUsername string
 	SMTPPassword string
 	FromEmail    string
+	SMTPTLS      string // auto, implicit, starttls, opportunistic or none (see mailer)
+	SMTPAuth     string // auto, plain, login, cram-md5 or none
+
+	SMTPConnectTimeout time.Duration // for the TCP connection and TLS handshake
+	SMTPTimeout        time.Duration // for each read or write once connected
+
+	DKIMDomain   string // d= in the DKIM signature; signing is off without it
+	DKIMSelector string // s=, naming the DNS record with the public key
+	DKIMKeyPath  string // PEM file with the RSA or Ed25519 private key
+
+	MailTransport string // smtp, or outbox to write messages to OutboxDir instead
+	OutboxDir     string
+
+	DevSMTP     bool // run the development SMTP server and send everything to it
+	DevSMTPPort int
 }
 
 type Config struct {
 	EmailConfig
 	AppConfig
+	ImageConfig
+	SpamConfig
 }
 
 var App *Config
@@ -32,11 +71,19 @@ func LoadConfigs() {
 		port = 587
 	}
 
+	// Leave half the CPUs free for serving requests
+	defaultWorkers := max(1, runtime.NumCPU()/2)
+
 	App = &Config{
 		AppConfig: AppConfig{
 			BaseURL:    getEnv("BASE_URL", "http://localhost:8080"),
 			ServerPort: getEnv("WEB_PORT", "8080"),
 			DBPath:     getEnv("DB_PATH", "./data/app.db"),
+			ThemeDir:   getEnv("THEME_DIR", ""),
+			APIKeys:    getEnv("API_KEYS", ""),
+			AdminPass:  getEnv("ADMIN_PASSWORD", ""),
+
+			InviteReminderDays: getEnvDays("INVITE_REMINDER_DAYS", "3,1"),
 		},
 		EmailConfig: EmailConfig{
 			SMTPServer:   getEnv("SMTP_SERVER", "smtp.gmail.com"),
@@ -44,14 +91,78 @@ func LoadConfigs() {
 			SMTPUsername: getEnv("SMTP_USERNAME", ""),
 			SMTPPassword: getEnv("SMTP_PASSWORD", ""),
 			FromEmail:    getEnv("SMTP_FROM_EMAIL", ""),
+			SMTPTLS:      strings.ToLower(getEnv("SMTP_TLS", "auto")),
+			SMTPAuth:     strings.ToLower(getEnv("SMTP_AUTH", "auto")),
+
+			SMTPConnectTimeout: time.Duration(getEnvInt("SMTP_CONNECT_TIMEOUT", 10)) * time.Second,
+			SMTPTimeout:        time.Duration(getEnvInt("SMTP_TIMEOUT", 60)) * time.Second,
+
+			DKIMDomain:   getEnv("DKIM_DOMAIN", ""),
+			DKIMSelector: getEnv("DKIM_SELECTOR", ""),
+			DKIMKeyPath:  getEnv("DKIM_PRIVATE_KEY", ""),
+
+			MailTransport: strings.ToLower(getEnv("MAIL_TRANSPORT", "smtp")),
+			OutboxDir:     getEnv("MAIL_OUTBOX_DIR", "./data/outbox"),
+
+			DevSMTP:     getEnvBool("DEV_SMTP"),
+			DevSMTPPort: getEnvInt("DEV_SMTP_PORT", 2525),
+		},
+		ImageConfig: ImageConfig{
+			ImageWorkers:   getEnvInt("IMAGE_WORKERS", defaultWorkers),
+			ImageQueueSize: getEnvInt("IMAGE_QUEUE_SIZE", 64),
+		},
+		SpamConfig: SpamConfig{
+			TrustedProxies:     getEnv("TRUSTED_PROXIES", ""),
+			SubmitIPBurst:      getEnvInt("SUBMIT_IP_BURST", 5),
+			SubmitIPPerHour:    getEnvInt("SUBMIT_IP_PER_HOUR", 30),
+			SubmitEventBurst:   getEnvInt("SUBMIT_EVENT_BURST", 30),
+			SubmitEventPerHour: getEnvInt("SUBMIT_EVENT_PER_HOUR", 600),
+			PowDifficulty:      getEnvInt("POW_DIFFICULTY", 16),
 		},
 	}
 
 }
 
+// UseDevSMTP points outgoing email at the development SMTP server, which
+// needs neither TLS nor a login
+func (c *Config) UseDevSMTP() {
+	c.SMTPServer = "localhost"
+	c.SMTPPort = c.DevSMTPPort
+	c.SMTPTLS = "none"
+	c.SMTPAuth = "none"
+	c.MailTransport = "smtp"
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
+
+// getEnvBool treats anything but unset, "0" and "false" as on
+func getEnvBool(key string) bool {
+	value := strings.ToLower(getEnv(key, ""))
+	return value != "" && value != "0" && value != "false"
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvDays reads a comma-separated list of day counts such as "3,1",
+// leaving out anything that isn't a positive number; "none" turns it off
+func getEnvDays(key, defaultValue string) []int {
+	var days []int
+	for _, field := range strings.Split(getEnv(key, defaultValue), ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err == nil && n > 0 {
+			days = append(days, n)
+		}
+	}
+	return days
+}
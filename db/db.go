@@ -55,7 +55,18 @@ func createTables() {
 		email_sent BOOLEAN DEFAULT 0,
 		email_sent_at DATETIME,
         website_link TEXT,
-        created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+        created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        media_max_seconds INTEGER NOT NULL DEFAULT 60,
+        media_max_bytes INTEGER NOT NULL DEFAULT 26214400,
+        moderated BOOLEAN NOT NULL DEFAULT FALSE,
+        manage_token_hash TEXT,
+        filter_action TEXT NOT NULL DEFAULT '',
+        filter_words TEXT NOT NULL DEFAULT '',
+        notify_channel TEXT NOT NULL DEFAULT 'email',
+        notify_url TEXT NOT NULL DEFAULT '',
+        digest_days TEXT NOT NULL DEFAULT '3,1',
+        digest_sent_at DATETIME,
+        digest_token_hash TEXT
     );`
 
 	// Create submissions table
@@ -65,6 +76,100 @@ func createTables() {
         name TEXT NOT NULL,
         message TEXT NOT NULL,
         filename TEXT,
+        status TEXT NOT NULL DEFAULT 'ready',
+        original_filename TEXT,
+        processing_error TEXT,
+        media_filename TEXT,
+        media_type TEXT,
+        media_duration_ms INTEGER,
+        contributor_email TEXT,
+        edit_token_hash TEXT,
+        moderation TEXT NOT NULL DEFAULT 'approved',
+        created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE
+    );`
+
+	// Create resumable (tus) uploads table
+	createUploadsTable := `CREATE TABLE IF NOT EXISTS uploads (
+        id TEXT PRIMARY KEY,
+        length INTEGER NOT NULL,
+        upload_offset INTEGER NOT NULL DEFAULT 0,
+        metadata TEXT NOT NULL DEFAULT '',
+        expires_at DATETIME NOT NULL,
+        completed_at DATETIME,
+        client TEXT NOT NULL DEFAULT '',
+        created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );`
+
+	// Create webhook subscriptions and their delivery log
+	createWebhooksTable := `CREATE TABLE IF NOT EXISTS webhooks (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        url TEXT NOT NULL,
+        secret TEXT NOT NULL,
+        events TEXT NOT NULL DEFAULT '',
+        active BOOLEAN NOT NULL DEFAULT TRUE,
+        created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );`
+
+	createWebhookDeliveriesTable := `CREATE TABLE IF NOT EXISTS webhook_deliveries (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        webhook_id INTEGER NOT NULL,
+        event_type TEXT NOT NULL,
+        payload TEXT NOT NULL,
+        status TEXT NOT NULL DEFAULT 'pending',
+        attempts INTEGER NOT NULL DEFAULT 0,
+        next_attempt_at DATETIME NOT NULL,
+        response_status INTEGER,
+        error TEXT,
+        replay_of INTEGER,
+        created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        completed_at DATETIME,
+        FOREIGN KEY (webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+    );`
+
+	// Create the people each event's messages are sent to
+	createEventRecipientsTable := `CREATE TABLE IF NOT EXISTS event_recipients (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        event_id INTEGER NOT NULL,
+        name TEXT NOT NULL DEFAULT '',
+        email TEXT NOT NULL,
+        role TEXT NOT NULL DEFAULT 'to',
+        status TEXT NOT NULL DEFAULT 'pending',
+        error TEXT NOT NULL DEFAULT '',
+        sent_at DATETIME,
+        created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE
+    );`
+
+	// Create the history of each event's deliveries
+	createEventDeliveriesTable := `CREATE TABLE IF NOT EXISTS event_deliveries (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        event_id INTEGER NOT NULL,
+        triggered_by TEXT NOT NULL,
+        channel TEXT NOT NULL,
+        sent_to TEXT NOT NULL DEFAULT '',
+        status TEXT NOT NULL,
+        error TEXT NOT NULL DEFAULT '',
+        request_key TEXT,
+        created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE
+    );`
+
+	// Create the people coordinators invite to send a message
+	createEventInviteesTable := `CREATE TABLE IF NOT EXISTS event_invitees (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        event_id INTEGER NOT NULL,
+        name TEXT NOT NULL DEFAULT '',
+        email TEXT NOT NULL COLLATE NOCASE,
+        token TEXT NOT NULL,
+        invited_at DATETIME,
+        reminders_sent INTEGER NOT NULL DEFAULT 0,
+        reminded_at DATETIME,
+        submission_id INTEGER,
+        submitted_at DATETIME,
+        unsubscribed_at DATETIME,
+        error TEXT NOT NULL DEFAULT '',
+        attempts INTEGER NOT NULL DEFAULT 0,
         created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
         FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE
     );`
@@ -75,6 +180,16 @@ func createTables() {
         CREATE INDEX IF NOT EXISTS idx_events_active ON events(active);
         CREATE INDEX IF NOT EXISTS idx_submissions_event_id ON submissions(event_id);
         CREATE INDEX IF NOT EXISTS idx_submissions_created_at ON submissions(created_at);
+        CREATE INDEX IF NOT EXISTS idx_submissions_status ON submissions(status);
+        CREATE INDEX IF NOT EXISTS idx_submissions_moderation ON submissions(event_id, moderation);
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_submissions_edit_token_hash ON submissions(edit_token_hash);
+        CREATE INDEX IF NOT EXISTS idx_uploads_expires_at ON uploads(expires_at);
+        CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+        CREATE INDEX IF NOT EXISTS idx_event_recipients_event_id ON event_recipients(event_id);
+        CREATE INDEX IF NOT EXISTS idx_event_deliveries_event_id ON event_deliveries(event_id);
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_event_deliveries_request_key ON event_deliveries(request_key);
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_event_invitees_email ON event_invitees(event_id, email);
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_event_invitees_token ON event_invitees(token);
     `
 
 	_, err := DB.Exec(createEventsTable)
@@ -87,6 +202,71 @@ func createTables() {
 		panic("could not create Submissions table")
 	}
 
+	_, err = DB.Exec(createUploadsTable)
+	if err != nil {
+		panic("could not create Uploads table")
+	}
+
+	_, err = DB.Exec(createWebhooksTable)
+	if err != nil {
+		panic("could not create Webhooks table")
+	}
+
+	_, err = DB.Exec(createWebhookDeliveriesTable)
+	if err != nil {
+		panic("could not create Webhook Deliveries table")
+	}
+
+	_, err = DB.Exec(createEventRecipientsTable)
+	if err != nil {
+		panic("could not create Event Recipients table")
+	}
+
+	_, err = DB.Exec(createEventDeliveriesTable)
+	if err != nil {
+		panic("could not create Event Deliveries table")
+	}
+
+	_, err = DB.Exec(createEventInviteesTable)
+	if err != nil {
+		panic("could not create Event Invitees table")
+	}
+
+	// Columns added after the original schema, for databases created by older versions
+	addColumn("submissions", "status", "TEXT NOT NULL DEFAULT 'ready'")
+	addColumn("submissions", "original_filename", "TEXT")
+	addColumn("submissions", "processing_error", "TEXT")
+	addColumn("submissions", "media_filename", "TEXT")
+	addColumn("submissions", "media_type", "TEXT")
+	addColumn("submissions", "media_duration_ms", "INTEGER")
+	addColumn("submissions", "contributor_email", "TEXT")
+	addColumn("submissions", "edit_token_hash", "TEXT")
+	addColumn("submissions", "moderation", "TEXT NOT NULL DEFAULT 'approved'")
+	addColumn("events", "media_max_seconds", "INTEGER NOT NULL DEFAULT 60")
+	addColumn("events", "media_max_bytes", "INTEGER NOT NULL DEFAULT 26214400")
+	addColumn("events", "moderated", "BOOLEAN NOT NULL DEFAULT FALSE")
+	addColumn("events", "manage_token_hash", "TEXT")
+	addColumn("events", "filter_action", "TEXT NOT NULL DEFAULT ''")
+	addColumn("events", "filter_words", "TEXT NOT NULL DEFAULT ''")
+	addColumn("events", "notify_channel", "TEXT NOT NULL DEFAULT 'email'")
+	addColumn("events", "notify_url", "TEXT NOT NULL DEFAULT ''")
+	addColumn("uploads", "client", "TEXT NOT NULL DEFAULT ''")
+	addColumn("events", "digest_days", "TEXT NOT NULL DEFAULT '3,1'")
+	addColumn("events", "digest_sent_at", "DATETIME")
+	addColumn("events", "digest_token_hash", "TEXT")
+	addColumn("event_invitees", "attempts", "INTEGER NOT NULL DEFAULT 0")
+
+	// Events from before event_recipients had their one recipient on the event row
+	_, err = DB.Exec(`INSERT INTO event_recipients (event_id, name, email, role, status, sent_at)
+        SELECT id, COALESCE(recipient_name, ''), recipient_email, 'to',
+               CASE WHEN email_sent THEN 'sent' ELSE 'pending' END, email_sent_at
+        FROM events e
+        WHERE COALESCE(recipient_email, '') != ''
+          AND NOT EXISTS (SELECT 1 FROM event_recipients r WHERE r.event_id = e.id)`)
+	if err != nil {
+		panic(fmt.Sprintf("could not copy event recipients: %v", err))
+	}
+
 	_, err = DB.Exec(createIndexes)
 	if err != nil {
 		log.Printf("Warning: could not create indexes: %v", err)
@@ -94,3 +274,36 @@ func createTables() {
 
 	slog.Debug("Database initialized successfully")
 }
+
+// addColumn adds a column to an existing table if it isn't there yet
+func addColumn(table, column, definition string) {
+	rows, err := DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		panic(fmt.Sprintf("could not read %s table schema: %v", table, err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    bool
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			panic(fmt.Sprintf("could not read %s table schema: %v", table, err))
+		}
+		if name == column {
+			return
+		}
+	}
+	rows.Close()
+
+	_, err = DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		panic(fmt.Sprintf("could not add column %s.%s: %v", table, column, err))
+	}
+	slog.Debug("Added database column", "table", table, "column", column)
+}
@@ -0,0 +1,247 @@
+// Package devsmtp is an SMTP server for development that accepts any mail
+// and keeps it in memory to be looked at, instead of delivering it
+package devsmtp
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	keepMessages   = 200             // older messages are forgotten
+	maxMessageSize = 50 << 20        // 50 MB, well above what the notifier sends
+	commandTimeout = 5 * time.Minute // how long a client may sit idle
+)
+
+// Message is one message the sink received
+type Message struct {
+	ID       int
+	Received time.Time
+	From     string   // the envelope sender
+	To       []string // the envelope recipients
+	Raw      []byte
+}
+
+var (
+	mu       sync.Mutex
+	messages []*Message // oldest first
+	nextID   = 1
+	running  bool
+)
+
+// Start listens on 127.0.0.1:port and accepts mail until the program exits.
+// It returns the port it's listening on, which is a free one if port is 0.
+func Start(port int) (int, error) {
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return 0, fmt.Errorf("error starting development SMTP server: %w", err)
+	}
+
+	mu.Lock()
+	running = true
+	mu.Unlock()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				slog.Error("Development SMTP server stopped", "error", err)
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+// Running reports whether Start has been called, so /dev/mail can stay
+// hidden otherwise
+func Running() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return running
+}
+
+// Messages returns what has been received, newest first
+func Messages() []*Message {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := make([]*Message, len(messages))
+	for i, m := range messages {
+		list[len(messages)-1-i] = m
+	}
+	return list
+}
+
+// Get returns the message with the given ID, if it's still kept
+func Get(id int) (*Message, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, m := range messages {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// Clear forgets every message
+func Clear() {
+	mu.Lock()
+	defer mu.Unlock()
+	messages = nil
+}
+
+func store(from string, to []string, raw []byte) int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	m := &Message{ID: nextID, Received: time.Now(), From: from, To: to, Raw: raw}
+	nextID++
+	messages = append(messages, m)
+	if len(messages) > keepMessages {
+		messages = messages[len(messages)-keepMessages:]
+	}
+	return m.ID
+}
+
+// serve speaks just enough SMTP for net/smtp: any login is accepted and
+// every message is kept
+func serve(conn net.Conn) {
+	defer conn.Close()
+	tc := textproto.NewConn(&deadlineConn{conn})
+
+	var from string
+	var to []string
+	inMail := false // whether MAIL has started a message; the sender may be empty
+	reply := func(code int, text string) error { return tc.PrintfLine("%d %s", code, text) }
+
+	if reply(220, "event-messenger development SMTP server") != nil {
+		return
+	}
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			err = tc.PrintfLine("250-localhost\r\n250-8BITMIME\r\n250-SIZE %d\r\n250 AUTH PLAIN LOGIN", maxMessageSize)
+		case "HELO":
+			err = reply(250, "localhost")
+		case "AUTH":
+			err = acceptAuth(tc, arg)
+		case "MAIL":
+			from, to, inMail = address(arg), nil, true
+			err = reply(250, "OK")
+		case "RCPT":
+			if !inMail {
+				err = reply(503, "MAIL first")
+				break
+			}
+			to = append(to, address(arg))
+			err = reply(250, "OK")
+		case "DATA":
+			if len(to) == 0 {
+				err = reply(503, "RCPT first")
+				break
+			}
+			if err = reply(354, "End data with <CR><LF>.<CR><LF>"); err != nil {
+				break
+			}
+			data := tc.DotReader()
+			raw, readErr := io.ReadAll(io.LimitReader(data, maxMessageSize+1))
+			if readErr != nil {
+				return
+			}
+			if len(raw) > maxMessageSize {
+				io.Copy(io.Discard, data)
+				err = reply(552, "Message too large")
+				break
+			}
+			id := store(from, to, raw)
+			slog.Info("Development SMTP server caught a message", "id", id, "to", strings.Join(to, ", "))
+			from, to, inMail = "", nil, false
+			err = reply(250, fmt.Sprintf("OK: queued as %d", id))
+		case "RSET":
+			from, to, inMail = "", nil, false
+			err = reply(250, "OK")
+		case "NOOP":
+			err = reply(250, "OK")
+		case "QUIT":
+			reply(221, "Bye")
+			return
+		default:
+			err = reply(502, "Command not implemented")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// acceptAuth goes through the PLAIN or LOGIN exchange and lets anyone in
+func acceptAuth(tc *textproto.Conn, arg string) error {
+	mech, initial, _ := strings.Cut(arg, " ")
+	switch strings.ToUpper(mech) {
+	case "PLAIN":
+		if initial == "" {
+			if err := tc.PrintfLine("334 "); err != nil {
+				return err
+			}
+			if _, err := tc.ReadLine(); err != nil {
+				return err
+			}
+		}
+	case "LOGIN":
+		// "Username:" and "Password:" in base64
+		for _, prompt := range []string{"VXNlcm5hbWU6", "UGFzc3dvcmQ6"} {
+			if err := tc.PrintfLine("334 %s", prompt); err != nil {
+				return err
+			}
+			if _, err := tc.ReadLine(); err != nil {
+				return err
+			}
+		}
+	default:
+		return tc.PrintfLine("504 Unrecognized authentication type")
+	}
+	return tc.PrintfLine("235 Authentication successful")
+}
+
+// address takes the mailbox out of "FROM:<ann@example.com> SIZE=123"
+func address(arg string) string {
+	start := strings.IndexByte(arg, '<')
+	end := strings.IndexByte(arg, '>')
+	if start < 0 || end < start {
+		_, addr, _ := strings.Cut(arg, ":")
+		return strings.TrimSpace(addr)
+	}
+	return arg[start+1 : end]
+}
+
+// deadlineConn gives every read and write its own deadline, so an idle
+// client doesn't hold a goroutine forever
+type deadlineConn struct {
+	net.Conn
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(commandTimeout))
+	return c.Conn.Read(b)
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(commandTimeout))
+	return c.Conn.Write(b)
+}
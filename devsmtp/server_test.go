@@ -0,0 +1,103 @@
+package devsmtp_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"event-messenger.com/config"
+	"event-messenger.com/devsmtp"
+	"event-messenger.com/internal/testutil"
+	"event-messenger.com/mailer"
+	"event-messenger.com/models"
+	"event-messenger.com/notify"
+	"event-messenger.com/utils"
+)
+
+// A delivery email, with an attachment added, goes through the mailer's SMTP
+// client and the development server and reads back the same
+func TestCatchesNotificationEmail(t *testing.T) {
+	testutil.Setup(t)
+	port, err := devsmtp.Start(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port == 0 || !devsmtp.Running() {
+		t.Fatalf("Start returned port %d, running %v", port, devsmtp.Running())
+	}
+	config.App.DevSMTPPort = port
+	config.App.UseDevSMTP()
+	config.App.FromEmail = "events@example.com"
+	config.App.SMTPConnectTimeout = 5 * time.Second
+	config.App.SMTPTimeout = 5 * time.Second
+	if err := mailer.Configure(config.App.EmailConfig); err != nil {
+		t.Fatal(err)
+	}
+	devsmtp.Clear()
+
+	event := models.NewEvent("Farewell", "farewell", time.Now(), models.WithRecipient("Alex", "alex@example.com"))
+	if err := event.SaveEvent(); err != nil {
+		t.Fatal(err)
+	}
+	if err := event.SaveRecipients([]models.Recipient{{Name: "Alex", Email: "alex@example.com", Role: models.RoleTo}}); err != nil {
+		t.Fatal(err)
+	}
+	// A line of just a dot ends an SMTP message unless it's escaped
+	s := &models.Submission{EventID: event.ID, Name: "Zoë", Message: "Good luck!\n.\n.. and see you soon"}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := notify.Preview(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte("\r\n.\r\n\x00\xff"), 200)...)
+	msg.Attachments = []mailer.Attachment{{Filename: "photo.png", ContentType: "image/png", Data: photo}}
+	if err := utils.SendEmail("alex@example.com", msg); err != nil {
+		t.Fatal(err)
+	}
+
+	caught := devsmtp.Messages()
+	if len(caught) != 1 {
+		t.Fatalf("caught %d messages, want 1", len(caught))
+	}
+	m := caught[0]
+	if m.From != "events@example.com" || strings.Join(m.To, ",") != "alex@example.com" {
+		t.Errorf("envelope from %s to %v", m.From, m.To)
+	}
+	// The server reads DATA with a textproto.DotReader, which unstuffs dots
+	// and turns CRLF line endings into LF
+	if want := bytes.ReplaceAll(msg.Bytes(), []byte("\r\n"), []byte("\n")); !bytes.Equal(m.Raw, want) {
+		t.Errorf("message changed on the way: %d bytes, want %d", len(m.Raw), len(want))
+	}
+	if got, ok := devsmtp.Get(m.ID); !ok || got != m {
+		t.Errorf("Get(%d) = %v, %v", m.ID, got, ok)
+	}
+
+	p, err := mailer.Parse(m.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Subject != "Your Farewell Messages" || p.To != `"Alex" <alex@example.com>` {
+		t.Errorf("headers read back as To %q, Subject %q", p.To, p.Subject)
+	}
+	if p.Text != msg.Text || !strings.Contains(p.Text, "Good luck!\n.\n.. and see you soon") {
+		t.Errorf("text read back as %q, want %q", p.Text, msg.Text)
+	}
+	if p.HTML != msg.HTML || !strings.Contains(p.HTML, "Zoë") {
+		t.Errorf("HTML doesn't read back as sent")
+	}
+	if len(p.Attachments) != 1 {
+		t.Fatalf("%d attachments read back, want 1", len(p.Attachments))
+	}
+	if a := p.Attachments[0]; a.Filename != "photo.png" || a.ContentType != "image/png" || !bytes.Equal(a.Data, photo) {
+		t.Errorf("attachment read back as %s (%s), %d bytes", a.Filename, a.ContentType, len(a.Data))
+	}
+
+	devsmtp.Clear()
+	if n := len(devsmtp.Messages()); n != 0 {
+		t.Errorf("%d messages left after Clear", n)
+	}
+}
@@ -1 +1,269 @@
-package handlers
\ No newline at end of file
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"event-messenger.com/config"
+	"event-messenger.com/mailer"
+	"event-messenger.com/models"
+	"event-messenger.com/webhooks"
+)
+
+// recentDeliveries is how much of the webhook delivery log the admin page shows
+const recentDeliveries = 50
+
+// outboxPageSize is how many of the newest captured emails the outbox page lists
+const outboxPageSize = 200
+
+// RequireAdmin guards the admin pages with HTTP basic auth against
+// ADMIN_PASSWORD (any username). Without a password set they don't exist.
+// Changes must come from our own pages, since browsers resend basic auth
+// credentials on cross-site form posts.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.App.AdminPass == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		_, pass, ok := r.BasicAuth()
+		sent, want := sha256.Sum256([]byte(pass)), sha256.Sum256([]byte(config.App.AdminPass))
+		if !ok || subtle.ConstantTimeCompare(sent[:], want[:]) != 1 {
+			if ok {
+				log.Printf("Failed admin login from %s", r.RemoteAddr)
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="Event Messenger admin", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method == http.MethodPost && !sameOrigin(r) {
+			http.Error(w, "Cross-site request refused", http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Frame-Options", "DENY")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin reports whether a form post came from one of our own pages
+func sameOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin"
+	}
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	u, err := url.Parse(source)
+	return err == nil && u.Host == r.Host
+}
+
+// AdminWebhooksHandler lists webhooks and their recent deliveries. POST
+// adds, turns on or off, or deletes a webhook, or replays a delivery.
+func AdminWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		renderAdminWebhooks(w, r)
+	case http.MethodPost:
+		done, ok := applyWebhookAction(w, r)
+		if !ok {
+			return
+		}
+		http.Redirect(w, r, "/admin/webhooks?done="+url.QueryEscape(done), http.StatusSeeOther)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func renderAdminWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := models.GetWebhooks()
+	if err != nil {
+		http.Error(w, "Error loading webhooks", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return
+	}
+
+	deliveries, err := models.GetRecentWebhookDeliveries(recentDeliveries)
+	if err != nil {
+		http.Error(w, "Error loading webhook deliveries", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return
+	}
+
+	data := struct {
+		Webhooks   []models.Webhook
+		Deliveries []models.WebhookDelivery
+		EventTypes []string
+		Done       string
+	}{
+		Webhooks:   hooks,
+		Deliveries: deliveries,
+		EventTypes: webhooks.EventTypes,
+		Done:       r.URL.Query().Get("done"),
+	}
+
+	renderTemplate(w, "admin_webhooks.html", data)
+}
+
+// applyWebhookAction carries out a form post from the webhooks page,
+// returning a note for the page to show. It writes the error response
+// itself when ok is false.
+func applyWebhookAction(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return "", false
+	}
+
+	action := r.FormValue("action")
+	switch action {
+	case "create":
+		target, err := url.Parse(r.FormValue("url"))
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			http.Error(w, "Enter the full http:// or https:// address of the webhook", http.StatusBadRequest)
+			return "", false
+		}
+		events := r.Form["events"]
+		for _, e := range events {
+			if !webhooks.ValidEventType(e) {
+				http.Error(w, "Unknown event type", http.StatusBadRequest)
+				return "", false
+			}
+		}
+		if len(events) == len(webhooks.EventTypes) {
+			events = nil
+		}
+
+		hook, err := models.NewWebhook(target.String(), events)
+		if err != nil {
+			http.Error(w, "Error saving webhook", http.StatusInternalServerError)
+			log.Printf("%v", err)
+			return "", false
+		}
+		log.Printf("Admin added webhook %d for %s", hook.ID, hook.URL)
+		return "Webhook added", true
+
+	case "replay":
+		id, err := strconv.Atoi(r.FormValue("delivery_id"))
+		if err != nil {
+			http.Error(w, "Invalid delivery", http.StatusBadRequest)
+			return "", false
+		}
+		delivery, err := webhooks.Replay(id)
+		if err != nil {
+			http.Error(w, "Could not replay delivery", http.StatusBadRequest)
+			log.Printf("%v", err)
+			return "", false
+		}
+		log.Printf("Admin replayed webhook delivery %d as %d", id, delivery.ID)
+		return "Delivery queued again", true
+	}
+
+	id, err := strconv.Atoi(r.FormValue("webhook_id"))
+	if err != nil {
+		http.Error(w, "Invalid webhook", http.StatusBadRequest)
+		return "", false
+	}
+	hook, err := models.GetWebhook(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return "", false
+	}
+
+	var done string
+	switch action {
+	case "toggle":
+		err = hook.SetActive(!hook.Active)
+		done = "Webhook turned off"
+		if hook.Active {
+			done = "Webhook turned on"
+		}
+	case "delete":
+		err = hook.Delete()
+		done = "Webhook deleted"
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return "", false
+	}
+
+	if err != nil {
+		http.Error(w, "Error updating webhook", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return "", false
+	}
+
+	log.Printf("Admin applied %q to webhook %d", action, hook.ID)
+	return done, true
+}
+
+// AdminOutboxHandler lists the messages MAIL_TRANSPORT=outbox has captured
+func AdminOutboxHandler(w http.ResponseWriter, r *http.Request) {
+	messages, err := mailer.ListOutbox(outboxPageSize)
+	if err != nil {
+		http.Error(w, "Error loading outbox", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return
+	}
+
+	data := struct {
+		Messages []mailer.OutboxMessage
+		Capture  bool // whether mail is going to the outbox right now
+		Dir      string
+	}{
+		Messages: messages,
+		Capture:  config.App.MailTransport == mailer.TransportOutbox,
+		Dir:      config.App.OutboxDir,
+	}
+
+	renderTemplate(w, "admin_outbox.html", data)
+}
+
+// AdminOutboxMessageHandler shows one captured message rendered, or with
+// ?format=raw its source, or with ?format=eml the file itself
+func AdminOutboxMessageHandler(w http.ResponseWriter, r *http.Request, name string) {
+	raw, err := mailer.ReadOutbox(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "raw":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(raw)
+		return
+	case "eml":
+		w.Header().Set("Content-Type", "message/rfc822")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		w.Write(raw)
+		return
+	}
+
+	msg, err := mailer.Parse(raw)
+	if err != nil {
+		http.Error(w, "Could not read message", http.StatusInternalServerError)
+		log.Printf("Outbox message %s: %v", name, err)
+		return
+	}
+
+	data := struct {
+		Name    string
+		Message *mailer.Parsed
+		To      string // the envelope recipient
+		Date    string
+	}{
+		Name:    name,
+		Message: msg,
+		To:      msg.Header.Get("Delivered-To"),
+		Date:    msg.Header.Get("Date"),
+	}
+
+	renderTemplate(w, "admin_outbox_message.html", data)
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+
+	"event-messenger.com/models"
+)
+
+// Set in main to the scheduler's own delivery, which this package can't
+// import
+var (
+	DeliverNow func(event *models.Event, requestKey string) error
+	Resend     func(event *models.Event, address *mail.Address, requestKey string) error
+)
+
+// ReviewDeliveryHandler lets the coordinator deliver an event's messages
+// early (action "deliver") or send them again after they've gone out
+// (action "resend"). GET asks for confirmation; the form it shows carries a
+// one-off key, so submitting it twice only delivers once.
+func ReviewDeliveryHandler(w http.ResponseWriter, r *http.Request, slug, token, action string) {
+	event, err := models.GetEventBySlugIncludingArchived(slug)
+	if err != nil || !event.ValidManageToken(token) {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		renderDeliveryConfirmation(w, r, event, token, action)
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid form data", http.StatusBadRequest)
+			return
+		}
+		key := r.FormValue("request_key")
+		if key == "" {
+			http.Error(w, "Please confirm from the review page", http.StatusBadRequest)
+			return
+		}
+
+		var result string
+		switch action {
+		case "deliver":
+			err = DeliverNow(event, key)
+			result = "delivered"
+		case "resend":
+			var address *mail.Address
+			if r.FormValue("to") == "other" {
+				if event.NotifyChannel != models.NotifyEmail {
+					http.Error(w, "Messages posted to a channel can't be sent to an email address", http.StatusBadRequest)
+					return
+				}
+				address, err = mail.ParseAddress(r.FormValue("email"))
+				if err != nil {
+					http.Error(w, "Invalid email address", http.StatusBadRequest)
+					return
+				}
+			}
+			err = Resend(event, address, key)
+			result = "resent"
+		}
+
+		switch {
+		case err == nil:
+			log.Printf("Coordinator %s event %s", result, event.Slug)
+		case errors.Is(err, models.ErrAlreadyDelivered), errors.Is(err, models.ErrDuplicateDelivery):
+			result = "already"
+		case errors.Is(err, models.ErrNoSubmissions):
+			result = "empty"
+		default:
+			log.Printf("Coordinator could not deliver event %s: %v", event.Slug, err)
+			result = "failed"
+		}
+		http.Redirect(w, r, reviewPath(slug, token)+"?delivery="+result, http.StatusSeeOther)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func renderDeliveryConfirmation(w http.ResponseWriter, r *http.Request, event *models.Event, token, action string) {
+	// Delivering early is only for events that haven't gone out, and
+	// resending only for those that have
+	if (action == "deliver") == event.EmailSent {
+		http.Redirect(w, r, reviewPath(event.Slug, token), http.StatusSeeOther)
+		return
+	}
+
+	count, err := event.GetSubmissionCount()
+	if err != nil {
+		http.Error(w, "Error counting event submissions", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return
+	}
+
+	recipients, err := models.GetRecipients(event.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving event recipients", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return
+	}
+
+	key, err := models.NewDeliveryRequestKey()
+	if err != nil {
+		http.Error(w, "Error preparing delivery", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return
+	}
+
+	data := struct {
+		Event           *models.Event
+		ReviewPath      string
+		Action          string
+		RequestKey      string
+		Recipients      []models.Recipient
+		SubmissionCount int
+	}{
+		Event:           event,
+		ReviewPath:      reviewPath(event.Slug, token),
+		Action:          action,
+		RequestKey:      key,
+		Recipients:      recipients,
+		SubmissionCount: count,
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	renderTemplate(w, "review_delivery.html", data)
+}
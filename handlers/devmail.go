@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"event-messenger.com/devsmtp"
+	"event-messenger.com/mailer"
+)
+
+// DevMailHandler lists the email the development SMTP server has caught.
+// POST forgets all of it. Without DEV_SMTP the page doesn't exist.
+func DevMailHandler(w http.ResponseWriter, r *http.Request) {
+	if !devsmtp.Running() {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if !sameOrigin(r) {
+			http.Error(w, "Cross-site request refused", http.StatusForbidden)
+			return
+		}
+		devsmtp.Clear()
+		http.Redirect(w, r, "/dev/mail", http.StatusSeeOther)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type row struct {
+		*devsmtp.Message
+		Subject string
+	}
+	var rows []row
+	for _, m := range devsmtp.Messages() {
+		subject := ""
+		if parsed, err := mailer.Parse(m.Raw); err == nil {
+			subject = parsed.Subject
+		}
+		rows = append(rows, row{Message: m, Subject: subject})
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	renderTemplate(w, "dev_mail.html", struct{ Messages []row }{rows})
+}
+
+// DevMailMessageHandler shows one caught message. part is "" for the
+// rendered message, "raw" for its source or "attachments/{n}" for a download.
+func DevMailMessageHandler(w http.ResponseWriter, r *http.Request, id int, part string) {
+	m, ok := devsmtp.Get(id)
+	if !devsmtp.Running() || !ok || r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	if part == "raw" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(m.Raw)
+		return
+	}
+
+	msg, err := mailer.Parse(m.Raw)
+	if err != nil {
+		http.Error(w, "Could not read message", http.StatusInternalServerError)
+		log.Printf("Development mail %d: %v", id, err)
+		return
+	}
+
+	if part != "" {
+		index, ok := strings.CutPrefix(part, "attachments/")
+		n, err := strconv.Atoi(index)
+		if !ok || err != nil || n < 0 || n >= len(msg.Attachments) {
+			http.NotFound(w, r)
+			return
+		}
+		a := msg.Attachments[n]
+		filename := a.Filename
+		if filename == "" {
+			filename = "attachment-" + strconv.Itoa(n)
+		}
+		// Always a download, so an attached page can't run as one of ours
+		w.Header().Set("Content-Type", a.ContentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		w.Write(a.Data)
+		return
+	}
+
+	data := struct {
+		*devsmtp.Message
+		Parsed *mailer.Parsed
+		Date   string
+	}{
+		Message: m,
+		Parsed:  msg,
+		Date:    msg.Header.Get("Date"),
+	}
+
+	renderTemplate(w, "dev_mail_message.html", data)
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"fmt"
+	"net/mail"
+
+	"event-messenger.com/config"
+	"event-messenger.com/mailer"
+	"event-messenger.com/models"
+	"event-messenger.com/utils"
+)
+
+// How much of each list a progress digest shows
+const (
+	digestContributors = 5
+	digestPending      = 10
+	digestSnippet      = 140 // characters of each pending message
+)
+
+// digestItem is one message listed in a progress digest
+type digestItem struct {
+	Name    string
+	Message string
+}
+
+// SendDigest emails coordinators how their event is going: how many
+// messages there are, who sent the latest, what's waiting for their
+// approval, and a review link with token. daysLeft is how many days remain
+// until delivery. Coordinators without an email address are skipped.
+func SendDigest(event *models.Event, token string, daysLeft int) error {
+	to, err := mail.ParseAddress(event.CoordinatorContact)
+	if err != nil {
+		return nil
+	}
+
+	count, err := event.GetSubmissionCount()
+	if err != nil {
+		return err
+	}
+	approved, err := models.GetSubmissionsByEventSlug(event.Slug)
+	if err != nil {
+		return err
+	}
+	all, err := models.GetSubmissionsForReview(event.ID)
+	if err != nil {
+		return err
+	}
+
+	var newest []string
+	for _, s := range approved {
+		if len(newest) == digestContributors {
+			break
+		}
+		newest = append(newest, s.Name)
+	}
+
+	var pending []digestItem
+	pendingCount := 0
+	for _, s := range all {
+		if s.Moderation != models.ModerationPending {
+			continue
+		}
+		pendingCount++
+		if len(pending) < digestPending {
+			pending = append(pending, digestItem{Name: s.Name, Message: snippet(s.Message, digestSnippet)})
+		}
+	}
+
+	data := struct {
+		Coordinator   string
+		EventName     string
+		RecipientName string
+		EventDate     string
+		DaysLeft      int
+		Count         int
+		Newest        []string
+		Others        int // approved contributors not named in Newest
+		Pending       []digestItem
+		PendingCount  int
+		MorePending   int // pending messages not listed
+		ReviewLink    string
+	}{
+		Coordinator:   event.Coordinator,
+		EventName:     event.Name,
+		RecipientName: event.RecipientName,
+		EventDate:     event.EventDate.Format("January 2"),
+		DaysLeft:      daysLeft,
+		Count:         count,
+		Newest:        newest,
+		Others:        count - len(newest),
+		Pending:       pending,
+		PendingCount:  pendingCount,
+		MorePending:   pendingCount - len(pending),
+		ReviewLink:    ReviewLink(config.App.BaseURL, event.Slug, token),
+	}
+
+	htmlContent, err := renderEmail("email_digest.html", data)
+	if err != nil {
+		return fmt.Errorf("failed to render digest: %w", err)
+	}
+
+	plural := "s"
+	if count == 1 {
+		plural = ""
+	}
+	subject := fmt.Sprintf("%s: %d message%s so far", event.Name, count, plural)
+	if pendingCount > 0 {
+		subject += fmt.Sprintf(", %d waiting for you", pendingCount)
+	}
+	msg := mailer.NewMessage(subject)
+	msg.To = []mail.Address{*to}
+	msg.HTML = htmlContent
+	return utils.SendEmail(to.Address, msg)
+}
+
+// snippet shortens text to at most n characters, ending in an ellipsis if
+// anything was cut
+func snippet(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"event-messenger.com/media"
+	"event-messenger.com/models"
+	"event-messenger.com/utils"
+)
+
+// editPath is the contributor's private link for a submission
+func editPath(slug, token string) string {
+	return "/events/" + slug + "/edit/" + token
+}
+
+// EditLink is the full URL of a contributor's private link
+func EditLink(baseURL, slug, token string) string {
+	return baseURL + editPath(slug, token)
+}
+
+// EditSubmissionHandler serves a contributor's edit link. GET shows the
+// submission, POST saves changes or, with action=withdraw, deletes it. The
+// link stops working once the event has been delivered.
+func EditSubmissionHandler(w http.ResponseWriter, r *http.Request, slug, token string) {
+	submission, err := models.GetSubmissionByEditToken(token)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	event, err := models.GetEventBySlugIncludingArchived(slug)
+	if err != nil || event.ID != submission.EventID {
+		http.NotFound(w, r)
+		return
+	}
+
+	if event.EmailSent {
+		http.Error(w, "This event has already been delivered, so messages can no longer be changed", http.StatusGone)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		renderEditForm(w, event, submission, token, r.URL.Query().Has("saved"))
+	case http.MethodPost:
+		if err := parseSubmissionForm(w, r, event); err != nil {
+			writeError(w, err)
+			return
+		}
+		if r.FormValue("action") == "withdraw" {
+			withdrawSubmission(w, event, submission)
+			return
+		}
+		updateSubmission(w, r, event, submission, token)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func renderEditForm(w http.ResponseWriter, event *models.Event, submission *models.Submission, token string, saved bool) {
+	data := struct {
+		EventName       string
+		RecipientName   string
+		EventSlug       string
+		EditPath        string
+		MediaMaxSeconds int
+		MediaMaxMB      int64
+		Submission      *models.Submission
+		Saved           bool
+		Withdrawn       bool
+	}{
+		EventName:       event.Name,
+		RecipientName:   event.RecipientName,
+		EventSlug:       event.Slug,
+		EditPath:        editPath(event.Slug, token),
+		MediaMaxSeconds: event.MediaMaxSeconds,
+		MediaMaxMB:      event.MediaMaxBytes >> 20,
+		Submission:      submission,
+		Saved:           saved,
+	}
+
+	renderTemplate(w, "edit_submission.html", data)
+}
+
+// updateSubmission applies an edit form. A new photo or recording replaces the
+// old one; leaving the file inputs empty keeps what's there.
+func updateSubmission(w http.ResponseWriter, r *http.Request, event *models.Event, submission *models.Submission, token string) {
+	name := r.FormValue("name")
+	message := r.FormValue("message")
+
+	if err := checkText(name, message); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	name, message, flagged, err := filterText(event, name, message)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	image, err := ReadSubmittedFile(r, "image", "upload_id", MaxFileSize)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	recording, err := ReadSubmittedFile(r, "media", "media_upload_id", event.MediaMaxBytes)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	removeRecording := recording.Data == nil && r.FormValue("remove_media") != ""
+
+	// The same rules as a new submission, counting what's already attached
+	hasRecording := recording.Data != nil || (submission.MediaFilename != "" && !removeRecording)
+	hasImage := image.Data != nil || submission.Filename != "" || submission.Status == models.SubmissionProcessing
+	if len(name) == 0 || (len(message) == 0 && !hasRecording) {
+		http.Error(w, "Name and message are required", http.StatusBadRequest)
+		return
+	}
+	if !hasImage && !hasRecording {
+		http.Error(w, "Image upload is required", http.StatusBadRequest)
+		return
+	}
+
+	var contentType string
+	if image.Data != nil {
+		// Swapping the original under a running worker would lose one of them
+		if submission.Status == models.SubmissionProcessing {
+			http.Error(w, "Your previous photo is still being processed, please try again in a minute", http.StatusConflict)
+			return
+		}
+		contentType, err = checkImage(image.Data)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	var recordingInfo media.MediaInfo
+	if recording.Data != nil {
+		recordingInfo, err = checkRecording(recording.Data, event)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	err = submission.UpdateText(name, message)
+	if err != nil {
+		http.Error(w, "Error saving submission to database", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return
+	}
+
+	oldRecording := submission.MediaFilename
+	if recording.Data != nil {
+		filename, err := media.SaveMedia(recording.Data, UploadDir, storedBaseName(recording.Filename), recordingInfo)
+		if err != nil {
+			http.Error(w, "Error saving file", http.StatusInternalServerError)
+			log.Printf("Recording save error: %v", err)
+			return
+		}
+		err = submission.SetMedia(filename, recordingInfo.ContentType, recordingInfo.Duration)
+		if err != nil {
+			removeStoredFile(filename)
+			http.Error(w, "Error saving submission to database", http.StatusInternalServerError)
+			log.Printf("%v", err)
+			return
+		}
+		removeStoredFile(oldRecording)
+	} else if removeRecording && oldRecording != "" {
+		err = submission.SetMedia("", "", 0)
+		if err != nil {
+			http.Error(w, "Error saving submission to database", http.StatusInternalServerError)
+			log.Printf("%v", err)
+			return
+		}
+		removeStoredFile(oldRecording)
+	}
+
+	if image.Data != nil {
+		baseName := storedBaseName(image.Filename)
+		original, err := media.SaveOriginal(image.Data, baseName, contentType)
+		if err != nil {
+			http.Error(w, "Error saving file", http.StatusInternalServerError)
+			log.Printf("File save error: %v", err)
+			return
+		}
+
+		oldImage := submission.Filename
+		err = submission.ReplaceImage(original)
+		if err != nil {
+			media.RemoveOriginal(original)
+			http.Error(w, "Error saving submission to database", http.StatusInternalServerError)
+			log.Printf("%v", err)
+			return
+		}
+		removeImageFiles(oldImage)
+
+		err = media.Enqueue(media.Job{SubmissionID: submission.ID, BaseName: baseName})
+		if err != nil {
+			// The old photo is already gone, so record why there's no new one
+			media.RemoveOriginal(original)
+			submission.MarkFailed("We were too busy to process this photo. Please upload it again in a minute.")
+			log.Printf("Could not queue image for submission %d: %v", submission.ID, err)
+		}
+	}
+
+	consumeUpload(image.UploadID)
+	consumeUpload(recording.UploadID)
+
+	// Changes go back past the coordinator before they're delivered
+	if (event.Moderated || flagged) && submission.Moderation != models.ModerationPending {
+		if err := submission.SetModeration(models.ModerationPending); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+
+	log.Printf("Submission %d edited by its contributor", submission.ID)
+	http.Redirect(w, r, editPath(event.Slug, token)+"?saved=1", http.StatusSeeOther)
+}
+
+// withdrawSubmission deletes a submission and its files at the contributor's request
+func withdrawSubmission(w http.ResponseWriter, event *models.Event, submission *models.Submission) {
+	err := submission.Delete()
+	if err != nil {
+		http.Error(w, "Error withdrawing submission", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return
+	}
+
+	RemoveSubmissionFiles(submission)
+
+	log.Printf("Submission %d withdrawn by its contributor", submission.ID)
+
+	data := struct {
+		EventName     string
+		RecipientName string
+		EventSlug     string
+		Withdrawn     bool
+	}{
+		EventName:     event.Name,
+		RecipientName: event.RecipientName,
+		EventSlug:     event.Slug,
+		Withdrawn:     true,
+	}
+
+	renderTemplate(w, "edit_submission.html", data)
+}
+
+// RemoveSubmissionFiles deletes a submission's photo, any original still
+// waiting for the image workers, and its recording
+func RemoveSubmissionFiles(submission *models.Submission) {
+	removeImageFiles(submission.Filename)
+	media.RemoveOriginal(submission.OriginalFilename)
+	removeStoredFile(submission.MediaFilename)
+}
+
+// removeImageFiles deletes a processed image and, for animations, its poster
+func removeImageFiles(filename string) {
+	removeStoredFile(filename)
+	removeStoredFile(media.PosterFilename(filename))
+}
+
+// sendEditLink emails contributors their edit link; failures are only logged
+// since the link is also on the success page
+func sendEditLink(event *models.Event, submission *models.Submission, link string) {
+	data := struct {
+		Name          string
+		EventName     string
+		RecipientName string
+		EditLink      string
+	}{
+		Name:          submission.Name,
+		EventName:     event.Name,
+		RecipientName: event.RecipientName,
+		EditLink:      link,
+	}
+
+	htmlContent, err := renderEmail("email_edit_link.html", data)
+	if err != nil {
+		log.Printf("Could not render edit link email for submission %d: %v", submission.ID, err)
+		return
+	}
+
+	subject := fmt.Sprintf("Your message for %s's %s", event.RecipientName, event.Name)
+	err = utils.SendEmailNotification(submission.ContributorEmail, subject, htmlContent)
+	if err != nil {
+		log.Printf("Could not send edit link for submission %d: %v", submission.ID, err)
+	}
+}
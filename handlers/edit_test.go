@@ -0,0 +1,180 @@
+package handlers_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"event-messenger.com/db"
+	"event-messenger.com/handlers"
+	"event-messenger.com/internal/testutil"
+	"event-messenger.com/models"
+)
+
+// editSetup saves an event and a submission in a temporary directory,
+// returning the submission with its edit token
+func editSetup(t *testing.T) (*models.Event, *models.Submission) {
+	t.Helper()
+	testutil.Setup(t)
+	t.Chdir(t.TempDir())
+
+	event := models.NewEvent("Farewell", "farewell", time.Now().AddDate(0, 0, 7))
+	if err := event.SaveEvent(); err != nil {
+		t.Fatal(err)
+	}
+	s := &models.Submission{EventID: event.ID, Name: "Riley", Message: "Good luck!", Filename: "photo.jpg"}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return event, s
+}
+
+// editRequest sends a request to an edit link, posting fields as a
+// multipart form like the edit page does
+func editRequest(t *testing.T, method, slug, token string, fields ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for i := 0; i < len(fields); i += 2 {
+		form.WriteField(fields[i], fields[i+1])
+	}
+	form.Close()
+
+	r := httptest.NewRequest(method, "/events/"+slug+"/edit/"+token, &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	handlers.EditSubmissionHandler(w, r, slug, token)
+	return w
+}
+
+func TestEditTokenStoredAsHash(t *testing.T) {
+	_, s := editSetup(t)
+	if s.EditToken == "" {
+		t.Fatal("Save didn't give the submission an edit token")
+	}
+
+	sum := sha256.Sum256([]byte(s.EditToken))
+	var stored string
+	if err := db.DB.QueryRow(`SELECT edit_token_hash FROM submissions WHERE id = ?`, s.ID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != hex.EncodeToString(sum[:]) {
+		t.Errorf("stored %q, want the token's SHA-256", stored)
+	}
+
+	// The token itself is nowhere in the row
+	rows, err := db.DB.Query(`SELECT * FROM submissions WHERE id = ?`, s.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	columns, _ := rows.Columns()
+	values := make([]any, len(columns))
+	for i := range values {
+		values[i] = new(any)
+	}
+	rows.Next()
+	if err := rows.Scan(values...); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range values {
+		if strings.Contains(fmt.Sprint(*v.(*any)), s.EditToken) {
+			t.Errorf("column %s holds the token", columns[i])
+		}
+	}
+
+	// and what is stored doesn't work as a link
+	if w := editRequest(t, http.MethodGet, "farewell", stored); w.Code != http.StatusNotFound {
+		t.Errorf("GET with the stored hash: %d, want 404", w.Code)
+	}
+}
+
+func TestEditLinkEdits(t *testing.T) {
+	event, s := editSetup(t)
+
+	w := editRequest(t, http.MethodGet, event.Slug, s.EditToken)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Good luck!") {
+		t.Fatalf("GET: %d %s", w.Code, w.Body)
+	}
+
+	w = editRequest(t, http.MethodPost, event.Slug, s.EditToken, "name", "Riley B", "message", "All the best!")
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("POST: %d %s", w.Code, w.Body)
+	}
+	if got, want := w.Header().Get("Location"), "/events/farewell/edit/"+s.EditToken+"?saved=1"; got != want {
+		t.Errorf("redirected to %s, want %s", got, want)
+	}
+	got, err := models.GetSubmissionByID(s.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Riley B" || got.Message != "All the best!" || got.Filename != "photo.jpg" {
+		t.Errorf("submission is now %q, %q with %q", got.Name, got.Message, got.Filename)
+	}
+
+	// Links only work for their own submission's event, and must be exact
+	other := models.NewEvent("Retirement", "retirement", time.Now().AddDate(0, 0, 7))
+	if err := other.SaveEvent(); err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range [][2]string{
+		{"retirement", s.EditToken},
+		{"farewell", s.EditToken[1:]},
+		{"farewell", ""},
+	} {
+		if w := editRequest(t, http.MethodGet, link[0], link[1]); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s/%s: %d, want 404", link[0], link[1], w.Code)
+		}
+	}
+}
+
+func TestEditLinkWithdraws(t *testing.T) {
+	event, s := editSetup(t)
+
+	w := editRequest(t, http.MethodPost, event.Slug, s.EditToken, "action", "withdraw")
+	if w.Code != http.StatusOK {
+		t.Fatalf("withdraw: %d %s", w.Code, w.Body)
+	}
+	if _, err := models.GetSubmissionByID(s.ID); err == nil {
+		t.Error("submission still there after withdrawing it")
+	}
+	if w := editRequest(t, http.MethodGet, event.Slug, s.EditToken); w.Code != http.StatusNotFound {
+		t.Errorf("GET after withdrawing: %d, want 404", w.Code)
+	}
+}
+
+func TestEditLinkClosesOnDelivery(t *testing.T) {
+	event, s := editSetup(t)
+	if err := event.MarkEmailSent(); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []struct {
+		method string
+		fields []string
+	}{
+		{http.MethodGet, nil},
+		{http.MethodPost, []string{"name", "Someone else", "message", "Changed after delivery"}},
+		{http.MethodPost, []string{"action", "withdraw"}},
+	}
+	for _, req := range requests {
+		if w := editRequest(t, req.method, event.Slug, s.EditToken, req.fields...); w.Code != http.StatusGone {
+			t.Errorf("%s %v after delivery: %d, want 410", req.method, req.fields, w.Code)
+		}
+	}
+
+	got, err := models.GetSubmissionByID(s.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Riley" || got.Message != "Good luck!" {
+		t.Errorf("delivered submission changed to %q, %q", got.Name, got.Message)
+	}
+}
@@ -1,14 +1,28 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"event-messenger.com/models"
 	"event-messenger.com/utils"
+	"event-messenger.com/webhooks"
+	"event-messenger.com/wordfilter"
 )
 
+// MaxFilterWordsLength limits a coordinator's own content filter list
+const MaxFilterWordsLength = 5000
+
+// MaxRecipients limits how many people one event's messages go to
+const MaxRecipients = 20
+
 func CreateEventForm(w http.ResponseWriter, r *http.Request) {
 
 	events, err := models.GetAllActiveEvents()
@@ -19,12 +33,14 @@ func CreateEventForm(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := struct {
-		Events []models.Event
+		Events        []models.Event
+		MaxRecipients int
 	}{
-		Events: events,
+		Events:        events,
+		MaxRecipients: MaxRecipients,
 	}
 
-	renderTemplate(w, "./templates/create_event_form.html", data)
+	renderTemplate(w, "create_event_form.html", data)
 
 }
 
@@ -56,6 +72,12 @@ func CreateEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	recipients, err := CheckRecipients(readRecipients(r, recipientName, recipientContact))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	eventDate, err := time.Parse("2006-01-02", r.FormValue("event_date"))
 	if err != nil {
 		http.Error(w, "Invalid event date format", http.StatusBadRequest)
@@ -68,21 +90,212 @@ func CreateEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Recording limits are optional; blank fields keep the defaults
+	mediaMaxSeconds := models.DefaultMediaMaxSeconds
+	if v := r.FormValue("media_max_seconds"); v != "" {
+		mediaMaxSeconds, err = strconv.Atoi(v)
+		if err != nil || mediaMaxSeconds < 1 || mediaMaxSeconds > MaxMediaSeconds {
+			http.Error(w, fmt.Sprintf("Recording length must be between 1 and %d seconds", MaxMediaSeconds), http.StatusBadRequest)
+			return
+		}
+	}
+
+	mediaMaxMB := int64(models.DefaultMediaMaxBytes >> 20)
+	if v := r.FormValue("media_max_mb"); v != "" {
+		mediaMaxMB, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || mediaMaxMB < 1 || mediaMaxMB > MaxResumableUploadSize>>20 {
+			http.Error(w, fmt.Sprintf("Recording size must be between 1 and %d MB", MaxResumableUploadSize>>20), http.StatusBadRequest)
+			return
+		}
+	}
+
+	filterAction, filterWords, ok := readFilterSettings(w, r)
+	if !ok {
+		return
+	}
+
+	digestDays := models.DefaultDigestDays
+	if r.Form.Has("digest_days") {
+		if digestDays, ok = readDigestDays(w, r); !ok {
+			return
+		}
+	}
+
+	notifyChannel := r.FormValue("notify_channel")
+	if notifyChannel == "" {
+		notifyChannel = models.NotifyEmail
+	}
+	notifyURL := ""
+	if notifyChannel != models.NotifyEmail {
+		notifyURL = strings.TrimSpace(r.FormValue("notify_url"))
+	}
+	if err := CheckNotifySettings(notifyChannel, notifyURL); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	event := models.NewEvent(
 		name,
 		slug,
 		eventDate,
 		models.WithDescription(description),
 		models.WithCoordinator(coordinator, coordinatorContact),
-		models.WithRecipient(recipientName, recipientContact),
+		models.WithRecipient(models.RecipientNames(recipients), recipients[0].Email),
 		models.WithWebsiteLink(websiteLink),
+		models.WithMediaLimits(mediaMaxSeconds, mediaMaxMB<<20),
+		models.WithModeration(r.FormValue("moderated") != ""),
+		models.WithContentFilter(filterAction, filterWords),
+		models.WithNotifyChannel(notifyChannel, notifyURL),
+		models.WithDigestDays(digestDays),
 	)
 
 	err = event.SaveEvent()
+	if err == nil {
+		err = event.SaveRecipients(recipients)
+	}
 	if err != nil {
 		http.Error(w, "Failed to create event", http.StatusInternalServerError)
+		log.Printf("Error creating event %s: %v", event.Slug, err)
 		return
 	}
 
-	http.Redirect(w, r, "/admin/events", http.StatusSeeOther)
+	webhooks.PublishEvent(webhooks.EventCreated, event)
+
+	// The review screen is the coordinator's way back in, so show it straight away
+	reviewURL := reviewPath(event.Slug, event.ManageToken)
+	go SendReviewLink(event, utils.GetBaseURL(r)+reviewURL)
+
+	http.Redirect(w, r, reviewURL+"?created=1", http.StatusSeeOther)
+}
+
+// readFilterSettings reads the content filter fields shared by the event
+// creation form and the review screen. It writes the error response itself
+// when ok is false.
+func readFilterSettings(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	action := r.FormValue("filter_action")
+	if !wordfilter.ValidAction(action) {
+		http.Error(w, "Unknown content filter setting", http.StatusBadRequest)
+		return "", "", false
+	}
+
+	words := strings.TrimSpace(r.FormValue("filter_words"))
+	if len(words) > MaxFilterWordsLength {
+		http.Error(w, fmt.Sprintf("The word list can be at most %d characters", MaxFilterWordsLength), http.StatusBadRequest)
+		return "", "", false
+	}
+
+	return action, words, true
+}
+
+// readDigestDays reads the progress digest schedule shared by the event
+// creation form and the review screen. It writes the error response itself
+// when ok is false.
+func readDigestDays(w http.ResponseWriter, r *http.Request) (string, bool) {
+	days, err := models.ParseDigestDays(r.FormValue("digest_days"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return days, true
+}
+
+// readRecipients collects the creation form's recipients: the main one,
+// any extra rows, and the coordinator if they asked for a copy
+func readRecipients(r *http.Request, name, email string) []models.Recipient {
+	recipients := []models.Recipient{{Name: name, Email: email, Role: models.RoleTo}}
+
+	names, emails, roles := r.Form["extra_name"], r.Form["extra_email"], r.Form["extra_role"]
+	for i, email := range emails {
+		extra := models.Recipient{Email: email}
+		if i < len(names) {
+			extra.Name = names[i]
+		}
+		if i < len(roles) {
+			extra.Role = roles[i]
+		}
+		recipients = append(recipients, extra)
+	}
+
+	if r.FormValue("cc_coordinator") != "" {
+		recipients = append(recipients, models.Recipient{
+			Name:  r.FormValue("coordinator"),
+			Email: r.FormValue("coordinator_contact"),
+			Role:  models.RoleCC,
+		})
+	}
+	return recipients
+}
+
+// CheckRecipients tidies up and checks an event's recipients. Rows without
+// an address are dropped, as are repeats of an address; the rest need a valid
+// address and To recipients a name. The result has at least one To recipient
+// and lists them first.
+func CheckRecipients(recipients []models.Recipient) ([]models.Recipient, error) {
+	var to, copies []models.Recipient
+	seen := map[string]bool{}
+	for _, r := range recipients {
+		r.Name = strings.TrimSpace(r.Name)
+		r.Email = strings.TrimSpace(r.Email)
+		r.Role = strings.ToLower(strings.TrimSpace(r.Role))
+		if r.Email == "" && r.Name == "" {
+			continue
+		}
+		if r.Role == "" {
+			r.Role = models.RoleTo
+		}
+		if !models.ValidRecipientRole(r.Role) {
+			return nil, fmt.Errorf("Unknown recipient role %q", r.Role)
+		}
+
+		if r.Email == "" {
+			return nil, fmt.Errorf("Enter an email address for %s", r.Name)
+		}
+		addr, err := mail.ParseAddress(r.Email)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid email address", r.Email)
+		}
+		r.Email = addr.Address
+		if r.Name == "" {
+			r.Name = addr.Name
+		}
+		if r.Role == models.RoleTo && r.Name == "" {
+			return nil, fmt.Errorf("Give a name for %s", r.Email)
+		}
+
+		if seen[strings.ToLower(r.Email)] {
+			continue
+		}
+		seen[strings.ToLower(r.Email)] = true
+
+		if r.Role == models.RoleTo {
+			to = append(to, r)
+		} else {
+			copies = append(copies, r)
+		}
+	}
+
+	if len(to) == 0 {
+		return nil, errors.New("At least one recipient is required")
+	}
+	if len(to)+len(copies) > MaxRecipients {
+		return nil, fmt.Errorf("An event can have at most %d recipients", MaxRecipients)
+	}
+	return append(to, copies...), nil
+}
+
+// CheckNotifySettings checks an event's delivery channel and, for chat
+// channels, its incoming webhook URL
+func CheckNotifySettings(channel, target string) error {
+	if !models.ValidNotifyChannel(channel) {
+		return errors.New("Unknown delivery channel")
+	}
+	if channel == models.NotifyEmail {
+		return nil
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("Enter the full https:// address of the channel's incoming webhook")
+	}
+	return nil
 }
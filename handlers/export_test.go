@@ -0,0 +1,7 @@
+package handlers
+
+// Unexported names the package's external tests need
+var (
+	ReviewPath     = reviewPath
+	StoredBaseName = storedBaseName
+)
@@ -21,6 +21,6 @@ func HomeHandler(w http.ResponseWriter, r *http.Request) {
 		Events: events,
 	}
 
-	renderTemplate(w, "./templates/home.html", data)
+	renderTemplate(w, "home.html", data)
 
 }
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"event-messenger.com/mailer"
+	"event-messenger.com/models"
+	"event-messenger.com/utils"
+)
+
+// Largest invitee list, pasted and uploaded together, the review page takes
+const maxInviteeListSize = 1 << 20 // 1 MB
+
+// ReviewInviteesHandler adds people to the event's invitee list from a
+// pasted list or an uploaded CSV file and sends them their invitations. With
+// action=remove it takes one off the list instead.
+func ReviewInviteesHandler(w http.ResponseWriter, r *http.Request, slug, token string) {
+	event, err := models.GetEventBySlugIncludingArchived(slug)
+	if err != nil || !event.ValidManageToken(token) {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if event.EmailSent {
+		http.Error(w, "This event has already been delivered, so nobody else can be invited", http.StatusGone)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxInviteeListSize+64<<10)
+	// The remove buttons post a plain form
+	if err := r.ParseMultipartForm(maxInviteeListSize); err != nil && err != http.ErrNotMultipart {
+		http.Error(w, "The invitee list is too large", http.StatusBadRequest)
+		return
+	}
+
+	if r.FormValue("action") == "remove" {
+		id, err := strconv.Atoi(r.FormValue("invitee_id"))
+		if err != nil {
+			http.Error(w, "Invalid invitee", http.StatusBadRequest)
+			return
+		}
+		if err := event.RemoveInvitee(id); err != nil {
+			http.Error(w, "Error removing invitee", http.StatusInternalServerError)
+			log.Printf("%v", err)
+			return
+		}
+		http.Redirect(w, r, reviewPath(slug, token), http.StatusSeeOther)
+		return
+	}
+
+	list := io.Reader(strings.NewReader(r.FormValue("invitees") + "\n"))
+	if file, _, err := r.FormFile("file"); err == nil {
+		defer file.Close()
+		list = io.MultiReader(list, file)
+	}
+	invitees, skipped, err := parseInvitees(list)
+	if err != nil {
+		http.Error(w, "Could not read the invitee list: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(invitees) == 0 {
+		http.Error(w, "No email addresses were found in the invitee list", http.StatusBadRequest)
+		return
+	}
+
+	existing, err := models.GetInvitees(event.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving invitees", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return
+	}
+	if len(existing)+len(invitees) > models.MaxInvitees {
+		http.Error(w, fmt.Sprintf("An event can have up to %d invitees", models.MaxInvitees), http.StatusBadRequest)
+		return
+	}
+
+	added, err := event.AddInvitees(invitees)
+	if err != nil {
+		http.Error(w, "Error adding invitees", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return
+	}
+	log.Printf("Coordinator invited %d people to event %s", added, event.Slug)
+
+	go SendInvitations(event)
+
+	http.Redirect(w, r, fmt.Sprintf("%s?invited=%d&skipped=%d", reviewPath(slug, token), added, skipped), http.StatusSeeOther)
+}
+
+// parseInvitees reads one person per line, as "Ann Lee <ann@example.com>",
+// "Ann Lee, ann@example.com" or just an address, so pasted lists and CSV
+// files from a spreadsheet both work. Rows without an address, like a
+// header, are passed over; it also returns how many rows had an address
+// that couldn't be read.
+func parseInvitees(r io.Reader) ([]models.Invitee, int, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+
+	var invitees []models.Invitee
+	seen := make(map[string]bool)
+	skipped := 0
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var addr *mail.Address
+		var names []string
+		hasAt := false
+		for _, field := range record {
+			field = strings.TrimSpace(field)
+			switch {
+			case field == "":
+			case strings.Contains(field, "@"):
+				hasAt = true
+				if a, err := mail.ParseAddress(field); err == nil && addr == nil {
+					addr = a
+				}
+			default:
+				names = append(names, field)
+			}
+		}
+		if !hasAt {
+			continue
+		}
+		if addr == nil {
+			skipped++
+			continue
+		}
+
+		name := addr.Name
+		if name == "" {
+			name = strings.Join(names, " ")
+		}
+		if runes := []rune(name); len(runes) > MaxNameLength {
+			name = string(runes[:MaxNameLength])
+		}
+
+		key := strings.ToLower(addr.Address)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		invitees = append(invitees, models.Invitee{Name: name, Email: addr.Address})
+	}
+	return invitees, skipped, nil
+}
+
+// SendInvitations emails everyone on the event's invitee list who hasn't
+// been invited yet. Those it can't reach are tried again by the reminder
+// scheduler, up to models.MaxInviteAttempts times in all.
+func SendInvitations(event *models.Event) {
+	invitees, err := models.GetInvitees(event.ID)
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+
+	for i := range invitees {
+		inv := &invitees[i]
+		if inv.InvitedAt.Valid || inv.UnsubscribedAt.Valid || inv.GaveUp() {
+			continue
+		}
+		claimed, err := inv.ClaimInvitation()
+		if err != nil {
+			log.Printf("%v", err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		if err := sendInviteEmail(event, inv, false); err != nil {
+			log.Printf("Could not send invitation for event %s to %s: %v", event.Slug, inv.Email, err)
+			if err := inv.InvitationFailed(err); err != nil {
+				log.Printf("%v", err)
+			}
+			if inv.GaveUp() {
+				log.Printf("Giving up on the invitation for event %s to %s after %d attempts", event.Slug, inv.Email, inv.Attempts)
+			}
+		}
+	}
+}
+
+// SendReminder emails an invitee who hasn't sent a message yet
+func SendReminder(event *models.Event, inv *models.Invitee) error {
+	return sendInviteEmail(event, inv, true)
+}
+
+func sendInviteEmail(event *models.Event, inv *models.Invitee, reminder bool) error {
+	data := struct {
+		Name            string
+		EventName       string
+		RecipientName   string
+		Coordinator     string
+		EventDate       string
+		Reminder        bool
+		InviteLink      string
+		UnsubscribeLink string
+	}{
+		Name:            inv.Name,
+		EventName:       event.Name,
+		RecipientName:   event.RecipientName,
+		Coordinator:     event.Coordinator,
+		EventDate:       event.EventDate.Format("January 2"),
+		Reminder:        reminder,
+		InviteLink:      utils.GetInviteURL(event.Slug, inv.Token),
+		UnsubscribeLink: utils.GetUnsubscribeURL(inv.Token),
+	}
+
+	htmlContent, err := renderEmail("email_invitation.html", data)
+	if err != nil {
+		return fmt.Errorf("failed to render invitation: %w", err)
+	}
+
+	subject := fmt.Sprintf("Write a message for %s's %s", event.RecipientName, event.Name)
+	if reminder {
+		subject = "Reminder: " + subject
+	}
+	msg := mailer.NewMessage(subject)
+	msg.To = []mail.Address{{Name: inv.Name, Address: inv.Email}}
+	msg.HTML = htmlContent
+	msg.Unsubscribe = data.UnsubscribeLink
+	return utils.SendEmail(inv.Email, msg)
+}
+
+// inviteeFor returns the invitee an invite token belongs to, if it's one of
+// the event's
+func inviteeFor(event *models.Event, token string) *models.Invitee {
+	inv, err := models.GetInviteeByToken(token)
+	if err != nil || inv.EventID != event.ID {
+		return nil
+	}
+	return inv
+}
+
+// UnsubscribeHandler stops an invitee's emails. GET asks first, as mail
+// scanners follow links; POST unsubscribes, including the one-click POST
+// mail clients send from the List-Unsubscribe header.
+func UnsubscribeHandler(w http.ResponseWriter, r *http.Request, token string) {
+	inv, err := models.GetInviteeByToken(token)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	event, err := models.GetEventByID(inv.EventID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := inv.Unsubscribe(); err != nil {
+			http.Error(w, "Error unsubscribing", http.StatusInternalServerError)
+			log.Printf("%v", err)
+			return
+		}
+		log.Printf("Invitee %d unsubscribed from event %s", inv.ID, event.Slug)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data := struct {
+		EventName    string
+		Email        string
+		Unsubscribed bool
+		Path         string
+	}{
+		EventName:    event.Name,
+		Email:        inv.Email,
+		Unsubscribed: inv.UnsubscribedAt.Valid,
+		Path:         "/unsubscribe/" + token,
+	}
+	renderTemplate(w, "unsubscribe.html", data)
+}
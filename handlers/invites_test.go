@@ -0,0 +1,80 @@
+package handlers_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"event-messenger.com/handlers"
+	"event-messenger.com/internal/testutil"
+	"event-messenger.com/mailer"
+	"event-messenger.com/models"
+)
+
+func TestSendInvitationsGivesUp(t *testing.T) {
+	testutil.Setup(t)
+	// Writing to an outbox that isn't there fails every send
+	outbox := testutil.UseOutbox(t)
+	if err := os.Remove(outbox); err != nil {
+		t.Fatal(err)
+	}
+
+	event := models.NewEvent("Farewell", "farewell", time.Now().AddDate(0, 0, 7))
+	if err := event.SaveEvent(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := event.AddInvitees([]models.Invitee{{Email: "unlucky@example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	invitee := func(email string) models.Invitee {
+		t.Helper()
+		invitees, err := models.GetInvitees(event.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, inv := range invitees {
+			if inv.Email == email {
+				return inv
+			}
+		}
+		t.Fatalf("no invitee %s", email)
+		return models.Invitee{}
+	}
+
+	// Each run tries the invitation once more, keeping the last error
+	for run := 1; run <= models.MaxInviteAttempts+2; run++ {
+		handlers.SendInvitations(event)
+		inv := invitee("unlucky@example.com")
+		want := min(run, models.MaxInviteAttempts)
+		if inv.Attempts != want || inv.InvitedAt.Valid || inv.Error == "" {
+			t.Fatalf("run %d: %d attempts, invited %v, error %q; want %d attempts and an error", run, inv.Attempts, inv.InvitedAt.Valid, inv.Error, want)
+		}
+		if inv.GaveUp() != (run >= models.MaxInviteAttempts) {
+			t.Errorf("run %d: GaveUp = %v", run, inv.GaveUp())
+		}
+	}
+
+	// Once the outbox is there, someone added since is invited, but the
+	// address that was given up on isn't tried again
+	if err := os.Mkdir(outbox, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := event.AddInvitees([]models.Invitee{{Email: "later@example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	handlers.SendInvitations(event)
+
+	if inv := invitee("later@example.com"); !inv.InvitedAt.Valid || inv.Error != "" || inv.Attempts != 1 {
+		t.Errorf("later@example.com: invited %v, error %q, %d attempts", inv.InvitedAt.Valid, inv.Error, inv.Attempts)
+	}
+	if inv := invitee("unlucky@example.com"); inv.InvitedAt.Valid || inv.Attempts != models.MaxInviteAttempts {
+		t.Errorf("unlucky@example.com tried again: invited %v, %d attempts", inv.InvitedAt.Valid, inv.Attempts)
+	}
+	saved, err := mailer.ListOutbox(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0].To != "later@example.com" {
+		t.Errorf("outbox has %+v, want just the invitation to later@example.com", saved)
+	}
+}
@@ -2,15 +2,36 @@ package handlers
 
 import (
 	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
-	"text/template"
+	"strings"
+
+	"event-messenger.com/static"
+	"event-messenger.com/templates"
+	"event-messenger.com/utils"
 )
 
+// Emails are standalone documents; every other top-level template is a page
+// rendered inside layouts/base.html
+const emailPrefix = "email_"
+
 var baseDir string
 
+// Parsed once by LoadAssets. In dev mode templates are re-read from disk
+// on every render instead, so edits show up without a restart.
+var (
+	templateFS      fs.FS = templates.FS
+	staticFS        fs.FS = static.FS
+	reloadTemplates bool
+	pageTemplates   map[string]*template.Template
+	mailTemplates   map[string]*template.Template
+)
+
 func init() {
 	// Use current working directory instead of executable path
 	// This works for both 'go run' and compiled binaries
@@ -21,35 +42,133 @@ func init() {
 	}
 }
 
-func renderTemplate(w http.ResponseWriter, templatePath string, data interface{}) {
-	// Make path absolute relative to executable
-	fullPath := filepath.Join(baseDir, templatePath)
+// LoadAssets sets up templates and static files and parses every template up
+// front, so a broken one stops the server at startup. Both are embedded in
+// the binary; files under themeDir/templates and themeDir/static replace
+// their embedded counterparts. With dev set, ./templates and ./static are
+// used when present and templates are re-parsed on each render.
+func LoadAssets(themeDir string, dev bool) error {
+	templateBase, staticBase := fs.FS(templates.FS), fs.FS(static.FS)
+	if dev {
+		if dir := filepath.Join(baseDir, "templates"); isDir(dir) {
+			templateBase = os.DirFS(dir)
+			reloadTemplates = true
+		}
+		if dir := filepath.Join(baseDir, "static"); isDir(dir) {
+			staticBase = os.DirFS(dir)
+		}
+	}
+
+	if themeDir != "" {
+		templateFS = utils.OverlayFS(filepath.Join(themeDir, "templates"), templateBase)
+		staticFS = utils.OverlayFS(filepath.Join(themeDir, "static"), staticBase)
+	} else {
+		templateFS, staticFS = templateBase, staticBase
+	}
+
+	pages, mail, err := parseTemplates(templateFS)
+	if err != nil {
+		return err
+	}
+
+	pageTemplates = pages
+	mailTemplates = mail
+	return nil
+}
+
+// StaticHandler serves the static assets set up by LoadAssets
+func StaticHandler() http.Handler {
+	return http.FileServerFS(staticFS)
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
 
-	// Read and parse the HTML template
-	tmpl, err := template.ParseFiles(fullPath)
+// parseTemplates builds one template set per page, each containing the
+// layouts and partials, and one per email
+func parseTemplates(fsys fs.FS) (pages, mail map[string]*template.Template, err error) {
+	files, err := fs.Glob(fsys, "*.html")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, err
+	}
+
+	pages = make(map[string]*template.Template, len(files))
+	mail = make(map[string]*template.Template)
+	for _, name := range files {
+		var tmpl *template.Template
+		if strings.HasPrefix(name, emailPrefix) {
+			tmpl, err = template.ParseFS(fsys, name)
+			mail[name] = tmpl
+		} else {
+			tmpl, err = template.New(name).ParseFS(fsys, "layouts/*.html", "partials/*.html", name)
+			pages[name] = tmpl
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing template %s: %w", name, err)
+		}
+	}
+
+	return pages, mail, nil
+}
+
+// currentTemplates returns the cached templates, or freshly parsed ones in dev mode
+func currentTemplates() (pages, mail map[string]*template.Template, err error) {
+	if reloadTemplates {
+		return parseTemplates(templateFS)
+	}
+	if pageTemplates == nil {
+		return nil, nil, fmt.Errorf("templates have not been loaded")
+	}
+	return pageTemplates, mailTemplates, nil
+}
+
+func renderTemplate(w http.ResponseWriter, name string, data interface{}) {
+	pages, _, err := currentTemplates()
+	if err != nil {
+		http.Error(w, "Error rendering template", http.StatusInternalServerError)
 		log.Printf("Template error: %v", err)
 		return
 	}
 
-	err = tmpl.Execute(w, data)
+	tmpl, ok := pages[name]
+	if !ok {
+		http.Error(w, "Error rendering template", http.StatusInternalServerError)
+		log.Printf("Template error: no template named %s", name)
+		return
+	}
+
+	// Render into a buffer so a failure doesn't leave a half-written page
+	var buf bytes.Buffer
+	err = tmpl.ExecuteTemplate(&buf, "base", data)
 	if err != nil {
 		http.Error(w, "Error rendering template", http.StatusInternalServerError)
 		log.Printf("Render error: %v", err)
+		return
 	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
 }
 
+// RenderEmailTemplate renders the recipient's notification email
 func RenderEmailTemplate(data any) (string, error) {
-	// Make path absolute relative to executable
-	fullPath := filepath.Join(baseDir, "templates/email_notification.html")
+	return renderEmail("email_notification.html", data)
+}
 
-	tmpl, err := template.ParseFiles(fullPath)
+func renderEmail(name string, data any) (string, error) {
+	_, mail, err := currentTemplates()
 	if err != nil {
 		log.Printf("Email template parse error: %v", err)
 		return "", err
 	}
 
+	tmpl, ok := mail[name]
+	if !ok {
+		return "", fmt.Errorf("no email template named %s", name)
+	}
+
 	// Use a bytes.Buffer instead of http.ResponseWriter to capture the output
 	var buf bytes.Buffer
 	err = tmpl.Execute(&buf, data)
@@ -60,5 +179,4 @@ func RenderEmailTemplate(data any) (string, error) {
 
 	// Return rendered HTML as a string
 	return buf.String(), nil
-
 }
@@ -0,0 +1,114 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"event-messenger.com/handlers"
+	"event-messenger.com/internal/testutil"
+	"event-messenger.com/models"
+)
+
+// Input meant to break out of the page, each paired with the escaped form
+// that should appear instead
+var hostileInputs = []struct {
+	name    string
+	input   string
+	escaped string
+}{
+	{"script", `<script>alert(1)</script>`, `&lt;script&gt;alert(1)&lt;/script&gt;`},
+	{"attribute", `x" onerror="alert(1)`, `x&#34; onerror=&#34;alert(1)`},
+	{"tag with handler", `"><img src=x onerror=alert(1)>`, `&#34;&gt;&lt;img src=x onerror=alert(1)&gt;`},
+	{"javascript url", `javascript:alert(1)`, `javascript:alert(1)`},
+	{"css", `</style><style>body{display:none}</style>`, `&lt;/style&gt;&lt;style&gt;body{display:none}&lt;/style&gt;`},
+	{"css expression", `red;}body{background:url(javascript:alert(1))}`, `red;}body{background:url(javascript:alert(1))}`},
+}
+
+// Markup that only appears if hostile input was written unescaped
+var unescaped = []string{
+	`<script>alert(1)`,
+	`" onerror=`,
+	`<img src=x`,
+	`href="javascript:`,
+	`src="javascript:`,
+	`<style>body{display:none}`,
+}
+
+// hostileEvent saves an event, an approved message, a recipient and an
+// invitee, with input in every field a visitor or coordinator can fill in.
+// It returns the event and the invitee's token.
+func hostileEvent(t *testing.T, slug, input string) (*models.Event, string) {
+	t.Helper()
+	event := models.NewEvent(input, slug, time.Now().AddDate(0, 0, 7),
+		models.WithDescription(input),
+		models.WithCoordinator(input, input),
+		models.WithRecipient(input, "recipient@example.com"),
+		models.WithWebsiteLink(input),
+	)
+	if err := event.SaveEvent(); err != nil {
+		t.Fatal(err)
+	}
+
+	submission := &models.Submission{EventID: event.ID, Name: input, Message: input}
+	if err := submission.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := event.SaveRecipients([]models.Recipient{{Name: input, Email: "cc@example.com", Role: models.RoleCC}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := event.AddInvitees([]models.Invitee{{Name: input, Email: "invitee@example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	invitees, err := models.GetInvitees(event.ID)
+	if err != nil || len(invitees) != 1 {
+		t.Fatalf("GetInvitees = %d, %v", len(invitees), err)
+	}
+	return event, invitees[0].Token
+}
+
+func TestPagesEscapeInput(t *testing.T) {
+	testutil.Setup(t)
+
+	for i, tt := range hostileInputs {
+		t.Run(tt.name, func(t *testing.T) {
+			slug := "hostile-" + string(rune('a'+i))
+			event, inviteToken := hostileEvent(t, slug, tt.input)
+
+			pages := []struct {
+				name  string
+				serve func(w http.ResponseWriter, r *http.Request)
+				path  string
+			}{
+				{"submission form", func(w http.ResponseWriter, r *http.Request) {
+					handlers.SubmissionFormHandler(w, r, slug)
+				}, "/events/" + slug + "/submit?invite=" + inviteToken},
+				{"review", func(w http.ResponseWriter, r *http.Request) {
+					handlers.ReviewHandler(w, r, slug, event.ManageToken)
+				}, handlers.ReviewPath(slug, event.ManageToken)},
+				{"keepsake", func(w http.ResponseWriter, r *http.Request) {
+					handlers.ViewSubmissionsByEvent(w, r, slug)
+				}, "/events/" + slug},
+			}
+			for _, page := range pages {
+				rec := httptest.NewRecorder()
+				page.serve(rec, httptest.NewRequest(http.MethodGet, page.path, nil))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("%s: status %d: %s", page.name, rec.Code, rec.Body)
+				}
+
+				body := rec.Body.String()
+				if !strings.Contains(body, tt.escaped) {
+					t.Errorf("%s: doesn't show the input escaped as %s", page.name, tt.escaped)
+				}
+				for _, s := range unescaped {
+					if strings.Contains(body, s) {
+						t.Errorf("%s: contains unescaped %s", page.name, s)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+
+	"event-messenger.com/mailer"
+	"event-messenger.com/models"
+	"event-messenger.com/utils"
+)
+
+// ComposeNotification composes the email the scheduler would send for an
+// event. It's set in main, as notify renders its email through this package.
+var ComposeNotification func(event *models.Event) (*mailer.Message, error)
+
+// reviewPath is the coordinator's private link for an event
+func reviewPath(slug, token string) string {
+	return "/events/" + slug + "/review/" + token
+}
+
+// ReviewLink is the full URL of a coordinator's private link
+func ReviewLink(baseURL, slug, token string) string {
+	return baseURL + reviewPath(slug, token)
+}
+
+// ReviewHandler serves the coordinator's review screen. GET lists every
+// submission, pending first; POST approves, rejects or edits one, or changes
+// one of the event's settings.
+func ReviewHandler(w http.ResponseWriter, r *http.Request, slug, token string) {
+	event, err := models.GetEventBySlugIncludingArchived(slug)
+	if err != nil || !event.ValidManageToken(token) {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		renderReview(w, r, event, token)
+	case http.MethodPost:
+		if event.EmailSent {
+			http.Error(w, "This event has already been delivered, so messages can no longer be changed", http.StatusGone)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid form data", http.StatusBadRequest)
+			return
+		}
+		if !applyReviewAction(w, r, event) {
+			return
+		}
+		http.Redirect(w, r, reviewPath(slug, token), http.StatusSeeOther)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ReviewPreviewHandler shows the coordinator the notification email as it
+// would be sent right now
+func ReviewPreviewHandler(w http.ResponseWriter, r *http.Request, slug, token string) {
+	event, err := models.GetEventBySlugIncludingArchived(slug)
+	if err != nil || !event.ValidManageToken(token) || event.NotifyChannel != models.NotifyEmail {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	msg, err := ComposeNotification(event)
+	if err != nil {
+		http.Error(w, "Error composing email", http.StatusInternalServerError)
+		log.Printf("Could not preview email for event %s: %v", event.Slug, err)
+		return
+	}
+
+	// The email is a document of its own; it may show its inline images and
+	// styles but nothing else
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src data: http: https:; style-src 'unsafe-inline'")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(msg.HTML))
+}
+
+// ReviewTestSendHandler emails the coordinator a copy of the notification
+// email. Nothing is marked as sent, so the recipients still get theirs on
+// the event date.
+func ReviewTestSendHandler(w http.ResponseWriter, r *http.Request, slug, token string) {
+	event, err := models.GetEventBySlugIncludingArchived(slug)
+	if err != nil || !event.ValidManageToken(token) || event.NotifyChannel != models.NotifyEmail {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	addr, err := mail.ParseAddress(event.CoordinatorContact)
+	if err != nil {
+		http.Error(w, "The coordinator's contact details aren't an email address", http.StatusBadRequest)
+		return
+	}
+
+	msg, err := ComposeNotification(event)
+	if err != nil {
+		http.Error(w, "Error composing email", http.StatusInternalServerError)
+		log.Printf("Could not compose test email for event %s: %v", event.Slug, err)
+		return
+	}
+	msg.Subject = "[Test] " + msg.Subject
+	msg.To = []mail.Address{{Name: event.Coordinator, Address: addr.Address}}
+	msg.Cc = nil
+
+	result := "sent"
+	if err := utils.SendEmail(addr.Address, msg); err != nil {
+		log.Printf("Could not send test email for event %s: %v", event.Slug, err)
+		result = "failed"
+	} else {
+		log.Printf("Sent test email for event %s to the coordinator", event.Slug)
+	}
+	http.Redirect(w, r, reviewPath(slug, token)+"?test="+result, http.StatusSeeOther)
+}
+
+func renderReview(w http.ResponseWriter, r *http.Request, event *models.Event, token string) {
+	submissions, err := models.GetSubmissionsForReview(event.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving event submissions", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return
+	}
+
+	recipients, err := models.GetRecipients(event.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving event recipients", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return
+	}
+
+	deliveries, err := models.GetDeliveries(event.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving event deliveries", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return
+	}
+
+	invitees, err := models.GetInvitees(event.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving event invitees", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return
+	}
+	responded := 0
+	for _, inv := range invitees {
+		if inv.SubmittedAt.Valid {
+			responded++
+		}
+	}
+
+	pending := 0
+	for _, s := range submissions {
+		if s.Moderation == models.ModerationPending {
+			pending++
+		}
+	}
+
+	data := struct {
+		Event          *models.Event
+		ReviewPath     string
+		ReviewLink     string
+		Created        bool
+		Submissions    []models.Submission
+		PendingCount   int
+		Recipients     []models.Recipient
+		CanTest        bool   // whether the coordinator has an email address to send a test to
+		TestResult     string // "sent" or "failed" after sending a test
+		Deliveries     []models.Delivery
+		DeliveryResult string // how a delivery from ReviewDeliveryHandler went
+		Invitees       []models.Invitee
+		Responded      int  // invitees who have sent a message
+		InviteResult   bool // whether invitees were just added
+		Invited        int
+		InviteSkipped  int // rows whose address couldn't be read
+	}{
+		Event:          event,
+		ReviewPath:     reviewPath(event.Slug, token),
+		ReviewLink:     utils.GetBaseURL(r) + reviewPath(event.Slug, token),
+		Created:        r.URL.Query().Has("created"),
+		Submissions:    submissions,
+		PendingCount:   pending,
+		Recipients:     recipients,
+		TestResult:     r.URL.Query().Get("test"),
+		Deliveries:     deliveries,
+		DeliveryResult: r.URL.Query().Get("delivery"),
+		Invitees:       invitees,
+		Responded:      responded,
+		InviteResult:   r.URL.Query().Has("invited"),
+	}
+	data.Invited, _ = strconv.Atoi(r.URL.Query().Get("invited"))
+	data.InviteSkipped, _ = strconv.Atoi(r.URL.Query().Get("skipped"))
+	if _, err := mail.ParseAddress(event.CoordinatorContact); err == nil {
+		data.CanTest = true
+	}
+
+	renderTemplate(w, "review.html", data)
+}
+
+// applyReviewAction carries out one form action from the review screen. It
+// writes the error response itself when it returns false.
+func applyReviewAction(w http.ResponseWriter, r *http.Request, event *models.Event) bool {
+	action := r.FormValue("action")
+
+	// Settings for the event as a whole
+	switch action {
+	case "moderation":
+		event.Moderated = r.FormValue("moderated") != ""
+	case "filter":
+		filterAction, filterWords, ok := readFilterSettings(w, r)
+		if !ok {
+			return false
+		}
+		event.FilterAction, event.FilterWords = filterAction, filterWords
+	case "digest":
+		digestDays, ok := readDigestDays(w, r)
+		if !ok {
+			return false
+		}
+		event.DigestDays = digestDays
+	}
+	if action == "moderation" || action == "filter" || action == "digest" {
+		if err := event.Update(); err != nil {
+			http.Error(w, "Error updating event", http.StatusInternalServerError)
+			log.Printf("%v", err)
+			return false
+		}
+		log.Printf("Coordinator updated %s settings for event %s", action, event.Slug)
+		return true
+	}
+
+	id, err := strconv.Atoi(r.FormValue("submission_id"))
+	if err != nil {
+		http.Error(w, "Invalid submission", http.StatusBadRequest)
+		return false
+	}
+	submission, err := models.GetSubmissionByID(id)
+	if err != nil || submission.EventID != event.ID {
+		http.NotFound(w, r)
+		return false
+	}
+
+	switch action {
+	case "approve":
+		err = submission.SetModeration(models.ModerationApproved)
+	case "reject":
+		err = submission.SetModeration(models.ModerationRejected)
+	case "save":
+		name, message := r.FormValue("name"), r.FormValue("message")
+		if len(name) == 0 {
+			http.Error(w, "Name is required", http.StatusBadRequest)
+			return false
+		}
+		if err := checkText(name, message); err != nil {
+			writeError(w, err)
+			return false
+		}
+		err = submission.UpdateText(name, message)
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return false
+	}
+
+	if err != nil {
+		http.Error(w, "Error updating submission", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return false
+	}
+
+	log.Printf("Coordinator applied %q to submission %d for event %s", action, submission.ID, event.Slug)
+	return true
+}
+
+// SendReviewLink emails coordinators their review link, if their contact
+// details are an email address; failures are only logged since the link is
+// also shown after creating the event
+func SendReviewLink(event *models.Event, link string) {
+	if _, err := mail.ParseAddress(event.CoordinatorContact); err != nil {
+		return
+	}
+
+	data := struct {
+		Coordinator   string
+		EventName     string
+		RecipientName string
+		Moderated     bool
+		ReviewLink    string
+	}{
+		Coordinator:   event.Coordinator,
+		EventName:     event.Name,
+		RecipientName: event.RecipientName,
+		Moderated:     event.Moderated,
+		ReviewLink:    link,
+	}
+
+	htmlContent, err := renderEmail("email_review_link.html", data)
+	if err != nil {
+		log.Printf("Could not render review link email for event %s: %v", event.Slug, err)
+		return
+	}
+
+	subject := fmt.Sprintf("Managing %s", event.Name)
+	err = utils.SendEmailNotification(event.CoordinatorContact, subject, htmlContent)
+	if err != nil {
+		log.Printf("Could not send review link for event %s: %v", event.Slug, err)
+	}
+}
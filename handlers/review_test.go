@@ -0,0 +1,133 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"event-messenger.com/handlers"
+	"event-messenger.com/internal/testutil"
+	"event-messenger.com/models"
+	"event-messenger.com/notify"
+)
+
+// reviewSetup saves an email event with a coordinator, two recipients and a
+// message, composing previews with the notifier as main does
+func reviewSetup(t *testing.T) *models.Event {
+	t.Helper()
+	testutil.Setup(t)
+	testutil.UseOutbox(t)
+	compose := handlers.ComposeNotification
+	handlers.ComposeNotification = notify.Preview
+	t.Cleanup(func() { handlers.ComposeNotification = compose })
+
+	event := models.NewEvent("Farewell", "farewell", time.Now().AddDate(0, 0, 7),
+		models.WithCoordinator("Casey", "casey@example.com"),
+		models.WithRecipient("Alex", "alex@example.com"))
+	if err := event.SaveEvent(); err != nil {
+		t.Fatal(err)
+	}
+	err := event.SaveRecipients([]models.Recipient{
+		{Name: "Alex", Email: "alex@example.com", Role: models.RoleTo},
+		{Name: "Sam", Email: "sam@example.com", Role: models.RoleCC},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &models.Submission{EventID: event.ID, Name: "Riley", Message: "Good luck!"}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestReviewPreview(t *testing.T) {
+	event := reviewSetup(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/events/farewell/review/"+event.ManageToken+"/preview", nil)
+	w := httptest.NewRecorder()
+	handlers.ReviewPreviewHandler(w, r, event.Slug, event.ManageToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("preview: %d %s", w.Code, w.Body)
+	}
+	msg, err := notify.Preview(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Body.String() != msg.HTML || !strings.Contains(msg.HTML, "Good luck!") {
+		t.Errorf("preview isn't the notification email:\n%s", w.Body)
+	}
+	if csp := w.Header().Get("Content-Security-Policy"); !strings.HasPrefix(csp, "default-src 'none'") {
+		t.Errorf("Content-Security-Policy %q", csp)
+	}
+
+	// Previewing sends nothing
+	if sent := testutil.Outbox(t); len(sent) != 0 {
+		t.Errorf("previewing sent %d emails", len(sent))
+	}
+
+	for _, token := range []string{"", "not-the-token"} {
+		w := httptest.NewRecorder()
+		handlers.ReviewPreviewHandler(w, r, event.Slug, token)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("preview with token %q: %d, want 404", token, w.Code)
+		}
+	}
+}
+
+func TestReviewTestSend(t *testing.T) {
+	event := reviewSetup(t)
+
+	r := httptest.NewRequest(http.MethodPost, "/events/farewell/review/"+event.ManageToken+"/test-send", nil)
+	w := httptest.NewRecorder()
+	handlers.ReviewTestSendHandler(w, r, event.Slug, event.ManageToken)
+	if w.Code != http.StatusSeeOther || !strings.HasSuffix(w.Header().Get("Location"), "?test=sent") {
+		t.Fatalf("test send: %d to %s", w.Code, w.Header().Get("Location"))
+	}
+
+	// Only the coordinator gets it
+	sent := testutil.Outbox(t)
+	if len(sent) != 1 {
+		t.Fatalf("%d emails sent, want 1", len(sent))
+	}
+	m := sent[0]
+	if m.Header.Get("Delivered-To") != "casey@example.com" || m.To != `"Casey" <casey@example.com>` || m.Cc != "" {
+		t.Errorf("test email went to %s, To %q, Cc %q", m.Header.Get("Delivered-To"), m.To, m.Cc)
+	}
+	if m.Subject != "[Test] Your Farewell Messages" || !strings.Contains(m.Text, "Good luck!") {
+		t.Errorf("test email %q:\n%s", m.Subject, m.Text)
+	}
+
+	// and nothing about the real delivery changes
+	got, err := models.GetEventBySlugIncludingArchived(event.Slug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.EmailSent || got.EmailSentAt.Valid || !got.Active {
+		t.Errorf("test send marked the event sent: %v at %v, active %v", got.EmailSent, got.EmailSentAt, got.Active)
+	}
+	if deliveries, err := models.GetDeliveries(event.ID); err != nil || len(deliveries) != 0 {
+		t.Errorf("test send recorded deliveries %+v, %v", deliveries, err)
+	}
+	recipients, err := models.GetRecipients(event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rcpt := range recipients {
+		if rcpt.SentAt.Valid || rcpt.Error != "" {
+			t.Errorf("test send changed %s to %s", rcpt.Email, rcpt.Status)
+		}
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/events/farewell/review/"+event.ManageToken+"/test-send", nil)
+	w = httptest.NewRecorder()
+	handlers.ReviewTestSendHandler(w, r, event.Slug, event.ManageToken)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET test send: %d, want 405", w.Code)
+	}
+	if sent := testutil.Outbox(t); len(sent) != 1 {
+		t.Errorf("%d emails sent after a GET, want 1", len(sent))
+	}
+}
@@ -1,73 +1,108 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"image"
-	"image/jpeg"
+	"io"
 	"log"
 	"net/http"
+	"net/mail"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"event-messenger.com/antispam"
+	"event-messenger.com/media"
 	"event-messenger.com/models"
-	"golang.org/x/image/draw"
+	"event-messenger.com/utils"
+	"event-messenger.com/webhooks"
+	"event-messenger.com/wordfilter"
 )
 
 const (
 	MaxNameLength    = 100
 	MaxMessageLength = 500
 	MaxFileSize      = 10 << 20 // 10 MB
+	formOverhead     = 64 << 10 // room for the text fields and multipart framing
+	MaxMediaSeconds  = 10 * 60  // longest recording limit a coordinator can set
 	UploadDir        = "./data/uploads"
-	MaxImageWidth    = 800
+	PendingDir       = "./data/pending" // originals waiting for the image workers
 )
 
-// Allowed MIME types for image uploads
-var allowedImageTypes = map[string]bool{
-	"image/jpeg": true,
-	"image/jpg":  true,
-	"image/png":  true,
-	"image/gif":  true,
-	"image/webp": true,
-}
-
-// saveSubmission saves a submission to the database
-func saveSubmission(eventId int, name string, message string, filename string) error {
-	submission := models.Submission{
-		EventID:  eventId,
-		Name:     name,
-		Message:  message,
-		Filename: filename,
-	}
-
-	// Save submission to the event database
-	err := submission.Save()
-
-	return err
-}
-
 // create formHandler that handles the generation of the input form
 func SubmissionFormHandler(w http.ResponseWriter, r *http.Request, slug string) {
 
 	event, err := models.GetEventBySlug(slug)
 	if err != nil {
-		http.Error(w, "Unable to retreive event data", http.StatusInternalServerError)
-		log.Fatal(err)
+		http.NotFound(w, r)
+		log.Printf("Unable to retreive event %s: %v", slug, err)
 		return
 	}
 
 	data := struct {
-		EventName     string
-		RecipientName string
-		EventSlug     string
+		EventName       string
+		RecipientName   string
+		EventSlug       string
+		MediaMaxSeconds int
+		MediaMaxMB      int64
+		Challenge       antispam.Challenge
+		Invitee         *models.Invitee // when arriving from an invitation
 	}{
-		EventName:     event.Name,
-		RecipientName: event.RecipientName,
-		EventSlug:     slug,
+		EventName:       event.Name,
+		RecipientName:   event.RecipientName,
+		EventSlug:       slug,
+		MediaMaxSeconds: event.MediaMaxSeconds,
+		MediaMaxMB:      event.MediaMaxBytes >> 20,
+		Challenge:       antispam.NewChallenge(),
+		Invitee:         inviteeFor(event, r.URL.Query().Get("invite")),
 	}
 
-	renderTemplate(w, "./templates/submission_form.html", data)
+	renderTemplate(w, "submission_form.html", data)
+
+}
+
+// RequestError is a problem with a request that the sender can fix, or a
+// temporary refusal. Status is the HTTP status to answer with.
+type RequestError struct {
+	Status     int
+	Message    string
+	RetryAfter int // seconds, if the sender should try again later
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+func requestError(status int, format string, args ...any) *RequestError {
+	return &RequestError{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// errBusy is returned while the image workers have no room for another photo
+var errBusy = &RequestError{
+	Status:     http.StatusServiceUnavailable,
+	Message:    "We're processing a lot of photos right now, please try again in a minute",
+	RetryAfter: 60,
+}
+
+// writeError answers a form request with a RequestError's status and
+// message; any other error is logged and answered with a 500
+func writeError(w http.ResponseWriter, err error) {
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		if reqErr.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(reqErr.RetryAfter))
+		}
+		http.Error(w, reqErr.Message, reqErr.Status)
+		return
+	}
 
+	log.Printf("%v", err)
+	http.Error(w, "Something went wrong saving your message, please try again", http.StatusInternalServerError)
 }
 
 // create submitHandler for handling the submission of the message and contents (include success/error msg)
@@ -77,161 +112,460 @@ func SubmissionHandler(w http.ResponseWriter, r *http.Request, slug string) {
 		return
 	}
 
-	// Parse the multipart form (10 MB max)
-	err := r.ParseMultipartForm(10 << 20)
+	event, err := models.GetEventBySlug(slug)
 	if err != nil {
-		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		http.NotFound(w, r)
+		log.Printf("Submission for unknown event %s: %v", slug, err)
 		return
 	}
 
-	// Get form values
-	name := r.FormValue("name")
-	message := r.FormValue("message")
-
-	if len(name) > MaxNameLength {
-		http.Error(w, fmt.Sprintf("Name exceeds maximum length of %d characters", MaxNameLength), http.StatusBadRequest)
+	// Turn floods away before reading uploads or decoding images
+	if wait, ok := antispam.CheckRate(r, slug, event.ID); !ok {
+		w.Header().Set("Retry-After", fmt.Sprint(int(wait.Seconds())+1))
+		http.Error(w, "You're sending messages too quickly, please try again later", http.StatusTooManyRequests)
 		return
 	}
 
-	if len(message) > MaxMessageLength {
-		http.Error(w, fmt.Sprintf("Message exceeds maximum length of %d characters", MaxMessageLength), http.StatusBadRequest)
+	if err := parseSubmissionForm(w, r, event); err != nil {
+		writeError(w, err)
 		return
 	}
 
-	if len(name) == 0 || len(message) == 0 {
-		http.Error(w, "Name and message are required", http.StatusBadRequest)
+	if msg, ok := antispam.CheckForm(r, slug); !ok {
+		http.Error(w, msg, http.StatusForbidden)
 		return
 	}
+	// A refused message can be fixed and sent again with the same form
+	defer antispam.ReleaseChallenge(r)
 
-	// Handle file upload
-	file, handler, err := r.FormFile("image")
+	// Attachments either arrive inline or were sent ahead as resumable uploads
+	image, err := ReadSubmittedFile(r, "image", "upload_id", MaxFileSize)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	recording, err := ReadSubmittedFile(r, "media", "media_upload_id", event.MediaMaxBytes)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 
+	submission, err := CreateSubmission(event, NewSubmission{
+		Name:      r.FormValue("name"),
+		Message:   r.FormValue("message"),
+		Email:     r.FormValue("email"),
+		Image:     image,
+		Recording: recording,
+	}, utils.GetBaseURL(r))
 	if err != nil {
-		if err == http.ErrMissingFile {
-			http.Error(w, "Image upload is required", http.StatusBadRequest)
-			return
-		}
-		http.Error(w, "Error processing image", http.StatusInternalServerError)
-		log.Println(err)
+		writeError(w, err)
 		return
 	}
+	antispam.SpendChallenge(r)
 
-	defer file.Close()
+	// Invitees who sent a message aren't reminded
+	if inv := inviteeFor(event, r.FormValue("invite")); inv != nil {
+		if err := inv.MarkSubmitted(submission.ID); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+
+	// Prepare data for template
+	data := struct {
+		ID        int
+		Name      string
+		Message   string
+		HasImage  bool
+		Status    string
+		EventSlug string
+		Recording *models.Submission
+		EditLink  string
+		Emailed   bool
+		Pending   bool
+	}{
+		ID:        submission.ID,
+		Name:      submission.Name,
+		Message:   submission.Message,
+		HasImage:  image.Data != nil,
+		Status:    submission.Status,
+		EventSlug: slug,
+		EditLink:  EditLink(utils.GetBaseURL(r), slug, submission.EditToken),
+		Emailed:   submission.ContributorEmail != "",
+		Pending:   submission.Moderation == models.ModerationPending,
+	}
+	if submission.MediaFilename != "" {
+		data.Recording = submission
+	}
 
-	//Validate file is an image by checking MIME type
-	buffer := make([]byte, 512) // checks the first 512 bytes of a program
-	_, err = file.Read(buffer)
+	renderTemplate(w, "success.html", data)
+}
+
+// NewSubmission is a message sent in through the form or the API
+type NewSubmission struct {
+	Name      string
+	Message   string
+	Email     string // optional, for sending the edit link
+	Image     SubmittedFile
+	Recording SubmittedFile
+}
+
+// CreateSubmission checks, filters and stores a new submission for event and
+// queues its photo for processing. Contributors who gave an email address
+// are sent their edit link. Problems the sender can fix are returned as a
+// *RequestError.
+func CreateSubmission(event *models.Event, in NewSubmission, baseURL string) (*models.Submission, error) {
+	if err := checkText(in.Name, in.Message); err != nil {
+		return nil, err
+	}
+
+	name, message, flagged, err := filterText(event, in.Name, in.Message)
 	if err != nil {
-		http.Error(w, "Error reading file", http.StatusInternalServerError)
-		return
+		return nil, err
+	}
+
+	email := strings.TrimSpace(in.Email)
+	if email != "" {
+		if _, err := mail.ParseAddress(email); err != nil {
+			return nil, requestError(http.StatusBadRequest, "Please enter a valid email address, or leave it blank")
+		}
+	}
+
+	image, recording := in.Image, in.Recording
+
+	// A voice or video greeting can stand in for the written message and photo
+	if len(name) == 0 || (len(message) == 0 && recording.Data == nil) {
+		return nil, requestError(http.StatusBadRequest, "Name and message are required")
+	}
+
+	if image.Data == nil && recording.Data == nil {
+		return nil, requestError(http.StatusBadRequest, "Image upload is required")
+	}
+
+	var contentType string
+	if image.Data != nil {
+		contentType, err = checkImage(image.Data)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// Reset file pointer to beginning after reading
-	_, err = file.Seek(0, 0)
+	var recordingInfo media.MediaInfo
+	if recording.Data != nil {
+		recordingInfo, err = checkRecording(recording.Data, event)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Create unique filename; the extension is chosen by the processor
+	// (.gif for animations, .jpg for other images, the container's own for recordings)
+	originalName := image.Filename
+	if originalName == "" {
+		originalName = recording.Filename
+	}
+	baseName := storedBaseName(originalName)
+
+	submission := &models.Submission{
+		EventID:          event.ID,
+		Name:             name,
+		Message:          message,
+		Status:           models.SubmissionReady,
+		Moderation:       models.ModerationApproved,
+		ContributorEmail: email,
+	}
+	if event.Moderated || flagged {
+		submission.Moderation = models.ModerationPending
+	}
+
+	// Recordings are stored as uploaded; only their headers are read
+	if recording.Data != nil {
+		submission.MediaFilename, err = media.SaveMedia(recording.Data, UploadDir, baseName, recordingInfo)
+		if err != nil {
+			return nil, fmt.Errorf("recording save error: %v", err)
+		}
+		submission.MediaType = recordingInfo.ContentType
+		submission.MediaDuration = recordingInfo.Duration
+	}
+
+	// Store the original image untouched; resizing happens in the image workers
+	if image.Data != nil {
+		submission.OriginalFilename, err = media.SaveOriginal(image.Data, baseName, contentType)
+		if err != nil {
+			removeStoredFile(submission.MediaFilename)
+			return nil, fmt.Errorf("file save error: %v", err)
+		}
+		submission.Status = models.SubmissionProcessing
+	}
+
+	// Save to database
+	err = submission.Save()
 	if err != nil {
-		http.Error(w, "Error processing file", http.StatusInternalServerError)
-		return
+		media.RemoveOriginal(submission.OriginalFilename)
+		removeStoredFile(submission.MediaFilename)
+		return nil, fmt.Errorf("database save error: %v", err)
+	}
+
+	if image.Data != nil {
+		err = media.Enqueue(media.Job{SubmissionID: submission.ID, BaseName: baseName})
+		if err != nil {
+			submission.Delete()
+			media.RemoveOriginal(submission.OriginalFilename)
+			removeStoredFile(submission.MediaFilename)
+			log.Printf("Could not queue image for submission %d: %v", submission.ID, err)
+			return nil, errBusy
+		}
 	}
 
+	// Resumable uploads have been copied into place
+	consumeUpload(image.UploadID)
+	consumeUpload(recording.UploadID)
+
+	webhooks.PublishSubmission(event, submission)
+
+	if email != "" {
+		go sendEditLink(event, submission, EditLink(baseURL, event.Slug, submission.EditToken))
+	}
+
+	log.Printf("Received submission - Name: %s", name)
+	return submission, nil
+}
+
+// checkText enforces the name and message length limits
+func checkText(name, message string) error {
+	if len(name) > MaxNameLength {
+		return requestError(http.StatusBadRequest, "Name exceeds maximum length of %d characters", MaxNameLength)
+	}
+
+	if len(message) > MaxMessageLength {
+		return requestError(http.StatusBadRequest, "Message exceeds maximum length of %d characters", MaxMessageLength)
+	}
+
+	return nil
+}
+
+// filterText runs the event's content filter over a submission's name and
+// message. It returns the text to store, masked if the event asks for that,
+// and whether to hold the submission for review.
+func filterText(event *models.Event, name, message string) (string, string, bool, error) {
+	if event.FilterAction == wordfilter.ActionOff {
+		return name, message, false, nil
+	}
+
+	filter := wordfilter.New(event.FilterWords)
+	nameMatches, messageMatches := filter.Find(name), filter.Find(message)
+	if len(nameMatches) == 0 && len(messageMatches) == 0 {
+		return name, message, false, nil
+	}
+
+	wordfilter.Record(event.FilterAction)
+	log.Printf("Content filter matched a submission for %s (%s)", event.Slug, event.FilterAction)
+
+	switch event.FilterAction {
+	case wordfilter.ActionReject:
+		return "", "", false, requestError(http.StatusUnprocessableEntity, "Your message contains language this event doesn't allow, please rephrase it")
+	case wordfilter.ActionFlag:
+		return name, message, true, nil
+	default:
+		return wordfilter.Mask(name, nameMatches), wordfilter.Mask(message, messageMatches), false, nil
+	}
+}
+
+// storedBaseName builds a unique filename, without extension, for an upload;
+// the extension is chosen when the file is stored. The random part keeps
+// uploads in the same second apart, as resumable uploads often share a name.
+func storedBaseName(originalName string) string {
+	baseFilename := filepath.Base(originalName)
+	ext := filepath.Ext(baseFilename)
+	nameWithoutExt := baseFilename[:len(baseFilename)-len(ext)]
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%d_%s_%s", time.Now().Unix(), hex.EncodeToString(suffix), nameWithoutExt)
+}
+
+// checkImage detects an uploaded image's type and makes sure the image
+// workers can take it
+func checkImage(data []byte) (string, error) {
 	// Detect content type
-	contentType := http.DetectContentType(buffer)
+	contentType := media.DetectContentType(data)
+
+	if reason, ok := media.UnsupportedImageTypes[contentType]; ok {
+		log.Printf("Unsupported image type: %s", contentType)
+		return "", requestError(http.StatusUnsupportedMediaType, "%s", reason)
+	}
 
-	if !allowedImageTypes[contentType] {
-		http.Error(w, "Only image files (JPEG, PNG, GIF, WebP) are allowed", http.StatusBadRequest)
+	if !media.AllowedImageTypes[contentType] {
 		log.Printf("Invalid file type: %s", contentType)
-		return
+		return "", requestError(http.StatusBadRequest, "Only image files (JPEG, PNG, GIF, WebP) are allowed")
+	}
+
+	// Refuse early rather than storing an original nobody will get to
+	if !media.QueueHasRoom() {
+		return "", errBusy
 	}
 
-	// Decode and resize image
-	img, format, err := image.Decode(file)
+	return contentType, nil
+}
+
+// checkRecording reads an audio/video attachment's container headers and
+// enforces the event's size and duration limits
+func checkRecording(data []byte, event *models.Event) (media.MediaInfo, error) {
+	if int64(len(data)) > event.MediaMaxBytes {
+		return media.MediaInfo{}, requestError(http.StatusRequestEntityTooLarge, "Recordings for this event can be at most %d MB", event.MediaMaxBytes>>20)
+	}
+
+	info, err := media.ProbeMedia(data)
 	if err != nil {
-		http.Error(w, "Error processing image", http.StatusInternalServerError)
-		log.Printf("Image decode error: %v", err)
-		return
+		var limitErr *media.LimitError
+		if errors.As(err, &limitErr) {
+			return media.MediaInfo{}, requestError(http.StatusUnsupportedMediaType, "%s", limitErr.Reason)
+		}
+		log.Printf("Recording probe error: %v", err)
+		return media.MediaInfo{}, requestError(http.StatusBadRequest, "We couldn't read the length of this recording, please try a different file")
 	}
-	log.Printf("Successfully decoded image format: %s", format)
 
-	// Resize if width exceeds limit
-	bounds := img.Bounds()
-	width := bounds.Dx()
-	height := bounds.Dy()
-	log.Printf("Original image dimensions: %dx%d", width, height)
+	maxDuration := time.Duration(event.MediaMaxSeconds) * time.Second
+	if info.Duration > maxDuration {
+		return media.MediaInfo{}, requestError(http.StatusBadRequest, "Recordings for this event can be at most %d seconds long (this one is %d)", event.MediaMaxSeconds, int(info.Duration.Seconds()))
+	}
 
-	if width > MaxImageWidth {
-		// Calculate new dimensions maintaining aspect ratio
-		newWidth := MaxImageWidth
-		newHeight := (height * MaxImageWidth) / width
+	log.Printf("Received %s recording (%v, %.2f KB)", info.ContentType, info.Duration.Round(time.Second), float64(len(data))/1024)
+	return info, nil
+}
 
-		// Create resized image
-		resized := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
-		draw.CatmullRom.Scale(resized, resized.Bounds(), img, bounds, draw.Over, nil)
-		img = resized
-		log.Printf("Resized image from %dx%d to %dx%d", width, height, newWidth, newHeight)
+// parseSubmissionForm reads a submission or edit form, refusing bodies larger
+// than a photo and a recording for the event could need before any of it is
+// stored
+func parseSubmissionForm(w http.ResponseWriter, r *http.Request, event *models.Event) error {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxFileSize+event.MediaMaxBytes+formOverhead)
+
+	// Up to 10 MB is held in memory, the rest goes to temporary files
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return requestError(http.StatusRequestEntityTooLarge, "Photos can be at most %d MB and recordings %d MB", MaxFileSize>>20, event.MediaMaxBytes>>20)
+		}
+		return requestError(http.StatusBadRequest, "Error parsing form")
 	}
+	return nil
+}
 
-	var filename string
+// SubmittedFile is an attachment read from a submission form
+type SubmittedFile struct {
+	Data     []byte // nil if nothing was attached
+	Filename string
+	UploadID string // resumable upload it came from, if any
+}
 
-	// Create uploads directory if it doesn't exist
-	os.MkdirAll("./data/uploads", os.ModePerm)
+// ReadSubmittedFile returns an attachment and its original filename, either
+// from the multipart field or from a completed resumable upload named by
+// uploadField. Either way it can be at most maxSize bytes, which is smaller
+// than the resumable upload limit for some kinds of attachment.
+func ReadSubmittedFile(r *http.Request, field, uploadField string, maxSize int64) (SubmittedFile, error) {
+	if uploadID := r.FormValue(uploadField); uploadID != "" {
+		data, filename, err := ReadCompletedUpload(uploadID)
+		if err != nil {
+			log.Printf("Resumable upload error: %v", err)
+			return SubmittedFile{}, requestError(http.StatusBadRequest, "Your upload didn't finish or has expired, please choose the file again")
+		}
+		if filename == "" {
+			filename = "upload"
+		}
+		if int64(len(data)) > maxSize {
+			return SubmittedFile{}, requestError(http.StatusRequestEntityTooLarge, "%s is too large, it can be at most %d MB", filename, maxSize>>20)
+		}
+		return SubmittedFile{Data: data, Filename: filename, UploadID: uploadID}, nil
+	}
 
-	// Create unique filename (replace all img extensions with .jpg)
-	baseFilename := filepath.Base(handler.Filename)
-	ext := filepath.Ext(baseFilename)
-	nameWithoutExt := baseFilename[:len(baseFilename)-len(ext)]
-	filename = fmt.Sprintf("%d_%s.jpg", time.Now().Unix(), nameWithoutExt)
-	filePath := filepath.Join("./data/uploads", filename)
+	file, handler, err := r.FormFile(field)
+	if err != nil {
+		if err == http.ErrMissingFile || err == http.ErrNotMultipart {
+			return SubmittedFile{}, nil
+		}
+		return SubmittedFile{}, fmt.Errorf("error processing file: %v", err)
+	}
+	defer file.Close()
 
-	// Save file
-	dst, err := os.Create(filePath)
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
 	if err != nil {
-		http.Error(w, "Error saving file", http.StatusInternalServerError)
-		log.Printf("File save error: %v", err)
-		return
+		return SubmittedFile{}, fmt.Errorf("error reading file: %v", err)
+	}
+	if int64(len(data)) > maxSize {
+		return SubmittedFile{}, requestError(http.StatusRequestEntityTooLarge, "%s is too large, it can be at most %d MB", handler.Filename, maxSize>>20)
+	}
+	if len(data) == 0 {
+		return SubmittedFile{}, nil
 	}
 
-	defer dst.Close()
+	return SubmittedFile{Data: data, Filename: handler.Filename}, nil
+}
 
-	// Encode as JPEG with quality 85
-	err = jpeg.Encode(dst, img, &jpeg.Options{Quality: 85})
+// consumeUpload removes a resumable upload once a submission has used it
+func consumeUpload(uploadID string) {
+	if uploadID == "" {
+		return
+	}
+	upload, err := models.GetUpload(uploadID)
 	if err != nil {
-		http.Error(w, "Error saving file", http.StatusInternalServerError)
-		log.Printf("JPEG encode error: %v", err)
 		return
 	}
+	if err := RemoveUpload(upload); err != nil {
+		log.Printf("Could not remove consumed upload %s: %v", uploadID, err)
+	}
+}
 
-	event, err := models.GetEventBySlug(slug)
-	if err != nil {
-		http.Error(w, "Unable to retreive event data", http.StatusInternalServerError)
-		log.Fatal(err)
+// removeStoredFile deletes a file from the uploads directory
+func removeStoredFile(filename string) {
+	if filename == "" {
 		return
 	}
+	if err := os.Remove(filepath.Join(UploadDir, filename)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Could not remove %s: %v", filename, err)
+	}
+}
 
-	// Save to database
-	err = saveSubmission(event.ID, name, message, filename)
+// SubmissionStatusHandler reports whether a submission's image has finished
+// processing, for the success page to poll
+func SubmissionStatusHandler(w http.ResponseWriter, r *http.Request, slug string, id int) {
+	submission, err := models.GetSubmissionByID(id)
 	if err != nil {
-		http.Error(w, "Error saving submission to database", http.StatusInternalServerError)
-		log.Printf("Database save error: %v", err)
+		http.NotFound(w, r)
 		return
 	}
 
-	// Prepare data for template
-	data := map[string]string{
-		"Name":      name,
-		"Message":   message,
-		"Filename":  filename,
-		"EventSlug": slug,
+	event, err := models.GetEventBySlug(slug)
+	if err != nil || event.ID != submission.EventID {
+		http.NotFound(w, r)
+		return
 	}
 
-	// Get saved file size for verification
-	fileInfo, _ := dst.Stat()
-	log.Printf("Successfully saved processed image: %s (size: %.2f KB)", filename, float64(fileInfo.Size())/1024)
-
-	renderTemplate(w, "./templates/success.html", data)
+	status := struct {
+		Status   string `json:"status"`
+		ImageURL string `json:"image_url,omitempty"`
+		Error    string `json:"error,omitempty"`
+	}{
+		Status: submission.Status,
+		Error:  submission.ProcessingError,
+	}
+	if submission.Filename != "" {
+		status.ImageURL = "/uploads/" + submission.Filename
+	}
 
-	log.Printf("Received submission - Name: %s", name)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(status)
 }
 
+// ViewSubmissionsByEvent renders the keepsake page with every message,
+// photo and recording for an event, including after it has been archived
 func ViewSubmissionsByEvent(w http.ResponseWriter, r *http.Request, slug string) {
+	event, err := models.GetEventBySlugIncludingArchived(slug)
+	if err != nil {
+		http.NotFound(w, r)
+		log.Printf("Unable to retreive event %s: %v", slug, err)
+		return
+	}
+
 	submissions, err := models.GetSubmissionsByEventSlug(slug)
 	if err != nil {
 		http.Error(w, "Error retrieving event submissions", http.StatusInternalServerError)
@@ -239,6 +573,17 @@ func ViewSubmissionsByEvent(w http.ResponseWriter, r *http.Request, slug string)
 		return
 	}
 
-	renderTemplate(w, "./templates/view_messages.html", submissions)
+	data := struct {
+		EventName     string
+		RecipientName string
+		EventDate     time.Time
+		Submissions   []models.Submission
+	}{
+		EventName:     event.Name,
+		RecipientName: event.RecipientName,
+		EventDate:     event.EventDate,
+		Submissions:   submissions,
+	}
 
+	renderTemplate(w, "view_messages.html", data)
 }
@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"event-messenger.com/antispam"
+	"event-messenger.com/models"
+)
+
+// Resumable uploads implement the core tus 1.0.0 protocol plus the creation,
+// expiration and termination extensions: https://tus.io/protocols/resumable-upload
+const (
+	TusDir                 = "./data/tus"
+	TusVersion             = "1.0.0"
+	MaxResumableUploadSize = 50 << 20 // 50 MB
+	UploadExpiry           = 24 * time.Hour
+
+	// Unfinished or unused uploads take up disk until they expire, so each
+	// client can only have a few, and all of them together are capped
+	MaxUploadsPerClient = 10
+	MaxPendingUploads   = 2 << 30 // 2 GB
+
+	// How long a single PATCH may take to arrive; longer than the server's
+	// ReadTimeout so slow mobile connections can finish a chunk
+	tusPatchTimeout = 2 * time.Minute
+)
+
+// PATCH requests for the same upload must not interleave
+var uploadLocks sync.Map
+
+// Held while checking the upload quotas and creating an upload
+var createMu sync.Mutex
+
+func lockUpload(id string) func() {
+	mu, _ := uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// TusHandler serves /uploads/tus and /uploads/tus/{id}
+func TusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", TusVersion)
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/uploads/tus"), "/")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", TusVersion)
+		w.Header().Set("Tus-Extension", "creation,expiration,termination")
+		w.Header().Set("Tus-Max-Size", strconv.Itoa(MaxResumableUploadSize))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Header.Get("Tus-Resumable") != TusVersion {
+		w.Header().Set("Tus-Version", TusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		createUpload(w, r)
+	case id != "" && r.Method == http.MethodHead:
+		uploadOffset(w, r, id)
+	case id != "" && r.Method == http.MethodPatch:
+		appendUpload(w, r, id)
+	case id != "" && r.Method == http.MethodDelete:
+		terminateUpload(w, r, id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// createUpload handles POST /uploads/tus
+func createUpload(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "Upload-Length header is required", http.StatusBadRequest)
+		return
+	}
+
+	if length > MaxResumableUploadSize {
+		http.Error(w, fmt.Sprintf("Uploads can be at most %d MB", MaxResumableUploadSize>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if wait, ok := antispam.CheckUploadRate(r); !ok {
+		w.Header().Set("Retry-After", fmt.Sprint(int(wait.Seconds())+1))
+		http.Error(w, "You're uploading too quickly, please try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	client := antispam.ClientKey(r)
+	createMu.Lock()
+	defer createMu.Unlock()
+
+	count, total, err := models.CountLiveUploads(client)
+	if err != nil {
+		http.Error(w, "Error creating upload", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return
+	}
+	if count >= MaxUploadsPerClient {
+		http.Error(w, "You have too many unfinished uploads, please try again later", http.StatusTooManyRequests)
+		return
+	}
+	if total+length > MaxPendingUploads {
+		log.Printf("Refused resumable upload: %d bytes already reserved", total)
+		http.Error(w, "The server is busy, please try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	upload, err := models.NewUpload(length, r.Header.Get("Upload-Metadata"), client, UploadExpiry)
+	if err != nil {
+		http.Error(w, "Error creating upload", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return
+	}
+
+	err = os.MkdirAll(TusDir, os.ModePerm)
+	if err == nil {
+		var f *os.File
+		f, err = os.Create(uploadPath(upload.ID))
+		if err == nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		upload.Delete()
+		http.Error(w, "Error creating upload", http.StatusInternalServerError)
+		log.Printf("Upload file create error: %v", err)
+		return
+	}
+
+	w.Header().Set("Location", "/uploads/tus/"+upload.ID)
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+
+	log.Printf("Created resumable upload %s (%d bytes)", upload.ID, length)
+}
+
+// uploadOffset handles HEAD /uploads/tus/{id}, telling the client where to resume
+func uploadOffset(w http.ResponseWriter, r *http.Request, id string) {
+	upload, ok := getLiveUpload(w, id)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+// appendUpload handles PATCH /uploads/tus/{id}, appending a chunk at Upload-Offset
+func appendUpload(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Upload-Offset header is required", http.StatusBadRequest)
+		return
+	}
+
+	unlock := lockUpload(id)
+	defer unlock()
+
+	upload, ok := getLiveUpload(w, id)
+	if !ok {
+		return
+	}
+
+	if offset != upload.Offset {
+		http.Error(w, "Upload-Offset does not match the current offset", http.StatusConflict)
+		return
+	}
+
+	file, err := os.OpenFile(uploadPath(id), os.O_WRONLY, 0644)
+	if err != nil {
+		http.Error(w, "Error writing upload", http.StatusInternalServerError)
+		log.Printf("Upload file open error: %v", err)
+		return
+	}
+	defer file.Close()
+
+	// Drop anything past the recorded offset left by an interrupted write
+	if err := file.Truncate(offset); err != nil {
+		http.Error(w, "Error writing upload", http.StatusInternalServerError)
+		log.Printf("Upload file truncate error: %v", err)
+		return
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		http.Error(w, "Error writing upload", http.StatusInternalServerError)
+		log.Printf("Upload file seek error: %v", err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Now().Add(tusPatchTimeout))
+	rc.SetWriteDeadline(time.Now().Add(tusPatchTimeout))
+
+	// Keep whatever arrives, even if the connection drops part way through;
+	// the client resumes from the new offset
+	written, copyErr := io.Copy(file, io.LimitReader(r.Body, upload.Length-offset))
+	if syncErr := file.Sync(); syncErr != nil {
+		log.Printf("Upload file sync error: %v", syncErr)
+	}
+
+	err = upload.SetOffset(offset + written)
+	if err != nil {
+		http.Error(w, "Error writing upload", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return
+	}
+
+	if copyErr != nil {
+		log.Printf("Upload %s interrupted at offset %d: %v", id, upload.Offset, copyErr)
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+
+	if upload.Complete() {
+		log.Printf("Resumable upload %s complete", id)
+	}
+}
+
+// terminateUpload handles DELETE /uploads/tus/{id}
+func terminateUpload(w http.ResponseWriter, r *http.Request, id string) {
+	unlock := lockUpload(id)
+	defer unlock()
+
+	upload, ok := getLiveUpload(w, id)
+	if !ok {
+		return
+	}
+
+	if err := RemoveUpload(upload); err != nil {
+		http.Error(w, "Error deleting upload", http.StatusInternalServerError)
+		log.Printf("%v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getLiveUpload loads an upload, writing 404 or 410 if it's missing or expired
+func getLiveUpload(w http.ResponseWriter, id string) (*models.Upload, bool) {
+	if !isUploadID(id) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	upload, err := models.GetUpload(id)
+	if err != nil {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	if upload.Expired() {
+		http.Error(w, "Upload has expired", http.StatusGone)
+		return nil, false
+	}
+
+	return upload, true
+}
+
+// ReadCompletedUpload returns the bytes and original filename of a finished
+// upload, for a submission that references it by ID
+func ReadCompletedUpload(id string) ([]byte, string, error) {
+	if !isUploadID(id) {
+		return nil, "", fmt.Errorf("invalid upload id")
+	}
+
+	upload, err := models.GetUpload(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if upload.Expired() {
+		return nil, "", fmt.Errorf("upload %s has expired", id)
+	}
+
+	if !upload.Complete() {
+		return nil, "", fmt.Errorf("upload %s is incomplete (%d of %d bytes)", id, upload.Offset, upload.Length)
+	}
+
+	data, err := os.ReadFile(uploadPath(id))
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read upload: %w", err)
+	}
+
+	return data, upload.MetadataValue("filename"), nil
+}
+
+// RemoveUpload deletes an upload's file and record
+func RemoveUpload(upload *models.Upload) error {
+	err := os.Remove(uploadPath(upload.ID))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not remove upload file: %w", err)
+	}
+	uploadLocks.Delete(upload.ID)
+	return upload.Delete()
+}
+
+func uploadPath(id string) string {
+	return filepath.Join(TusDir, id)
+}
+
+// isUploadID guards file paths against anything but the hex IDs we generate
+func isUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	for _, c := range id {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
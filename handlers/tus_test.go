@@ -0,0 +1,313 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"event-messenger.com/antispam"
+	"event-messenger.com/config"
+	"event-messenger.com/handlers"
+	"event-messenger.com/internal/testutil"
+	"event-messenger.com/models"
+)
+
+// httptest requests come from 192.0.2.1
+const testClient = "192.0.2.1"
+
+// setupTus runs in a temporary directory, so uploads land in its data/tus,
+// with fresh rate limits too generous to get in the way
+func setupTus(t *testing.T) {
+	t.Helper()
+	testutil.Setup(t)
+	t.Chdir(t.TempDir())
+	antispam.Configure(config.SpamConfig{SubmitIPBurst: 1000, SubmitIPPerHour: 1000})
+}
+
+// tus sends a tus request, with headers given as name, value pairs
+func tus(t *testing.T, method, path string, body []byte, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, path, bytes.NewReader(body))
+	r.Header.Set("Tus-Resumable", handlers.TusVersion)
+	for i := 0; i < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	handlers.TusHandler(w, r)
+	if got := w.Header().Get("Tus-Resumable"); got != handlers.TusVersion {
+		t.Errorf("%s %s: Tus-Resumable %q", method, path, got)
+	}
+	return w
+}
+
+func patch(t *testing.T, location string, offset int, chunk []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	return tus(t, http.MethodPatch, location, chunk,
+		"Content-Type", "application/offset+octet-stream",
+		"Upload-Offset", strconv.Itoa(offset))
+}
+
+// createTusUpload starts an upload of length bytes, returning its location
+func createTusUpload(t *testing.T, length int, filename string) string {
+	t.Helper()
+	w := tus(t, http.MethodPost, "/uploads/tus", nil,
+		"Upload-Length", strconv.Itoa(length),
+		"Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte(filename)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST: %d %s", w.Code, w.Body)
+	}
+	location := w.Header().Get("Location")
+	if !strings.HasPrefix(location, "/uploads/tus/") {
+		t.Fatalf("POST: Location %q", location)
+	}
+	return location
+}
+
+func TestTusOptions(t *testing.T) {
+	setupTus(t)
+	r := httptest.NewRequest(http.MethodOptions, "/uploads/tus", nil)
+	w := httptest.NewRecorder()
+	handlers.TusHandler(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("OPTIONS: %d", w.Code)
+	}
+	for name, want := range map[string]string{
+		"Tus-Version":   "1.0.0",
+		"Tus-Extension": "creation,expiration,termination",
+		"Tus-Max-Size":  strconv.Itoa(50 << 20),
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("OPTIONS: %s %q, want %q", name, got, want)
+		}
+	}
+
+	// Anything else needs the protocol version
+	r = httptest.NewRequest(http.MethodPost, "/uploads/tus", nil)
+	r.Header.Set("Upload-Length", "10")
+	w = httptest.NewRecorder()
+	handlers.TusHandler(w, r)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("POST without Tus-Resumable: %d", w.Code)
+	}
+}
+
+func TestTusCreateLimits(t *testing.T) {
+	setupTus(t)
+
+	tests := []struct {
+		name   string
+		length string
+		want   int
+	}{
+		{"no length", "", http.StatusBadRequest},
+		{"empty", "0", http.StatusBadRequest},
+		{"largest", strconv.Itoa(handlers.MaxResumableUploadSize), http.StatusCreated},
+		{"above 50 MB", strconv.Itoa(handlers.MaxResumableUploadSize + 1), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := tus(t, http.MethodPost, "/uploads/tus", nil, "Upload-Length", tt.length)
+			if w.Code != tt.want {
+				t.Errorf("POST: %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestTusCreateQuota(t *testing.T) {
+	setupTus(t)
+
+	// Each client can have a few unfinished uploads
+	for range handlers.MaxUploadsPerClient - 1 {
+		if _, err := models.NewUpload(10, "", testClient, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	createTusUpload(t, 10, "last.jpg")
+	if w := tus(t, http.MethodPost, "/uploads/tus", nil, "Upload-Length", "10"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("POST over the client's quota: %d %s", w.Code, w.Body)
+	}
+
+	// Expired ones don't count against it
+	setupTus(t)
+	for range handlers.MaxUploadsPerClient {
+		if _, err := models.NewUpload(10, "", testClient, -time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	createTusUpload(t, 10, "photo.jpg")
+
+	// and everybody's together can only take up so much disk
+	remaining := int64(handlers.MaxPendingUploads) - 10
+	for remaining > 0 {
+		length := min(remaining, handlers.MaxResumableUploadSize)
+		if _, err := models.NewUpload(length, "", "198.51.100.7", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		remaining -= length
+	}
+	if w := tus(t, http.MethodPost, "/uploads/tus", nil, "Upload-Length", "1"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("POST over the total quota: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestTusResume(t *testing.T) {
+	setupTus(t)
+	data := []byte("0123456789abcdefghij")
+	location := createTusUpload(t, len(data), "note.txt")
+
+	w := patch(t, location, 0, data[:8])
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "8" {
+		t.Fatalf("first PATCH: %d, offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+
+	// A chunk sent again, or from the wrong place, is refused
+	for _, offset := range []int{0, 4, 12} {
+		if w := patch(t, location, offset, data[offset:]); w.Code != http.StatusConflict {
+			t.Errorf("PATCH at %d: %d, want 409", offset, w.Code)
+		}
+	}
+	if w := tus(t, http.MethodPatch, location, data[8:], "Upload-Offset", "8"); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("PATCH without the tus Content-Type: %d", w.Code)
+	}
+
+	// After a dropped connection the client asks where to carry on from
+	w = tus(t, http.MethodHead, location, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("HEAD: %d", w.Code)
+	}
+	if offset, length := w.Header().Get("Upload-Offset"), w.Header().Get("Upload-Length"); offset != "8" || length != "20" {
+		t.Errorf("HEAD: offset %q of %q", offset, length)
+	}
+	if w.Header().Get("Upload-Expires") == "" || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("HEAD: headers %v", w.Header())
+	}
+
+	// Anything past the upload's length is left unread
+	w = patch(t, location, 8, append(data[8:], "and more"...))
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "20" {
+		t.Fatalf("last PATCH: %d, offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+
+	got, filename, err := handlers.ReadCompletedUpload(strings.TrimPrefix(location, "/uploads/tus/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) || filename != "note.txt" {
+		t.Errorf("completed upload %q named %q", got, filename)
+	}
+}
+
+func TestTusIncompleteUploadCantBeUsed(t *testing.T) {
+	setupTus(t)
+	location := createTusUpload(t, 10, "photo.jpg")
+	patch(t, location, 0, []byte("01234"))
+
+	if _, _, err := handlers.ReadCompletedUpload(strings.TrimPrefix(location, "/uploads/tus/")); err == nil {
+		t.Error("ReadCompletedUpload returned half an upload")
+	}
+}
+
+func TestTusExpired(t *testing.T) {
+	setupTus(t)
+	upload, err := models.NewUpload(10, "", testClient, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	location := "/uploads/tus/" + upload.ID
+
+	if w := tus(t, http.MethodHead, location, nil); w.Code != http.StatusGone {
+		t.Errorf("HEAD: %d, want 410", w.Code)
+	}
+	if w := patch(t, location, 0, []byte("0123456789")); w.Code != http.StatusGone {
+		t.Errorf("PATCH: %d, want 410", w.Code)
+	}
+	if _, _, err := handlers.ReadCompletedUpload(upload.ID); err == nil {
+		t.Error("ReadCompletedUpload returned an expired upload")
+	}
+}
+
+func TestTusDelete(t *testing.T) {
+	setupTus(t)
+	location := createTusUpload(t, 10, "photo.jpg")
+	id := strings.TrimPrefix(location, "/uploads/tus/")
+	patch(t, location, 0, []byte("01234"))
+
+	if w := tus(t, http.MethodDelete, location, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(handlers.TusDir, id)); !os.IsNotExist(err) {
+		t.Errorf("upload file left behind: %v", err)
+	}
+	if _, err := models.GetUpload(id); err == nil {
+		t.Error("upload record left behind")
+	}
+	for _, method := range []string{http.MethodHead, http.MethodDelete} {
+		if w := tus(t, method, location, nil); w.Code != http.StatusNotFound {
+			t.Errorf("%s after DELETE: %d, want 404", method, w.Code)
+		}
+	}
+
+	// IDs that aren't ours never reach the file system
+	if w := tus(t, http.MethodDelete, "/uploads/tus/..%2F..%2Fapp.db", nil); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE of a path: %d, want 404", w.Code)
+	}
+}
+
+// A photo sent ahead as a resumable upload is held to the photo limit, not
+// the larger resumable upload limit
+func TestReadSubmittedFileLimitsUploads(t *testing.T) {
+	setupTus(t)
+	size := handlers.MaxFileSize + 1
+	location := createTusUpload(t, size, "huge.jpg")
+	if w := patch(t, location, 0, make([]byte, size)); w.Header().Get("Upload-Offset") != strconv.Itoa(size) {
+		t.Fatalf("PATCH: %d, offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+
+	form := url.Values{"upload_id": {strings.TrimPrefix(location, "/uploads/tus/")}}
+	read := func(maxSize int64) (handlers.SubmittedFile, error) {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return handlers.ReadSubmittedFile(r, "image", "upload_id", maxSize)
+	}
+
+	_, err := read(handlers.MaxFileSize)
+	var reqErr *handlers.RequestError
+	if !errors.As(err, &reqErr) || reqErr.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("photo over the limit: %v, want 413", err)
+	}
+
+	file, err := read(handlers.MaxResumableUploadSize)
+	if err != nil || len(file.Data) != size || file.Filename != "huge.jpg" {
+		t.Errorf("recording under the limit: %d bytes named %q, %v", len(file.Data), file.Filename, err)
+	}
+}
+
+// Resumable uploads without a filename all arrive as "upload", so stored
+// names can't rely on it
+func TestStoredBaseNameIsUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for range 100 {
+		name := handlers.StoredBaseName("upload")
+		if seen[name] {
+			t.Fatalf("%s stored twice", name)
+		}
+		seen[name] = true
+		if !strings.HasSuffix(name, "_upload") {
+			t.Errorf("%s doesn't keep the original name", name)
+		}
+	}
+	if name := handlers.StoredBaseName("../../etc/passwd.jpg"); strings.ContainsAny(name, "/.") {
+		t.Errorf("stored name %q has a path in it", name)
+	}
+}
@@ -0,0 +1,60 @@
+// Package testutil sets up the global configuration, database and templates
+// the app's packages share, so each test starts from a clean slate.
+package testutil
+
+import (
+	"path/filepath"
+	"testing"
+
+	"event-messenger.com/config"
+	"event-messenger.com/db"
+	"event-messenger.com/handlers"
+	"event-messenger.com/mailer"
+)
+
+// Setup replaces config.App with an empty configuration, opens a fresh
+// database in a temporary directory and loads the embedded templates
+func Setup(t testing.TB) {
+	t.Helper()
+	config.App = &config.Config{}
+	config.App.DBPath = filepath.Join(t.TempDir(), "app.db")
+	db.InitDB()
+	t.Cleanup(func() { db.DB.Close() })
+
+	if err := handlers.LoadAssets("", false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// UseOutbox sends mail from events@example.com to a new outbox directory,
+// which it returns, rather than over SMTP
+func UseOutbox(t testing.TB) string {
+	t.Helper()
+	config.App.MailTransport = mailer.TransportOutbox
+	config.App.OutboxDir = t.TempDir()
+	config.App.FromEmail = "events@example.com"
+	return config.App.OutboxDir
+}
+
+// Outbox returns the messages in the outbox, newest first. Each copy's
+// envelope recipient is in its Delivered-To header.
+func Outbox(t testing.TB) []*mailer.Parsed {
+	t.Helper()
+	saved, err := mailer.ListOutbox(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []*mailer.Parsed
+	for _, m := range saved {
+		raw, err := mailer.ReadOutbox(m.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := mailer.Parse(raw)
+		if err != nil {
+			t.Fatalf("%s: %v", m.Name, err)
+		}
+		messages = append(messages, p)
+	}
+	return messages
+}
@@ -0,0 +1,206 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The examples from RFC 6376 section 3.4.5
+func TestRelaxedCanonicalization(t *testing.T) {
+	header := "A: X\r\nB : Y\t\r\n\tZ  \r\n"
+	var got []string
+	for _, field := range headerFields([]byte(header)) {
+		got = append(got, relaxedHeader(field))
+	}
+	if want := "a:X\r\nb:Y Z\r\n"; strings.Join(got, "") != want {
+		t.Errorf("relaxed header = %q, want %q", strings.Join(got, ""), want)
+	}
+
+	body := " C \r\nD \t E\r\n\r\n\r\n"
+	if got, want := string(relaxedBody([]byte(body))), " C\r\nD E\r\n"; got != want {
+		t.Errorf("relaxed body = %q, want %q", got, want)
+	}
+}
+
+func TestRelaxedBodyHash(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		// The example message of RFC 8463 appendix A
+		{"rfc 8463", "Hi.\r\n\r\nWe lost the game.  Are you hungry yet?\r\n\r\nJoe.\r\n", "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8="},
+		// An empty body canonicalizes to nothing at all (RFC 6376 errata 3951)
+		{"empty", "", "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="},
+		{"only blank lines", "\r\n\r\n", "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="},
+		{"trailing whitespace", "Hi. \t\r\n\r\nWe lost the game.\t Are you hungry yet?\r\n\r\nJoe.\r\n\r\n", "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sum := sha256.Sum256(relaxedBody([]byte(tt.body)))
+			if got := base64.StdEncoding.EncodeToString(sum[:]); got != tt.want {
+				t.Errorf("body hash = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// readKey loads one of the fixed keys in testdata
+func readKey(t *testing.T, name string) crypto.Signer {
+	t.Helper()
+	pemData, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := parseDKIMKey(pemData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+var bTag = regexp.MustCompile(`(^|;)(\s*b\s*=)[^;]*`)
+
+// verifyDKIM checks the DKIM-Signature at the top of msg the way a receiving
+// server would, against the public key it would find in DNS
+func verifyDKIM(t *testing.T, msg []byte, pub crypto.PublicKey) error {
+	t.Helper()
+	header, body, _ := bytes.Cut(msg, []byte("\r\n\r\n"))
+	fields := headerFields(append(header, "\r\n"...))
+	if fieldName(fields[0]) != "DKIM-Signature" {
+		t.Fatalf("message starts with %q", fields[0])
+	}
+	sigField := fields[0]
+
+	tags := map[string]string{}
+	_, value, _ := strings.Cut(sigField, ":")
+	for _, tag := range strings.Split(value, ";") {
+		name, v, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(name)] = strings.Join(strings.Fields(v), "")
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+	if got := base64.StdEncoding.EncodeToString(bodyHash[:]); got != tags["bh"] {
+		return fmt.Errorf("body hash %s, signed %s", got, tags["bh"])
+	}
+
+	var data strings.Builder
+	for _, name := range strings.Split(tags["h"], ":") {
+		for i := len(fields) - 1; i > 0; i-- {
+			if strings.EqualFold(fieldName(fields[i]), name) {
+				data.WriteString(relaxedHeader(fields[i]))
+				break
+			}
+		}
+	}
+	name, value, _ := strings.Cut(sigField, ":")
+	data.WriteString(strings.TrimSuffix(relaxedHeader(name+":"+bTag.ReplaceAllString(value, "$1$2")), "\r\n"))
+	hash := sha256.Sum256([]byte(data.String()))
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		t.Fatalf("b= isn't base64: %v", err)
+	}
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if tags["a"] != "rsa-sha256" {
+			t.Errorf("a=%s for an RSA key", tags["a"])
+		}
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], sig)
+	case ed25519.PublicKey:
+		if tags["a"] != "ed25519-sha256" {
+			t.Errorf("a=%s for an Ed25519 key", tags["a"])
+		}
+		if !ed25519.Verify(pub, hash[:], sig) {
+			return errors.New("ed25519 signature doesn't verify")
+		}
+		return nil
+	}
+	t.Fatalf("unexpected key %T", pub)
+	return nil
+}
+
+func TestDKIMSign(t *testing.T) {
+	now := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	for _, keyFile := range []string{"dkim_rsa.pem", "dkim_ed25519.pem"} {
+		t.Run(keyFile, func(t *testing.T) {
+			key := readKey(t, keyFile)
+			signer, err := NewDKIMSigner("example.com", "events", key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m := goldenMessage()
+			m.Text = "Good luck!   \r\n\r\n"
+			m.Subject = "Your Café Messages, with a subject long enough that it has to be folded over two lines"
+			m.Unsubscribe = "https://example.com/unsubscribe/token"
+			msg := m.Bytes()
+
+			signed, err := signer.Sign(msg, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasSuffix(signed, msg) {
+				t.Fatal("signing changed the message")
+			}
+			if err := verifyDKIM(t, signed, key.Public()); err != nil {
+				t.Fatalf("signature doesn't verify: %v", err)
+			}
+
+			sigField := headerFields(signed)[0]
+			for _, tag := range []string{"d=example.com;", "s=events;", "c=relaxed/relaxed;", "t=1709285400;",
+				"h=from:to:subject:date:message-id:list-unsubscribe:list-unsubscribe-post:mime-version:content-type;"} {
+				if !strings.Contains(sigField, tag) {
+					t.Errorf("signature has no %s: %s", tag, sigField)
+				}
+			}
+
+			// Relaxed canonicalization survives servers refolding headers
+			// and reflowing whitespace in the body
+			refolded := bytes.Replace(signed, []byte("To: "), []byte("To:\r\n\t  "), 1)
+			refolded = bytes.Replace(refolded, []byte("Good luck!"), []byte("Good  luck!\t"), 1)
+			if bytes.Count(refolded, []byte("\t")) < 2 {
+				t.Fatal("message wasn't refolded")
+			}
+			if err := verifyDKIM(t, refolded, key.Public()); err != nil {
+				t.Errorf("refolded message doesn't verify: %v", err)
+			}
+
+			// but not changes to the content
+			for _, change := range [][2]string{
+				{"Messages", "Massages"},
+				{"Good luck!", "Bad luck!"},
+				{"From: ", "From: \"Someone Else\" <x@example.org>, "},
+			} {
+				tampered := bytes.Replace(signed, []byte(change[0]), []byte(change[1]), 1)
+				if bytes.Equal(tampered, signed) {
+					t.Fatalf("message has no %q", change[0])
+				}
+				if err := verifyDKIM(t, tampered, key.Public()); err == nil {
+					t.Errorf("message with %q changed to %q still verifies", change[0], change[1])
+				}
+			}
+		})
+	}
+}
+
+func TestParseDKIMKeyRejectsOtherData(t *testing.T) {
+	if _, err := parseDKIMKey([]byte("-----BEGIN PUBLIC KEY-----\nAAAA\n-----END PUBLIC KEY-----\n")); err == nil {
+		t.Error("parseDKIMKey accepted a public key")
+	}
+	if _, err := parseDKIMKey([]byte("not a key")); err == nil {
+		t.Error("parseDKIMKey accepted text that isn't PEM")
+	}
+}
@@ -0,0 +1,52 @@
+// Package mailer composes email and delivers it over SMTP, or to an outbox
+// directory during development
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"event-messenger.com/config"
+)
+
+// Transports for MAIL_TRANSPORT
+const (
+	TransportSMTP   = "smtp"
+	TransportOutbox = "outbox" // write .eml files to MAIL_OUTBOX_DIR
+)
+
+// Configure checks the mail settings and loads the DKIM key, so mistakes
+// show up at startup rather than on the first send
+func Configure(cfg config.EmailConfig) error {
+	switch cfg.MailTransport {
+	case TransportSMTP:
+	case TransportOutbox:
+		if err := os.MkdirAll(cfg.OutboxDir, 0755); err != nil {
+			return fmt.Errorf("error creating outbox: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown MAIL_TRANSPORT %q", cfg.MailTransport)
+	}
+
+	return loadDKIM(cfg)
+}
+
+// Send delivers msg, a complete message with headers, from from to each of
+// rcpts, signing it first if DKIM is set up
+func Send(from string, rcpts []string, msg []byte) error {
+	cfg := config.App.EmailConfig
+
+	if dkim != nil {
+		signed, err := dkim.Sign(msg, time.Now())
+		if err != nil {
+			return err
+		}
+		msg = signed
+	}
+
+	if cfg.MailTransport == TransportOutbox {
+		return writeOutbox(cfg.OutboxDir, from, rcpts, msg)
+	}
+	return sendSMTP(cfg, from, rcpts, msg)
+}
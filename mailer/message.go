@@ -0,0 +1,209 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	"event-messenger.com/config"
+)
+
+// Header lines are folded to stay under this length where they can be
+const maxLineLength = 78
+
+// Message is an email with an HTML body and, optionally, a plain-text
+// alternative. Bytes renders it the same way every time, so Date and
+// MessageID are fields rather than filled in when sending.
+type Message struct {
+	From      mail.Address
+	To        []mail.Address
+	Cc        []mail.Address // Bcc recipients never appear in the message
+	Subject   string
+	Text      string
+	HTML      string
+	Date      time.Time
+	MessageID string // without the angle brackets
+
+	// Unsubscribe is a URL that unsubscribes the recipient when
+	// POSTed to, offered as one-click unsubscribe (RFC 8058)
+	Unsubscribe string
+
+	// Attachments follow the bodies. Those with a ContentID are shown
+	// inline, for cid: links in the HTML.
+	Attachments []Attachment
+}
+
+// NewMessage starts a message from SMTP_FROM_EMAIL, which may include a
+// display name, dated now and with a new Message-ID
+func NewMessage(subject string) *Message {
+	from, err := mail.ParseAddress(config.App.FromEmail)
+	if err != nil {
+		from = &mail.Address{Address: config.App.FromEmail}
+	}
+	return &Message{
+		From:      *from,
+		Subject:   subject,
+		Date:      time.Now(),
+		MessageID: NewMessageID(from.Address),
+	}
+}
+
+// NewMessageID returns a random Message-ID in the sender's domain
+func NewMessageID(from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b) + "@" + domain
+}
+
+// Bytes renders the message with RFC 5322 headers in a fixed order, RFC 2047
+// encoded words where they're needed, quoted-printable bodies and base64
+// attachments
+func (m *Message) Bytes() []byte {
+	var b bytes.Buffer
+
+	writeHeader(&b, "Date", m.Date.Format(time.RFC1123Z))
+	writeHeader(&b, "From", m.From.String())
+	if len(m.To) > 0 {
+		writeHeader(&b, "To", addressList(m.To))
+	}
+	if len(m.Cc) > 0 {
+		writeHeader(&b, "Cc", addressList(m.Cc))
+	}
+	writeHeader(&b, "Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	writeHeader(&b, "Message-ID", "<"+m.MessageID+">")
+	if m.Unsubscribe != "" {
+		writeHeader(&b, "List-Unsubscribe", "<"+m.Unsubscribe+">")
+		writeHeader(&b, "List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+	writeHeader(&b, "MIME-Version", "1.0")
+
+	if len(m.Attachments) == 0 {
+		m.writeBody(&b)
+		return b.Bytes()
+	}
+
+	h := sha256.New()
+	h.Write([]byte("mixed\x00" + m.Text + "\x00" + m.HTML))
+	for _, a := range m.Attachments {
+		h.Write([]byte("\x00" + a.Filename + "\x00"))
+		h.Write(a.Data)
+	}
+	boundary := "=_" + hex.EncodeToString(h.Sum(nil)[:12])
+
+	writeHeader(&b, "Content-Type", fmt.Sprintf(`multipart/mixed; boundary="%s"`, boundary))
+	b.WriteString("\r\n")
+	b.WriteString("--" + boundary + "\r\n")
+	m.writeBody(&b)
+	for _, a := range m.Attachments {
+		b.WriteString("\r\n--" + boundary + "\r\n")
+		writeAttachment(&b, a)
+	}
+	b.WriteString("\r\n--" + boundary + "--\r\n")
+	return b.Bytes()
+}
+
+// writeBody writes the HTML body, or the plain-text and HTML bodies as
+// alternatives, with their Content-Type header
+func (m *Message) writeBody(b *bytes.Buffer) {
+	if m.Text == "" {
+		writePart(b, "text/html", m.HTML)
+		return
+	}
+
+	// Quoted-printable turns every "=" into "=3D", so a boundary starting
+	// "=_" can't turn up in either body. Deriving the rest from the content
+	// keeps the output the same from one run to the next.
+	sum := sha256.Sum256([]byte(m.Text + "\x00" + m.HTML))
+	boundary := "=_" + hex.EncodeToString(sum[:12])
+
+	writeHeader(b, "Content-Type", fmt.Sprintf(`multipart/alternative; boundary="%s"`, boundary))
+	b.WriteString("\r\n")
+	b.WriteString("--" + boundary + "\r\n")
+	writePart(b, "text/plain", m.Text)
+	b.WriteString("\r\n--" + boundary + "\r\n")
+	writePart(b, "text/html", m.HTML)
+	b.WriteString("\r\n--" + boundary + "--\r\n")
+}
+
+// writeAttachment writes an attachment in base64, which has no "=" except
+// as padding, so the "=_" boundaries can't turn up in it either
+func writeAttachment(b *bytes.Buffer, a Attachment) {
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	disposition := "attachment"
+	if a.ContentID != "" {
+		disposition = "inline"
+	}
+	// FormatMediaType uses RFC 2231 for filenames that aren't ASCII
+	typeParams, dispParams := map[string]string{}, map[string]string{}
+	if a.Filename != "" {
+		typeParams["name"] = a.Filename
+		dispParams["filename"] = a.Filename
+	}
+	writeHeader(b, "Content-Type", mime.FormatMediaType(contentType, typeParams))
+	writeHeader(b, "Content-Disposition", mime.FormatMediaType(disposition, dispParams))
+	writeHeader(b, "Content-Transfer-Encoding", "base64")
+	if a.ContentID != "" {
+		writeHeader(b, "Content-ID", "<"+a.ContentID+">")
+	}
+	b.WriteString("\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString(a.Data)
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+}
+
+// writePart writes a part's headers and its body in quoted-printable
+func writePart(b *bytes.Buffer, contentType, body string) {
+	writeHeader(b, "Content-Type", contentType+`; charset="utf-8"`)
+	writeHeader(b, "Content-Transfer-Encoding", "quoted-printable")
+	b.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(b)
+	qp.Write([]byte(body))
+	qp.Close()
+}
+
+// addressList joins addresses, encoding any names that need it
+func addressList(addresses []mail.Address) string {
+	parts := make([]string, len(addresses))
+	for i, a := range addresses {
+		parts[i] = a.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// writeHeader writes "name: value", folding long values at spaces. Encoded
+// words and address lists both have a space at every place they can break.
+func writeHeader(b *bytes.Buffer, name, value string) {
+	// Newlines in a value would start a new header
+	value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+
+	line := name + ":"
+	for _, word := range strings.Split(value, " ") {
+		// A word too long for any line isn't worth starting a new one for
+		if len(line)+1+len(word) > maxLineLength && len(word) < maxLineLength {
+			b.WriteString(line + "\r\n")
+			line = ""
+		}
+		line += " " + word
+	}
+	b.WriteString(line + "\r\n")
+}
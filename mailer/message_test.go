@@ -0,0 +1,114 @@
+package mailer
+
+import (
+	"bytes"
+	"flag"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenMessage returns a message with everything Bytes could vary on fixed
+func goldenMessage() *Message {
+	return &Message{
+		From:      mail.Address{Name: "Event Messenger", Address: "events@example.com"},
+		To:        []mail.Address{{Name: "Alex", Address: "alex@example.com"}},
+		Subject:   "Your Farewell Messages",
+		HTML:      "<p>Good luck!</p>",
+		Date:      time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC),
+		MessageID: "0123456789abcdef@example.com",
+	}
+}
+
+func TestMessageBytes(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(m *Message)
+	}{
+		{"plain", func(m *Message) {}},
+		{"alternative", func(m *Message) {
+			m.Text = "Good luck!"
+			m.Unsubscribe = "https://example.com/unsubscribe/token"
+		}},
+		{"attachments", func(m *Message) {
+			m.Text = "Good luck!"
+			m.HTML = `<p>Good luck!</p><img src="cid:photo@example.com">`
+			m.Attachments = []Attachment{
+				{Filename: "photo.png", ContentType: "image/png", ContentID: "photo@example.com", Data: []byte("\x89PNG\r\n\x1a\n not really a png")},
+				{Filename: "Grüße.txt", ContentType: "text/plain", Data: []byte(strings.Repeat("All the best from everyone. ", 5))},
+			}
+		}},
+		{"encoded_headers", func(m *Message) {
+			m.From.Name = "Zoë’s Party"
+			m.To = []mail.Address{{Name: "José Müller", Address: "jose@example.com"}, {Name: "Plain Name", Address: "plain@example.com"}}
+			m.Cc = []mail.Address{{Name: "李雷", Address: "li@example.com"}}
+			m.Subject = "Your Café Soirée Messages 🎉 from everyone who couldn’t be there in person"
+		}},
+		{"long_lines", func(m *Message) {
+			m.Text = strings.Repeat("A message that goes on and on without a line break. ", 8) + "\nSigned, Ünal = happy"
+			m.HTML = "<p>" + strings.Repeat("x", 200) + "</p>"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := goldenMessage()
+			tt.edit(m)
+			got := m.Bytes()
+
+			golden := filepath.Join("testdata", tt.name+".eml")
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("Bytes() doesn't match %s (run with -update if the change is intended)\ngot:\n%s", golden, got)
+			}
+
+			// Output is plain ASCII in lines short enough for any server
+			for i, line := range strings.Split(strings.TrimSuffix(string(got), "\r\n"), "\r\n") {
+				if len(line) > maxLineLength {
+					t.Errorf("line %d is %d characters: %q", i+1, len(line), line)
+				}
+				for _, c := range []byte(line) {
+					if c > 0x7e || c == '\r' || c == '\n' {
+						t.Errorf("line %d has byte %#x: %q", i+1, c, line)
+						break
+					}
+				}
+			}
+
+			// and reads back as what went in
+			p, err := Parse(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from, err := mail.ParseAddress(p.From)
+			if err != nil || *from != m.From || p.Subject != m.Subject {
+				t.Errorf("headers read back as From %q, Subject %q", p.From, p.Subject)
+			}
+			// Quoted-printable sends line breaks as CRLF
+			if strings.ReplaceAll(p.Text, "\r\n", "\n") != m.Text || p.HTML != m.HTML {
+				t.Errorf("bodies read back as %q and %q", p.Text, p.HTML)
+			}
+			if len(p.Attachments) != len(m.Attachments) {
+				t.Fatalf("%d attachments read back, want %d", len(p.Attachments), len(m.Attachments))
+			}
+			for i, a := range m.Attachments {
+				got := p.Attachments[i]
+				if got.Filename != a.Filename || got.ContentType != a.ContentType || got.ContentID != a.ContentID || !bytes.Equal(got.Data, a.Data) {
+					t.Errorf("attachment %d read back as %+v", i, got)
+				}
+			}
+		})
+	}
+}
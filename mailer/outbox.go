@@ -0,0 +1,105 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"event-messenger.com/config"
+)
+
+// OutboxMessage is a message the outbox transport wrote instead of sending
+type OutboxMessage struct {
+	Name    string // file name in the outbox directory
+	Time    time.Time
+	To      string // the envelope recipient, who may be a Bcc
+	Subject string
+	Size    int64
+}
+
+// writeOutbox saves one copy of msg per recipient, with the envelope in
+// Return-Path and Delivered-To headers as a mail server would add them
+func writeOutbox(dir, from string, rcpts []string, msg []byte) error {
+	for _, rcpt := range rcpts {
+		var b bytes.Buffer
+		writeHeader(&b, "Return-Path", "<"+from+">")
+		writeHeader(&b, "Delivered-To", rcpt)
+		b.Write(msg)
+
+		suffix := make([]byte, 4)
+		rand.Read(suffix)
+		name := time.Now().UTC().Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix) + ".eml"
+
+		// Written under another name first, so a half-written file never
+		// shows up in the list
+		tmp := filepath.Join(dir, "."+name+".tmp")
+		if err := os.WriteFile(tmp, b.Bytes(), 0644); err != nil {
+			return fmt.Errorf("error writing to outbox: %w", err)
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("error writing to outbox: %w", err)
+		}
+	}
+	return nil
+}
+
+// ListOutbox returns up to limit of the newest messages in the outbox
+func ListOutbox(limit int) ([]OutboxMessage, error) {
+	entries, err := os.ReadDir(config.App.OutboxDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading outbox: %w", err)
+	}
+
+	// Names start with the time they were written
+	var names []string
+	for _, e := range entries {
+		if validOutboxName(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	if len(names) > limit {
+		names = names[:limit]
+	}
+
+	messages := make([]OutboxMessage, 0, len(names))
+	for _, name := range names {
+		raw, err := ReadOutbox(name)
+		if err != nil {
+			return nil, err
+		}
+		m := OutboxMessage{Name: name, Size: int64(len(raw))}
+		if msg, err := mail.ReadMessage(bytes.NewReader(raw)); err == nil {
+			m.To = msg.Header.Get("Delivered-To")
+			m.Subject = decodeHeader(msg.Header.Get("Subject"))
+			m.Time, _ = msg.Header.Date()
+		}
+		messages = append(messages, m)
+	}
+	return messages, nil
+}
+
+// ReadOutbox returns the raw message saved under name
+func ReadOutbox(name string) ([]byte, error) {
+	if !validOutboxName(name) {
+		return nil, os.ErrNotExist
+	}
+	return os.ReadFile(filepath.Join(config.App.OutboxDir, name))
+}
+
+// validOutboxName rejects anything that isn't a plain .eml file name, so
+// names from URLs can't reach outside the outbox
+func validOutboxName(name string) bool {
+	return strings.HasSuffix(name, ".eml") && !strings.HasPrefix(name, ".") && filepath.Base(name) == name
+}
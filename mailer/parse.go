@@ -0,0 +1,132 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// Parsed is a message taken apart for display
+type Parsed struct {
+	Header  mail.Header
+	From    string // decoded from encoded words, like To, Cc and Subject
+	To      string
+	Cc      string
+	Subject string
+	Text    string
+	HTML    string
+
+	Attachments []Attachment
+}
+
+// Attachment is any part of a message other than its text and HTML bodies
+type Attachment struct {
+	Filename    string
+	ContentType string
+	ContentID   string // for parts an HTML body shows inline with cid: links
+	Data        []byte
+}
+
+// Parse reads a raw message, picking the plain-text and HTML bodies and any
+// attachments out of its multipart structure
+func Parse(raw []byte) (*Parsed, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("error reading message: %w", err)
+	}
+
+	p := &Parsed{
+		Header:  msg.Header,
+		From:    decodeHeader(msg.Header.Get("From")),
+		To:      decodeHeader(msg.Header.Get("To")),
+		Cc:      decodeHeader(msg.Header.Get("Cc")),
+		Subject: decodeHeader(msg.Header.Get("Subject")),
+	}
+	if err := p.readPart(textproto.MIMEHeader(msg.Header), msg.Body); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// readPart keeps the first text/plain and text/html bodies it comes across,
+// and anything else as an attachment
+func (p *Parsed) readPart(header textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error reading message part: %w", err)
+			}
+			if err := p.readPart(part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(decodeBody(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("error decoding message part: %w", err)
+	}
+
+	disposition, dispParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := decodeHeader(dispParams["filename"])
+	if filename == "" {
+		filename = decodeHeader(params["name"])
+	}
+
+	if disposition != "attachment" && filename == "" {
+		if mediaType == "text/html" && p.HTML == "" {
+			p.HTML = string(data)
+			return nil
+		}
+		if mediaType == "text/plain" && p.Text == "" {
+			p.Text = string(data)
+			return nil
+		}
+	}
+
+	p.Attachments = append(p.Attachments, Attachment{
+		Filename:    filename,
+		ContentType: mediaType,
+		ContentID:   strings.Trim(header.Get("Content-ID"), "<>"),
+		Data:        data,
+	})
+	return nil
+}
+
+func decodeBody(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	case "base64":
+		// The decoder skips the line breaks base64 bodies are wrapped with
+		return base64.NewDecoder(base64.StdEncoding, body)
+	}
+	return body
+}
+
+// decodeHeader turns RFC 2047 encoded words back into text, leaving the value
+// as it was if it can't be decoded
+func decodeHeader(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
@@ -0,0 +1,266 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"event-messenger.com/config"
+)
+
+// TLS modes for SMTP_TLS
+const (
+	TLSAuto          = "auto"          // implicit on port 465, otherwise opportunistic
+	TLSImplicit      = "implicit"      // TLS from the first byte, as on port 465
+	TLSStartTLS      = "starttls"      // upgrade with STARTTLS or refuse to send
+	TLSOpportunistic = "opportunistic" // upgrade with STARTTLS if the server offers it
+	TLSNone          = "none"          // never encrypt, e.g. for a local relay
+)
+
+// Auth methods for SMTP_AUTH
+const (
+	AuthAuto    = "auto" // the best one the server offers, or none without a username
+	AuthPlain   = "plain"
+	AuthLogin   = "login"
+	AuthCRAMMD5 = "cram-md5"
+	AuthNone    = "none"
+)
+
+// rootCAs verifies the server's certificate; nil uses the system's roots
+var rootCAs *x509.CertPool
+
+// sendSMTP delivers msg through the configured server
+func sendSMTP(cfg config.EmailConfig, from string, rcpts []string, msg []byte) error {
+	c, err := dial(cfg)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := startTLS(c, cfg); err != nil {
+		return err
+	}
+	if err := authenticate(c, cfg); err != nil {
+		return err
+	}
+
+	if err := c.Mail(from); err != nil {
+		return fmt.Errorf("MAIL FROM rejected: %w", err)
+	}
+	for _, rcpt := range rcpts {
+		if err := c.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("RCPT TO %s rejected: %w", rcpt, err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("DATA rejected: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("error writing message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("message rejected: %w", err)
+	}
+
+	// The message is accepted by now, so a failed QUIT doesn't matter
+	c.Quit()
+	return nil
+}
+
+// tlsMode resolves TLSAuto and rejects unknown modes
+func tlsMode(cfg config.EmailConfig) (string, error) {
+	switch cfg.SMTPTLS {
+	case "", TLSAuto:
+		if cfg.SMTPPort == 465 {
+			return TLSImplicit, nil
+		}
+		return TLSOpportunistic, nil
+	case TLSImplicit, TLSStartTLS, TLSOpportunistic, TLSNone:
+		return cfg.SMTPTLS, nil
+	}
+	return "", fmt.Errorf("unknown SMTP_TLS mode %q", cfg.SMTPTLS)
+}
+
+func dial(cfg config.EmailConfig) (*smtp.Client, error) {
+	mode, err := tlsMode(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := net.JoinHostPort(cfg.SMTPServer, strconv.Itoa(cfg.SMTPPort))
+	dialer := net.Dialer{Timeout: cfg.SMTPConnectTimeout}
+	raw, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %w", addr, err)
+	}
+	dc := &deadlineConn{Conn: raw, timeout: cfg.SMTPTimeout}
+	var conn net.Conn = dc
+
+	if mode == TLSImplicit {
+		dc.timeout = cfg.SMTPConnectTimeout
+		tlsConn := tls.Client(dc, tlsConfig(cfg))
+		if err := tlsConn.Handshake(); err != nil {
+			raw.Close()
+			return nil, fmt.Errorf("TLS handshake with %s failed: %w", addr, err)
+		}
+		dc.timeout = cfg.SMTPTimeout
+		// net/smtp only knows the connection is encrypted if it gets the
+		// *tls.Conn itself
+		conn = tlsConn
+	}
+
+	c, err := smtp.NewClient(conn, cfg.SMTPServer)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error greeting %s: %w", addr, err)
+	}
+	return c, nil
+}
+
+func tlsConfig(cfg config.EmailConfig) *tls.Config {
+	return &tls.Config{ServerName: cfg.SMTPServer, RootCAs: rootCAs}
+}
+
+func startTLS(c *smtp.Client, cfg config.EmailConfig) error {
+	mode, _ := tlsMode(cfg)
+	if mode != TLSStartTLS && mode != TLSOpportunistic {
+		return nil
+	}
+
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		if mode == TLSStartTLS {
+			return errors.New("server doesn't offer STARTTLS")
+		}
+		return nil
+	}
+	if err := c.StartTLS(tlsConfig(cfg)); err != nil {
+		return fmt.Errorf("STARTTLS failed: %w", err)
+	}
+	return nil
+}
+
+func authenticate(c *smtp.Client, cfg config.EmailConfig) error {
+	method := cfg.SMTPAuth
+	if method == "" || method == AuthAuto {
+		if cfg.SMTPUsername == "" {
+			return nil
+		}
+		method = pickAuth(c)
+		if method == "" {
+			return errors.New("server doesn't offer AUTH PLAIN, LOGIN or CRAM-MD5")
+		}
+	}
+
+	var auth smtp.Auth
+	switch method {
+	case AuthNone:
+		return nil
+	case AuthPlain:
+		auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPServer)
+	case AuthLogin:
+		auth = &loginAuth{username: cfg.SMTPUsername, password: cfg.SMTPPassword, host: cfg.SMTPServer}
+	case AuthCRAMMD5:
+		auth = smtp.CRAMMD5Auth(cfg.SMTPUsername, cfg.SMTPPassword)
+	default:
+		return fmt.Errorf("unknown SMTP_AUTH method %q", cfg.SMTPAuth)
+	}
+
+	mech := strings.ToUpper(method)
+	if !offersAuth(c, mech) {
+		return fmt.Errorf("server doesn't offer AUTH %s", mech)
+	}
+	if err := c.Auth(auth); err != nil {
+		return fmt.Errorf("AUTH %s failed: %w", mech, err)
+	}
+	return nil
+}
+
+// pickAuth chooses from the mechanisms the server offers. Over TLS the
+// password can go as is; otherwise CRAM-MD5 keeps it off the wire.
+func pickAuth(c *smtp.Client) string {
+	order := []string{AuthPlain, AuthLogin, AuthCRAMMD5}
+	if _, ok := c.TLSConnectionState(); !ok {
+		order = []string{AuthCRAMMD5, AuthPlain, AuthLogin}
+	}
+	for _, method := range order {
+		if offersAuth(c, strings.ToUpper(method)) {
+			return method
+		}
+	}
+	return ""
+}
+
+func offersAuth(c *smtp.Client, mech string) bool {
+	ok, params := c.Extension("AUTH")
+	if !ok {
+		return false
+	}
+	for _, m := range strings.Fields(params) {
+		if strings.EqualFold(m, mech) {
+			return true
+		}
+	}
+	return false
+}
+
+// loginAuth is the LOGIN mechanism, which net/smtp lacks. Like PlainAuth it
+// won't send the password unencrypted to anything but localhost.
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// deadlineConn gives every read and write its own deadline, so a server
+// that stops answering fails the send instead of blocking it forever
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	if c.timeout > 0 {
+		c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	if c.timeout > 0 {
+		c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,412 @@
+package mailer
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"event-messenger.com/config"
+)
+
+const (
+	testUser     = "mailer"
+	testPassword = "hunter2"
+)
+
+// testCert returns a self-signed certificate for 127.0.0.1 and a pool that
+// trusts it
+func testCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// fakeServer is just enough of an SMTP server to test the client against
+type fakeServer struct {
+	implicitTLS bool     // TLS from the first byte
+	startTLS    bool     // offer STARTTLS
+	mechs       []string // AUTH mechanisms offered
+	stall       string   // stop answering at this command, e.g. "EHLO"
+	tls         *tls.Config
+
+	mu   sync.Mutex
+	got  []received
+	addr string
+}
+
+// received is what the server saw of one message
+type received struct {
+	tls  bool   // whether MAIL FROM came over TLS
+	auth string // the mechanism the client logged in with
+	from string
+	to   []string
+	data string
+}
+
+// startFakeServer listens on a free port until the test ends
+func startFakeServer(t *testing.T, s *fakeServer) *fakeServer {
+	t.Helper()
+	cert, pool := testCert(t)
+	s.tls = &tls.Config{Certificates: []tls.Certificate{cert}}
+	saved := rootCAs
+	rootCAs = pool
+	t.Cleanup(func() { rootCAs = saved })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s.addr = ln.Addr().String()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// config returns client settings for the server
+func (s *fakeServer) config(tlsMode, auth string) config.EmailConfig {
+	_, port, _ := net.SplitHostPort(s.addr)
+	var n int
+	fmt.Sscan(port, &n)
+	return config.EmailConfig{
+		SMTPServer:         "127.0.0.1",
+		SMTPPort:           n,
+		SMTPTLS:            tlsMode,
+		SMTPAuth:           auth,
+		SMTPUsername:       testUser,
+		SMTPPassword:       testPassword,
+		SMTPConnectTimeout: 2 * time.Second,
+		SMTPTimeout:        2 * time.Second,
+	}
+}
+
+func (s *fakeServer) messages() []received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]received(nil), s.got...)
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	if s.implicitTLS {
+		conn = tls.Server(conn, s.tls)
+	}
+	r := bufio.NewReader(conn)
+	reply := func(lines ...string) {
+		for _, line := range lines {
+			fmt.Fprintf(conn, "%s\r\n", line)
+		}
+	}
+	readLine := func() (string, bool) {
+		line, err := r.ReadString('\n')
+		return strings.TrimRight(line, "\r\n"), err == nil
+	}
+	decode := func(line string) string {
+		b, _ := base64.StdEncoding.DecodeString(line)
+		return string(b)
+	}
+
+	_, encrypted := conn.(*tls.Conn)
+	var msg received
+	reply("220 localhost ESMTP fake")
+	for {
+		line, ok := readLine()
+		if !ok {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+		if verb == s.stall {
+			// Hold the connection open without answering
+			io.Copy(io.Discard, r)
+			return
+		}
+
+		switch verb {
+		case "EHLO":
+			ext := []string{"250-localhost"}
+			if s.startTLS && !encrypted {
+				ext = append(ext, "250-STARTTLS")
+			}
+			if len(s.mechs) > 0 {
+				ext = append(ext, "250-AUTH "+strings.Join(s.mechs, " "))
+			}
+			ext = append(ext, "250 8BITMIME")
+			reply(ext...)
+		case "STARTTLS":
+			reply("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, encrypted = tlsConn, true
+			r = bufio.NewReader(conn)
+		case "AUTH":
+			mech, initial, _ := strings.Cut(arg, " ")
+			var user, password string
+			switch strings.ToUpper(mech) {
+			case "PLAIN":
+				parts := strings.Split(decode(initial), "\x00")
+				if len(parts) == 3 {
+					user, password = parts[1], parts[2]
+				}
+			case "LOGIN":
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Username:")))
+				line, _ := readLine()
+				user = decode(line)
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Password:")))
+				line, _ = readLine()
+				password = decode(line)
+			case "CRAM-MD5":
+				challenge := "<1234.5678@localhost>"
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte(challenge)))
+				line, _ := readLine()
+				name, digest, _ := strings.Cut(decode(line), " ")
+				mac := hmac.New(md5.New, []byte(testPassword))
+				mac.Write([]byte(challenge))
+				user = name
+				if digest == hex.EncodeToString(mac.Sum(nil)) {
+					password = testPassword
+				}
+			}
+			if user != testUser || password != testPassword {
+				reply("535 5.7.8 Authentication credentials invalid")
+				continue
+			}
+			msg.auth = strings.ToUpper(mech)
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL":
+			msg.tls = encrypted
+			msg.from = envelopeAddress(arg, "FROM:")
+			reply("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, envelopeAddress(arg, "TO:"))
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, ok := readLine()
+				if !ok {
+					return
+				}
+				if line == "." {
+					break
+				}
+				data.WriteString(line + "\r\n")
+			}
+			msg.data = data.String()
+			s.mu.Lock()
+			s.got = append(s.got, msg)
+			s.mu.Unlock()
+			msg = received{auth: msg.auth}
+			reply("250 OK queued")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+// envelopeAddress reads the address from a MAIL FROM or RCPT TO argument,
+// dropping any parameters after it
+func envelopeAddress(arg, prefix string) string {
+	addr, _, _ := strings.Cut(strings.TrimPrefix(arg, prefix), " ")
+	return strings.Trim(addr, "<>")
+}
+
+var testMessage = []byte("From: events@example.com\r\nTo: alex@example.com\r\nSubject: Hi\r\n\r\nHello\r\n")
+
+func TestSendSMTP(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   *fakeServer
+		tlsMode  string
+		auth     string
+		wantTLS  bool
+		wantAuth string
+	}{
+		{"plain text, no auth", &fakeServer{}, TLSNone, AuthNone, false, ""},
+		{"none ignores STARTTLS", &fakeServer{startTLS: true}, TLSNone, AuthNone, false, ""},
+		{"starttls", &fakeServer{startTLS: true}, TLSStartTLS, AuthNone, true, ""},
+		{"opportunistic upgrades", &fakeServer{startTLS: true}, TLSOpportunistic, AuthNone, true, ""},
+		{"opportunistic without STARTTLS", &fakeServer{}, TLSOpportunistic, AuthNone, false, ""},
+		{"implicit", &fakeServer{implicitTLS: true}, TLSImplicit, AuthNone, true, ""},
+		{"plain over starttls", &fakeServer{startTLS: true, mechs: []string{"PLAIN"}}, TLSStartTLS, AuthPlain, true, "PLAIN"},
+		{"login over implicit tls", &fakeServer{implicitTLS: true, mechs: []string{"LOGIN"}}, TLSImplicit, AuthLogin, true, "LOGIN"},
+		{"cram-md5", &fakeServer{mechs: []string{"CRAM-MD5"}}, TLSNone, AuthCRAMMD5, false, "CRAM-MD5"},
+		{"auto picks plain over tls", &fakeServer{implicitTLS: true, mechs: []string{"CRAM-MD5", "LOGIN", "PLAIN"}}, TLSImplicit, AuthAuto, true, "PLAIN"},
+		{"auto picks cram-md5 without tls", &fakeServer{mechs: []string{"PLAIN", "LOGIN", "CRAM-MD5"}}, TLSNone, AuthAuto, false, "CRAM-MD5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := startFakeServer(t, tt.server)
+			err := sendSMTP(s.config(tt.tlsMode, tt.auth), "events@example.com", []string{"alex@example.com"}, testMessage)
+			if err != nil {
+				t.Fatalf("sendSMTP: %v", err)
+			}
+
+			got := s.messages()
+			if len(got) != 1 {
+				t.Fatalf("server received %d messages, want 1", len(got))
+			}
+			m := got[0]
+			if m.tls != tt.wantTLS || m.auth != tt.wantAuth {
+				t.Errorf("sent with tls %v, auth %q; want %v, %q", m.tls, m.auth, tt.wantTLS, tt.wantAuth)
+			}
+			if m.from != "events@example.com" || strings.Join(m.to, ",") != "alex@example.com" {
+				t.Errorf("envelope from %s to %v", m.from, m.to)
+			}
+			if m.data != string(testMessage) {
+				t.Errorf("data = %q, want %q", m.data, testMessage)
+			}
+		})
+	}
+}
+
+func TestSendSMTPRefused(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  *fakeServer
+		tlsMode string
+		auth    string
+		config  func(*config.EmailConfig)
+		want    string
+	}{
+		{"wrong password", &fakeServer{startTLS: true, mechs: []string{"PLAIN"}}, TLSStartTLS, AuthPlain,
+			func(c *config.EmailConfig) { c.SMTPPassword = "wrong" }, "AUTH PLAIN failed"},
+		{"wrong login", &fakeServer{implicitTLS: true, mechs: []string{"LOGIN"}}, TLSImplicit, AuthLogin,
+			func(c *config.EmailConfig) { c.SMTPUsername = "someone" }, "AUTH LOGIN failed"},
+		{"wrong cram-md5", &fakeServer{mechs: []string{"CRAM-MD5"}}, TLSNone, AuthCRAMMD5,
+			func(c *config.EmailConfig) { c.SMTPPassword = "wrong" }, "AUTH CRAM-MD5 failed"},
+		{"mechanism not offered", &fakeServer{startTLS: true, mechs: []string{"PLAIN"}}, TLSStartTLS, AuthLogin, nil, "doesn't offer AUTH LOGIN"},
+		{"no usable mechanism", &fakeServer{mechs: []string{"XOAUTH2"}}, TLSNone, AuthAuto, nil, "doesn't offer AUTH PLAIN, LOGIN or CRAM-MD5"},
+		{"starttls required", &fakeServer{}, TLSStartTLS, AuthNone, nil, "doesn't offer STARTTLS"},
+		{"untrusted certificate", &fakeServer{implicitTLS: true}, TLSImplicit, AuthNone,
+			func(c *config.EmailConfig) { rootCAs = x509.NewCertPool() }, "TLS handshake"},
+		{"unknown tls mode", &fakeServer{}, "sometimes", AuthNone, nil, "unknown SMTP_TLS mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := startFakeServer(t, tt.server)
+			cfg := s.config(tt.tlsMode, tt.auth)
+			if tt.config != nil {
+				tt.config(&cfg)
+			}
+			err := sendSMTP(cfg, "events@example.com", []string{"alex@example.com"}, testMessage)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("sendSMTP error = %v, want one containing %q", err, tt.want)
+			}
+			if got := s.messages(); len(got) != 0 {
+				t.Errorf("server received %d messages", len(got))
+			}
+		})
+	}
+}
+
+func TestSendSMTPTimeouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  *fakeServer
+		tlsMode string
+	}{
+		{"server stops answering", &fakeServer{stall: "EHLO"}, TLSNone},
+		{"server stops during DATA", &fakeServer{stall: "DATA"}, TLSNone},
+		{"server stops after STARTTLS", &fakeServer{startTLS: true, stall: "MAIL"}, TLSStartTLS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := startFakeServer(t, tt.server)
+			cfg := s.config(tt.tlsMode, AuthNone)
+			cfg.SMTPTimeout = 200 * time.Millisecond
+
+			start := time.Now()
+			err := sendSMTP(cfg, "events@example.com", []string{"alex@example.com"}, testMessage)
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				t.Errorf("sendSMTP error = %v, want a timeout", err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("sendSMTP took %v to give up", elapsed)
+			}
+		})
+	}
+
+	// A server that accepts connections but never starts TLS is given up on
+	// after the connect timeout
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	cfg := (&fakeServer{addr: "127.0.0.1:" + port}).config(TLSImplicit, AuthNone)
+	cfg.SMTPConnectTimeout = 200 * time.Millisecond
+	start := time.Now()
+	err = sendSMTP(cfg, "events@example.com", []string{"alex@example.com"}, testMessage)
+	if err == nil || !strings.Contains(err.Error(), "TLS handshake") {
+		t.Errorf("sendSMTP error = %v, want a failed TLS handshake", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("sendSMTP took %v to give up on the handshake", elapsed)
+	}
+}
@@ -7,11 +7,19 @@ import (
 	"os"
 	"time"
 
+	"event-messenger.com/antispam"
+	"event-messenger.com/api"
 	"event-messenger.com/config"
 	"event-messenger.com/db"
+	"event-messenger.com/devsmtp"
+	"event-messenger.com/handlers"
 	"event-messenger.com/logger"
+	"event-messenger.com/mailer"
+	"event-messenger.com/media"
+	"event-messenger.com/notify"
 	"event-messenger.com/routes"
 	"event-messenger.com/scheduler"
+	"event-messenger.com/webhooks"
 	"github.com/joho/godotenv"
 )
 
@@ -47,6 +55,51 @@ func init() {
 	// Initialize database
 	db.InitDB()
 
+	// Parse page and email templates; in development they're reloaded on every request
+	err := handlers.LoadAssets(config.App.ThemeDir, env == "" || env == "development")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// DEV_SMTP catches outgoing email in-process to be read at /dev/mail.
+	// It's only for development, so production ignores it.
+	if config.App.DevSMTP {
+		if env == "" || env == "development" {
+			port, err := devsmtp.Start(config.App.DevSMTPPort)
+			if err != nil {
+				log.Fatal(err)
+			}
+			config.App.DevSMTPPort = port
+			config.App.UseDevSMTP()
+			slog.Info("Development SMTP server catching email", "port", config.App.DevSMTPPort, "view", config.App.BaseURL+"/dev/mail")
+		} else {
+			slog.Warn("DEV_SMTP is ignored outside development", "env", env)
+		}
+	}
+
+	// Check the mail transport and load the DKIM key, if there is one
+	if err := mailer.Configure(config.App.EmailConfig); err != nil {
+		log.Fatal(err)
+	}
+
+	// The review page previews the notification email notify composes, and
+	// delivers it through the scheduler
+	handlers.ComposeNotification = notify.Preview
+	handlers.DeliverNow = scheduler.DeliverNow
+	handlers.Resend = scheduler.Resend
+
+	// In development, API responses are checked against the OpenAPI document
+	api.CheckResponses = env == "" || env == "development"
+
+	// Rate limits and challenges for the public submission form
+	antispam.Configure(config.App.SpamConfig)
+
+	// Start image workers; uploads are resized in the background
+	media.StartWorkers(config.App.ImageWorkers, config.App.ImageQueueSize, handlers.PendingDir, handlers.UploadDir)
+
+	// Deliver queued webhook payloads, retrying failed ones
+	webhooks.Start()
+
 	// Start scheduler for daily email notifications
 	// Runs at 8AM system time (configurable)
 	scheduler.StartScheduler(8)
@@ -54,6 +107,15 @@ func init() {
 	// Start cleanup scheduler with 30-day grace period
 	// Runs weekly to delete events in which email was sent 30+ days ago
 	scheduler.StartCleanupScheduler(30)
+
+	// Remove resumable uploads that expired before being used
+	scheduler.StartUploadCleanupScheduler(time.Hour)
+
+	// Retry failed invitations and remind invitees who haven't sent a message
+	scheduler.StartReminderScheduler(time.Hour)
+
+	// Email coordinators a progress digest in the days before delivery
+	scheduler.StartDigestScheduler(time.Hour)
 }
 
 func main() {
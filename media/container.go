@@ -0,0 +1,544 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Allowed audio and video types, mapped to the extension they're stored with
+var AllowedMediaTypes = map[string]string{
+	"audio/mpeg": ".mp3",
+	"audio/mp4":  ".m4a",
+	"audio/ogg":  ".ogg",
+	"audio/webm": ".webm",
+	"video/mp4":  ".mp4",
+	"video/webm": ".webm",
+}
+
+// MediaInfo describes an audio or video upload, read from its container headers
+type MediaInfo struct {
+	ContentType string
+	Duration    time.Duration
+}
+
+// IsVideo reports whether the recording has a video track
+func (m MediaInfo) IsVideo() bool {
+	return m.ContentType == "video/mp4" || m.ContentType == "video/webm"
+}
+
+// Extension returns the file extension the recording is stored with
+func (m MediaInfo) Extension() string {
+	return AllowedMediaTypes[m.ContentType]
+}
+
+var errNoDuration = errors.New("could not determine duration")
+
+// SaveMedia writes a probed recording into dir unchanged and returns the
+// stored filename
+func SaveMedia(data []byte, dir, baseName string, info MediaInfo) (string, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("error creating upload directory: %w", err)
+	}
+
+	filename := baseName + info.Extension()
+	err := os.WriteFile(filepath.Join(dir, filename), data, 0644)
+	if err != nil {
+		return "", fmt.Errorf("error saving recording: %w", err)
+	}
+
+	return filename, nil
+}
+
+// ProbeMedia identifies an audio or video file and reads its duration without
+// decoding it. Formats outside AllowedMediaTypes return a LimitError.
+func ProbeMedia(data []byte) (MediaInfo, error) {
+	var (
+		info MediaInfo
+		err  error
+	)
+
+	switch {
+	case len(data) >= 12 && bytes.Equal(data[4:8], []byte("ftyp")):
+		info, err = probeMP4(data)
+	case bytes.HasPrefix(data, []byte("\x1a\x45\xdf\xa3")):
+		info, err = probeWebM(data)
+	case bytes.HasPrefix(data, []byte("OggS")):
+		info, err = probeOgg(data)
+	case bytes.HasPrefix(data, []byte("ID3")) || isMP3FrameSync(data):
+		info, err = probeMP3(data)
+	default:
+		return MediaInfo{}, &LimitError{Reason: "Only MP3, M4A and OGG audio or MP4 and WebM video can be attached"}
+	}
+
+	if err != nil {
+		return MediaInfo{}, err
+	}
+	if info.Duration <= 0 {
+		return MediaInfo{}, errNoDuration
+	}
+
+	return info, nil
+}
+
+// --- MP4 / M4A (ISO base media file format) ---
+
+func probeMP4(data []byte) (MediaInfo, error) {
+	moov := findBox(data, "moov")
+	if moov == nil {
+		// moov at the end of a truncated file, or a fragmented file
+		return MediaInfo{}, fmt.Errorf("mp4: no moov box: %w", errNoDuration)
+	}
+
+	mvhd := findBox(moov, "mvhd")
+	if len(mvhd) < 20 {
+		return MediaInfo{}, fmt.Errorf("mp4: no mvhd box: %w", errNoDuration)
+	}
+
+	var timescale uint32
+	var duration uint64
+	if mvhd[0] == 1 {
+		if len(mvhd) < 32 {
+			return MediaInfo{}, fmt.Errorf("mp4: short mvhd box: %w", errNoDuration)
+		}
+		timescale = binary.BigEndian.Uint32(mvhd[20:24])
+		duration = binary.BigEndian.Uint64(mvhd[24:32])
+	} else {
+		timescale = binary.BigEndian.Uint32(mvhd[12:16])
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+	}
+	if timescale == 0 {
+		return MediaInfo{}, fmt.Errorf("mp4: zero timescale: %w", errNoDuration)
+	}
+
+	info := MediaInfo{
+		ContentType: "audio/mp4",
+		Duration:    time.Duration(float64(duration) / float64(timescale) * float64(time.Second)),
+	}
+
+	// A track whose handler is "vide" makes it a video
+	for _, trak := range childBoxes(moov, "trak") {
+		hdlr := findBox(findBox(trak, "mdia"), "hdlr")
+		if len(hdlr) >= 12 && string(hdlr[8:12]) == "vide" {
+			info.ContentType = "video/mp4"
+		}
+	}
+
+	return info, nil
+}
+
+// findBox returns the payload of the first box of the given type directly inside data
+func findBox(data []byte, boxType string) []byte {
+	boxes := childBoxes(data, boxType)
+	if len(boxes) == 0 {
+		return nil
+	}
+	return boxes[0]
+}
+
+// childBoxes returns the payloads of every box of the given type directly inside data
+func childBoxes(data []byte, boxType string) [][]byte {
+	var boxes [][]byte
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		name := string(data[4:8])
+		header := uint64(8)
+
+		switch size {
+		case 0:
+			size = uint64(len(data)) // box runs to the end of the file
+		case 1:
+			if len(data) < 16 {
+				return boxes
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return boxes
+		}
+
+		if name == boxType {
+			boxes = append(boxes, data[header:size])
+		}
+		data = data[size:]
+	}
+	return boxes
+}
+
+// --- WebM (Matroska) ---
+
+// EBML element IDs used when probing WebM
+const (
+	ebmlSegment       = 0x18538067
+	ebmlInfo          = 0x1549a966
+	ebmlTimecodeScale = 0x2ad7b1
+	ebmlDuration      = 0x4489
+	ebmlTracks        = 0x1654ae6b
+	ebmlTrackEntry    = 0xae
+	ebmlTrackType     = 0x83
+	ebmlCluster       = 0x1f43b675
+	ebmlTimecode      = 0xe7
+	ebmlBlockGroup    = 0xa0
+	ebmlBlock         = 0xa1
+	ebmlSimpleBlock   = 0xa3
+)
+
+// Master elements we descend into instead of skipping
+var ebmlMasters = map[uint64]bool{
+	ebmlSegment:    true,
+	ebmlInfo:       true,
+	ebmlTracks:     true,
+	ebmlTrackEntry: true,
+	ebmlCluster:    true,
+	ebmlBlockGroup: true,
+}
+
+func probeWebM(data []byte) (MediaInfo, error) {
+	// Skip the EBML header
+	_, size, n, ok := readElementHeader(data)
+	if !ok || size < 0 || int64(len(data)-n) < size {
+		return MediaInfo{}, fmt.Errorf("webm: bad EBML header: %w", errNoDuration)
+	}
+	data = data[n+int(size):]
+
+	timecodeScale := uint64(1000000) // nanoseconds per timecode tick
+	var declared float64             // Info/Duration, in ticks
+	var clusterTime, lastBlock int64 // for recordings that don't declare a duration
+	hasVideo := false
+
+	// Walk the element stream flatly, entering masters rather than jumping
+	// over them, so "unknown size" segments and clusters written by browser
+	// recorders work too
+	for len(data) > 0 {
+		id, size, n, ok := readElementHeader(data)
+		if !ok {
+			break
+		}
+		data = data[n:]
+
+		if ebmlMasters[id] {
+			continue
+		}
+		if size < 0 || size > int64(len(data)) {
+			break
+		}
+		payload := data[:size]
+		data = data[size:]
+
+		switch id {
+		case ebmlTimecodeScale:
+			timecodeScale = readUint(payload)
+		case ebmlDuration:
+			switch len(payload) {
+			case 4:
+				declared = float64(math.Float32frombits(binary.BigEndian.Uint32(payload)))
+			case 8:
+				declared = math.Float64frombits(binary.BigEndian.Uint64(payload))
+			}
+		case ebmlTrackType:
+			if readUint(payload) == 1 {
+				hasVideo = true
+			}
+		case ebmlTimecode:
+			clusterTime = int64(readUint(payload))
+		case ebmlSimpleBlock, ebmlBlock:
+			// Track number (vint) followed by a signed 16 bit relative timecode
+			_, trackLen, ok := readVint(payload)
+			if ok && len(payload) >= trackLen+2 {
+				relative := int64(int16(binary.BigEndian.Uint16(payload[trackLen:])))
+				lastBlock = max(lastBlock, clusterTime+relative)
+			}
+		}
+	}
+
+	ticks := max(declared, float64(lastBlock))
+	info := MediaInfo{
+		ContentType: "audio/webm",
+		Duration:    time.Duration(ticks * float64(timecodeScale)),
+	}
+	if hasVideo {
+		info.ContentType = "video/webm"
+	}
+
+	return info, nil
+}
+
+// readElementHeader reads an EBML element ID and size. A size of -1 means
+// "unknown", used by live recorders for elements that run to the end.
+func readElementHeader(data []byte) (id uint64, size int64, n int, ok bool) {
+	if len(data) == 0 {
+		return 0, 0, 0, false
+	}
+
+	// IDs keep their length marker bits
+	idLen := bitsLeadingZeros(data[0]) + 1
+	if idLen > 4 || len(data) < idLen {
+		return 0, 0, 0, false
+	}
+	for _, b := range data[:idLen] {
+		id = id<<8 | uint64(b)
+	}
+
+	rawSize, sizeLen, ok := readVint(data[idLen:])
+	if !ok {
+		return 0, 0, 0, false
+	}
+
+	size = int64(rawSize)
+	if rawSize == (1<<(7*sizeLen))-1 {
+		size = -1
+	}
+
+	return id, size, idLen + sizeLen, true
+}
+
+// readVint reads an EBML variable length integer, without its length marker
+func readVint(data []byte) (value uint64, n int, ok bool) {
+	if len(data) == 0 {
+		return 0, 0, false
+	}
+
+	n = bitsLeadingZeros(data[0]) + 1
+	if n > 8 || len(data) < n {
+		return 0, 0, false
+	}
+
+	value = uint64(data[0]) & (0xff >> n)
+	for _, b := range data[1:n] {
+		value = value<<8 | uint64(b)
+	}
+
+	return value, n, true
+}
+
+func bitsLeadingZeros(b byte) int {
+	n := 0
+	for mask := byte(0x80); mask != 0 && b&mask == 0; mask >>= 1 {
+		n++
+	}
+	return n
+}
+
+func readUint(data []byte) uint64 {
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+// --- Ogg (Vorbis and Opus) ---
+
+func probeOgg(data []byte) (MediaInfo, error) {
+	var (
+		serial      uint32
+		sampleRate  uint64
+		preSkip     uint64
+		lastGranule uint64
+		first       = true
+	)
+
+	for len(data) >= 27 && bytes.HasPrefix(data, []byte("OggS")) {
+		granule := binary.LittleEndian.Uint64(data[6:14])
+		pageSerial := binary.LittleEndian.Uint32(data[14:18])
+		segments := int(data[26])
+		if len(data) < 27+segments {
+			break
+		}
+
+		bodyLen := 0
+		for _, l := range data[27 : 27+segments] {
+			bodyLen += int(l)
+		}
+		headerLen := 27 + segments
+		if len(data) < headerLen+bodyLen {
+			break
+		}
+		body := data[headerLen : headerLen+bodyLen]
+
+		if first {
+			// The first packet identifies the codec of the first stream
+			serial = pageSerial
+			switch {
+			case bytes.HasPrefix(body, []byte("\x01vorbis")) && len(body) >= 16:
+				sampleRate = uint64(binary.LittleEndian.Uint32(body[12:16]))
+			case bytes.HasPrefix(body, []byte("OpusHead")) && len(body) >= 12:
+				// Opus granule positions are always at 48kHz
+				sampleRate = 48000
+				preSkip = uint64(binary.LittleEndian.Uint16(body[10:12]))
+			default:
+				return MediaInfo{}, &LimitError{Reason: "Only Vorbis or Opus audio is supported in OGG files"}
+			}
+			first = false
+		}
+
+		// -1 means no packet finishes on this page
+		if pageSerial == serial && granule != math.MaxUint64 {
+			lastGranule = granule
+		}
+
+		data = data[headerLen+bodyLen:]
+	}
+
+	if sampleRate == 0 || lastGranule <= preSkip {
+		return MediaInfo{}, fmt.Errorf("ogg: %w", errNoDuration)
+	}
+
+	samples := lastGranule - preSkip
+	return MediaInfo{
+		ContentType: "audio/ogg",
+		Duration:    time.Duration(float64(samples) / float64(sampleRate) * float64(time.Second)),
+	}, nil
+}
+
+// --- MP3 ---
+
+// Bitrates in kbit/s, indexed by [MPEG-1?][layer-1][index]
+var mp3Bitrates = [2][3][16]int{
+	{ // MPEG-2 and 2.5
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	},
+	{ // MPEG-1
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	},
+}
+
+// Sample rates in Hz, indexed by [version bits][index]
+var mp3SampleRates = [4][3]int{
+	{11025, 12000, 8000},  // MPEG-2.5
+	{0, 0, 0},             // reserved
+	{22050, 24000, 16000}, // MPEG-2
+	{44100, 48000, 32000}, // MPEG-1
+}
+
+type mp3Frame struct {
+	length     int
+	samples    int
+	sampleRate int
+}
+
+func isMP3FrameSync(data []byte) bool {
+	_, ok := parseMP3Frame(data)
+	return ok
+}
+
+func parseMP3Frame(data []byte) (mp3Frame, bool) {
+	if len(data) < 4 || data[0] != 0xff || data[1]&0xe0 != 0xe0 {
+		return mp3Frame{}, false
+	}
+
+	version := (data[1] >> 3) & 0x03 // 0: 2.5, 2: 2, 3: 1
+	layer := 4 - int((data[1]>>1)&0x03)
+	bitrateIndex := data[2] >> 4
+	rateIndex := (data[2] >> 2) & 0x03
+	padding := int((data[2] >> 1) & 0x01)
+
+	if version == 1 || layer == 4 || rateIndex == 3 || bitrateIndex == 0 || bitrateIndex == 15 {
+		return mp3Frame{}, false
+	}
+
+	mpeg1 := 0
+	if version == 3 {
+		mpeg1 = 1
+	}
+	bitrate := mp3Bitrates[mpeg1][layer-1][bitrateIndex] * 1000
+	sampleRate := mp3SampleRates[version][rateIndex]
+
+	var frame mp3Frame
+	frame.sampleRate = sampleRate
+	switch {
+	case layer == 1:
+		frame.samples = 384
+		frame.length = (12*bitrate/sampleRate + padding) * 4
+	case layer == 3 && mpeg1 == 0:
+		frame.samples = 576
+		frame.length = 72*bitrate/sampleRate + padding
+	default:
+		frame.samples = 1152
+		frame.length = 144*bitrate/sampleRate + padding
+	}
+
+	return frame, frame.length > 4
+}
+
+// isMP3InfoFrame reports whether a frame carries an encoder's Xing, Info or
+// VBRI header. Xing and Info follow the side information, whose size depends
+// on the MPEG version and channel mode; VBRI is always 32 bytes in.
+func isMP3InfoFrame(frame []byte) bool {
+	if len(frame) >= 40 && string(frame[36:40]) == "VBRI" {
+		return true
+	}
+
+	mpeg1 := (frame[1]>>3)&0x03 == 3
+	mono := frame[3]>>6 == 3
+	offset := 4 + 32
+	switch {
+	case mpeg1 && mono, !mpeg1 && !mono:
+		offset = 4 + 17
+	case !mpeg1 && mono:
+		offset = 4 + 9
+	}
+	if len(frame) < offset+4 {
+		return false
+	}
+	tag := string(frame[offset : offset+4])
+	return tag == "Xing" || tag == "Info"
+}
+
+func probeMP3(data []byte) (MediaInfo, error) {
+	// Skip an ID3v2 tag; its size is a 28 bit "synchsafe" integer
+	if bytes.HasPrefix(data, []byte("ID3")) && len(data) >= 10 {
+		size := int(data[6]&0x7f)<<21 | int(data[7]&0x7f)<<14 | int(data[8]&0x7f)<<7 | int(data[9]&0x7f)
+		if data[5]&0x10 != 0 {
+			size += 10 // footer
+		}
+		if 10+size > len(data) {
+			return MediaInfo{}, fmt.Errorf("mp3: %w", errNoDuration)
+		}
+		data = data[10+size:]
+	}
+
+	// Count every frame rather than trusting a Xing/VBRI header, which
+	// isn't always present (or right) for variable bitrate files
+	var seconds float64
+	frames := 0
+	for len(data) >= 4 {
+		frame, ok := parseMP3Frame(data)
+		if !ok || frame.length > len(data) {
+			// Resync past junk between frames, but give up on trailing tags
+			next := bytes.IndexByte(data[1:], 0xff)
+			if next < 0 || frames == 0 {
+				break
+			}
+			data = data[next+1:]
+			continue
+		}
+		// The frame holding a Xing/VBRI header has no audio in it
+		if frames == 0 && isMP3InfoFrame(data[:frame.length]) {
+			data = data[frame.length:]
+			continue
+		}
+		seconds += float64(frame.samples) / float64(frame.sampleRate)
+		frames++
+		data = data[frame.length:]
+	}
+
+	if frames == 0 {
+		return MediaInfo{}, fmt.Errorf("mp3: no frames: %w", errNoDuration)
+	}
+
+	return MediaInfo{
+		ContentType: "audio/mpeg",
+		Duration:    time.Duration(seconds * float64(time.Second)),
+	}, nil
+}
@@ -0,0 +1,170 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The fixtures are written by testdata/containers.go
+func TestProbeMedia(t *testing.T) {
+	tests := []struct {
+		file        string
+		contentType string
+		duration    time.Duration
+	}{
+		{"v0.m4a", "audio/mp4", 5500 * time.Millisecond},
+		{"v1.mp4", "video/mp4", 3 * time.Second},
+		{"declared.webm", "audio/webm", 4500 * time.Millisecond},
+		{"video.webm", "video/webm", 2 * time.Second},
+		{"recorder.webm", "audio/webm", 1980 * time.Millisecond},
+		{"opus.ogg", "audio/ogg", 2 * time.Second},
+		{"vorbis.ogg", "audio/ogg", 3 * time.Second},
+		{"cbr.mp3", "audio/mpeg", 100 * 1152 * time.Second / 44100},
+		{"xing.mp3", "audio/mpeg", 50 * 1152 * time.Second / 44100},
+		{"vbri.mp3", "audio/mpeg", 40 * 1152 * time.Second / 44100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := ProbeMedia(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.ContentType != tt.contentType {
+				t.Errorf("content type %s, want %s", info.ContentType, tt.contentType)
+			}
+			if d := info.Duration - tt.duration; d < -time.Millisecond || d > time.Millisecond {
+				t.Errorf("duration %v, want %v", info.Duration, tt.duration)
+			}
+
+			// Every part of a file that stopped uploading part way is
+			// either refused or shorter
+			for n := range len(data) {
+				if info, err := ProbeMedia(data[:n]); err == nil && info.Duration > tt.duration+time.Millisecond {
+					t.Errorf("first %d bytes: duration %v", n, info.Duration)
+				}
+			}
+		})
+	}
+}
+
+func mp4Box(name string, payload ...[]byte) []byte {
+	b := bytes.Join(payload, nil)
+	return append(append(binary.BigEndian.AppendUint32(nil, uint32(8+len(b))), name...), b...)
+}
+
+func be32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+// join concatenates parts into a new slice
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+var (
+	isomFtyp   = mp4Box("ftyp", []byte("isom"), be32(0))
+	ebmlHeader = []byte("\x1a\x45\xdf\xa3\x84\x42\x82\x81\x00")
+	opusHead   = []byte("OpusHead\x01\x01\x38\x01\x80\xbb\x00\x00\x00\x00\x00")
+)
+
+// oggPage builds a page holding one packet of under 255 bytes
+func oggPage(granule uint64, packet []byte) []byte {
+	page := append([]byte("OggS\x00\x02"), binary.LittleEndian.AppendUint64(nil, granule)...)
+	page = append(page, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, byte(len(packet)))
+	return append(page, packet...)
+}
+
+// testMP3Frame is an MPEG-1 layer III frame at 128 kbit/s, with info at offset
+func testMP3Frame(info string, offset int) []byte {
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xff, 0xfb, 0x90, 0x00})
+	copy(frame[offset:], info)
+	return frame
+}
+
+// Malformed and hostile files must be refused, without panicking or looping
+func TestProbeMediaRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		limit bool // refused as a format we don't take, rather than unreadable
+	}{
+		{"empty", nil, true},
+		{"wav", []byte("RIFF\x24\x00\x00\x00WAVEfmt "), true},
+
+		{"mp4 without moov", join(isomFtyp, mp4Box("mdat", make([]byte, 32))), false},
+		{"mp4 box larger than the file", join(isomFtyp, []byte("\xff\xff\xff\xffmoov\x00\x00\x00\x6cmvhd")), false},
+		{"mp4 64 bit box larger than the file", join(isomFtyp, []byte("\x00\x00\x00\x01moov\xff\xff\xff\xff\xff\xff\xff\xff")), false},
+		{"mp4 64 bit box smaller than its header", join(isomFtyp, []byte("\x00\x00\x00\x01moov\x00\x00\x00\x00\x00\x00\x00\x08")), false},
+		{"mp4 box smaller than its header", join(isomFtyp, []byte("\x00\x00\x00\x04moov")), false},
+		{"mp4 zero timescale", join(isomFtyp, mp4Box("moov", mp4Box("mvhd", be32(0), be32(0), be32(0), be32(0), be32(5000)))), false},
+		{"mp4 zero duration", join(isomFtyp, mp4Box("moov", mp4Box("mvhd", be32(0), be32(0), be32(0), be32(1000), be32(0)))), false},
+		{"mp4 short version 1 mvhd", join(isomFtyp, mp4Box("moov", mp4Box("mvhd", be32(1<<24), make([]byte, 20)))), false},
+
+		{"webm header larger than the file", []byte("\x1a\x45\xdf\xa3\x88\x42\x82"), false},
+		{"webm without duration or blocks", join(ebmlHeader, []byte("\x18\x53\x80\x67\xff\x15\x49\xa9\x66\x80")), false},
+		{"webm element of unknown size", join(ebmlHeader, []byte("\x18\x53\x80\x67\xff\x44\x89\xff\x40\xb1\x94\x00\x00\x00\x00\x00")), false},
+		{"webm element larger than the file", join(ebmlHeader, []byte("\x18\x53\x80\x67\xff\x44\x89\x01\xff\xff\xff\xff\xff\xff\xfe\x40")), false},
+		{"webm invalid id", join(ebmlHeader, make([]byte, 16)), false},
+
+		{"ogg truncated page", []byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\xff\xff"), false},
+		{"ogg headers only", oggPage(0, opusHead), false},
+		{"ogg granule within pre-skip", join(oggPage(0, opusHead), oggPage(300, []byte("audio"))), false},
+		{"ogg flac", oggPage(0, []byte("\x7fFLAC\x01\x00\x00\x01fLaC")), true},
+
+		{"mp3 tag larger than the file", []byte("ID3\x04\x00\x00\x7f\x7f\x7f\x7f\xff\xfb\x90\x00"), false},
+		{"mp3 tag and junk", join([]byte("ID3\x04\x00\x00\x00\x00\x00\x00"), bytes.Repeat([]byte("\xff\x00"), 500)), false},
+		{"mp3 info frame only", testMP3Frame("Xing", 36), false},
+		{"mp3 truncated frame", testMP3Frame("", 0)[:200], false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := ProbeMedia(tt.data)
+			if err == nil {
+				t.Fatalf("probed as %s lasting %v", info.ContentType, info.Duration)
+			}
+			var limitErr *LimitError
+			if errors.As(err, &limitErr) != tt.limit {
+				t.Errorf("error %v, want a LimitError: %v", err, tt.limit)
+			}
+		})
+	}
+}
+
+// Xing and Info headers sit after side information whose size depends on
+// the MPEG version and channel mode; VBRI is always in the same place
+func TestIsMP3InfoFrame(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		tag    string
+		offset int
+		want   bool
+	}{
+		{"mpeg-1 stereo xing", []byte{0xff, 0xfb, 0x90, 0x00}, "Xing", 36, true},
+		{"mpeg-1 stereo info", []byte{0xff, 0xfb, 0x90, 0x40}, "Info", 36, true},
+		{"mpeg-1 mono xing", []byte{0xff, 0xfb, 0x90, 0xc0}, "Xing", 21, true},
+		{"mpeg-2 stereo xing", []byte{0xff, 0xf3, 0x90, 0x00}, "Xing", 21, true},
+		{"mpeg-2 mono xing", []byte{0xff, 0xf3, 0x90, 0xc0}, "Xing", 13, true},
+		{"mpeg-2 mono vbri", []byte{0xff, 0xf3, 0x90, 0xc0}, "VBRI", 36, true},
+		{"xing in the wrong place", []byte{0xff, 0xfb, 0x90, 0xc0}, "Xing", 36, false},
+		{"audio", []byte{0xff, 0xfb, 0x90, 0x00}, "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := make([]byte, 200)
+			copy(frame[tt.offset:], tt.tag)
+			copy(frame, tt.header)
+			if got := isMP3InfoFrame(frame); got != tt.want {
+				t.Errorf("isMP3InfoFrame = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	MaxImageWidth = 800
+	JPEGQuality   = 85
+
+	// Limits for animated GIFs. Every frame is held in memory while it is
+	// resized, so both the frame count and the canvas size are capped.
+	MaxGIFFrames       = 150
+	MaxGIFCanvasPixels = 4096 * 4096
+	MaxGIFTotalPixels  = 1 << 28 // summed over every frame
+	MaxGIFBytes        = 8 << 20 // 8 MB after resizing
+)
+
+// LimitError is returned when an upload is valid but exceeds one of the
+// processing limits. Its message is safe to show to the contributor.
+type LimitError struct {
+	Reason string
+}
+
+func (e *LimitError) Error() string {
+	return e.Reason
+}
+
+// SaveImage decodes an uploaded image, resizes it to MaxImageWidth and writes
+// it into dir. Animated GIFs are kept as GIFs (with a JPEG poster frame next to
+// them); everything else (including WebP) is converted to JPEG, with any
+// transparency flattened onto white. It returns the stored filename.
+func SaveImage(src io.ReadSeeker, dir, baseName string) (string, error) {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return "", fmt.Errorf("error reading image: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("error creating upload directory: %w", err)
+	}
+
+	if bytes.HasPrefix(data, []byte("GIF8")) {
+		g, err := decodeGIF(data)
+		if err != nil {
+			return "", err
+		}
+		if len(g.Image) > 1 {
+			return saveAnimatedGIF(g, data, dir, baseName)
+		}
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("image decode error: %w", err)
+	}
+	log.Printf("Successfully decoded image format: %s", format)
+
+	filename := baseName + ".jpg"
+	err = writeJPEG(filepath.Join(dir, filename), flatten(resize(img)))
+	if err != nil {
+		return "", err
+	}
+
+	return filename, nil
+}
+
+// PosterFilename returns the static poster frame stored alongside an animated
+// GIF, or an empty string if filename is not a GIF.
+func PosterFilename(filename string) string {
+	if !strings.EqualFold(filepath.Ext(filename), ".gif") {
+		return ""
+	}
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + "_poster.jpg"
+}
+
+// decodeGIF decodes every frame of a GIF, enforcing the animation limits
+func decodeGIF(data []byte) (*gif.GIF, error) {
+	cfg, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("gif decode error: %w", err)
+	}
+	if cfg.Width*cfg.Height > MaxGIFCanvasPixels {
+		return nil, &LimitError{Reason: fmt.Sprintf("GIFs can be at most %d pixels in total", MaxGIFCanvasPixels)}
+	}
+
+	// DecodeAll holds every frame in memory, so check the frames before
+	// decoding any of them
+	frames, pixels, err := gifFrames(data)
+	if err != nil {
+		return nil, fmt.Errorf("gif decode error: %w", err)
+	}
+	if frames > MaxGIFFrames {
+		return nil, &LimitError{Reason: fmt.Sprintf("Animated GIFs can have at most %d frames", MaxGIFFrames)}
+	}
+	if pixels > MaxGIFTotalPixels {
+		return nil, &LimitError{Reason: "This animated GIF is too large to process"}
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("gif decode error: %w", err)
+	}
+
+	return g, nil
+}
+
+// gifFrames walks a GIF's block structure without decoding any image data,
+// returning how many frames it has and their total area in pixels. It stops
+// counting once the frame limit is passed.
+func gifFrames(data []byte) (frames, pixels int, err error) {
+	errTruncated := fmt.Errorf("truncated GIF")
+
+	// Header and logical screen descriptor, then the global color table
+	if len(data) < 13 {
+		return 0, 0, errTruncated
+	}
+	pos := 13
+	if data[10]&0x80 != 0 {
+		pos += 3 << (data[10]&0x07 + 1)
+	}
+
+	// skipSubBlocks moves past a run of data sub-blocks and its terminator
+	skipSubBlocks := func() bool {
+		for pos < len(data) {
+			size := int(data[pos])
+			pos++
+			if size == 0 {
+				return true
+			}
+			pos += size
+		}
+		return false
+	}
+
+	for frames <= MaxGIFFrames && pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension: label, then sub-blocks
+			pos += 2
+			if !skipSubBlocks() {
+				return 0, 0, errTruncated
+			}
+		case 0x2C: // image descriptor
+			if pos+10 > len(data) {
+				return 0, 0, errTruncated
+			}
+			width := int(data[pos+5]) | int(data[pos+6])<<8
+			height := int(data[pos+7]) | int(data[pos+8])<<8
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			pos++ // LZW minimum code size
+			if !skipSubBlocks() {
+				return 0, 0, errTruncated
+			}
+			frames++
+			pixels += width * height
+		case 0x3B: // trailer; some encoders leave it out
+			return frames, pixels, nil
+		default:
+			return 0, 0, fmt.Errorf("unknown GIF block 0x%02x", data[pos])
+		}
+	}
+	return frames, pixels, nil
+}
+
+func saveAnimatedGIF(g *gif.GIF, data []byte, dir, baseName string) (string, error) {
+	log.Printf("Decoded animated GIF: %dx%d, %d frames", g.Config.Width, g.Config.Height, len(g.Image))
+
+	// Only re-encode when the animation actually needs to shrink, so small
+	// GIFs are stored byte-for-byte as uploaded
+	out := data
+	if g.Config.Width > MaxImageWidth {
+		resized := resizeAnimation(g)
+
+		var buf bytes.Buffer
+		err := gif.EncodeAll(&buf, resized)
+		if err != nil {
+			return "", fmt.Errorf("gif encode error: %w", err)
+		}
+		out = buf.Bytes()
+		log.Printf("Resized animated GIF from %dx%d to %dx%d", g.Config.Width, g.Config.Height, resized.Config.Width, resized.Config.Height)
+	}
+
+	if len(out) > MaxGIFBytes {
+		return "", &LimitError{Reason: fmt.Sprintf("Animated GIFs must be under %d MB after resizing", MaxGIFBytes>>20)}
+	}
+
+	filename := baseName + ".gif"
+	err := os.WriteFile(filepath.Join(dir, filename), out, 0644)
+	if err != nil {
+		return "", fmt.Errorf("error saving gif: %w", err)
+	}
+
+	// Email clients that can't animate (or can't take the size) get the first frame
+	poster := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	draw.Draw(poster, g.Image[0].Bounds(), g.Image[0], g.Image[0].Bounds().Min, draw.Over)
+
+	err = writeJPEG(filepath.Join(dir, PosterFilename(filename)), resize(flatten(poster)))
+	if err != nil {
+		os.Remove(filepath.Join(dir, filename))
+		return "", err
+	}
+
+	return filename, nil
+}
+
+// resizeAnimation renders every frame onto the full canvas (applying each
+// frame's disposal method), scales the result and maps it back onto the
+// palette of the frame it came from.
+func resizeAnimation(g *gif.GIF) *gif.GIF {
+	canvasBounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	canvas := image.NewRGBA(canvasBounds)
+
+	width, height := scaledSize(g.Config.Width, g.Config.Height)
+	bounds := image.Rect(0, 0, width, height)
+
+	out := &gif.GIF{
+		Image:     make([]*image.Paletted, 0, len(g.Image)),
+		Delay:     g.Delay,
+		Disposal:  make([]byte, len(g.Image)),
+		LoopCount: g.LoopCount,
+		Config: image.Config{
+			Width:  width,
+			Height: height,
+		},
+	}
+
+	for i, frame := range g.Image {
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(canvasBounds)
+			draw.Copy(previous, image.Point{}, canvas, canvasBounds, draw.Src, nil)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		scaled := image.NewRGBA(bounds)
+		draw.CatmullRom.Scale(scaled, bounds, canvas, canvasBounds, draw.Src, nil)
+
+		paletted := image.NewPaletted(bounds, frame.Palette)
+		draw.FloydSteinberg.Draw(paletted, bounds, scaled, image.Point{})
+
+		out.Image = append(out.Image, paletted)
+		// Frames are full composites, so each one replaces the last
+		out.Disposal[i] = gif.DisposalBackground
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return out
+}
+
+// resize scales img down to MaxImageWidth, maintaining aspect ratio
+func resize(img image.Image) image.Image {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
+	log.Printf("Original image dimensions: %dx%d", width, height)
+
+	if width <= MaxImageWidth {
+		return img
+	}
+
+	newWidth, newHeight := scaledSize(width, height)
+	resized := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	draw.CatmullRom.Scale(resized, resized.Bounds(), img, bounds, draw.Over, nil)
+	log.Printf("Resized image from %dx%d to %dx%d", width, height, newWidth, newHeight)
+
+	return resized
+}
+
+func scaledSize(width, height int) (int, int) {
+	if width <= MaxImageWidth {
+		return width, height
+	}
+	return MaxImageWidth, (height * MaxImageWidth) / width
+}
+
+// flatten draws img onto a white background, since JPEG has no transparency
+func flatten(img image.Image) image.Image {
+	bounds := img.Bounds()
+	flat := image.NewRGBA(bounds)
+	draw.Draw(flat, bounds, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, bounds, img, bounds.Min, draw.Over)
+	return flat
+}
+
+func writeJPEG(path string, img image.Image) error {
+	dst, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error saving file: %w", err)
+	}
+	defer dst.Close()
+
+	err = jpeg.Encode(dst, img, &jpeg.Options{Quality: JPEGQuality})
+	if err != nil {
+		return fmt.Errorf("jpeg encode error: %w", err)
+	}
+
+	if info, err := dst.Stat(); err == nil {
+		log.Printf("Successfully saved processed image: %s (size: %.2f KB)", filepath.Base(path), float64(info.Size())/1024)
+	}
+	return nil
+}
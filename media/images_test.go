@@ -0,0 +1,92 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+// encodeGIF returns a GIF of frames blank width×height frames
+func encodeGIF(t *testing.T, width, height, frames int) []byte {
+	t.Helper()
+	palette := color.Palette{color.White, color.Black}
+	g := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, width, height), palette))
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// bombGIF returns a GIF whose frames each claim the whole width×height
+// canvas but carry almost no image data, so only decoding would show how
+// large they are
+func bombGIF(width, height, frames int) []byte {
+	data := []byte("GIF89a")
+	data = append(data, byte(width), byte(width>>8), byte(height), byte(height>>8), 0, 0, 0)
+	for i := 0; i < frames; i++ {
+		data = append(data, 0x2C, 0, 0, 0, 0, byte(width), byte(width>>8), byte(height), byte(height>>8), 0)
+		data = append(data, 2, 1, 0x44, 0) // LZW code size, one sub-block, terminator
+	}
+	return append(data, 0x3B)
+}
+
+func TestGIFFrames(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		wantFrames int
+		wantPixels int
+	}{
+		{"single frame", encodeGIF(t, 10, 20, 1), 1, 200},
+		{"animation", encodeGIF(t, 8, 8, 3), 3, 192},
+		{"no trailer", bytes.TrimSuffix(encodeGIF(t, 4, 4, 2), []byte{0x3B}), 2, 32},
+		{"bomb", bombGIF(4000, 4000, 20), 20, 20 * 4000 * 4000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, pixels, err := gifFrames(tt.data)
+			if err != nil {
+				t.Fatalf("gifFrames: %v", err)
+			}
+			if frames != tt.wantFrames || pixels != tt.wantPixels {
+				t.Errorf("gifFrames = %d frames, %d pixels; want %d, %d", frames, pixels, tt.wantFrames, tt.wantPixels)
+			}
+		})
+	}
+
+	if _, _, err := gifFrames([]byte("GIF89a")); err == nil {
+		t.Error("gifFrames accepted a truncated header")
+	}
+}
+
+func TestDecodeGIFLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too many frames", encodeGIF(t, 2, 2, MaxGIFFrames+1)},
+		{"too many pixels", bombGIF(4000, 4000, 20)},
+		{"canvas too large", bombGIF(5000, 5000, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeGIF(tt.data)
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) {
+				t.Errorf("decodeGIF error = %v, want a LimitError", err)
+			}
+		})
+	}
+
+	if g, err := decodeGIF(encodeGIF(t, 4, 4, MaxGIFFrames)); err != nil || len(g.Image) != MaxGIFFrames {
+		t.Errorf("decodeGIF at the frame limit: %v", err)
+	}
+}
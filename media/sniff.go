@@ -0,0 +1,69 @@
+package media
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// SniffLen is the number of leading bytes DetectContentType looks at
+const SniffLen = 512
+
+// Allowed MIME types for image uploads. Each of these has a registered decoder.
+var AllowedImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// UnsupportedImageTypes are images we can recognise but not decode, mapped to
+// the message shown to the contributor
+var UnsupportedImageTypes = map[string]string{
+	"image/heic": "HEIC photos (the iPhone default) can't be processed yet. Please choose \"Most Compatible\" under Settings > Camera > Formats, or share the photo as a JPEG.",
+	"image/heif": "HEIF photos can't be processed yet. Please convert the photo to JPEG or PNG and try again.",
+	"image/avif": "AVIF images can't be processed yet. Please convert the image to JPEG or PNG and try again.",
+	"image/bmp":  "BMP images aren't supported. Please convert the image to JPEG or PNG and try again.",
+	"image/tiff": "TIFF images aren't supported. Please convert the image to JPEG or PNG and try again.",
+}
+
+// ISO base media file brands (the "ftyp" box) used by HEIF-family images
+var heifBrands = map[string]string{
+	"heic": "image/heic",
+	"heix": "image/heic",
+	"heim": "image/heic",
+	"heis": "image/heic",
+	"hevc": "image/heic",
+	"hevx": "image/heic",
+	"mif1": "image/heif",
+	"msf1": "image/heif",
+	"avif": "image/avif",
+	"avis": "image/avif",
+}
+
+// DetectContentType extends http.DetectContentType with the image formats it
+// doesn't know about: it only reports WebP for some Go versions and never
+// reports HEIC/HEIF/AVIF.
+func DetectContentType(data []byte) string {
+	if len(data) > SniffLen {
+		data = data[:SniffLen]
+	}
+
+	// WebP: "RIFF" <4 byte size> "WEBP"
+	if len(data) >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")) {
+		return "image/webp"
+	}
+
+	// HEIF family: <4 byte box size> "ftyp" <major brand>
+	if len(data) >= 12 && bytes.Equal(data[4:8], []byte("ftyp")) {
+		if contentType, ok := heifBrands[string(data[8:12])]; ok {
+			return contentType
+		}
+	}
+
+	// TIFF isn't sniffed by net/http either
+	if bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")) {
+		return "image/tiff"
+	}
+
+	return http.DetectContentType(data)
+}
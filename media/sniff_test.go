@@ -0,0 +1,132 @@
+package media
+
+import (
+	"bytes"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// ftyp returns the start of an ISO base media file with the given major brand
+func ftyp(brand string) []byte {
+	return append([]byte("\x00\x00\x00\x18ftyp"), brand+"\x00\x00\x00\x00mif1"...)
+}
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"jpeg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), "image/jpeg"},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "image/png"},
+		{"gif87a", []byte("GIF87a\x01\x00\x01\x00"), "image/gif"},
+		{"gif89a", []byte("GIF89a\x01\x00\x01\x00"), "image/gif"},
+		{"webp", []byte("RIFF\x24\x00\x00\x00WEBPVP8 "), "image/webp"},
+		{"tiff little-endian", []byte("II*\x00\x08\x00\x00\x00"), "image/tiff"},
+		{"tiff big-endian", []byte("MM\x00*\x00\x00\x00\x08"), "image/tiff"},
+		{"bmp", []byte("BM\x36\x00\x00\x00\x00\x00\x00\x00\x36\x00"), "image/bmp"},
+
+		{"truncated webp", []byte("RIFF\x24\x00\x00\x00WEB"), "application/octet-stream"},
+		{"truncated ftyp", []byte("\x00\x00\x00\x18ftyphe"), "application/octet-stream"},
+		{"riff but not webp", []byte("RIFF\x24\x00\x00\x00WAVEfmt "), "audio/wave"},
+		{"unknown", []byte("\x00\x01\x02\x03\x04\x05\x06\x07"), "application/octet-stream"},
+		{"empty", nil, "text/plain; charset=utf-8"},
+		{"signature past the sniffed bytes", append(bytes.Repeat([]byte{0}, SniffLen), "GIF89a"...), "application/octet-stream"},
+	}
+	for brand, want := range heifBrands {
+		tests = append(tests, struct {
+			name string
+			data []byte
+			want string
+		}{"ftyp " + brand, ftyp(brand), want})
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectContentType(tt.data); got != tt.want {
+				t.Errorf("DetectContentType = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Every image type we recognise is either processed or explained to the
+// contributor
+func TestSniffedImageTypesAreHandled(t *testing.T) {
+	for _, contentType := range heifBrands {
+		if _, ok := UnsupportedImageTypes[contentType]; !ok && !AllowedImageTypes[contentType] {
+			t.Errorf("%s is sniffed but neither allowed nor explained", contentType)
+		}
+	}
+	for _, contentType := range []string{"image/webp", "image/tiff", "image/bmp"} {
+		if _, ok := UnsupportedImageTypes[contentType]; !ok && !AllowedImageTypes[contentType] {
+			t.Errorf("%s is sniffed but neither allowed nor explained", contentType)
+		}
+	}
+}
+
+// Every allowed type is one DetectContentType can report, so none of them is
+// unreachable
+func TestAllowedImageTypesAreSniffed(t *testing.T) {
+	samples := [][]byte{
+		[]byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"),
+		[]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"),
+		[]byte("GIF89a\x01\x00\x01\x00"),
+		[]byte("RIFF\x24\x00\x00\x00WEBPVP8 "),
+	}
+	sniffed := map[string]bool{}
+	for _, data := range samples {
+		sniffed[DetectContentType(data)] = true
+	}
+	for contentType := range AllowedImageTypes {
+		if !sniffed[contentType] {
+			t.Errorf("%s is allowed but never sniffed", contentType)
+		}
+	}
+}
+
+// The WebP files in testdata come from golang.org/x/image's test data: one
+// lossy (VP8) and one lossless (VP8L)
+func TestSaveImageDecodesWebP(t *testing.T) {
+	for _, name := range []string{"blue-purple-pink.lossy.webp", "gopher-doc.1bpp.lossless.webp"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := DetectContentType(data); !AllowedImageTypes[got] || got != "image/webp" {
+				t.Fatalf("DetectContentType = %q, want an allowed image/webp", got)
+			}
+			src, err := webp.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dir := t.TempDir()
+			filename, err := SaveImage(bytes.NewReader(data), dir, "photo")
+			if err != nil {
+				t.Fatalf("SaveImage: %v", err)
+			}
+			if filename != "photo.jpg" {
+				t.Errorf("stored as %s, want photo.jpg", filename)
+			}
+
+			f, err := os.Open(filepath.Join(dir, filename))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			stored, err := jpeg.DecodeConfig(f)
+			if err != nil {
+				t.Fatalf("stored file isn't a JPEG: %v", err)
+			}
+			if stored.Width != src.Width || stored.Height != src.Height {
+				t.Errorf("stored %dx%d, want the original %dx%d", stored.Width, stored.Height, src.Width, src.Height)
+			}
+		})
+	}
+}
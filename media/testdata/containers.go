@@ -0,0 +1,326 @@
+//go:build ignore
+
+// Writes the audio and video fixtures for the container probe tests. They're
+// built byte by byte, with just the headers the probes read around silent or
+// empty payloads, so each one's duration is known exactly.
+//
+//	cd media/testdata && go run containers.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"math"
+	"os"
+)
+
+func main() {
+	fixtures := map[string][]byte{
+		"v0.m4a":        mp4V0Audio(),
+		"v1.mp4":        mp4V1Video(),
+		"declared.webm": webmDeclared(),
+		"video.webm":    webmVideo(),
+		"recorder.webm": webmRecorder(),
+		"opus.ogg":      oggOpus(),
+		"vorbis.ogg":    oggVorbis(),
+		"cbr.mp3":       mp3CBR(),
+		"xing.mp3":      mp3Xing(),
+		"vbri.mp3":      mp3VBRI(),
+	}
+	for name, data := range fixtures {
+		if err := os.WriteFile(name, data, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// --- MP4 ---
+
+func box(name string, children ...[]byte) []byte {
+	payload := bytes.Join(children, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	return append(append(b, name...), payload...)
+}
+
+// largeBox uses the 64 bit size form
+func largeBox(name string, payload []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, 1)
+	b = append(b, name...)
+	b = binary.BigEndian.AppendUint64(b, uint64(16+len(payload)))
+	return append(b, payload...)
+}
+
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func u64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+func hdlr(handler string) []byte {
+	// version and flags, pre_defined, handler_type, reserved, name
+	return box("hdlr", u32(0), u32(0), []byte(handler), make([]byte, 12), []byte("\x00"))
+}
+
+func trak(handler string) []byte {
+	return box("trak", box("tkhd", make([]byte, 84)), box("mdia", box("mdhd", make([]byte, 24)), hdlr(handler)))
+}
+
+// 5.5 seconds of audio, with a version 0 mvhd
+func mp4V0Audio() []byte {
+	mvhd := box("mvhd", u32(0), u32(0), u32(0), u32(1000), u32(5500), make([]byte, 80))
+	return bytes.Join([][]byte{
+		box("ftyp", []byte("M4A "), u32(0), []byte("M4A isom")),
+		box("moov", mvhd, trak("soun")),
+		box("mdat", make([]byte, 64)),
+	}, nil)
+}
+
+// 3 seconds of video and audio, with a version 1 mvhd after a 64 bit mdat
+func mp4V1Video() []byte {
+	mvhd := box("mvhd", u32(1<<24), u64(0), u64(0), u32(90000), u64(270000), make([]byte, 80))
+	return bytes.Join([][]byte{
+		box("ftyp", []byte("isom"), u32(0x200), []byte("isomiso2mp41")),
+		box("free"),
+		largeBox("mdat", make([]byte, 64)),
+		box("moov", mvhd, trak("soun"), trak("vide")),
+	}, nil)
+}
+
+// --- WebM ---
+
+// unknownSize marks a master element that runs to the end of its parent
+const unknownSize = -1
+
+func element(id uint32, children ...[]byte) []byte {
+	payload := bytes.Join(children, nil)
+	return append(elementHeader(id, len(payload)), payload...)
+}
+
+func elementHeader(id uint32, size int) []byte {
+	var b []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if c := byte(id >> shift); c != 0 || len(b) > 0 {
+			b = append(b, c)
+		}
+	}
+	if size == unknownSize {
+		return append(b, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	}
+	// Always an 8 byte size, as muxers that patch sizes in afterwards write
+	return append(b, 0x01, byte(size>>48), byte(size>>40), byte(size>>32), byte(size>>24), byte(size>>16), byte(size>>8), byte(size))
+}
+
+func uintElement(id uint32, v uint64) []byte {
+	var b []byte
+	for shift := 56; shift >= 0; shift -= 8 {
+		if c := byte(v >> shift); c != 0 || len(b) > 0 || shift == 0 {
+			b = append(b, c)
+		}
+	}
+	return element(id, b)
+}
+
+func ebmlHeader(docType string) []byte {
+	return element(0x1a45dfa3, uintElement(0x4286, 1), element(0x4282, []byte(docType)))
+}
+
+func track(trackType uint64) []byte {
+	return element(0xae, uintElement(0xd7, 1), uintElement(0x83, trackType))
+}
+
+func simpleBlock(relative int16) []byte {
+	// Track 1, timecode, keyframe flag, frame data
+	return element(0xa3, []byte{0x81, byte(relative >> 8), byte(relative), 0x80}, make([]byte, 16))
+}
+
+func cluster(timecode uint64, blocks ...[]byte) []byte {
+	return element(0x1f43b675, uintElement(0xe7, timecode), bytes.Join(blocks, nil))
+}
+
+// liveCluster is a cluster of unknown size, as recorders stream them
+func liveCluster(timecode uint64, blocks ...[]byte) []byte {
+	b := append(elementHeader(0x1f43b675, unknownSize), uintElement(0xe7, timecode)...)
+	return append(b, bytes.Join(blocks, nil)...)
+}
+
+// 4.5 seconds of audio, declared as a float64 in an unknown size segment
+func webmDeclared() []byte {
+	duration := binary.BigEndian.AppendUint64(nil, math.Float64bits(4500))
+	segment := append(elementHeader(0x18538067, unknownSize), bytes.Join([][]byte{
+		element(0x1549a966, uintElement(0x2ad7b1, 1000000), element(0x4489, duration)),
+		element(0x1654ae6b, track(2)),
+		cluster(0, simpleBlock(0), simpleBlock(20)),
+	}, nil)...)
+	return append(ebmlHeader("webm"), segment...)
+}
+
+// 2 seconds of video, declared as a float32 in 10ms ticks
+func webmVideo() []byte {
+	duration := binary.BigEndian.AppendUint32(nil, math.Float32bits(200))
+	return append(ebmlHeader("webm"), element(0x18538067,
+		element(0x1549a966, uintElement(0x2ad7b1, 10000000), element(0x4489, duration)),
+		element(0x1654ae6b, track(1), track(2)),
+		cluster(0, simpleBlock(0)),
+	)...)
+}
+
+// What a browser's MediaRecorder writes: no duration, and unknown size
+// segment and clusters, with the last block at 1.98 seconds
+func webmRecorder() []byte {
+	var blocks [2][][]byte
+	for i := range blocks {
+		for t := int16(0); t < 1000; t += 20 {
+			blocks[i] = append(blocks[i], simpleBlock(t))
+		}
+	}
+	segment := append(elementHeader(0x18538067, unknownSize), bytes.Join([][]byte{
+		element(0x1549a966, uintElement(0x2ad7b1, 1000000)),
+		element(0x1654ae6b, track(2)),
+		liveCluster(0, blocks[0]...),
+		liveCluster(1000, blocks[1]...),
+	}, nil)...)
+	return append(ebmlHeader("webm"), segment...)
+}
+
+// --- Ogg ---
+
+var oggCRCTable = func() (table [256]uint32) {
+	for i := range table {
+		r := uint32(i) << 24
+		for range 8 {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+// oggPage wraps packets in a page. A granule of -1 means no packet ends on
+// it, so the last packet, a multiple of 255 bytes, carries on to the next.
+func oggPage(serial, sequence uint32, headerType byte, granule int64, packets ...[]byte) []byte {
+	var lacing, body []byte
+	for _, p := range packets {
+		n := len(p)
+		for ; n >= 255; n -= 255 {
+			lacing = append(lacing, 255)
+		}
+		lacing = append(lacing, byte(n))
+		body = append(body, p...)
+	}
+	if granule == -1 {
+		lacing = lacing[:len(lacing)-1]
+	}
+
+	page := []byte("OggS\x00")
+	page = append(page, headerType)
+	page = binary.LittleEndian.AppendUint64(page, uint64(granule))
+	page = binary.LittleEndian.AppendUint32(page, serial)
+	page = binary.LittleEndian.AppendUint32(page, sequence)
+	page = append(page, 0, 0, 0, 0) // checksum
+	page = append(page, byte(len(lacing)))
+	page = append(append(page, lacing...), body...)
+
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	binary.LittleEndian.PutUint32(page[22:], crc)
+	return page
+}
+
+// 2 seconds of Opus after a 312 sample pre-skip, with a page no packet ends
+// on and a second stream that runs longer
+func oggOpus() []byte {
+	head := []byte("OpusHead\x01\x01")
+	head = binary.LittleEndian.AppendUint16(head, 312)
+	head = binary.LittleEndian.AppendUint32(head, 48000)
+	head = append(head, 0, 0, 0)
+
+	return bytes.Join([][]byte{
+		oggPage(1, 0, 0x02, 0, head),
+		oggPage(7, 0, 0x02, 0, []byte("\x01vorbis\x00\x00\x00\x00\x01\x44\xac\x00\x00")),
+		oggPage(1, 1, 0, 0, []byte("OpusTags\x00\x00\x00\x00\x00\x00\x00\x00")),
+		oggPage(1, 2, 0, 48000+312, make([]byte, 300)),
+		oggPage(1, 3, 0, -1, make([]byte, 255*4)),
+		oggPage(7, 1, 0, 44100*9, make([]byte, 40)),
+		oggPage(1, 4, 0x05, 96000+312, make([]byte, 100)),
+	}, nil)
+}
+
+// 3 seconds of Vorbis at 44.1kHz
+func oggVorbis() []byte {
+	ident := []byte("\x01vorbis\x00\x00\x00\x00\x02")
+	ident = binary.LittleEndian.AppendUint32(ident, 44100)
+	ident = append(ident, make([]byte, 14)...)
+	ident = append(ident, 0xb8, 0x01)
+
+	return bytes.Join([][]byte{
+		oggPage(3, 0, 0x02, 0, ident),
+		oggPage(3, 1, 0, 0, []byte("\x03vorbis"), []byte("\x05vorbis")),
+		oggPage(3, 2, 0, 44100, make([]byte, 500)),
+		oggPage(3, 3, 0x04, 3*44100, make([]byte, 500)),
+	}, nil)
+}
+
+// --- MP3 ---
+
+// mp3Frame is an MPEG-1 layer III stereo frame at 44.1kHz, with info (a Xing
+// or VBRI header) where a decoder would look for it
+func mp3Frame(kbps int, info string) []byte {
+	index := map[int]byte{128: 9, 192: 11}[kbps]
+	length := 144 * kbps * 1000 / 44100
+	frame := make([]byte, length)
+	copy(frame, []byte{0xff, 0xfb, index<<4 | 0x00, 0x00})
+	copy(frame[36:], info)
+	return frame
+}
+
+func id3v2() []byte {
+	frame := []byte("TIT2\x00\x00\x00\x06\x00\x00\x03Test\x00")
+	tag := []byte("ID3\x04\x00\x00\x00\x00\x00")
+	return append(append(tag, byte(len(frame))), frame...)
+}
+
+func id3v1() []byte {
+	tag := make([]byte, 128)
+	copy(tag, "TAG")
+	tag[127] = 0xff // genre
+	return tag
+}
+
+// 100 frames (2.612s), with junk between two of them and an ID3v1 tag after
+func mp3CBR() []byte {
+	var b []byte
+	b = append(b, id3v2()...)
+	for i := range 100 {
+		if i == 60 {
+			b = append(b, 0x00, 0xff, 0x00, 0x12, 0xff, 0x1f)
+		}
+		b = append(b, mp3Frame(128, "")...)
+	}
+	return append(b, id3v1()...)
+}
+
+// 50 frames (1.306s) after a Xing header frame that holds no audio
+func mp3Xing() []byte {
+	xing := append([]byte("Xing"), u32(0x0f)...)
+	xing = append(xing, u32(50)...)
+	b := mp3Frame(128, string(xing))
+	for i := range 50 {
+		b = append(b, mp3Frame([]int{128, 192}[i%2], "")...)
+	}
+	return b
+}
+
+// 40 frames (1.045s) of varying bitrate after a VBRI header frame
+func mp3VBRI() []byte {
+	vbri := append([]byte("VBRI\x00\x01\x0d\xb1\x00\x64"), u32(0)...)
+	vbri = append(vbri, u32(40)...)
+	b := append(id3v2(), mp3Frame(128, string(vbri))...)
+	for i := range 40 {
+		b = append(b, mp3Frame([]int{192, 128, 128}[i%3], "")...)
+	}
+	return b
+}
@@ -0,0 +1,198 @@
+package media
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"event-messenger.com/models"
+)
+
+// ErrQueueFull is returned by Enqueue when every worker is busy and the queue
+// has no room left
+var ErrQueueFull = errors.New("image processing queue is full")
+
+// Metrics published on /debug/vars
+var (
+	jobsProcessed = expvar.NewInt("image_jobs_processed")
+	jobsFailed    = expvar.NewInt("image_jobs_failed")
+	jobsRejected  = expvar.NewInt("image_jobs_rejected")
+	jobMillis     = expvar.NewInt("image_job_milliseconds_total")
+)
+
+// Job is an uploaded original waiting to be turned into renditions
+type Job struct {
+	SubmissionID int
+	BaseName     string // stored filename without extension
+}
+
+var (
+	queue      chan Job
+	pendingDir string
+	uploadDir  string
+)
+
+func init() {
+	expvar.Publish("image_queue_depth", expvar.Func(func() any {
+		return len(queue)
+	}))
+}
+
+// StartWorkers starts a fixed pool of image workers reading from a queue of
+// queueSize jobs. Originals are read from pending and renditions written to
+// uploads. Submissions left in the processing state by a previous run are
+// queued again before it returns, so call it before serving uploads.
+func StartWorkers(workers, queueSize int, pending, uploads string) {
+	queue = make(chan Job, queueSize)
+	pendingDir = pending
+	uploadDir = uploads
+
+	for i := 0; i < workers; i++ {
+		go worker(queue)
+	}
+	slog.Debug(fmt.Sprintf("Image workers started - %d workers, queue size %d", workers, queueSize))
+
+	requeuePending()
+}
+
+// Enqueue queues a job without blocking, returning ErrQueueFull if there's no room
+func Enqueue(job Job) error {
+	select {
+	case queue <- job:
+		return nil
+	default:
+		jobsRejected.Add(1)
+		return ErrQueueFull
+	}
+}
+
+// QueueHasRoom reports whether Enqueue is currently likely to succeed
+func QueueHasRoom() bool {
+	return len(queue) < cap(queue)
+}
+
+// SaveOriginal stores an upload untouched in the pending directory so it can
+// be processed later. It returns the stored filename.
+func SaveOriginal(data []byte, baseName, contentType string) (string, error) {
+	if err := os.MkdirAll(pendingDir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("error creating pending directory: %w", err)
+	}
+
+	filename := baseName + extensionFor(contentType)
+	err := os.WriteFile(filepath.Join(pendingDir, filename), data, 0644)
+	if err != nil {
+		return "", fmt.Errorf("error saving original: %w", err)
+	}
+
+	return filename, nil
+}
+
+// RemoveOriginal deletes a pending original, e.g. when its job couldn't be queued
+func RemoveOriginal(filename string) {
+	if filename == "" {
+		return
+	}
+	if err := os.Remove(filepath.Join(pendingDir, filename)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Could not remove original %s: %v", filename, err)
+	}
+}
+
+func worker(jobs <-chan Job) {
+	for job := range jobs {
+		start := time.Now()
+		err := process(job)
+		jobMillis.Add(time.Since(start).Milliseconds())
+
+		if err != nil {
+			jobsFailed.Add(1)
+			log.Printf("Image processing failed for submission %d: %v", job.SubmissionID, err)
+			continue
+		}
+		jobsProcessed.Add(1)
+	}
+}
+
+func process(job Job) error {
+	submission, err := models.GetSubmissionByID(job.SubmissionID)
+	if err != nil {
+		// Withdrawn before we got to it
+		return err
+	}
+	if submission.Status != models.SubmissionProcessing {
+		return nil
+	}
+
+	// The original goes whatever the outcome, before the outcome is recorded,
+	// so nothing is left in pending once a submission stops processing
+	original := submission.OriginalFilename
+	file, err := os.Open(filepath.Join(pendingDir, original))
+	if err != nil {
+		RemoveOriginal(original)
+		submission.MarkFailed("The uploaded image was lost before it could be processed. Please submit it again.")
+		return err
+	}
+	filename, err := SaveImage(file, uploadDir, job.BaseName)
+	file.Close()
+	RemoveOriginal(original)
+	if err != nil {
+		reason := "We couldn't process this image. Please try a different photo."
+		var limitErr *LimitError
+		if errors.As(err, &limitErr) {
+			reason = limitErr.Reason
+		}
+		if markErr := submission.MarkFailed(reason); markErr != nil {
+			log.Printf("%v", markErr)
+		}
+		return err
+	}
+
+	return submission.MarkProcessed(filename)
+}
+
+// requeuePending queues submissions that were still processing when the
+// server last stopped. It never waits for room: any that don't fit stay
+// processing and are queued again on the next start.
+func requeuePending() {
+	submissions, err := models.GetSubmissionsByStatus(models.SubmissionProcessing)
+	if err != nil {
+		log.Printf("Could not load pending submissions: %v", err)
+		return
+	}
+
+	requeued, left := 0, 0
+	for _, s := range submissions {
+		baseName := strings.TrimSuffix(s.OriginalFilename, filepath.Ext(s.OriginalFilename))
+		select {
+		case queue <- Job{SubmissionID: s.ID, BaseName: baseName}:
+			requeued++
+		default:
+			left++
+		}
+	}
+
+	if requeued > 0 {
+		slog.Info(fmt.Sprintf("Re-queued %d submissions left processing by a previous run", requeued))
+	}
+	if left > 0 {
+		slog.Warn(fmt.Sprintf("Image queue is full; %d submissions left processing will be queued on the next start", left))
+	}
+}
+
+func extensionFor(contentType string) string {
+	switch contentType {
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ".jpg"
+	}
+}
@@ -0,0 +1,166 @@
+package media_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"event-messenger.com/internal/testutil"
+	"event-messenger.com/media"
+	"event-messenger.com/models"
+)
+
+// startWorkers runs workers over a queue of queueSize jobs in a temporary
+// directory, returning the pending and uploads directories
+func startWorkers(t *testing.T, workers, queueSize int) (pending, uploads string) {
+	t.Helper()
+	dir := t.TempDir()
+	pending, uploads = filepath.Join(dir, "pending"), filepath.Join(dir, "uploads")
+	media.StartWorkers(workers, queueSize, pending, uploads)
+	return pending, uploads
+}
+
+// processingSubmission saves a submission whose original is waiting in the
+// pending directory, as the submission handler leaves it
+func processingSubmission(t *testing.T, event *models.Event, baseName string, data []byte) *models.Submission {
+	t.Helper()
+	original, err := media.SaveOriginal(data, baseName, media.DetectContentType(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &models.Submission{EventID: event.ID, Name: "Riley", Message: "Congratulations",
+		Status: models.SubmissionProcessing, OriginalFilename: original}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// waitProcessed polls until the workers have finished with the submission
+func waitProcessed(t *testing.T, id int) *models.Submission {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s, err := models.GetSubmissionByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Status != models.SubmissionProcessing {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("submission %d still processing", id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testEvent(t *testing.T) *models.Event {
+	t.Helper()
+	event := models.NewEvent("Farewell", "farewell", time.Now().AddDate(0, 0, 7))
+	if err := event.SaveEvent(); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestQueuedImageIsProcessed(t *testing.T) {
+	testutil.Setup(t)
+	pending, uploads := startWorkers(t, 2, 4)
+	event := testEvent(t)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		wantStatus string
+	}{
+		{"image", testPNG(t), models.SubmissionReady},
+		{"decode failure", append([]byte("\x89PNG\r\n\x1a\n"), "not really a png"...), models.SubmissionFailed},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseName := "photo-" + string(rune('a'+i))
+			s := processingSubmission(t, event, baseName, tt.data)
+			if err := media.Enqueue(media.Job{SubmissionID: s.ID, BaseName: baseName}); err != nil {
+				t.Fatal(err)
+			}
+
+			got := waitProcessed(t, s.ID)
+			if got.Status != tt.wantStatus {
+				t.Fatalf("status %s (%s), want %s", got.Status, got.ProcessingError, tt.wantStatus)
+			}
+			switch got.Status {
+			case models.SubmissionReady:
+				if _, err := os.Stat(filepath.Join(uploads, baseName+".jpg")); err != nil {
+					t.Errorf("rendition not written: %v", err)
+				}
+			case models.SubmissionFailed:
+				if got.ProcessingError == "" {
+					t.Error("failed submission has no reason for the contributor")
+				}
+			}
+			if got.OriginalFilename != "" {
+				t.Errorf("original %s still recorded", got.OriginalFilename)
+			}
+			if _, err := os.Stat(filepath.Join(pending, s.OriginalFilename)); !os.IsNotExist(err) {
+				t.Errorf("original %s left in pending: %v", s.OriginalFilename, err)
+			}
+		})
+	}
+}
+
+func TestFullQueueRejectsJobs(t *testing.T) {
+	testutil.Setup(t)
+	// No workers, so nothing leaves the queue
+	startWorkers(t, 0, 1)
+
+	if err := media.Enqueue(media.Job{SubmissionID: 1, BaseName: "first"}); err != nil {
+		t.Fatalf("first job: %v", err)
+	}
+	if media.QueueHasRoom() {
+		t.Error("QueueHasRoom with the queue full")
+	}
+	if err := media.Enqueue(media.Job{SubmissionID: 2, BaseName: "second"}); !errors.Is(err, media.ErrQueueFull) {
+		t.Errorf("second job: %v, want ErrQueueFull", err)
+	}
+}
+
+// Submissions left processing by a previous run are queued again at start,
+// without waiting for room in the queue
+func TestStartWorkersRequeuesWithoutBlocking(t *testing.T) {
+	testutil.Setup(t)
+	startWorkers(t, 0, 1)
+	event := testEvent(t)
+	for _, name := range []string{"left-a", "left-b", "left-c"} {
+		processingSubmission(t, event, name, testPNG(t))
+	}
+
+	dir := t.TempDir()
+	started := make(chan struct{})
+	go func() {
+		media.StartWorkers(0, 2, filepath.Join(dir, "pending"), filepath.Join(dir, "uploads"))
+		close(started)
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("StartWorkers blocked requeueing more submissions than the queue holds")
+	}
+	if media.QueueHasRoom() {
+		t.Error("queue has room after requeueing three submissions into two places")
+	}
+}
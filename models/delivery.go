@@ -0,0 +1,113 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"event-messenger.com/db"
+)
+
+// Delivery is one attempt at sending an event's messages, by the scheduler
+// or by the coordinator. Together they make up the event's delivery history.
+type Delivery struct {
+	ID         int
+	EventID    int
+	Trigger    string // DeliveryScheduled, DeliveryEarly or DeliveryResend
+	Channel    string
+	SentTo     string // the addresses emailed, comma separated; empty for chat channels
+	Status     string // DeliverySucceeded or DeliveryFailed
+	Error      string
+	RequestKey string // from the coordinator's confirmation form, empty for the scheduler
+	CreatedAt  time.Time
+}
+
+// What started a delivery
+const (
+	DeliveryScheduled = "scheduled" // the scheduler, on the event date
+	DeliveryEarly     = "early"     // the coordinator, before the event date
+	DeliveryResend    = "resend"    // the coordinator, after it was delivered
+)
+
+// Delivery outcomes
+const (
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Reasons a delivery isn't attempted
+var (
+	ErrAlreadyDelivered  = errors.New("the messages have already been delivered")
+	ErrNotDelivered      = errors.New("the messages haven't been delivered yet")
+	ErrNoSubmissions     = errors.New("there are no messages to deliver")
+	ErrDuplicateDelivery = errors.New("this delivery request has already been carried out")
+)
+
+// RecordDelivery adds a delivery to its event's history. A request key that
+// has been recorded before is refused, so a form submitted twice is only
+// acted on once.
+func RecordDelivery(d *Delivery) error {
+	d.CreatedAt = time.Now().UTC()
+	result, err := db.DB.Exec(`INSERT INTO event_deliveries (event_id, triggered_by, channel, sent_to, status, error, request_key, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?)`,
+		d.EventID, d.Trigger, d.Channel, d.SentTo, d.Status, d.Error, d.RequestKey, d.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error recording delivery: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	d.ID = int(id)
+	return nil
+}
+
+// NewDeliveryRequestKey returns a key for a coordinator's confirmation form
+func NewDeliveryRequestKey() (string, error) {
+	return newToken()
+}
+
+// DeliveryRequestSeen reports whether a delivery with this request key has
+// already been recorded
+func DeliveryRequestSeen(key string) (bool, error) {
+	var count int
+	err := db.DB.QueryRow(`SELECT COUNT(*) FROM event_deliveries WHERE request_key = ?`, key).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking delivery request: %v", err)
+	}
+	return count > 0, nil
+}
+
+// GetDeliveries returns the event's delivery history, newest first
+func GetDeliveries(eventID int) ([]Delivery, error) {
+	query := `SELECT id, event_id, triggered_by, channel, sent_to, status, error, COALESCE(request_key, ''), created_at
+              FROM event_deliveries WHERE event_id = ? ORDER BY id DESC`
+
+	rows, err := db.DB.Query(query, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	var deliveries []Delivery
+	for rows.Next() {
+		var d Delivery
+		err := rows.Scan(&d.ID, &d.EventID, &d.Trigger, &d.Channel, &d.SentTo, &d.Status, &d.Error, &d.RequestKey, &d.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// ResetRecipients marks everyone pending again, so the notification can be
+// sent to them a second time
+func (e *Event) ResetRecipients() error {
+	_, err := db.DB.Exec(`UPDATE event_recipients SET status = ?, error = '' WHERE event_id = ?`, RecipientPending, e.ID)
+	if err != nil {
+		return fmt.Errorf("error resetting recipients: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"event-messenger.com/db"
+)
+
+// DefaultDigestDays is when coordinators get a progress digest unless they
+// choose otherwise: 3 days and 1 day before delivery
+const DefaultDigestDays = "3,1"
+
+// Limits on a coordinator's digest schedule
+const (
+	MaxDigestDaysBefore = 30
+	MaxDigests          = 5
+)
+
+// ParseDigestDays reads a digest schedule such as "3, 1" and returns it
+// tidied, furthest from delivery first, as stored in Event.DigestDays. An
+// empty schedule, or "none", turns the digest off.
+func ParseDigestDays(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "none") {
+		return "", nil
+	}
+
+	var days []int
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 || n > MaxDigestDaysBefore {
+			return "", fmt.Errorf("digest days must be whole numbers of days from 1 to %d", MaxDigestDaysBefore)
+		}
+		days = append(days, n)
+	}
+	return FormatDigestDays(days)
+}
+
+// FormatDigestDays checks a digest schedule and returns it as stored in
+// Event.DigestDays
+func FormatDigestDays(days []int) (string, error) {
+	days = slices.Clone(days)
+	slices.Sort(days)
+	slices.Reverse(days)
+	days = slices.Compact(days)
+	if len(days) > MaxDigests {
+		return "", fmt.Errorf("an event can have at most %d digests", MaxDigests)
+	}
+
+	fields := make([]string, len(days))
+	for i, d := range days {
+		if d < 1 || d > MaxDigestDaysBefore {
+			return "", fmt.Errorf("digest days must be whole numbers of days from 1 to %d", MaxDigestDaysBefore)
+		}
+		fields[i] = strconv.Itoa(d)
+	}
+	return strings.Join(fields, ","), nil
+}
+
+// DigestSchedule returns the days before delivery the coordinator gets a
+// progress digest
+func (e *Event) DigestSchedule() []int {
+	days := []int{}
+	for _, field := range strings.Split(e.DigestDays, ",") {
+		if n, err := strconv.Atoi(field); err == nil {
+			days = append(days, n)
+		}
+	}
+	return days
+}
+
+// ClaimDigest records that the digest which came due at dueAt is being sent
+// at now, unless one has been sent since, and returns the review link token
+// it carries. Each digest gets a new token, so only the latest digest's link
+// works; the coordinator's original link always does.
+func (e *Event) ClaimDigest(dueAt, now time.Time) (string, bool, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", false, err
+	}
+
+	now = now.UTC()
+	result, err := db.DB.Exec(`UPDATE events SET digest_sent_at = ?, digest_token_hash = ?
+        WHERE id = ? AND (digest_sent_at IS NULL OR digest_sent_at < ?)`,
+		now, hashToken(token), e.ID, dueAt.UTC())
+	if err != nil {
+		return "", false, fmt.Errorf("error updating event: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", false, nil
+	}
+	e.DigestSentAt.Time, e.DigestSentAt.Valid = now, true
+	e.digestTokenHash = hashToken(token)
+	return token, true, nil
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"crypto/subtle"
 	"database/sql"
 	"fmt"
 	"log"
@@ -26,6 +27,53 @@ type Event struct {
 	EmailSentAt    sql.NullTime `db:"email_sent_at"`
 	WebsiteLink    string       `db:"website_link"` // Link to send recipient
 	CreatedAt      time.Time    `db:"created_at"`
+	// Limits for audio/video message attachments
+	MediaMaxSeconds int   `db:"media_max_seconds"`
+	MediaMaxBytes   int64 `db:"media_max_bytes"`
+	// New submissions wait for the coordinator's approval
+	Moderated bool `db:"moderated"`
+	// Content filter: what to do with listed words (see wordfilter) and the
+	// coordinator's own words, one per line
+	FilterAction string `db:"filter_action"`
+	FilterWords  string `db:"filter_words"`
+	// How the messages reach the recipient on the event date (see
+	// NotifyChannels) and, for chat channels, the incoming webhook to post to
+	NotifyChannel string `db:"notify_channel"`
+	NotifyURL     string `db:"notify_url"`
+	// Days before delivery the coordinator is emailed a progress digest,
+	// comma-separated (see ParseDigestDays); empty for none
+	DigestDays   string       `db:"digest_days"`
+	DigestSentAt sql.NullTime `db:"digest_sent_at"`
+	// Set by SaveEvent for the coordinator's review link; only its hash is stored
+	ManageToken     string `db:"-"`
+	manageTokenHash string
+	// The latest digest's review link, which works alongside ManageToken
+	digestTokenHash string
+}
+
+// Default limits for audio/video attachments
+const (
+	DefaultMediaMaxSeconds = 60
+	DefaultMediaMaxBytes   = 25 << 20 // 25 MB
+)
+
+// Channels an event's messages can be delivered over
+const (
+	NotifyEmail = "email" // an HTML email with every message to the recipient
+	NotifySlack = "slack" // a summary posted to a Slack or Mattermost incoming webhook
+)
+
+// NotifyChannels lists every delivery channel, the default first
+var NotifyChannels = []string{NotifyEmail, NotifySlack}
+
+// ValidNotifyChannel reports whether channel is one of NotifyChannels
+func ValidNotifyChannel(channel string) bool {
+	for _, c := range NotifyChannels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
 }
 
 type EventOption func(*Event)
@@ -38,6 +86,11 @@ func NewEvent(name, slug string, eventDate time.Time, opts ...EventOption) *Even
 		EventDate: eventDate,
 		Active:    true,
 		CreatedAt: time.Now(),
+
+		MediaMaxSeconds: DefaultMediaMaxSeconds,
+		MediaMaxBytes:   DefaultMediaMaxBytes,
+		NotifyChannel:   NotifyEmail,
+		DigestDays:      DefaultDigestDays,
 	}
 
 	// Apply optional configurations
@@ -75,6 +128,45 @@ func WithWebsiteLink(link string) EventOption {
 	}
 }
 
+// WithMediaLimits sets the longest and largest audio/video attachment accepted
+func WithMediaLimits(maxSeconds int, maxBytes int64) EventOption {
+	return func(e *Event) {
+		e.MediaMaxSeconds = maxSeconds
+		e.MediaMaxBytes = maxBytes
+	}
+}
+
+func WithModeration(moderated bool) EventOption {
+	return func(e *Event) {
+		e.Moderated = moderated
+	}
+}
+
+// WithContentFilter sets what happens to messages containing listed words
+func WithContentFilter(action, words string) EventOption {
+	return func(e *Event) {
+		e.FilterAction = action
+		e.FilterWords = words
+	}
+}
+
+// WithNotifyChannel sets how the messages are delivered; url is the incoming
+// webhook for chat channels
+func WithNotifyChannel(channel, url string) EventOption {
+	return func(e *Event) {
+		e.NotifyChannel = channel
+		e.NotifyURL = url
+	}
+}
+
+// WithDigestDays sets the days before delivery the coordinator gets a
+// progress digest
+func WithDigestDays(days string) EventOption {
+	return func(e *Event) {
+		e.DigestDays = days
+	}
+}
+
 func WithActive(active bool) EventOption {
 	return func(e *Event) {
 		e.Active = active
@@ -164,28 +256,88 @@ func (e *Event) DeleteEvent() error {
 		return fmt.Errorf("error deleting event: %v", err)
 	}
 
+	_, err = db.DB.Exec(`DELETE FROM event_recipients WHERE event_id = ?`, e.ID)
+	if err != nil {
+		return fmt.Errorf("error deleting event recipients: %v", err)
+	}
+
+	_, err = db.DB.Exec(`DELETE FROM event_deliveries WHERE event_id = ?`, e.ID)
+	if err != nil {
+		return fmt.Errorf("error deleting event deliveries: %v", err)
+	}
+
+	_, err = db.DB.Exec(`DELETE FROM event_invitees WHERE event_id = ?`, e.ID)
+	if err != nil {
+		return fmt.Errorf("error deleting event invitees: %v", err)
+	}
+
 	log.Printf("Event deleted: %s (ID: %d)", e.Name, e.ID)
 	return nil
 }
 
+// Purge deletes the event and all of its submissions straight away, whether
+// or not it has been delivered. Stored files are left to the caller.
+func (e *Event) Purge() error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error deleting event: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM submissions WHERE event_id = ?`, e.ID); err != nil {
+		return fmt.Errorf("error deleting event submissions: %v", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM event_recipients WHERE event_id = ?`, e.ID); err != nil {
+		return fmt.Errorf("error deleting event recipients: %v", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM event_deliveries WHERE event_id = ?`, e.ID); err != nil {
+		return fmt.Errorf("error deleting event deliveries: %v", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM event_invitees WHERE event_id = ?`, e.ID); err != nil {
+		return fmt.Errorf("error deleting event invitees: %v", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM events WHERE id = ?`, e.ID); err != nil {
+		return fmt.Errorf("error deleting event: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error deleting event: %v", err)
+	}
+
+	log.Printf("Event purged: %s (ID: %d)", e.Name, e.ID)
+	return nil
+}
+
 func (e *Event) SaveEvent() error {
 	// converts datetimes to UTC datetime for consistency
 	eventDateUTC := e.EventDate.UTC()
 	createdAtUTC := e.CreatedAt.UTC()
 
+	// The coordinator's private link for reviewing submissions
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	e.ManageToken = token
+	e.manageTokenHash = hashToken(token)
+
 	insertSQL := `INSERT INTO events (
         name, slug, description, event_date, active, 
         coordinator, coordinator_contact, 
         recipient_name, recipient_email, website_link, 
-        created_at
-    ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+        created_at, media_max_seconds, media_max_bytes,
+        moderated, manage_token_hash, filter_action, filter_words,
+        notify_channel, notify_url, digest_days
+    ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 	result, err := db.DB.Exec(
 		insertSQL,
 		e.Name, e.Slug, e.Description, eventDateUTC, e.Active,
 		e.Coordinator, e.CoordinatorContact,
 		e.RecipientName, e.RecipientEmail, e.WebsiteLink,
-		createdAtUTC,
+		createdAtUTC, e.MediaMaxSeconds, e.MediaMaxBytes,
+		e.Moderated, e.manageTokenHash, e.FilterAction, e.FilterWords,
+		e.NotifyChannel, e.NotifyURL, e.DigestDays,
 	)
 	if err != nil {
 		return err
@@ -200,12 +352,27 @@ func (e *Event) SaveEvent() error {
 	return err
 }
 
-// GetSubmissionCount returns the number of submissions for this event
+// ValidManageToken reports whether token is this event's coordinator token,
+// or the one in the latest progress digest
+func (e *Event) ValidManageToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	hash := []byte(hashToken(token))
+	for _, valid := range []string{e.manageTokenHash, e.digestTokenHash} {
+		if valid != "" && subtle.ConstantTimeCompare(hash, []byte(valid)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSubmissionCount returns the number of approved submissions for this event
 func (e *Event) GetSubmissionCount() (int, error) {
-	query := `SELECT COUNT(*) FROM submissions WHERE event_id = ?`
+	query := `SELECT COUNT(*) FROM submissions WHERE event_id = ? AND moderation = ?`
 
 	var count int
-	err := db.DB.QueryRow(query, e.ID).Scan(&count)
+	err := db.DB.QueryRow(query, e.ID, ModerationApproved).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("error counting submissions: %v", err)
 	}
@@ -236,8 +403,8 @@ func GetEventsForToday() ([]Event, error) {
 	startOfDayUTC := startOfDay.UTC()
 	endOfDayUTC := endOfDay.UTC()
 
-	query := `
-	SELECT * FROM events
+	query := `SELECT ` + eventColumns + `
+	FROM events
 	WHERE event_date >= ? AND event_date < ?
 	`
 
@@ -250,13 +417,7 @@ func GetEventsForToday() ([]Event, error) {
 	var events []Event
 	for rows.Next() {
 		var event Event
-		err = rows.Scan(&event.ID, &event.Name, &event.Slug, &event.Description,
-			&event.EventDate, &event.Active,
-			&event.Coordinator, &event.CoordinatorContact,
-			&event.RecipientName, &event.RecipientEmail, &event.EmailSent,
-			&event.EmailSentAt, &event.WebsiteLink, &event.CreatedAt)
-
-		if err != nil {
+		if err := event.scanRow(rows); err != nil {
 			return nil, fmt.Errorf("error scanning row: %v", err)
 		}
 		events = append(events, event)
@@ -282,7 +443,7 @@ func GetActiveEventPreviews() ([]EventPreview, error) {
         e.recipient_name,
         COUNT(s.id) as submission_count
     FROM events e
-    LEFT JOIN submissions s ON e.id = s.event_id
+    LEFT JOIN submissions s ON e.id = s.event_id AND s.moderation = 'approved'
     WHERE e.active = true
     GROUP BY e.id
     ORDER BY e.event_date DESC`
@@ -311,9 +472,7 @@ func GetActiveEventPreviews() ([]EventPreview, error) {
 }
 
 func GetAllActiveEvents() ([]Event, error) {
-	query := `SELECT id, name, slug, description, event_date, active,
-              coordinator, coordinator_contact, 
-              recipient_name, recipient_email, website_link, created_at 
+	query := `SELECT ` + eventColumns + `
               FROM events WHERE active = true ORDER BY event_date DESC`
 
 	rows, err := db.DB.Query(query)
@@ -325,14 +484,7 @@ func GetAllActiveEvents() ([]Event, error) {
 	var events []Event
 	for rows.Next() {
 		var e Event
-		err := rows.Scan(
-			&e.ID, &e.Name, &e.Slug, &e.Description,
-			&e.EventDate, &e.Active,
-			&e.Coordinator, &e.CoordinatorContact,
-			&e.RecipientName, &e.RecipientEmail, &e.WebsiteLink,
-			&e.CreatedAt,
-		)
-		if err != nil {
+		if err := e.scanRow(rows); err != nil {
 			return nil, fmt.Errorf("error scanning row: %v", err)
 		}
 		events = append(events, e)
@@ -354,7 +506,7 @@ func GetAllActiveEventsWithCounts() ([]EventWithCount, error) {
         e.recipient_name, e.recipient_email, e.website_link, e.created_at,
         COUNT(s.id) as submission_count
     FROM events e
-    LEFT JOIN submissions s ON e.id = s.event_id
+    LEFT JOIN submissions s ON e.id = s.event_id AND s.moderation = 'approved'
     WHERE e.active = true
     GROUP BY e.id
     ORDER BY e.event_date DESC`
@@ -386,20 +538,53 @@ func GetAllActiveEventsWithCounts() ([]EventWithCount, error) {
 }
 
 func GetEventBySlug(slug string) (*Event, error) {
-	query := `SELECT id, name, slug, description, event_date, active, 
-              coordinator, coordinator_contact, 
-              recipient_name, recipient_email, website_link, created_at 
-              FROM events WHERE slug = ? AND active = true`
+	return getEventBySlug(slug, true)
+}
 
-	var e Event
-	err := db.DB.QueryRow(query, slug).Scan(
+// GetEventBySlugIncludingArchived also finds events that have been delivered,
+// for pages the recipient visits afterwards
+func GetEventBySlugIncludingArchived(slug string) (*Event, error) {
+	return getEventBySlug(slug, false)
+}
+
+const eventColumns = `id, name, slug, description, event_date, active,
+              coordinator, coordinator_contact,
+              recipient_name, recipient_email, email_sent, email_sent_at,
+              website_link, created_at, media_max_seconds, media_max_bytes,
+              moderated, COALESCE(manage_token_hash, ''), filter_action, filter_words,
+              notify_channel, notify_url, digest_days, digest_sent_at,
+              COALESCE(digest_token_hash, '')`
+
+// scanRow scans a row selected with eventColumns
+func (e *Event) scanRow(row interface{ Scan(...any) error }) error {
+	return row.Scan(
 		&e.ID, &e.Name, &e.Slug, &e.Description,
 		&e.EventDate, &e.Active,
 		&e.Coordinator, &e.CoordinatorContact,
-		&e.RecipientName, &e.RecipientEmail, &e.WebsiteLink,
-		&e.CreatedAt,
+		&e.RecipientName, &e.RecipientEmail, &e.EmailSent, &e.EmailSentAt,
+		&e.WebsiteLink, &e.CreatedAt, &e.MediaMaxSeconds, &e.MediaMaxBytes,
+		&e.Moderated, &e.manageTokenHash, &e.FilterAction, &e.FilterWords,
+		&e.NotifyChannel, &e.NotifyURL, &e.DigestDays, &e.DigestSentAt,
+		&e.digestTokenHash,
 	)
+}
 
+// GetEventByID returns an event whether or not it's still active
+func GetEventByID(id int) (*Event, error) {
+	var e Event
+	err := e.scanRow(db.DB.QueryRow(`SELECT `+eventColumns+` FROM events WHERE id = ?`, id))
+	if err != nil {
+		return nil, fmt.Errorf("event not found: %v", err)
+	}
+	return &e, nil
+}
+
+func getEventBySlug(slug string, activeOnly bool) (*Event, error) {
+	query := `SELECT ` + eventColumns + `
+              FROM events WHERE slug = ? AND (active = true OR ? = false)`
+
+	var e Event
+	err := e.scanRow(db.DB.QueryRow(query, slug, activeOnly))
 	if err != nil {
 		return nil, fmt.Errorf("event not found: %v", err)
 	}
@@ -407,13 +592,46 @@ func GetEventBySlug(slug string) (*Event, error) {
 	return &e, nil
 }
 
+// ListEvents returns a page of all events, delivered ones included, newest
+// event date first, along with the total number of events
+func ListEvents(limit, offset int) ([]Event, int, error) {
+	var total int
+	err := db.DB.QueryRow(`SELECT COUNT(*) FROM events`).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error counting events: %v", err)
+	}
+
+	query := `SELECT ` + eventColumns + `
+              FROM events ORDER BY event_date DESC, id DESC LIMIT ? OFFSET ?`
+
+	rows, err := db.DB.Query(query, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying events: %v", err)
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		if err := e.scanRow(rows); err != nil {
+			return nil, 0, fmt.Errorf("error scanning row: %v", err)
+		}
+		events = append(events, e)
+	}
+
+	return events, total, rows.Err()
+}
+
 func (e *Event) Update() error {
 	eventDateUTC := e.EventDate.UTC()
 
 	updateSQL := `UPDATE events SET 
         name = ?, description = ?, event_date = ?, active = ?,
         coordinator = ?, coordinator_contact = ?,
-        recipient_name = ?, recipient_email = ?, website_link = ?
+        recipient_name = ?, recipient_email = ?, website_link = ?,
+        media_max_seconds = ?, media_max_bytes = ?, moderated = ?,
+        filter_action = ?, filter_words = ?, notify_channel = ?, notify_url = ?,
+        digest_days = ?
         WHERE id = ?`
 
 	_, err := db.DB.Exec(
@@ -421,7 +639,9 @@ func (e *Event) Update() error {
 		e.Name, e.Description, eventDateUTC, e.Active,
 		e.Coordinator, e.CoordinatorContact,
 		e.RecipientName, e.RecipientEmail, e.WebsiteLink,
-		e.ID,
+		e.MediaMaxSeconds, e.MediaMaxBytes, e.Moderated,
+		e.FilterAction, e.FilterWords, e.NotifyChannel, e.NotifyURL,
+		e.DigestDays, e.ID,
 	)
 	return err
 }
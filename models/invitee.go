@@ -0,0 +1,207 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"event-messenger.com/db"
+)
+
+// MaxInvitees is how many people one event can invite
+const MaxInvitees = 500
+
+// MaxInviteAttempts is how many times an invitation is tried before giving
+// up on the address
+const MaxInviteAttempts = 5
+
+// Invitee is someone the coordinator asked to send a message. Their token is
+// in their invite and unsubscribe links; it's kept as is, unlike the
+// coordinator's and contributors' tokens, as every reminder repeats it.
+type Invitee struct {
+	ID             int
+	EventID        int
+	Name           string
+	Email          string
+	Token          string
+	InvitedAt      sql.NullTime
+	RemindersSent  int // how far through the reminder schedule they are
+	RemindedAt     sql.NullTime
+	SubmissionID   sql.NullInt64
+	SubmittedAt    sql.NullTime
+	UnsubscribedAt sql.NullTime
+	Error          string // why the last email to them failed, if it did
+	Attempts       int    // how many times the invitation has been tried
+	CreatedAt      time.Time
+}
+
+const inviteeColumns = `id, event_id, name, email, token, invited_at, reminders_sent, reminded_at,
+        submission_id, submitted_at, unsubscribed_at, error, attempts, created_at`
+
+func (i *Invitee) scanRow(row interface{ Scan(...any) error }) error {
+	return row.Scan(&i.ID, &i.EventID, &i.Name, &i.Email, &i.Token, &i.InvitedAt, &i.RemindersSent, &i.RemindedAt,
+		&i.SubmissionID, &i.SubmittedAt, &i.UnsubscribedAt, &i.Error, &i.Attempts, &i.CreatedAt)
+}
+
+// AddInvitees adds people to the event's invitee list, each with their own
+// token. Addresses already on the list, in any case, are skipped; it returns
+// how many were added.
+func (e *Event) AddInvitees(invitees []Invitee) (int, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error adding invitees: %v", err)
+	}
+	defer tx.Rollback()
+
+	added := 0
+	for _, inv := range invitees {
+		token, err := newToken()
+		if err != nil {
+			return 0, err
+		}
+		result, err := tx.Exec(`INSERT OR IGNORE INTO event_invitees (event_id, name, email, token, created_at)
+            VALUES (?, ?, ?, ?, ?)`,
+			e.ID, inv.Name, inv.Email, token, time.Now().UTC())
+		if err != nil {
+			return 0, fmt.Errorf("error adding invitee: %v", err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			added++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error adding invitees: %v", err)
+	}
+	return added, nil
+}
+
+// RemoveInvitee takes someone off the event's invitee list
+func (e *Event) RemoveInvitee(id int) error {
+	_, err := db.DB.Exec(`DELETE FROM event_invitees WHERE id = ? AND event_id = ?`, id, e.ID)
+	if err != nil {
+		return fmt.Errorf("error removing invitee: %v", err)
+	}
+	return nil
+}
+
+// GetInvitees returns the event's invitees in the order they were added
+func GetInvitees(eventID int) ([]Invitee, error) {
+	rows, err := db.DB.Query(`SELECT `+inviteeColumns+` FROM event_invitees WHERE event_id = ? ORDER BY id`, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying invitees: %v", err)
+	}
+	defer rows.Close()
+
+	var invitees []Invitee
+	for rows.Next() {
+		var inv Invitee
+		if err := inv.scanRow(rows); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		invitees = append(invitees, inv)
+	}
+	return invitees, rows.Err()
+}
+
+// GetInviteeByToken finds the invitee an invite or unsubscribe link belongs to
+func GetInviteeByToken(token string) (*Invitee, error) {
+	if token == "" {
+		return nil, sql.ErrNoRows
+	}
+	var inv Invitee
+	err := inv.scanRow(db.DB.QueryRow(`SELECT `+inviteeColumns+` FROM event_invitees WHERE token = ?`, token))
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// ClaimInvitation marks the invitation as sent before sending it, so two
+// senders can't both send it, and counts the attempt. It reports false if it
+// was already claimed or has been tried MaxInviteAttempts times.
+func (i *Invitee) ClaimInvitation() (bool, error) {
+	now := time.Now().UTC()
+	result, err := db.DB.Exec(`UPDATE event_invitees SET invited_at = ?, attempts = attempts + 1, error = ''
+        WHERE id = ? AND invited_at IS NULL AND unsubscribed_at IS NULL AND attempts < ?`, now, i.ID, MaxInviteAttempts)
+	if err != nil {
+		return false, fmt.Errorf("error updating invitee: %v", err)
+	}
+	n, _ := result.RowsAffected()
+	if n > 0 {
+		i.InvitedAt = sql.NullTime{Time: now, Valid: true}
+		i.Attempts++
+	}
+	return n > 0, nil
+}
+
+// GaveUp reports whether the invitation failed every time it was tried
+func (i *Invitee) GaveUp() bool {
+	return !i.InvitedAt.Valid && i.Attempts >= MaxInviteAttempts
+}
+
+// ClaimReminder moves the invitee on to reminder n of the schedule, as long
+// as they haven't had it, sent a message or unsubscribed. It reports false
+// if there's nothing to send.
+func (i *Invitee) ClaimReminder(n int) (bool, error) {
+	now := time.Now().UTC()
+	result, err := db.DB.Exec(`UPDATE event_invitees SET reminders_sent = ?, reminded_at = ?, error = ''
+        WHERE id = ? AND reminders_sent < ? AND submitted_at IS NULL AND unsubscribed_at IS NULL`, n, now, i.ID, n)
+	if err != nil {
+		return false, fmt.Errorf("error updating invitee: %v", err)
+	}
+	claimed, _ := result.RowsAffected()
+	if claimed > 0 {
+		i.RemindersSent = n
+		i.RemindedAt = sql.NullTime{Time: now, Valid: true}
+	}
+	return claimed > 0, nil
+}
+
+// InvitationFailed records why the invitation couldn't be sent and puts it
+// back to be tried again, unless it's out of attempts
+func (i *Invitee) InvitationFailed(reason error) error {
+	i.InvitedAt = sql.NullTime{}
+	i.Error = reason.Error()
+	_, err := db.DB.Exec(`UPDATE event_invitees SET invited_at = NULL, error = ? WHERE id = ?`, i.Error, i.ID)
+	if err != nil {
+		return fmt.Errorf("error updating invitee: %v", err)
+	}
+	return nil
+}
+
+// ReminderFailed records why a reminder couldn't be sent; it isn't retried
+func (i *Invitee) ReminderFailed(reason error) error {
+	i.Error = reason.Error()
+	_, err := db.DB.Exec(`UPDATE event_invitees SET error = ? WHERE id = ?`, i.Error, i.ID)
+	if err != nil {
+		return fmt.Errorf("error updating invitee: %v", err)
+	}
+	return nil
+}
+
+// MarkSubmitted links the invitee to the message they sent, which stops
+// their reminders
+func (i *Invitee) MarkSubmitted(submissionID int) error {
+	now := time.Now().UTC()
+	_, err := db.DB.Exec(`UPDATE event_invitees SET submission_id = ?, submitted_at = ? WHERE id = ?`, submissionID, now, i.ID)
+	if err != nil {
+		return fmt.Errorf("error updating invitee: %v", err)
+	}
+	i.SubmissionID = sql.NullInt64{Int64: int64(submissionID), Valid: true}
+	i.SubmittedAt = sql.NullTime{Time: now, Valid: true}
+	return nil
+}
+
+// Unsubscribe stops all further email to the invitee
+func (i *Invitee) Unsubscribe() error {
+	now := time.Now().UTC()
+	_, err := db.DB.Exec(`UPDATE event_invitees SET unsubscribed_at = ? WHERE id = ? AND unsubscribed_at IS NULL`, now, i.ID)
+	if err != nil {
+		return fmt.Errorf("error updating invitee: %v", err)
+	}
+	if !i.UnsubscribedAt.Valid {
+		i.UnsubscribedAt = sql.NullTime{Time: now, Valid: true}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"event-messenger.com/db"
+)
+
+// Recipient is someone an event's messages are sent to
+type Recipient struct {
+	ID      int
+	EventID int
+	Name    string
+	Email   string
+	Role    string // RoleTo, RoleCC or RoleBCC
+	Status  string // whether the notification has reached them
+	Error   string // why the last attempt failed, if it did
+	SentAt  sql.NullTime
+}
+
+// Recipient roles, as in an email's To, Cc and Bcc headers
+const (
+	RoleTo  = "to"
+	RoleCC  = "cc"
+	RoleBCC = "bcc"
+)
+
+// RecipientRoles lists every role, the default first
+var RecipientRoles = []string{RoleTo, RoleCC, RoleBCC}
+
+// ValidRecipientRole reports whether role is one of RecipientRoles
+func ValidRecipientRole(role string) bool {
+	for _, r := range RecipientRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Recipient delivery states
+const (
+	RecipientPending = "pending"
+	RecipientSent    = "sent"
+	RecipientFailed  = "failed"
+)
+
+// GetRecipients returns the event's recipients in the order they were given
+func GetRecipients(eventID int) ([]Recipient, error) {
+	query := `SELECT id, event_id, name, email, role, status, error, sent_at
+              FROM event_recipients WHERE event_id = ? ORDER BY id`
+
+	rows, err := db.DB.Query(query, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying recipients: %v", err)
+	}
+	defer rows.Close()
+
+	var recipients []Recipient
+	for rows.Next() {
+		var r Recipient
+		err := rows.Scan(&r.ID, &r.EventID, &r.Name, &r.Email, &r.Role, &r.Status, &r.Error, &r.SentAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, rows.Err()
+}
+
+// SaveRecipients replaces the event's recipients. Everyone starts out
+// pending, so it's only meant for events that haven't been delivered.
+func (e *Event) SaveRecipients(recipients []Recipient) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error saving recipients: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM event_recipients WHERE event_id = ?`, e.ID); err != nil {
+		return fmt.Errorf("error replacing recipients: %v", err)
+	}
+
+	now := time.Now().UTC()
+	for i := range recipients {
+		r := &recipients[i]
+		r.EventID = e.ID
+		r.Status = RecipientPending
+		r.Error = ""
+		r.SentAt = sql.NullTime{}
+
+		result, err := tx.Exec(`INSERT INTO event_recipients (event_id, name, email, role, status, created_at)
+            VALUES (?, ?, ?, ?, ?, ?)`, r.EventID, r.Name, r.Email, r.Role, r.Status, now)
+		if err != nil {
+			return fmt.Errorf("error saving recipient: %v", err)
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		r.ID = int(id)
+	}
+
+	return tx.Commit()
+}
+
+// MarkSent records that the notification reached the recipient
+func (r *Recipient) MarkSent() error {
+	r.Status = RecipientSent
+	r.Error = ""
+	r.SentAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	return r.saveStatus()
+}
+
+// MarkFailed records why the notification couldn't be sent to the recipient
+func (r *Recipient) MarkFailed(reason error) error {
+	r.Status = RecipientFailed
+	r.Error = reason.Error()
+	return r.saveStatus()
+}
+
+func (r *Recipient) saveStatus() error {
+	_, err := db.DB.Exec(`UPDATE event_recipients SET status = ?, error = ?, sent_at = ? WHERE id = ?`,
+		r.Status, r.Error, r.SentAt, r.ID)
+	if err != nil {
+		return fmt.Errorf("error updating recipient: %v", err)
+	}
+	return nil
+}
+
+// RecipientNames joins the names of the To recipients for display, e.g.
+// "Ann and Ben" or "Ann, Ben and Cy"
+func RecipientNames(recipients []Recipient) string {
+	var names []string
+	for _, r := range recipients {
+		if r.Role == RoleTo && r.Name != "" {
+			names = append(names, r.Name)
+		}
+	}
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
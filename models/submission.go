@@ -2,6 +2,7 @@ package models
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -9,19 +10,95 @@ import (
 	"event-messenger.com/db"
 )
 
+// Submission image processing states
+const (
+	SubmissionProcessing = "processing" // original stored, waiting for the image workers
+	SubmissionReady      = "ready"      // renditions written to the uploads directory
+	SubmissionFailed     = "failed"     // image could not be processed
+)
+
+// Submission moderation states; only approved submissions are delivered
+const (
+	ModerationPending  = "pending"  // waiting for the coordinator, in moderated events
+	ModerationApproved = "approved" // included in the keepsake page and email
+	ModerationRejected = "rejected" // hidden by the coordinator
+)
+
 type Submission struct {
-	ID        int
-	EventID   int
-	Name      string
-	Message   string
-	Filename  string
-	CreatedAt time.Time
+	ID               int
+	EventID          int
+	Name             string
+	Message          string
+	Filename         string
+	Status           string
+	OriginalFilename string // upload waiting to be processed, if Status is processing
+	ProcessingError  string // reason shown to the contributor, if Status is failed
+	MediaFilename    string // optional audio/video greeting
+	MediaType        string
+	MediaDuration    time.Duration
+	Moderation       string
+	ContributorEmail string // optional, for sending the edit link
+	EditToken        string // set by Save for the contributor's edit link; only its hash is stored
+	CreatedAt        time.Time
+}
+
+// HasVideo reports whether the attached recording is a video
+func (s *Submission) HasVideo() bool {
+	return strings.HasPrefix(s.MediaType, "video/")
+}
+
+// HasAudio reports whether the attached recording is audio only
+func (s *Submission) HasAudio() bool {
+	return strings.HasPrefix(s.MediaType, "audio/")
+}
+
+const submissionColumns = `s.id, s.event_id, s.name, s.message, COALESCE(s.filename, ''),
+              s.status, COALESCE(s.original_filename, ''), COALESCE(s.processing_error, ''),
+              COALESCE(s.media_filename, ''), COALESCE(s.media_type, ''), COALESCE(s.media_duration_ms, 0),
+              s.moderation, COALESCE(s.contributor_email, ''), s.created_at`
+
+// scanRow scans a row selected with submissionColumns
+func (s *Submission) scanRow(row interface{ Scan(...any) error }) error {
+	var mediaDurationMs int64
+	err := row.Scan(
+		&s.ID, &s.EventID, &s.Name, &s.Message, &s.Filename,
+		&s.Status, &s.OriginalFilename, &s.ProcessingError,
+		&s.MediaFilename, &s.MediaType, &mediaDurationMs,
+		&s.Moderation, &s.ContributorEmail, &s.CreatedAt,
+	)
+	s.MediaDuration = time.Duration(mediaDurationMs) * time.Millisecond
+	return err
 }
 
 func (s *Submission) Save() error {
-	insertSQL := `INSERT INTO submissions (event_id, name, message, filename, created_at) VALUES (?, ?, ?, ?, ?)`
+	if s.Status == "" {
+		s.Status = SubmissionReady
+	}
 
-	result, err := db.DB.Exec(insertSQL, s.EventID, s.Name, s.Message, s.Filename, time.Now().UTC())
+	if s.Moderation == "" {
+		s.Moderation = ModerationApproved
+	}
+
+	// The contributor's private link for revising or withdrawing this submission
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	s.EditToken = token
+	s.CreatedAt = time.Now().UTC()
+
+	insertSQL := `INSERT INTO submissions (
+        event_id, name, message, filename, status, original_filename,
+        media_filename, media_type, media_duration_ms, contributor_email,
+        edit_token_hash, moderation, created_at
+    ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := db.DB.Exec(
+		insertSQL,
+		s.EventID, s.Name, s.Message, s.Filename, s.Status, s.OriginalFilename,
+		s.MediaFilename, s.MediaType, s.MediaDuration.Milliseconds(), s.ContributorEmail,
+		hashToken(s.EditToken), s.Moderation, s.CreatedAt,
+	)
 	if err != nil {
 		return err
 	}
@@ -33,37 +110,202 @@ func (s *Submission) Save() error {
 	return err
 }
 
-func GetAllSubmissions() ([]Submission, error) {
-	query := `SELECT id, event_id, name, message, filename, created_at FROM submissions ORDER BY created_at DESC`
+// Delete removes the submission row; stored files are left to the caller
+func (s *Submission) Delete() error {
+	_, err := db.DB.Exec(`DELETE FROM submissions WHERE id = ?`, s.ID)
+	if err != nil {
+		return fmt.Errorf("error deleting submission: %v", err)
+	}
 
-	rows, err := db.DB.Query(query)
+	// An invitee whose message is withdrawn hasn't sent one any more
+	_, err = db.DB.Exec(`UPDATE event_invitees SET submission_id = NULL, submitted_at = NULL WHERE submission_id = ?`, s.ID)
 	if err != nil {
-		return nil, fmt.Errorf("error querying submissions: %v", err)
+		return fmt.Errorf("error updating invitee: %v", err)
 	}
+	return nil
+}
 
-	defer rows.Close()
+// UpdateText changes the contributor's name and message
+func (s *Submission) UpdateText(name, message string) error {
+	_, err := db.DB.Exec(`UPDATE submissions SET name = ?, message = ? WHERE id = ?`, name, message, s.ID)
+	if err != nil {
+		return fmt.Errorf("error updating submission: %v", err)
+	}
 
-	var submissions []Submission
-	for rows.Next() {
-		var s Submission
-		err := rows.Scan(&s.ID, &s.EventID, &s.Name, &s.Message, &s.Filename, &s.CreatedAt)
-		if err != nil {
-			return nil, fmt.Errorf("error scanning row: %v", err)
-		}
-		submissions = append(submissions, s)
+	s.Name = name
+	s.Message = message
+	return nil
+}
+
+// ReplaceImage swaps in a new original for the image workers; the previous
+// image's files are left to the caller
+func (s *Submission) ReplaceImage(originalFilename string) error {
+	query := `UPDATE submissions
+	SET filename = NULL, status = ?, original_filename = ?, processing_error = NULL
+	WHERE id = ?`
+
+	_, err := db.DB.Exec(query, SubmissionProcessing, originalFilename, s.ID)
+	if err != nil {
+		return fmt.Errorf("error updating submission: %v", err)
 	}
 
-	return submissions, nil
+	s.Filename = ""
+	s.Status = SubmissionProcessing
+	s.OriginalFilename = originalFilename
+	s.ProcessingError = ""
+	return nil
+}
+
+// SetMedia records the attached recording, or clears it if filename is empty
+func (s *Submission) SetMedia(filename, contentType string, duration time.Duration) error {
+	query := `UPDATE submissions
+	SET media_filename = NULLIF(?, ''), media_type = NULLIF(?, ''), media_duration_ms = ?
+	WHERE id = ?`
+
+	_, err := db.DB.Exec(query, filename, contentType, duration.Milliseconds(), s.ID)
+	if err != nil {
+		return fmt.Errorf("error updating submission: %v", err)
+	}
+
+	s.MediaFilename = filename
+	s.MediaType = contentType
+	s.MediaDuration = duration
+	return nil
 }
 
+// SetModeration records the coordinator's decision, or returns the submission
+// to the queue when set to ModerationPending
+func (s *Submission) SetModeration(state string) error {
+	_, err := db.DB.Exec(`UPDATE submissions SET moderation = ? WHERE id = ?`, state, s.ID)
+	if err != nil {
+		return fmt.Errorf("error updating submission: %v", err)
+	}
+
+	s.Moderation = state
+	return nil
+}
+
+// MarkProcessed records the processed image and clears the pending original
+func (s *Submission) MarkProcessed(filename string) error {
+	query := `UPDATE submissions
+	SET filename = ?, status = ?, original_filename = NULL, processing_error = NULL
+	WHERE id = ?`
+
+	_, err := db.DB.Exec(query, filename, SubmissionReady, s.ID)
+	if err != nil {
+		return fmt.Errorf("error updating submission: %v", err)
+	}
+
+	s.Filename = filename
+	s.Status = SubmissionReady
+	s.OriginalFilename = ""
+	return nil
+}
+
+// MarkFailed records that the image could not be processed
+func (s *Submission) MarkFailed(reason string) error {
+	query := `UPDATE submissions
+	SET status = ?, original_filename = NULL, processing_error = ?
+	WHERE id = ?`
+
+	_, err := db.DB.Exec(query, SubmissionFailed, reason, s.ID)
+	if err != nil {
+		return fmt.Errorf("error updating submission: %v", err)
+	}
+
+	s.Status = SubmissionFailed
+	s.OriginalFilename = ""
+	s.ProcessingError = reason
+	return nil
+}
+
+func GetSubmissionByID(id int) (*Submission, error) {
+	query := `SELECT ` + submissionColumns + ` FROM submissions s WHERE s.id = ?`
+
+	var s Submission
+	err := s.scanRow(db.DB.QueryRow(query, id))
+	if err != nil {
+		return nil, fmt.Errorf("submission not found: %v", err)
+	}
+
+	return &s, nil
+}
+
+// GetSubmissionByEditToken finds the submission a contributor's edit link belongs to
+func GetSubmissionByEditToken(token string) (*Submission, error) {
+	if token == "" {
+		return nil, fmt.Errorf("submission not found: empty token")
+	}
+
+	query := `SELECT ` + submissionColumns + ` FROM submissions s WHERE s.edit_token_hash = ?`
+
+	var s Submission
+	err := s.scanRow(db.DB.QueryRow(query, hashToken(token)))
+	if err != nil {
+		return nil, fmt.Errorf("submission not found: %v", err)
+	}
+
+	return &s, nil
+}
+
+func GetAllSubmissions() ([]Submission, error) {
+	query := `SELECT ` + submissionColumns + ` FROM submissions s ORDER BY s.created_at DESC`
+
+	return querySubmissions(query)
+}
+
+// GetSubmissionsByStatus returns submissions in the given processing state, oldest first
+func GetSubmissionsByStatus(status string) ([]Submission, error) {
+	query := `SELECT ` + submissionColumns + ` FROM submissions s WHERE s.status = ? ORDER BY s.created_at ASC`
+
+	return querySubmissions(query, status)
+}
+
+// GetSubmissionsByEventSlug returns the approved submissions, for delivery and
+// the keepsake page
 func GetSubmissionsByEventSlug(slug string) ([]Submission, error) {
-	query := `SELECT s.id, s.event_id, s.name, s.message, s.filename, s.created_at 
+	query := `SELECT ` + submissionColumns + `
               FROM submissions s
               JOIN events e ON s.event_id = e.id
-              WHERE e.slug = ?
+              WHERE e.slug = ? AND s.moderation = ?
               ORDER BY s.created_at DESC`
 
-	rows, err := db.DB.Query(query, slug)
+	return querySubmissions(query, slug, ModerationApproved)
+}
+
+// GetSubmissionsForReview returns every submission for an event whatever its
+// moderation state, pending first
+func GetSubmissionsForReview(eventID int) ([]Submission, error) {
+	query := `SELECT ` + submissionColumns + `
+              FROM submissions s
+              WHERE s.event_id = ?
+              ORDER BY s.moderation = ? DESC, s.created_at DESC`
+
+	return querySubmissions(query, eventID, ModerationPending)
+}
+
+// ListSubmissions returns a page of an event's submissions, oldest first,
+// along with the total. An empty moderation state lists every submission.
+func ListSubmissions(eventID int, moderation string, limit, offset int) ([]Submission, int, error) {
+	var total int
+	err := db.DB.QueryRow(`SELECT COUNT(*) FROM submissions s WHERE s.event_id = ? AND (? = '' OR s.moderation = ?)`,
+		eventID, moderation, moderation).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error counting submissions: %v", err)
+	}
+
+	query := `SELECT ` + submissionColumns + `
+              FROM submissions s
+              WHERE s.event_id = ? AND (? = '' OR s.moderation = ?)
+              ORDER BY s.created_at, s.id
+              LIMIT ? OFFSET ?`
+
+	submissions, err := querySubmissions(query, eventID, moderation, moderation, limit, offset)
+	return submissions, total, err
+}
+
+func querySubmissions(query string, args ...any) ([]Submission, error) {
+	rows, err := db.DB.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error querying submissions: %v", err)
 	}
@@ -72,7 +314,7 @@ func GetSubmissionsByEventSlug(slug string) ([]Submission, error) {
 	var submissions []Submission
 	for rows.Next() {
 		var s Submission
-		err := rows.Scan(&s.ID, &s.EventID, &s.Name, &s.Message, &s.Filename, &s.CreatedAt)
+		err := s.scanRow(rows)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning row: %v", err)
 		}
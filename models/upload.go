@@ -0,0 +1,163 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"event-messenger.com/db"
+)
+
+// Upload is a resumable (tus) upload. The bytes live on disk; this tracks how
+// much of the file we expect and how long it's kept.
+type Upload struct {
+	ID          string
+	Length      int64
+	Offset      int64
+	Metadata    string // raw Upload-Metadata header
+	ExpiresAt   time.Time
+	CompletedAt sql.NullTime
+	Client      string // who started it, for the per-client quota
+	CreatedAt   time.Time
+}
+
+// NewUpload creates and saves an upload of length bytes for client that
+// expires after ttl
+func NewUpload(length int64, metadata, client string, ttl time.Duration) (*Upload, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, fmt.Errorf("error generating upload id: %v", err)
+	}
+
+	now := time.Now().UTC()
+	u := &Upload{
+		ID:        hex.EncodeToString(idBytes),
+		Length:    length,
+		Metadata:  metadata,
+		ExpiresAt: now.Add(ttl),
+		Client:    client,
+		CreatedAt: now,
+	}
+
+	insertSQL := `INSERT INTO uploads (id, length, upload_offset, metadata, expires_at, client, created_at) VALUES (?, ?, 0, ?, ?, ?, ?)`
+	_, err := db.DB.Exec(insertSQL, u.ID, u.Length, u.Metadata, u.ExpiresAt, u.Client, u.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error saving upload: %v", err)
+	}
+
+	return u, nil
+}
+
+func GetUpload(id string) (*Upload, error) {
+	query := `SELECT id, length, upload_offset, metadata, expires_at, completed_at, created_at
+              FROM uploads WHERE id = ?`
+
+	var u Upload
+	err := db.DB.QueryRow(query, id).Scan(
+		&u.ID, &u.Length, &u.Offset, &u.Metadata,
+		&u.ExpiresAt, &u.CompletedAt, &u.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("upload not found: %v", err)
+	}
+
+	return &u, nil
+}
+
+// CountLiveUploads returns how many unexpired uploads client has, and how
+// many bytes all unexpired uploads together may take up
+func CountLiveUploads(client string) (int, int64, error) {
+	query := `SELECT COUNT(CASE WHEN client = ? THEN 1 END), COALESCE(SUM(length), 0)
+              FROM uploads WHERE expires_at > ?`
+
+	var count int
+	var total int64
+	err := db.DB.QueryRow(query, client, time.Now().UTC()).Scan(&count, &total)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error counting uploads: %v", err)
+	}
+	return count, total, nil
+}
+
+// Expired reports whether the upload is past its expiry time
+func (u *Upload) Expired() bool {
+	return time.Now().After(u.ExpiresAt)
+}
+
+// Complete reports whether every byte of the upload has been received
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// SetOffset records how many bytes have been received, marking the upload
+// complete once it reaches its length
+func (u *Upload) SetOffset(offset int64) error {
+	u.Offset = offset
+	if u.Complete() && !u.CompletedAt.Valid {
+		u.CompletedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	}
+
+	query := `UPDATE uploads SET upload_offset = ?, completed_at = ? WHERE id = ?`
+	_, err := db.DB.Exec(query, u.Offset, u.CompletedAt, u.ID)
+	if err != nil {
+		return fmt.Errorf("error updating upload: %v", err)
+	}
+
+	return nil
+}
+
+func (u *Upload) Delete() error {
+	_, err := db.DB.Exec(`DELETE FROM uploads WHERE id = ?`, u.ID)
+	if err != nil {
+		return fmt.Errorf("error deleting upload: %v", err)
+	}
+	return nil
+}
+
+// MetadataValue returns a decoded value from the Upload-Metadata header,
+// which is a comma separated list of "key base64(value)" pairs
+func (u *Upload) MetadataValue(key string) string {
+	for _, pair := range strings.Split(u.Metadata, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if k != key {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return ""
+		}
+		return string(decoded)
+	}
+	return ""
+}
+
+// GetExpiredUploads returns uploads past their expiry time
+func GetExpiredUploads() ([]Upload, error) {
+	query := `SELECT id, length, upload_offset, metadata, expires_at, completed_at, created_at
+              FROM uploads WHERE expires_at <= ?`
+
+	rows, err := db.DB.Query(query, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error querying expired uploads: %v", err)
+	}
+	defer rows.Close()
+
+	var uploads []Upload
+	for rows.Next() {
+		var u Upload
+		err := rows.Scan(
+			&u.ID, &u.Length, &u.Offset, &u.Metadata,
+			&u.ExpiresAt, &u.CompletedAt, &u.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		uploads = append(uploads, u)
+	}
+
+	return uploads, nil
+}
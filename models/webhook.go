@@ -0,0 +1,271 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"event-messenger.com/db"
+)
+
+// Webhook is a URL that's sent a signed JSON payload whenever one of its
+// event types happens
+type Webhook struct {
+	ID        int
+	URL       string
+	Secret    string   // key the payloads are signed with
+	Events    []string // event types it's sent; empty means all of them
+	Active    bool
+	CreatedAt time.Time
+}
+
+// Webhook delivery states
+const (
+	WebhookPending   = "pending"   // waiting for its first or next attempt
+	WebhookSucceeded = "succeeded" // the endpoint answered with a 2xx
+	WebhookFailed    = "failed"    // out of attempts, or the webhook was removed
+)
+
+// WebhookDelivery is one payload for one webhook, along with how sending it
+// has gone so far
+type WebhookDelivery struct {
+	ID             int
+	WebhookID      int
+	WebhookURL     string // filled in by the listing queries
+	EventType      string
+	Payload        string
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time
+	ResponseStatus int    // HTTP status of the last attempt, 0 if there was no response
+	Error          string // what went wrong with the last attempt
+	ReplayOf       int    // the delivery this one repeats, if any
+	CreatedAt      time.Time
+	CompletedAt    sql.NullTime
+}
+
+// NewWebhook creates and saves an active webhook with a fresh signing secret
+func NewWebhook(url string, events []string) (*Webhook, error) {
+	secret, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Webhook{
+		URL:       url,
+		Secret:    "whsec_" + secret,
+		Events:    events,
+		Active:    true,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	insertSQL := `INSERT INTO webhooks (url, secret, events, active, created_at) VALUES (?, ?, ?, ?, ?)`
+	result, err := db.DB.Exec(insertSQL, w.URL, w.Secret, strings.Join(w.Events, ","), w.Active, w.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error saving webhook: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	w.ID = int(id)
+	return w, err
+}
+
+// Subscribes reports whether the webhook is sent eventType
+func (w *Webhook) Subscribes(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+const webhookColumns = `id, url, secret, events, active, created_at`
+
+func (w *Webhook) scanRow(row interface{ Scan(...any) error }) error {
+	var events string
+	err := row.Scan(&w.ID, &w.URL, &w.Secret, &events, &w.Active, &w.CreatedAt)
+	w.Events = nil
+	if events != "" {
+		w.Events = strings.Split(events, ",")
+	}
+	return err
+}
+
+func GetWebhook(id int) (*Webhook, error) {
+	var w Webhook
+	err := w.scanRow(db.DB.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, id))
+	if err != nil {
+		return nil, fmt.Errorf("webhook not found: %v", err)
+	}
+	return &w, nil
+}
+
+// GetWebhooks returns every webhook, oldest first
+func GetWebhooks() ([]Webhook, error) {
+	rows, err := db.DB.Query(`SELECT ` + webhookColumns + ` FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying webhooks: %v", err)
+	}
+	defer rows.Close()
+
+	var webhooks []Webhook
+	for rows.Next() {
+		var w Webhook
+		if err := w.scanRow(rows); err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+// SetActive turns the webhook on or off; payloads aren't queued for it while
+// it's off
+func (w *Webhook) SetActive(active bool) error {
+	_, err := db.DB.Exec(`UPDATE webhooks SET active = ? WHERE id = ?`, active, w.ID)
+	if err != nil {
+		return fmt.Errorf("error updating webhook: %v", err)
+	}
+	w.Active = active
+	return nil
+}
+
+// Delete removes the webhook along with its delivery log
+func (w *Webhook) Delete() error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("error deleting webhook: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, w.ID); err != nil {
+		return fmt.Errorf("error deleting webhook deliveries: %v", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM webhooks WHERE id = ?`, w.ID); err != nil {
+		return fmt.Errorf("error deleting webhook: %v", err)
+	}
+	return tx.Commit()
+}
+
+// Save queues the delivery for its first attempt
+func (d *WebhookDelivery) Save() error {
+	now := time.Now().UTC()
+	d.Status = WebhookPending
+	d.CreatedAt = now
+	if d.NextAttemptAt.IsZero() {
+		d.NextAttemptAt = now
+	}
+
+	insertSQL := `INSERT INTO webhook_deliveries (
+        webhook_id, event_type, payload, status, attempts, next_attempt_at, replay_of, created_at
+    ) VALUES (?, ?, ?, ?, 0, ?, ?, ?)`
+
+	result, err := db.DB.Exec(insertSQL,
+		d.WebhookID, d.EventType, d.Payload, d.Status, d.NextAttemptAt.UTC(), d.ReplayOf, d.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving webhook delivery: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	d.ID = int(id)
+	return err
+}
+
+// RecordAttempt stores the outcome of an attempt. With status still pending
+// the delivery is tried again at next.
+func (d *WebhookDelivery) RecordAttempt(status string, responseStatus int, errMsg string, next time.Time) error {
+	d.Status = status
+	d.Attempts++
+	d.ResponseStatus = responseStatus
+	d.Error = errMsg
+	d.NextAttemptAt = next.UTC()
+	d.CompletedAt = sql.NullTime{}
+	if status != WebhookPending {
+		d.CompletedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	}
+
+	updateSQL := `UPDATE webhook_deliveries
+        SET status = ?, attempts = ?, response_status = ?, error = ?, next_attempt_at = ?, completed_at = ?
+        WHERE id = ?`
+
+	_, err := db.DB.Exec(updateSQL, d.Status, d.Attempts, d.ResponseStatus, d.Error, d.NextAttemptAt, d.CompletedAt, d.ID)
+	if err != nil {
+		return fmt.Errorf("error updating webhook delivery: %v", err)
+	}
+	return nil
+}
+
+const webhookDeliveryColumns = `d.id, d.webhook_id, COALESCE(w.url, ''), d.event_type, d.payload, d.status,
+              d.attempts, d.next_attempt_at, COALESCE(d.response_status, 0), COALESCE(d.error, ''),
+              COALESCE(d.replay_of, 0), d.created_at, d.completed_at`
+
+func queryWebhookDeliveries(query string, args ...any) ([]WebhookDelivery, error) {
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying webhook deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		err := rows.Scan(
+			&d.ID, &d.WebhookID, &d.WebhookURL, &d.EventType, &d.Payload, &d.Status,
+			&d.Attempts, &d.NextAttemptAt, &d.ResponseStatus, &d.Error,
+			&d.ReplayOf, &d.CreatedAt, &d.CompletedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %v", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func GetWebhookDelivery(id int) (*WebhookDelivery, error) {
+	deliveries, err := queryWebhookDeliveries(`SELECT `+webhookDeliveryColumns+`
+              FROM webhook_deliveries d LEFT JOIN webhooks w ON w.id = d.webhook_id
+              WHERE d.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return nil, fmt.Errorf("webhook delivery not found: %d", id)
+	}
+	return &deliveries[0], nil
+}
+
+// GetDueWebhookDeliveries returns up to limit pending deliveries whose next
+// attempt is due, oldest first
+func GetDueWebhookDeliveries(limit int) ([]WebhookDelivery, error) {
+	return queryWebhookDeliveries(`SELECT `+webhookDeliveryColumns+`
+              FROM webhook_deliveries d LEFT JOIN webhooks w ON w.id = d.webhook_id
+              WHERE d.status = ? AND d.next_attempt_at <= ?
+              ORDER BY d.next_attempt_at, d.id
+              LIMIT ?`, WebhookPending, time.Now().UTC(), limit)
+}
+
+// GetRecentWebhookDeliveries returns the latest deliveries, newest first
+func GetRecentWebhookDeliveries(limit int) ([]WebhookDelivery, error) {
+	return queryWebhookDeliveries(`SELECT `+webhookDeliveryColumns+`
+              FROM webhook_deliveries d LEFT JOIN webhooks w ON w.id = d.webhook_id
+              ORDER BY d.id DESC
+              LIMIT ?`, limit)
+}
+
+// DeleteWebhookDeliveriesBefore prunes finished deliveries created before
+// cutoff, returning how many were removed
+func DeleteWebhookDeliveriesBefore(cutoff time.Time) (int64, error) {
+	result, err := db.DB.Exec(`DELETE FROM webhook_deliveries WHERE status != ? AND created_at < ?`,
+		WebhookPending, cutoff.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("error pruning webhook deliveries: %v", err)
+	}
+	return result.RowsAffected()
+}
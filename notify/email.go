@@ -0,0 +1,267 @@
+package notify
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"log"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"event-messenger.com/handlers"
+	"event-messenger.com/mailer"
+	"event-messenger.com/media"
+	"event-messenger.com/models"
+	"event-messenger.com/utils"
+)
+
+// Set a cap so that email doesn't reach SMTP limit (25MB limit)
+const maxSubmissionsPerEmail = 100
+
+// Animated GIFs larger than this are swapped for their static poster frame in
+// emails; base64 inflates them further and many clients only show frame one
+const maxInlineGIFBytes = 1 << 20 // 1 MB
+
+type SubmissionEmailData struct {
+	MessageText  string
+	From         string
+	ImageDataURI template.URL // built by encodeImageAsDataURI, so safe to use as a src
+	MediaAction  string       // "Listen to" or "Watch", if a recording is attached
+	MediaLink    string       // the recording on the keepsake page
+}
+
+// Email sends each of the event's recipients their own copy of an HTML email
+// with every message and photo inline
+type Email struct{}
+
+func (Email) Notify(event *models.Event, submissions []models.Submission) error {
+	recipients, err := models.GetRecipients(event.ID)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("event %s has no recipients", event.Slug)
+	}
+
+	msg, err := composeEmail(event, submissions, recipients)
+	if err != nil {
+		return err
+	}
+
+	// Send everyone their own copy. Those who already got one on an earlier
+	// try are skipped, and only missing a To recipient counts as failing.
+	// One failure doesn't stop the rest; they're logged together at the end.
+	var failures, toFailures []string
+	for i := range recipients {
+		r := &recipients[i]
+		if r.Status == models.RecipientSent {
+			continue
+		}
+
+		err = utils.SendEmail(r.Email, msg)
+		if err != nil {
+			failure := fmt.Sprintf("%s: %v", r.Email, err)
+			failures = append(failures, failure)
+			if r.Role == models.RoleTo {
+				toFailures = append(toFailures, failure)
+			}
+			if err := r.MarkFailed(err); err != nil {
+				log.Printf("%v", err)
+			}
+			continue
+		}
+
+		if err := r.MarkSent(); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+
+	if len(failures) > 0 {
+		log.Printf("Failed to send email for event %s to %d of %d recipients: %s",
+			event.Name, len(failures), len(recipients), strings.Join(failures, "; "))
+	}
+	if len(toFailures) > 0 {
+		return fmt.Errorf("failed to send email to %d of %d recipients: %s", len(failures), len(recipients), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// Preview composes the email the scheduler would send for the event right
+// now, from the same submissions and recipients, without sending it
+func Preview(event *models.Event) (*mailer.Message, error) {
+	submissions, err := models.GetSubmissionsByEventSlug(event.Slug)
+	if err != nil {
+		return nil, err
+	}
+	recipients, err := models.GetRecipients(event.ID)
+	if err != nil {
+		return nil, err
+	}
+	return composeEmail(event, submissions, recipients)
+}
+
+// SendCopy emails the notification to one address on its own, leaving the
+// recipients' delivery status as it was
+func SendCopy(event *models.Event, submissions []models.Submission, to mail.Address) error {
+	recipients, err := models.GetRecipients(event.ID)
+	if err != nil {
+		return err
+	}
+	msg, err := composeEmail(event, submissions, recipients)
+	if err != nil {
+		return err
+	}
+	msg.To = []mail.Address{to}
+	msg.Cc = nil
+	return utils.SendEmail(to.Address, msg)
+}
+
+// composeEmail renders the notification email, addressed to the event's
+// recipients
+func composeEmail(event *models.Event, submissions []models.Submission, recipients []models.Recipient) (*mailer.Message, error) {
+	// If more submissions than email limit, cap emails in message at that limit
+	if len(submissions) > maxSubmissionsPerEmail {
+		log.Printf("Event %s has %d submissions, capping at %d for email size", event.Name, len(submissions), maxSubmissionsPerEmail)
+		submissions = submissions[:maxSubmissionsPerEmail]
+	}
+
+	// Build submission data with base64-encoded images
+	submissionData := make([]SubmissionEmailData, 0, len(submissions))
+	for _, sub := range submissions {
+
+		// Images still in the worker queue (or that failed) are left out
+		imgUri := ""
+		if sub.Status == models.SubmissionReady {
+			var err error
+			imgUri, err = encodeImageAsDataURI(sub.Filename)
+			// If error encoding image, input blank string for image URI
+			if err != nil {
+				log.Printf("could not encode image for submission from %v", sub.Name)
+				imgUri = ""
+			}
+		}
+		data := SubmissionEmailData{
+			MessageText:  sub.Message,
+			From:         sub.Name,
+			ImageDataURI: template.URL(imgUri),
+		}
+
+		// Recordings are too large to attach; link to them on the keepsake page
+		if sub.MediaFilename != "" {
+			data.MediaAction = "Listen to"
+			if sub.HasVideo() {
+				data.MediaAction = "Watch"
+			}
+			data.MediaLink = fmt.Sprintf("%s#submission-%d", utils.GetKeepsakeURL(event.Slug), sub.ID)
+		}
+
+		submissionData = append(submissionData, data)
+	}
+
+	// Prepare template data
+	templateData := struct {
+		EventName       string
+		RecipientName   string
+		EventDate       time.Time
+		Submissions     []SubmissionEmailData
+		TotalCount      int
+		CoordinatorName string
+	}{
+		EventName:       event.Name,
+		RecipientName:   event.RecipientName,
+		EventDate:       event.EventDate,
+		Submissions:     submissionData,
+		TotalCount:      len(submissions),
+		CoordinatorName: event.Coordinator,
+	}
+
+	// Render email HTML
+	htmlContent, err := handlers.RenderEmailTemplate(templateData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render email template: %w", err)
+	}
+
+	// Log email size for debugging
+	emailSizeKB := len(htmlContent) / 1024
+	log.Printf("Email size for event %s: %d KB (%d submissions)", event.Name, emailSizeKB, len(submissions))
+
+	if emailSizeKB > 15000 { // Warn if over 15MB
+		log.Printf("WARNING: Email size may be too large for SMTP")
+	}
+
+	msg := mailer.NewMessage(fmt.Sprintf("Your %s Messages", event.Name))
+	msg.HTML = htmlContent
+	msg.Text = notificationText(event, submissions)
+	for _, r := range recipients {
+		switch r.Role {
+		case models.RoleTo:
+			msg.To = append(msg.To, mail.Address{Name: r.Name, Address: r.Email})
+		case models.RoleCC:
+			msg.Cc = append(msg.Cc, mail.Address{Name: r.Name, Address: r.Email})
+		}
+	}
+	return msg, nil
+}
+
+// notificationText is the plain-text alternative to the HTML email, for
+// clients that don't show HTML. Photos are only on the keepsake page.
+func notificationText(event *models.Event, submissions []models.Submission) string {
+	var b strings.Builder
+
+	count := "1 special message"
+	if len(submissions) != 1 {
+		count = fmt.Sprintf("%d special messages", len(submissions))
+	}
+	fmt.Fprintf(&b, "Dear %s,\n\nYou have %s from %s.\n", event.RecipientName, count, event.Name)
+
+	for _, sub := range submissions {
+		b.WriteString("\n----\n\n")
+		if sub.Message != "" {
+			b.WriteString(strings.TrimSpace(sub.Message) + "\n\n")
+		}
+		fmt.Fprintf(&b, "From: %s\n", sub.Name)
+	}
+
+	fmt.Fprintf(&b, "\n----\n\nSee every message, photo and recording at %s\n", utils.GetKeepsakeURL(event.Slug))
+	if event.Coordinator != "" {
+		fmt.Fprintf(&b, "\nEvent coordinated by: %s\n", event.Coordinator)
+	}
+	return b.String()
+}
+
+func encodeImageAsDataURI(filename string) (string, error) {
+	imagePath := filepath.Join("./data/uploads", filename)
+
+	if poster := media.PosterFilename(filename); poster != "" {
+		info, err := os.Stat(imagePath)
+		if err == nil && info.Size() > maxInlineGIFBytes {
+			log.Printf("Using poster frame for large animated GIF %s (%d KB)", filename, info.Size()/1024)
+			filename = poster
+			imagePath = filepath.Join("./data/uploads", poster)
+		}
+	}
+
+	imageData, err := os.ReadFile(imagePath)
+	if err != nil {
+		return "", fmt.Errorf("could not read image file: %w", err)
+	}
+
+	// Detect MIME type from file extension
+	ext := strings.ToLower(filepath.Ext(filename))
+	mimeType := "image/jpeg" // Default
+	switch ext {
+	case ".png":
+		mimeType = "image/png"
+	case ".gif":
+		mimeType = "image/gif"
+	case ".webp":
+		mimeType = "image/webp"
+	}
+
+	// Encode to base64
+	base64Image := base64.StdEncoding.EncodeToString(imageData)
+	return fmt.Sprintf("data:%s;base64,%s", mimeType, base64Image), nil
+}
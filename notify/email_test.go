@@ -0,0 +1,191 @@
+package notify
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"event-messenger.com/config"
+	"event-messenger.com/internal/testutil"
+	"event-messenger.com/models"
+)
+
+// bouncingServer is an SMTP server that refuses every recipient with
+// "bounce" in their address and accepts the rest
+type bouncingServer struct {
+	mu        sync.Mutex
+	delivered []string
+}
+
+func (s *bouncingServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	var rcpts []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			rcpts = nil
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			if strings.Contains(cmd, "BOUNCE") {
+				reply("550 No such user")
+				continue
+			}
+			rcpts = append(rcpts, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 Go ahead")
+			for {
+				data, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if data == ".\r\n" {
+					break
+				}
+			}
+			s.mu.Lock()
+			s.delivered = append(s.delivered, rcpts...)
+			s.mu.Unlock()
+			reply("250 Queued")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+// startBouncingServer listens on a free port and points the mailer at it
+func startBouncingServer(t *testing.T) *bouncingServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &bouncingServer{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	config.App.SMTPServer = "127.0.0.1"
+	config.App.SMTPPort = ln.Addr().(*net.TCPAddr).Port
+	config.App.SMTPTLS = "none"
+	config.App.SMTPAuth = "none"
+	config.App.MailTransport = "smtp"
+	config.App.FromEmail = "events@example.com"
+	config.App.SMTPConnectTimeout = 5 * time.Second
+	config.App.SMTPTimeout = 5 * time.Second
+	return s
+}
+
+func TestEmailRecipientFailuresAreCollected(t *testing.T) {
+	testutil.Setup(t)
+	server := startBouncingServer(t)
+
+	event := models.NewEvent("Farewell", "farewell", time.Now())
+	if err := event.SaveEvent(); err != nil {
+		t.Fatal(err)
+	}
+	err := event.SaveRecipients([]models.Recipient{
+		{Name: "Alex", Email: "alex@example.com", Role: models.RoleTo},
+		{Name: "Gone", Email: "bounce-to@example.com", Role: models.RoleTo},
+		{Email: "bounce-cc@example.com", Role: models.RoleCC},
+		{Email: "bcc@example.com", Role: models.RoleBCC},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	submissions := []models.Submission{{EventID: event.ID, Name: "Riley", Message: "Good luck!"}}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	err = Email{}.Notify(event, submissions)
+
+	// Missing a To recipient fails the delivery, and the error counts
+	// everyone who was missed
+	if err == nil || !strings.HasPrefix(err.Error(), "failed to send email to 2 of 4 recipients: ") {
+		t.Errorf("Notify error = %v, want 2 of 4 recipients failed", err)
+	}
+	for _, addr := range []string{"bounce-to@example.com", "bounce-cc@example.com"} {
+		if err == nil || !strings.Contains(err.Error(), addr) {
+			t.Errorf("Notify error doesn't name %s: %v", addr, err)
+		}
+		if !strings.Contains(logged.String(), addr) {
+			t.Errorf("failure for %s isn't logged: %s", addr, logged.String())
+		}
+	}
+
+	// and the others were still sent their copies
+	server.mu.Lock()
+	delivered := strings.Join(server.delivered, ",")
+	server.mu.Unlock()
+	if delivered != "alex@example.com,bcc@example.com" {
+		t.Errorf("delivered to %s, want alex@example.com and bcc@example.com", delivered)
+	}
+
+	recipients, err := models.GetRecipients(event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range recipients {
+		wantFailed := strings.HasPrefix(r.Email, "bounce")
+		if (r.Status == models.RecipientFailed) != wantFailed || (wantFailed && r.Error == "") {
+			t.Errorf("%s: status %s, error %q", r.Email, r.Status, r.Error)
+		}
+	}
+}
+
+func TestEmailCcFailureDoesntFailDelivery(t *testing.T) {
+	testutil.Setup(t)
+	server := startBouncingServer(t)
+
+	event := models.NewEvent("Farewell", "farewell", time.Now())
+	if err := event.SaveEvent(); err != nil {
+		t.Fatal(err)
+	}
+	err := event.SaveRecipients([]models.Recipient{
+		{Name: "Alex", Email: "alex@example.com", Role: models.RoleTo},
+		{Email: "bounce-cc@example.com", Role: models.RoleCC},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	submissions := []models.Submission{{EventID: event.ID, Name: "Riley", Message: "Good luck!"}}
+
+	if err := (Email{}).Notify(event, submissions); err != nil {
+		t.Errorf("Notify error = %v with every To recipient sent their copy", err)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if strings.Join(server.delivered, ",") != "alex@example.com" {
+		t.Errorf("delivered to %v", server.delivered)
+	}
+}
@@ -0,0 +1,28 @@
+// Package notify delivers an event's messages to its recipient on the event
+// date, over whichever channel the event was set up with.
+package notify
+
+import (
+	"fmt"
+
+	"event-messenger.com/models"
+)
+
+// Notifier sends an event's approved submissions to its recipient
+type Notifier interface {
+	Notify(event *models.Event, submissions []models.Submission) error
+}
+
+// For returns the notifier for the event's delivery channel
+func For(event *models.Event) (Notifier, error) {
+	switch event.NotifyChannel {
+	case models.NotifyEmail, "":
+		return Email{}, nil
+	case models.NotifySlack:
+		if event.NotifyURL == "" {
+			return nil, fmt.Errorf("event %s has no incoming webhook URL", event.Slug)
+		}
+		return Slack{URL: event.NotifyURL}, nil
+	}
+	return nil, fmt.Errorf("event %s has unknown delivery channel %q", event.Slug, event.NotifyChannel)
+}
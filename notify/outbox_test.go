@@ -0,0 +1,92 @@
+package notify
+
+import (
+	"bytes"
+	"net/mail"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"event-messenger.com/internal/testutil"
+	"event-messenger.com/mailer"
+	"event-messenger.com/models"
+)
+
+func TestEmailToOutbox(t *testing.T) {
+	testutil.Setup(t)
+	testutil.UseOutbox(t)
+
+	event := models.NewEvent("Farewell", "farewell", time.Now())
+	if err := event.SaveEvent(); err != nil {
+		t.Fatal(err)
+	}
+	err := event.SaveRecipients([]models.Recipient{
+		{Name: "Alex", Email: "alex@example.com", Role: models.RoleTo},
+		{Name: "Sam", Email: "sam@example.com", Role: models.RoleTo},
+		{Email: "cc@example.com", Role: models.RoleCC},
+		{Name: "Secret", Email: "bcc@example.com", Role: models.RoleBCC},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	submissions := []models.Submission{{EventID: event.ID, Name: "Riley", Message: "Good luck!"}}
+
+	if err := (Email{}).Notify(event, submissions); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := mailer.ListOutbox(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var delivered []string
+	for _, m := range saved {
+		delivered = append(delivered, m.To)
+		if m.Subject != "Your Farewell Messages" {
+			t.Errorf("%s: subject %q", m.Name, m.Subject)
+		}
+
+		raw, err := mailer.ReadOutbox(m.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := msg.Header.Get("To"); got != `"Alex" <alex@example.com>, "Sam" <sam@example.com>` {
+			t.Errorf("%s: To %q", m.Name, got)
+		}
+		if got := msg.Header.Get("Cc"); got != "<cc@example.com>" {
+			t.Errorf("%s: Cc %q", m.Name, got)
+		}
+
+		// Only the Bcc recipient's own envelope may name them
+		header, _, _ := bytes.Cut(raw, []byte("\r\n\r\n"))
+		for _, line := range strings.Split(string(header), "\r\n") {
+			if strings.HasPrefix(line, "Delivered-To:") {
+				continue
+			}
+			if strings.Contains(strings.ToLower(line), "bcc") || strings.Contains(line, "Secret") {
+				t.Errorf("%s: header gives the Bcc recipient away: %q", m.Name, line)
+			}
+		}
+	}
+
+	// One copy for each recipient, whatever their role
+	sort.Strings(delivered)
+	if got, want := strings.Join(delivered, ","), "alex@example.com,bcc@example.com,cc@example.com,sam@example.com"; got != want {
+		t.Errorf("outbox has copies for %s, want %s", got, want)
+	}
+}
+
+func TestReadOutboxStaysInTheOutbox(t *testing.T) {
+	testutil.Setup(t)
+	testutil.UseOutbox(t)
+	for _, name := range []string{"../app.db", "../secret.eml", "/etc/passwd.eml", ".hidden.eml.tmp", "notes.txt"} {
+		if _, err := mailer.ReadOutbox(name); err == nil {
+			t.Errorf("ReadOutbox(%q) didn't fail", name)
+		}
+	}
+}
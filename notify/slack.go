@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"event-messenger.com/models"
+	"event-messenger.com/utils"
+)
+
+// How much of the messages the chat summary quotes
+const (
+	slackQuotes      = 3
+	slackQuoteLength = 200
+)
+
+var slackClient = &http.Client{Timeout: 10 * time.Second}
+
+// Slack posts a summary of the messages, with a link to the keepsake page,
+// to a Slack or Mattermost incoming webhook. Both accept the same payload.
+type Slack struct {
+	URL string
+}
+
+func (s Slack) Notify(event *models.Event, submissions []models.Submission) error {
+	body, err := json.Marshal(map[string]string{"text": slackSummary(event, submissions)})
+	if err != nil {
+		return err
+	}
+
+	resp, err := slackClient.Post(s.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post to incoming webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		reply, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("incoming webhook answered %s: %s", resp.Status, strings.TrimSpace(string(reply)))
+	}
+	return nil
+}
+
+// slackSummary says how many messages there are and who they're from,
+// quotes the first few and links to the keepsake page for the rest
+func slackSummary(event *models.Event, submissions []models.Submission) string {
+	var b strings.Builder
+
+	count := "1 message"
+	if len(submissions) != 1 {
+		count = fmt.Sprintf("%d messages", len(submissions))
+	}
+	fmt.Fprintf(&b, "*%s for %s* from %s\n", count, slackEscape(event.RecipientName), slackEscape(event.Name))
+
+	quoted := 0
+	for _, sub := range submissions {
+		text := strings.Join(strings.Fields(sub.Message), " ")
+		if text == "" {
+			continue
+		}
+		if r := []rune(text); len(r) > slackQuoteLength {
+			text = string(r[:slackQuoteLength]) + "…"
+		}
+		fmt.Fprintf(&b, "> %s\n> — %s\n", slackEscape(text), slackEscape(sub.Name))
+
+		if quoted++; quoted == slackQuotes {
+			break
+		}
+	}
+
+	fmt.Fprintf(&b, "<%s|Open the keepsake page>", utils.GetKeepsakeURL(event.Slug))
+	return b.String()
+}
+
+// slackEscape stops guests' text being read as links or mentions
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -1,9 +1,12 @@
 package routes
 
 import (
+	"expvar"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"event-messenger.com/api"
 	"event-messenger.com/handlers"
 )
 
@@ -20,9 +23,31 @@ func RegisterRoutes() *http.ServeMux {
 	// Event-specific public routes
 	mux.HandleFunc("/events/", eventRouteHandler) // Handles all /events/* routes
 
+	// Invitees' unsubscribe links
+	mux.HandleFunc("/unsubscribe/", unsubscribeRouteHandler)
+
+	// JSON API for other systems, authenticated with API_KEYS
+	mux.Handle("/api/", api.Handler())
+
+	// Admin pages, behind ADMIN_PASSWORD
+	mux.Handle("/admin/webhooks", handlers.RequireAdmin(http.HandlerFunc(handlers.AdminWebhooksHandler)))
+	mux.Handle("/admin/outbox", handlers.RequireAdmin(http.HandlerFunc(handlers.AdminOutboxHandler)))
+	mux.Handle("/admin/", handlers.RequireAdmin(http.HandlerFunc(adminRouteHandler)))
+
+	// Email caught by the development SMTP server (DEV_SMTP)
+	mux.HandleFunc("/dev/mail", handlers.DevMailHandler)
+	mux.HandleFunc("/dev/mail/", devMailRouteHandler)
+
+	// Resumable (tus) uploads for large photos on flaky connections
+	mux.HandleFunc("/uploads/tus", handlers.TusHandler)
+	mux.HandleFunc("/uploads/tus/", handlers.TusHandler)
+
+	// Runtime metrics (image queue depth etc.), for admins only
+	mux.Handle("/debug/vars", handlers.RequireAdmin(expvar.Handler()))
+
 	// Static file serving
 	mux.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir("./data/uploads"))))
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
+	mux.Handle("/static/", http.StripPrefix("/static/", handlers.StaticHandler()))
 
 	return mux
 }
@@ -57,10 +82,101 @@ func eventRouteHandler(w http.ResponseWriter, r *http.Request) {
 		} else {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
+	case "messages":
+		// GET /events/graduation-2025/messages - Keepsake page
+		handlers.ViewSubmissionsByEvent(w, r, slug)
 
 	default:
+		// GET/POST /events/graduation-2025/edit/{token} - Contributor's edit link
+		if token, ok := strings.CutPrefix(action, "edit/"); ok && token != "" && !strings.Contains(token, "/") {
+			handlers.EditSubmissionHandler(w, r, slug, token)
+			return
+		}
+		// GET/POST /events/graduation-2025/review/{token} - Coordinator's review screen
+		// GET /events/graduation-2025/review/{token}/preview - The notification email as it stands
+		// POST /events/graduation-2025/review/{token}/test - Email the coordinator a test copy
+		// GET/POST /events/graduation-2025/review/{token}/deliver - Deliver before the event date
+		// GET/POST /events/graduation-2025/review/{token}/resend - Send the messages again
+		// POST /events/graduation-2025/review/{token}/invitees - Invite people, or remove one
+		if rest, ok := strings.CutPrefix(action, "review/"); ok {
+			token, page, _ := strings.Cut(rest, "/")
+			switch {
+			case token == "":
+			case rest == token:
+				handlers.ReviewHandler(w, r, slug, token)
+				return
+			case page == "preview":
+				handlers.ReviewPreviewHandler(w, r, slug, token)
+				return
+			case page == "test":
+				handlers.ReviewTestSendHandler(w, r, slug, token)
+				return
+			case page == "deliver" || page == "resend":
+				handlers.ReviewDeliveryHandler(w, r, slug, token, page)
+				return
+			case page == "invitees":
+				handlers.ReviewInviteesHandler(w, r, slug, token)
+				return
+			}
+		}
+		// GET /events/graduation-2025/submissions/12/status - Image processing status
+		if id, ok := parseSubmissionStatusPath(action); ok && r.Method == http.MethodGet {
+			handlers.SubmissionStatusHandler(w, r, slug, id)
+			return
+		}
+		http.NotFound(w, r)
+	}
+}
+
+// adminRouteHandler sends /admin/ to the webhooks page and serves the
+// outbox's individual messages
+func adminRouteHandler(w http.ResponseWriter, r *http.Request) {
+	// GET /admin/outbox/{name} - One captured email
+	if name, ok := strings.CutPrefix(r.URL.Path, "/admin/outbox/"); ok && name != "" && r.Method == http.MethodGet {
+		handlers.AdminOutboxMessageHandler(w, r, name)
+		return
+	}
+	if r.URL.Path != "/admin/" {
 		http.NotFound(w, r)
+		return
+	}
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+// unsubscribeRouteHandler serves /unsubscribe/{token}
+func unsubscribeRouteHandler(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, "/unsubscribe/")
+	if token == "" || strings.Contains(token, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	handlers.UnsubscribeHandler(w, r, token)
+}
+
+// devMailRouteHandler serves /dev/mail/{id}, /dev/mail/{id}/raw and
+// /dev/mail/{id}/attachments/{n}
+func devMailRouteHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/dev/mail/")
+	idPart, part, _ := strings.Cut(path, "/")
+	id, err := strconv.Atoi(idPart)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	handlers.DevMailMessageHandler(w, r, id, part)
+}
+
+// parseSubmissionStatusPath extracts the submission ID from "submissions/{id}/status"
+func parseSubmissionStatusPath(action string) (int, bool) {
+	parts := strings.Split(action, "/")
+	if len(parts) != 3 || parts[0] != "submissions" || parts[2] != "status" {
+		return 0, false
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, false
 	}
+	return id, true
 }
 
 // parseEventPath extracts slug and action from path
@@ -5,7 +5,9 @@ import (
 	"log/slog"
 	"time"
 
+	"event-messenger.com/handlers"
 	"event-messenger.com/models"
+	"event-messenger.com/webhooks"
 )
 
 // StartCleanupScheduler runs weekly to clean up old events
@@ -58,7 +60,37 @@ func cleanupOldEvents(graceDays int) {
 			slog.Error(fmt.Sprintf("Failed to delete event %s: %v", event.Name, err))
 			continue
 		}
+		webhooks.PublishEvent(webhooks.EventPurged, &event)
 
 		slog.Debug("Successfully deleted event: ", "name", event.Name)
 	}
 }
+
+// StartUploadCleanupScheduler removes expired resumable uploads at the given interval
+func StartUploadCleanupScheduler(interval time.Duration) {
+	slog.Debug(fmt.Sprintf("Upload cleanup scheduler started - will run every %v", interval))
+
+	go func() {
+		for {
+			cleanupExpiredUploads()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func cleanupExpiredUploads() {
+	uploads, err := models.GetExpiredUploads()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error retrieving expired uploads: %v", err))
+		return
+	}
+
+	for _, upload := range uploads {
+		err := handlers.RemoveUpload(&upload)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to remove expired upload %s: %v", upload.ID, err))
+			continue
+		}
+		slog.Debug("Removed expired upload", "id", upload.ID, "received", upload.Offset, "length", upload.Length)
+	}
+}
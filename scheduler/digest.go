@@ -0,0 +1,68 @@
+package scheduler
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/mail"
+	"time"
+
+	"event-messenger.com/handlers"
+	"event-messenger.com/models"
+)
+
+// StartDigestScheduler checks every interval for coordinators due a progress
+// digest
+func StartDigestScheduler(interval time.Duration) {
+	slog.Debug(fmt.Sprintf("Progress digest scheduler started - will run every %v", interval))
+
+	go func() {
+		for {
+			sendDigests(time.Now())
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func sendDigests(now time.Time) {
+	events, err := models.GetAllActiveEvents()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error retrieving events for digests: %v", err))
+		return
+	}
+
+	for i := range events {
+		event := &events[i]
+		if event.EmailSent {
+			continue
+		}
+		if _, err := mail.ParseAddress(event.CoordinatorContact); err != nil {
+			continue
+		}
+
+		step, dueAt := dueStep(event.DigestSchedule(), event.EventDate, now)
+		if step == 0 {
+			continue
+		}
+		// The digest came due before the event existed, or has been sent
+		if event.CreatedAt.After(dueAt) || (event.DigestSentAt.Valid && !event.DigestSentAt.Time.Before(dueAt)) {
+			continue
+		}
+
+		token, claimed, err := event.ClaimDigest(dueAt, now)
+		if err != nil {
+			slog.Error(fmt.Sprintf("%v", err))
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		daysLeft := int(math.Ceil(DeliveryTime(event.EventDate).Sub(now).Hours() / 24))
+		if err := handlers.SendDigest(event, token, daysLeft); err != nil {
+			slog.Error(fmt.Sprintf("Failed to send progress digest for event %s: %v", event.Slug, err))
+			continue
+		}
+		slog.Debug("Sent progress digest", "event", event.Slug, "digest", step)
+	}
+}
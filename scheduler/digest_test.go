@@ -0,0 +1,199 @@
+package scheduler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"event-messenger.com/db"
+	"event-messenger.com/internal/testutil"
+	"event-messenger.com/mailer"
+	"event-messenger.com/models"
+)
+
+// digestEvent saves an event dated days from now whose coordinator gets
+// progress digests by email on the default schedule
+func digestEvent(t *testing.T, slug string, days int) *models.Event {
+	t.Helper()
+	event := models.NewEvent("Farewell", slug, time.Now().AddDate(0, 0, days),
+		models.WithCoordinator("Casey", "casey@example.com"),
+		models.WithRecipient("Alex", "alex@example.com"))
+	if err := event.SaveEvent(); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+// addMessage saves a message dated the given number of minutes after the
+// test's first, so messages sort in a known order
+func addMessage(t *testing.T, event *models.Event, name, message, moderation string, minutes int) {
+	t.Helper()
+	s := &models.Submission{EventID: event.ID, Name: name, Message: message, Moderation: moderation}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	createdAt := time.Now().UTC().Add(time.Duration(minutes-1000) * time.Minute)
+	if _, err := db.DB.Exec(`UPDATE submissions SET created_at = ? WHERE id = ?`, createdAt, s.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var reviewLinkPattern = regexp.MustCompile(`/review/([A-Za-z0-9_-]+)"`)
+
+// digestToken is the review link token in a digest
+func digestToken(t *testing.T, digest *mailer.Parsed) string {
+	t.Helper()
+	m := reviewLinkPattern.FindStringSubmatch(digest.HTML)
+	if m == nil {
+		t.Fatalf("no review link in digest:\n%s", digest.HTML)
+	}
+	return m[1]
+}
+
+func TestDigestSchedule(t *testing.T) {
+	testutil.Setup(t)
+	testutil.UseOutbox(t)
+	event := digestEvent(t, "farewell", 10)
+	addMessage(t, event, "Riley", "Good luck!", models.ModerationApproved, 0)
+	delivery := DeliveryTime(event.EventDate)
+
+	runs := []struct {
+		at         time.Time
+		concurrent bool // run twice at once
+		sent       int  // digests sent so far
+		daysLeft   string
+	}{
+		{delivery.AddDate(0, 0, -4), false, 0, ""},
+		{delivery.AddDate(0, 0, -3), true, 1, "with 3 days until"},
+		{delivery.AddDate(0, 0, -3).Add(time.Hour), false, 1, ""},
+		{delivery.AddDate(0, 0, -2), false, 1, ""},
+		{delivery.AddDate(0, 0, -1).Add(time.Minute), true, 2, "with 1 day until"},
+		{delivery.Add(-time.Minute), false, 2, ""},
+		{delivery, false, 2, ""},
+	}
+	for _, run := range runs {
+		if run.concurrent {
+			var wg sync.WaitGroup
+			for range 2 {
+				wg.Go(func() { sendDigests(run.at) })
+			}
+			wg.Wait()
+		} else {
+			sendDigests(run.at)
+		}
+
+		sent := testutil.Outbox(t)
+		if len(sent) != run.sent {
+			t.Fatalf("at %v: %d digests sent, want %d", run.at, len(sent), run.sent)
+		}
+		if run.daysLeft != "" && !strings.Contains(strings.Join(strings.Fields(sent[0].HTML), " "), run.daysLeft) {
+			t.Errorf("digest sent at %v doesn't say %q:\n%s", run.at, run.daysLeft, sent[0].HTML)
+		}
+	}
+
+	// Only the latest digest's link works, alongside the coordinator's own
+	sent := testutil.Outbox(t)
+	got, err := models.GetEventBySlugIncludingArchived(event.Slug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range sent {
+		if to := m.Header.Get("Delivered-To"); to != "casey@example.com" {
+			t.Errorf("digest %d went to %s", i, to)
+		}
+		if got.ValidManageToken(digestToken(t, m)) != (i == 0) {
+			t.Errorf("link in digest %d works: %v", len(sent)-i, i != 0)
+		}
+	}
+	if !got.ValidManageToken(event.ManageToken) {
+		t.Error("coordinator's own link stopped working")
+	}
+}
+
+func TestDigestSkipsWhatCameDueEarlier(t *testing.T) {
+	testutil.Setup(t)
+	testutil.UseOutbox(t)
+
+	// Created two days out, the 3 day digest came due before the event
+	// existed, so the first one is the day before
+	late := digestEvent(t, "late", 2)
+	delivery := DeliveryTime(late.EventDate)
+	sendDigests(time.Now())
+	sendDigests(delivery.AddDate(0, 0, -2).Add(time.Minute))
+	if sent := testutil.Outbox(t); len(sent) != 0 {
+		t.Fatalf("%d digests sent for an event created after they came due", len(sent))
+	}
+	sendDigests(delivery.AddDate(0, 0, -1))
+	if sent := testutil.Outbox(t); len(sent) != 1 {
+		t.Fatalf("%d digests sent the day before, want 1", len(sent))
+	}
+
+	// Nothing goes to coordinators without an email address, or once the
+	// messages have been delivered
+	noEmail := models.NewEvent("Farewell", "no-email", time.Now().AddDate(0, 0, 10), models.WithCoordinator("Casey", "by phone"))
+	if err := noEmail.SaveEvent(); err != nil {
+		t.Fatal(err)
+	}
+	delivered := digestEvent(t, "delivered", 10)
+	if err := delivered.MarkEmailSent(); err != nil {
+		t.Fatal(err)
+	}
+	sendDigests(DeliveryTime(noEmail.EventDate).AddDate(0, 0, -1))
+	if sent := testutil.Outbox(t); len(sent) != 1 {
+		t.Errorf("%d digests sent, want only the earlier one", len(sent))
+	}
+}
+
+func TestDigestContents(t *testing.T) {
+	testutil.Setup(t)
+	testutil.UseOutbox(t)
+	event := digestEvent(t, "farewell", 10)
+
+	// Seven approved messages, the newest last; twelve waiting for
+	// approval, one of them long; and one rejected
+	for i := range 7 {
+		addMessage(t, event, fmt.Sprintf("Approved %d", i), "Good luck!", models.ModerationApproved, i)
+	}
+	for i := range 12 {
+		message := "Please approve me"
+		if i == 11 {
+			message = strings.Repeat("a", 200)
+		}
+		addMessage(t, event, fmt.Sprintf("Pending %d", i), message, models.ModerationPending, 10+i)
+	}
+	addMessage(t, event, "Rejected", "Spam", models.ModerationRejected, 30)
+
+	sendDigests(DeliveryTime(event.EventDate).AddDate(0, 0, -3))
+	sent := testutil.Outbox(t)
+	if len(sent) != 1 {
+		t.Fatalf("%d digests sent, want 1", len(sent))
+	}
+	digest := sent[0]
+	if want := "Farewell: 7 messages so far, 12 waiting for you"; digest.Subject != want {
+		t.Errorf("subject %q, want %q", digest.Subject, want)
+	}
+	body := strings.Join(strings.Fields(digest.HTML), " ")
+
+	for _, want := range []string{
+		"Hi Casey,",
+		"7 messages so far",
+		// The five newest approved contributors, newest first
+		"Latest from Approved 6, Approved 5, Approved 4, Approved 3, Approved 2 and 2 others.",
+		"Waiting for your approval (12)",
+		"<strong>Pending 11</strong>: " + strings.Repeat("a", 139) + "…",
+		"<strong>Pending 2</strong>: Please approve me",
+		"and 2 more",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("digest doesn't say %q", want)
+		}
+	}
+	for _, notWant := range []string{"Approved 1,", "Pending 1<", "Pending 0<", "Rejected", "Spam"} {
+		if strings.Contains(body, notWant) {
+			t.Errorf("digest says %q", notWant)
+		}
+	}
+}
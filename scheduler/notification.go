@@ -1,137 +1,190 @@
 package scheduler
 
 import (
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
-	"os"
-	"path/filepath"
+	"net/mail"
 	"strings"
-	"time"
+	"sync"
 
-	"event-messenger.com/handlers"
 	"event-messenger.com/models"
-	"event-messenger.com/utils"
+	"event-messenger.com/notify"
+	"event-messenger.com/webhooks"
 )
 
-// Set a cap so that email doesn't reach SMTP limit (25MB limit)
-const maxSubmissionsPerEmail = 100
-
-type SubmissionEmailData struct {
-	MessageText  string
-	From         string
-	ImageDataURI string
-}
+// deliveryMu keeps the scheduler and coordinators from delivering an event
+// at the same time
+var deliveryMu sync.Mutex
 
 func sendEventNotification(event *models.Event) error {
-	submissions, err := models.GetSubmissionsByEventSlug(event.Slug)
-	if err != nil {
-		log.Printf("Error retreiving submissions")
-		return err
-	}
-
-	if len(submissions) == 0 {
+	err := deliver(event, models.DeliveryScheduled, "")
+	switch {
+	case errors.Is(err, models.ErrAlreadyDelivered):
+		slog.Info(fmt.Sprintf("Skipping event %s - email already sent", event.Name))
+		return nil
+	case errors.Is(err, models.ErrNoSubmissions):
 		log.Printf("No submissions were made for this event")
 		return nil
 	}
+	return err
+}
 
-	// If more submissions than email limit, cap emails in message at that limit
-	if len(submissions) > maxSubmissionsPerEmail {
-		log.Printf("Event %s has %d submissions, capping at %d for email size", event.Name, len(submissions), maxSubmissionsPerEmail)
-		submissions = submissions[:maxSubmissionsPerEmail]
-	}
-
-	// Build submission data with base64-encoded images
-	submissionData := make([]SubmissionEmailData, 0, len(submissions))
-	for _, sub := range submissions {
+// DeliverNow delivers an event's messages ahead of its date, the same way the
+// scheduler does on the day. Once they've gone out it returns
+// models.ErrAlreadyDelivered, so they're never delivered twice.
+func DeliverNow(event *models.Event, requestKey string) error {
+	return deliver(event, models.DeliveryEarly, requestKey)
+}
 
-		imgUri, err := encodeImageAsDataURI(sub.Filename)
-		// If error encoding image, input blank string for image URI
-		if err != nil {
-			log.Printf("could not encode image for submission from %v", sub.Name)
-			imgUri = ""
-		}
-		data := SubmissionEmailData{
-			MessageText:  sub.Message,
-			From:         sub.Name,
-			ImageDataURI: imgUri,
-		}
+func deliver(event *models.Event, trigger, requestKey string) error {
+	deliveryMu.Lock()
+	defer deliveryMu.Unlock()
 
-		submissionData = append(submissionData, data)
+	// Someone else may have delivered it since it was loaded
+	event, err := reloadEvent(event, requestKey)
+	if err != nil {
+		return err
 	}
-
-	// Prepare template data
-	templateData := struct {
-		EventName       string
-		RecipientName   string
-		EventDate       time.Time
-		Submissions     []SubmissionEmailData
-		TotalCount      int
-		CoordinatorName string
-	}{
-		EventName:       event.Name,
-		RecipientName:   event.RecipientName,
-		EventDate:       event.EventDate,
-		Submissions:     submissionData,
-		TotalCount:      len(submissions),
-		CoordinatorName: event.Coordinator,
+	if event.EmailSent {
+		return models.ErrAlreadyDelivered
 	}
 
-	// Render email HTML
-	htmlContent, err := handlers.RenderEmailTemplate(templateData)
+	submissions, err := models.GetSubmissionsByEventSlug(event.Slug)
 	if err != nil {
-		return fmt.Errorf("failed to render email template: %w", err)
+		log.Printf("Error retreiving submissions")
+		return err
 	}
 
-	// Log email size for debugging
-	emailSizeKB := len(htmlContent) / 1024
-	log.Printf("Email size for event %s: %d KB (%d submissions)", event.Name, emailSizeKB, len(submissions))
+	if len(submissions) == 0 {
+		return models.ErrNoSubmissions
+	}
 
-	if emailSizeKB > 15000 { // Warn if over 15MB
-		log.Printf("WARNING: Email size may be too large for SMTP")
+	notifier, err := notify.For(event)
+	if err != nil {
+		recordDelivery(event, trigger, requestKey, "", err)
+		return err
 	}
 
-	// Send email
-	subject := fmt.Sprintf("Your %s Messages", event.Name)
-	err = utils.SendEmailNotification(event.RecipientEmail, subject, htmlContent)
+	err = notifier.Notify(event, submissions)
+	recordDelivery(event, trigger, requestKey, deliveredTo(event), err)
 	if err != nil {
-		log.Printf("Failed to send email for event %s: %v", event.Name, err)
-		return fmt.Errorf("failed to send email: %w", err)
+		log.Printf("Failed to deliver messages for event %s over %s: %v", event.Name, event.NotifyChannel, err)
+		return fmt.Errorf("failed to deliver messages: %w", err)
 	}
 
 	// Mark email as sent
 	err = event.MarkEmailSent()
 	if err != nil {
-		log.Printf("WARNING: Email sent for event %s but failed to mark as sent in DB: %v",
+		log.Printf("WARNING: Messages delivered for event %s but failed to mark as sent in DB: %v",
 			event.Name, err)
 		return fmt.Errorf("failed to mark email as sent: %w", err)
 	}
 
-	slog.Info(fmt.Sprintf("Successfully sent notification for event: %s to %s", event.Name, event.RecipientEmail))
+	webhooks.PublishEvent(webhooks.EventDelivered, event)
+
+	slog.Info(fmt.Sprintf("Successfully sent notification for event: %s over %s", event.Name, event.NotifyChannel))
 	return nil
 }
 
-func encodeImageAsDataURI(filename string) (string, error) {
-	imagePath := filepath.Join("./data/uploads", filename)
-	imageData, err := os.ReadFile(imagePath)
+// Resend delivers an event's messages again after they've gone out: to
+// everyone they went to, or only to address when one is given. The event
+// stays delivered whatever happens.
+func Resend(event *models.Event, address *mail.Address, requestKey string) error {
+	deliveryMu.Lock()
+	defer deliveryMu.Unlock()
+
+	event, err := reloadEvent(event, requestKey)
 	if err != nil {
-		return "", fmt.Errorf("could not read image file: %w", err)
+		return err
+	}
+	if !event.EmailSent {
+		return models.ErrNotDelivered
 	}
 
-	// Detect MIME type from file extension
-	ext := strings.ToLower(filepath.Ext(filename))
-	mimeType := "image/jpeg" // Default
-	switch ext {
-	case ".png":
-		mimeType = "image/png"
-	case ".gif":
-		mimeType = "image/gif"
-	case ".webp":
-		mimeType = "image/webp"
+	submissions, err := models.GetSubmissionsByEventSlug(event.Slug)
+	if err != nil {
+		return err
+	}
+
+	var sentTo string
+	if address != nil {
+		err = notify.SendCopy(event, submissions, *address)
+		if err == nil {
+			sentTo = address.Address
+		}
+	} else {
+		var notifier notify.Notifier
+		notifier, err = notify.For(event)
+		if err != nil {
+			return err
+		}
+		if err := event.ResetRecipients(); err != nil {
+			return err
+		}
+		err = notifier.Notify(event, submissions)
+		sentTo = deliveredTo(event)
 	}
 
-	// Encode to base64
-	base64Image := base64.StdEncoding.EncodeToString(imageData)
-	return fmt.Sprintf("data:%s;base64,%s", mimeType, base64Image), nil
+	recordDelivery(event, models.DeliveryResend, requestKey, sentTo, err)
+	if err != nil {
+		log.Printf("Failed to resend messages for event %s: %v", event.Name, err)
+		return fmt.Errorf("failed to resend messages: %w", err)
+	}
+
+	slog.Info(fmt.Sprintf("Resent notification for event: %s", event.Name))
+	return nil
+}
+
+// reloadEvent fetches the event's current state, refusing a request key
+// that's been acted on already
+func reloadEvent(event *models.Event, requestKey string) (*models.Event, error) {
+	if requestKey != "" {
+		seen, err := models.DeliveryRequestSeen(requestKey)
+		if err != nil {
+			return nil, err
+		}
+		if seen {
+			return nil, models.ErrDuplicateDelivery
+		}
+	}
+	return models.GetEventBySlugIncludingArchived(event.Slug)
+}
+
+// deliveredTo lists the recipients the email has reached, for the history
+func deliveredTo(event *models.Event) string {
+	if event.NotifyChannel != models.NotifyEmail {
+		return ""
+	}
+	recipients, err := models.GetRecipients(event.ID)
+	if err != nil {
+		log.Printf("%v", err)
+		return ""
+	}
+	var addresses []string
+	for _, r := range recipients {
+		if r.Status == models.RecipientSent {
+			addresses = append(addresses, r.Email)
+		}
+	}
+	return strings.Join(addresses, ", ")
+}
+
+func recordDelivery(event *models.Event, trigger, requestKey, sentTo string, err error) {
+	d := &models.Delivery{
+		EventID:    event.ID,
+		Trigger:    trigger,
+		Channel:    event.NotifyChannel,
+		SentTo:     sentTo,
+		Status:     models.DeliverySucceeded,
+		RequestKey: requestKey,
+	}
+	if err != nil {
+		d.Status = models.DeliveryFailed
+		d.Error = err.Error()
+	}
+	if err := models.RecordDelivery(d); err != nil {
+		log.Printf("%v", err)
+	}
 }
@@ -0,0 +1,204 @@
+package scheduler
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"event-messenger.com/internal/testutil"
+	"event-messenger.com/models"
+)
+
+// emailEvent saves an event dated today that's delivered by email to
+// alex@example.com, with a message from each contributor. Contributors
+// marked with a moderation state in moderation get that state.
+func emailEvent(t *testing.T, slug string, contributors []string, moderation map[string]string) *models.Event {
+	t.Helper()
+	event := models.NewEvent(slug, slug, time.Now(), models.WithRecipient("Alex", "alex@example.com"))
+	if err := event.SaveEvent(); err != nil {
+		t.Fatal(err)
+	}
+	if err := event.SaveRecipients([]models.Recipient{{Name: "Alex", Email: "alex@example.com", Role: models.RoleTo}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range contributors {
+		s := &models.Submission{EventID: event.ID, Name: name, Message: "A message from " + name, Moderation: moderation[name]}
+		if err := s.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return event
+}
+
+func TestDeliveryLeavesOutUnapprovedMessages(t *testing.T) {
+	testutil.Setup(t)
+	testutil.UseOutbox(t)
+	event := emailEvent(t, "farewell", []string{"Riley", "Sam", "Jo"}, map[string]string{
+		"Sam": models.ModerationPending,
+		"Jo":  models.ModerationRejected,
+	})
+
+	// Counts shown to coordinators and visitors
+	if count, err := event.GetSubmissionCount(); err != nil || count != 1 {
+		t.Errorf("GetSubmissionCount = %d, %v; want 1", count, err)
+	}
+	previews, err := models.GetActiveEventPreviews()
+	if err != nil || len(previews) != 1 || previews[0].SubmissionCount != 1 {
+		t.Errorf("GetActiveEventPreviews = %+v, %v; want a count of 1", previews, err)
+	}
+	withCounts, err := models.GetAllActiveEventsWithCounts()
+	if err != nil || len(withCounts) != 1 || withCounts[0].SubmissionCount != 1 {
+		t.Errorf("GetAllActiveEventsWithCounts = %d events, %v; want a count of 1", len(withCounts), err)
+	}
+	approved, err := models.GetSubmissionsByEventSlug(event.Slug)
+	if err != nil || len(approved) != 1 || approved[0].Name != "Riley" {
+		t.Errorf("GetSubmissionsByEventSlug = %+v, %v; want only Riley's", approved, err)
+	}
+
+	if err := DeliverNow(event, ""); err != nil {
+		t.Fatal(err)
+	}
+	sent := testutil.Outbox(t)
+	if len(sent) != 1 {
+		t.Fatalf("%d emails sent, want 1", len(sent))
+	}
+	for _, body := range []string{sent[0].Text, sent[0].HTML} {
+		if !strings.Contains(body, "A message from Riley") {
+			t.Errorf("delivery email has no approved message:\n%s", body)
+		}
+		for _, name := range []string{"Sam", "Jo"} {
+			if strings.Contains(body, "A message from "+name) {
+				t.Errorf("delivery email includes %s's unapproved message", name)
+			}
+		}
+	}
+}
+
+func TestDeliverNowThenSchedulerSendsOnce(t *testing.T) {
+	testutil.Setup(t)
+	testutil.UseOutbox(t)
+	early := emailEvent(t, "early", []string{"Riley"}, nil)
+	racing := emailEvent(t, "racing", []string{"Riley"}, nil)
+
+	// Delivered early, then the scheduler's run on the day finds it done
+	if err := DeliverNow(early, ""); err != nil {
+		t.Fatal(err)
+	}
+	StartDailyNotifications()
+
+	// The coordinator and the scheduler at the same moment deliver it once
+	// between them
+	done := make(chan error)
+	go func() { done <- DeliverNow(racing, "") }()
+	StartDailyNotifications()
+	if err := <-done; err != nil && !errors.Is(err, models.ErrAlreadyDelivered) {
+		t.Fatal(err)
+	}
+
+	if sent := testutil.Outbox(t); len(sent) != 2 {
+		t.Fatalf("%d emails sent, want one for each event", len(sent))
+	}
+	for _, event := range []*models.Event{early, racing} {
+		deliveries, err := models.GetDeliveries(event.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) != 1 || deliveries[0].Status != models.DeliverySucceeded {
+			t.Errorf("%s delivered %d times: %+v", event.Slug, len(deliveries), deliveries)
+		} else if event == early && deliveries[0].Trigger != models.DeliveryEarly {
+			t.Errorf("early delivery recorded as %s", deliveries[0].Trigger)
+		}
+	}
+}
+
+func TestRepeatedDeliveryRequestIsNoop(t *testing.T) {
+	testutil.Setup(t)
+	testutil.UseOutbox(t)
+	event := emailEvent(t, "farewell", []string{"Riley"}, nil)
+	key, err := models.NewDeliveryRequestKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen, err := models.DeliveryRequestSeen(key); err != nil || seen {
+		t.Fatalf("DeliveryRequestSeen before it's used = %v, %v", seen, err)
+	}
+
+	if err := DeliverNow(event, key); err != nil {
+		t.Fatal(err)
+	}
+	if seen, err := models.DeliveryRequestSeen(key); err != nil || !seen {
+		t.Errorf("DeliveryRequestSeen after delivering = %v, %v", seen, err)
+	}
+
+	// The same form submitted again, as a delivery or a resend, does nothing
+	if err := DeliverNow(event, key); !errors.Is(err, models.ErrDuplicateDelivery) {
+		t.Errorf("DeliverNow again = %v, want ErrDuplicateDelivery", err)
+	}
+	if err := Resend(event, nil, key); !errors.Is(err, models.ErrDuplicateDelivery) {
+		t.Errorf("Resend with the same key = %v, want ErrDuplicateDelivery", err)
+	}
+	if sent := testutil.Outbox(t); len(sent) != 1 {
+		t.Errorf("%d emails sent, want 1", len(sent))
+	}
+	if deliveries, err := models.GetDeliveries(event.ID); err != nil || len(deliveries) != 1 {
+		t.Errorf("%d deliveries recorded, %v; want 1", len(deliveries), err)
+	}
+}
+
+func TestResendRecordsItsOwnDelivery(t *testing.T) {
+	testutil.Setup(t)
+	testutil.UseOutbox(t)
+	event := emailEvent(t, "farewell", []string{"Riley"}, nil)
+
+	if err := Resend(event, nil, ""); !errors.Is(err, models.ErrNotDelivered) {
+		t.Errorf("Resend before delivery = %v, want ErrNotDelivered", err)
+	}
+	if err := DeliverNow(event, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := Resend(event, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := Resend(event, &mail.Address{Name: "Sam", Address: "sam@example.com"}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := testutil.Outbox(t)
+	if len(sent) != 3 {
+		t.Fatalf("%d emails sent, want 3", len(sent))
+	}
+	for i, want := range []string{"sam@example.com", "alex@example.com", "alex@example.com"} {
+		if got := sent[i].Header.Get("Delivered-To"); got != want {
+			t.Errorf("email %d went to %s, want %s", len(sent)-i, got, want)
+		}
+	}
+
+	deliveries, err := models.GetDeliveries(event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ trigger, sentTo string }{
+		{models.DeliveryResend, "sam@example.com"},
+		{models.DeliveryResend, "alex@example.com"},
+		{models.DeliveryEarly, "alex@example.com"},
+	}
+	if len(deliveries) != len(want) {
+		t.Fatalf("%d deliveries recorded, want %d", len(deliveries), len(want))
+	}
+	for i, d := range deliveries {
+		if d.Trigger != want[i].trigger || d.SentTo != want[i].sentTo || d.Status != models.DeliverySucceeded {
+			t.Errorf("delivery %d: %s to %q, %s; want %s to %q", d.ID, d.Trigger, d.SentTo, d.Status, want[i].trigger, want[i].sentTo)
+		}
+	}
+
+	// Resending doesn't make the event due again
+	got, err := models.GetEventBySlugIncludingArchived(event.Slug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.EmailSent || got.Active {
+		t.Errorf("after resending: sent %v, active %v", got.EmailSent, got.Active)
+	}
+}
@@ -0,0 +1,115 @@
+package scheduler
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"event-messenger.com/config"
+	"event-messenger.com/handlers"
+	"event-messenger.com/models"
+)
+
+// StartReminderScheduler checks every interval for invitations that haven't
+// gone out yet and invitees due a reminder
+func StartReminderScheduler(interval time.Duration) {
+	slog.Debug(fmt.Sprintf("Invitee reminder scheduler started - will run every %v", interval))
+
+	go func() {
+		for {
+			sendInviteReminders(time.Now())
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func sendInviteReminders(now time.Time) {
+	events, err := models.GetAllActiveEvents()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error retrieving events for reminders: %v", err))
+		return
+	}
+
+	for i := range events {
+		event := &events[i]
+		if event.EmailSent {
+			continue
+		}
+		invitees, err := models.GetInvitees(event.ID)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error retrieving invitees for event %s: %v", event.Slug, err))
+			continue
+		}
+		if len(invitees) == 0 {
+			continue
+		}
+
+		// Invitations that failed earlier get another try
+		handlers.SendInvitations(event)
+
+		step, dueAt := reminderStep(event.EventDate, now)
+		if step == 0 {
+			continue
+		}
+
+		for j := range invitees {
+			inv := &invitees[j]
+			if !inv.InvitedAt.Valid || inv.SubmittedAt.Valid || inv.UnsubscribedAt.Valid || inv.RemindersSent >= step {
+				continue
+			}
+			// Anyone invited since this reminder came due has only just
+			// had the invitation
+			if !inv.InvitedAt.Time.Before(dueAt) {
+				continue
+			}
+
+			claimed, err := inv.ClaimReminder(step)
+			if err != nil {
+				slog.Error(fmt.Sprintf("%v", err))
+				continue
+			}
+			if !claimed {
+				continue
+			}
+			if err := handlers.SendReminder(event, inv); err != nil {
+				slog.Error(fmt.Sprintf("Failed to remind %s about event %s: %v", inv.Email, event.Slug, err))
+				if err := inv.ReminderFailed(err); err != nil {
+					slog.Error(fmt.Sprintf("%v", err))
+				}
+				continue
+			}
+			slog.Debug("Reminded invitee", "event", event.Slug, "invitee", inv.ID, "reminder", step)
+		}
+	}
+}
+
+// reminderStep returns how many of the INVITE_REMINDER_DAYS reminders are
+// due by now, and when the latest of them came due
+func reminderStep(eventDate, now time.Time) (int, time.Time) {
+	return dueStep(config.App.InviteReminderDays, eventDate, now)
+}
+
+// dueStep returns how many of the emails sent the given numbers of days
+// before delivery are due by now, and when the latest of them came due.
+// Nothing is due once the messages are being delivered.
+func dueStep(daysBefore []int, eventDate, now time.Time) (int, time.Time) {
+	delivery := DeliveryTime(eventDate)
+	if !now.Before(delivery) {
+		return 0, time.Time{}
+	}
+
+	// Furthest from the delivery first
+	days := slices.Clone(daysBefore)
+	slices.Sort(days)
+	slices.Reverse(days)
+	days = slices.Compact(days)
+
+	step, dueAt := 0, time.Time{}
+	for i, d := range days {
+		if t := delivery.AddDate(0, 0, -d); !now.Before(t) {
+			step, dueAt = i+1, t
+		}
+	}
+	return step, dueAt
+}
@@ -9,12 +9,21 @@ import (
 	"event-messenger.com/models"
 )
 
+// sendHour is the local hour notifications go out on an event's date
+var sendHour = 8
+
+// DeliveryTime is when an event dated eventDate is due to be emailed
+func DeliveryTime(eventDate time.Time) time.Time {
+	d := eventDate.UTC()
+	return time.Date(d.Year(), d.Month(), d.Day(), sendHour, 0, 0, 0, time.Local)
+}
+
 // runs at set intervals for sending notifications on event dates
 func StartDailyNotifications() {
 	// Gather events
 	events, err := models.GetEventsForToday()
 	if err != nil {
-		log.Fatalf("scheduler could not retreive events: %v", err)
+		log.Printf("scheduler could not retreive events: %v", err)
 		return
 	}
 
@@ -29,9 +38,11 @@ func StartDailyNotifications() {
 			slog.Info(fmt.Sprintf("Skipping event %s - email already sent", event.Name))
 			continue
 		}
+		// A failure is kept in the event's delivery history, where the
+		// coordinator can see it and deliver from the review page
 		err := sendEventNotification(&event)
 		if err != nil {
-			log.Fatalf("Could not sent notification for event: %s to %s: %v", event.Name, event.RecipientEmail, err)
+			log.Printf("Could not send notification for event %s: %v", event.Name, err)
 			continue
 		}
 	}
@@ -39,6 +50,7 @@ func StartDailyNotifications() {
 }
 
 func StartScheduler(hourToRun int) {
+	sendHour = hourToRun
 	slog.Info(fmt.Sprintf("Scheduler started - will run daily at %d:00", hourToRun))
 
 	// Run immediately at startup
@@ -0,0 +1,80 @@
+package scheduler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"event-messenger.com/internal/testutil"
+	"event-messenger.com/models"
+)
+
+// eventWithMessage saves an event dated today, with one approved message,
+// delivered over a chat webhook at url
+func eventWithMessage(t *testing.T, slug, url string) *models.Event {
+	t.Helper()
+	event := models.NewEvent(slug, slug, time.Now(), models.WithNotifyChannel(models.NotifySlack, url))
+	if err := event.SaveEvent(); err != nil {
+		t.Fatal(err)
+	}
+	submission := &models.Submission{EventID: event.ID, Name: "Riley", Message: "Congratulations"}
+	if err := submission.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+// webhookServer answers chat webhook posts with status
+func webhookServer(t *testing.T, status int) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestFailedNotificationsDontStopTheRest(t *testing.T) {
+	testutil.Setup(t)
+
+	rejected := eventWithMessage(t, "rejected", webhookServer(t, http.StatusInternalServerError))
+	unconfigured := eventWithMessage(t, "unconfigured", "")
+	delivered := eventWithMessage(t, "delivered", webhookServer(t, http.StatusOK))
+
+	StartDailyNotifications()
+
+	for _, tt := range []struct {
+		event      *models.Event
+		wantSent   bool
+		wantStatus string
+	}{
+		{rejected, false, models.DeliveryFailed},
+		{unconfigured, false, models.DeliveryFailed},
+		{delivered, true, models.DeliverySucceeded},
+	} {
+		event, err := models.GetEventBySlugIncludingArchived(tt.event.Slug)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.EmailSent != tt.wantSent {
+			t.Errorf("%s: EmailSent = %v, want %v", event.Slug, event.EmailSent, tt.wantSent)
+		}
+
+		deliveries, err := models.GetDeliveries(event.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) != 1 {
+			t.Errorf("%s: %d deliveries recorded, want 1", event.Slug, len(deliveries))
+			continue
+		}
+		d := deliveries[0]
+		if d.Status != tt.wantStatus || d.Trigger != models.DeliveryScheduled {
+			t.Errorf("%s: delivery %s by %s, want %s by %s", event.Slug, d.Status, d.Trigger, tt.wantStatus, models.DeliveryScheduled)
+		}
+		if d.Status == models.DeliveryFailed && d.Error == "" {
+			t.Errorf("%s: failed delivery has no error", event.Slug)
+		}
+	}
+}
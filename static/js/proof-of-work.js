@@ -0,0 +1,135 @@
+// Solve the form's anti-spam challenge in the background: find a nonce so
+// that SHA-256(challenge + ":" + nonce) starts with the required number of
+// zero bits (antispam/challenge.go checks it the same way). SHA-256 is
+// implemented here because crypto.subtle isn't available over plain http.
+(function () {
+  const form = document.querySelector("form");
+  const tokenField = form.querySelector("input[name=challenge]");
+  const nonceField = form.querySelector("input[name=pow_nonce]");
+  if (!tokenField || !nonceField) {
+    return;
+  }
+
+  const token = tokenField.value;
+  const difficulty = Number(tokenField.dataset.difficulty);
+  const BATCH = 5000;
+
+  const K = [
+    0x428a2f98, 0x71374491, 0xb5c0fbcf, 0xe9b5dba5, 0x3956c25b, 0x59f111f1,
+    0x923f82a4, 0xab1c5ed5, 0xd807aa98, 0x12835b01, 0x243185be, 0x550c7dc3,
+    0x72be5d74, 0x80deb1fe, 0x9bdc06a7, 0xc19bf174, 0xe49b69c1, 0xefbe4786,
+    0x0fc19dc6, 0x240ca1cc, 0x2de92c6f, 0x4a7484aa, 0x5cb0a9dc, 0x76f988da,
+    0x983e5152, 0xa831c66d, 0xb00327c8, 0xbf597fc7, 0xc6e00bf3, 0xd5a79147,
+    0x06ca6351, 0x14292967, 0x27b70a85, 0x2e1b2138, 0x4d2c6dfc, 0x53380d13,
+    0x650a7354, 0x766a0abb, 0x81c2c92e, 0x92722c85, 0xa2bfe8a1, 0xa81a664b,
+    0xc24b8b70, 0xc76c51a3, 0xd192e819, 0xd6990624, 0xf40e3585, 0x106aa070,
+    0x19a4c116, 0x1e376c08, 0x2748774c, 0x34b0bcb5, 0x391c0cb3, 0x4ed8aa4a,
+    0x5b9cca4f, 0x682e6ff3, 0x748f82ee, 0x78a5636f, 0x84c87814, 0x8cc70208,
+    0x90befffa, 0xa4506ceb, 0xbef9a3f7, 0xc67178f2,
+  ];
+  const w = new Int32Array(64);
+
+  const rotr = (x, n) => (x >>> n) | (x << (32 - n));
+
+  // SHA-256 of an ASCII string, as eight 32-bit words
+  function sha256(text) {
+    const length = text.length;
+    const blocks = (((length + 8) >> 6) + 1) * 16;
+    const words = new Int32Array(blocks);
+    for (let i = 0; i < length; i++) {
+      words[i >> 2] |= text.charCodeAt(i) << (24 - (i % 4) * 8);
+    }
+    words[length >> 2] |= 0x80 << (24 - (length % 4) * 8);
+    words[blocks - 1] = length * 8;
+
+    const h = [
+      0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a, 0x510e527f, 0x9b05688c,
+      0x1f83d9ab, 0x5be0cd19,
+    ];
+    for (let block = 0; block < blocks; block += 16) {
+      for (let t = 0; t < 16; t++) {
+        w[t] = words[block + t];
+      }
+      for (let t = 16; t < 64; t++) {
+        const s0 = rotr(w[t - 15], 7) ^ rotr(w[t - 15], 18) ^ (w[t - 15] >>> 3);
+        const s1 = rotr(w[t - 2], 17) ^ rotr(w[t - 2], 19) ^ (w[t - 2] >>> 10);
+        w[t] = w[t - 16] + s0 + w[t - 7] + s1;
+      }
+
+      let [a, b, c, d, e, f, g, hh] = h;
+      for (let t = 0; t < 64; t++) {
+        const t1 =
+          (hh + (rotr(e, 6) ^ rotr(e, 11) ^ rotr(e, 25)) +
+            ((e & f) ^ (~e & g)) + K[t] + w[t]) | 0;
+        const t2 =
+          ((rotr(a, 2) ^ rotr(a, 13) ^ rotr(a, 22)) +
+            ((a & b) ^ (a & c) ^ (b & c))) | 0;
+        hh = g;
+        g = f;
+        f = e;
+        e = (d + t1) | 0;
+        d = c;
+        c = b;
+        b = a;
+        a = (t1 + t2) | 0;
+      }
+
+      h[0] = (h[0] + a) | 0;
+      h[1] = (h[1] + b) | 0;
+      h[2] = (h[2] + c) | 0;
+      h[3] = (h[3] + d) | 0;
+      h[4] = (h[4] + e) | 0;
+      h[5] = (h[5] + f) | 0;
+      h[6] = (h[6] + g) | 0;
+      h[7] = (h[7] + hh) | 0;
+    }
+    return h;
+  }
+
+  function leadingZeroBits(hash) {
+    let zeros = 0;
+    for (const word of hash) {
+      zeros += Math.clz32(word);
+      if (word !== 0) {
+        break;
+      }
+    }
+    return zeros;
+  }
+
+  // Search in batches so the page stays responsive while people type
+  const solved = new Promise(function (resolve) {
+    let nonce = 0;
+    function search() {
+      for (const end = nonce + BATCH; nonce < end; nonce++) {
+        if (leadingZeroBits(sha256(token + ":" + nonce)) >= difficulty) {
+          resolve(String(nonce));
+          return;
+        }
+      }
+      setTimeout(search, 0);
+    }
+    search();
+  });
+
+  solved.then(function (nonce) {
+    nonceField.value = nonce;
+  });
+
+  // Hold the submission until the challenge is solved; this runs before the
+  // upload script, which then sees the submit event again
+  form.addEventListener("submit", function (event) {
+    if (nonceField.value) {
+      return;
+    }
+    event.preventDefault();
+    event.stopImmediatePropagation();
+
+    const submitButton = form.querySelector(".submit-btn");
+    submitButton.disabled = true;
+    solved.then(function () {
+      submitButton.disabled = false;
+      form.requestSubmit();
+    });
+  });
+})();
@@ -0,0 +1,149 @@
+// Send the photo and recording ahead of the form as resumable (tus)
+// uploads, so a dropped connection resumes where it stopped instead of
+// starting over. Without JavaScript they're posted with the form as usual.
+const CHUNK_SIZE = 512 * 1024;
+const MAX_RETRIES = 10;
+const TUS_HEADERS = { "Tus-Resumable": "1.0.0" };
+
+const form = document.querySelector("form");
+const submitButton = form.querySelector(".submit-btn");
+const uploadProgress = document.getElementById("uploadProgress");
+const uploadBar = document.getElementById("uploadBar");
+const uploadStatus = document.getElementById("uploadStatus");
+
+// Each file input and the hidden field that carries its upload ID
+const attachments = [
+  {
+    input: document.getElementById("image"),
+    uploadId: document.getElementById("upload_id"),
+    label: "photo",
+  },
+  {
+    input: document.getElementById("media"),
+    uploadId: document.getElementById("media_upload_id"),
+    label: "recording",
+  },
+];
+
+const sleep = (ms) => new Promise((resolve) => setTimeout(resolve, ms));
+
+function encodeMetadata(value) {
+  return btoa(unescape(encodeURIComponent(value)));
+}
+
+async function createUpload(file) {
+  const response = await fetch("/uploads/tus", {
+    method: "POST",
+    headers: {
+      ...TUS_HEADERS,
+      "Upload-Length": String(file.size),
+      "Upload-Metadata":
+        "filename " + encodeMetadata(file.name) +
+        ",filetype " + encodeMetadata(file.type),
+    },
+  });
+  if (response.status !== 201) {
+    throw new Error(await response.text());
+  }
+  return response.headers.get("Location");
+}
+
+// Returns the server's offset, or null if the upload is gone
+async function currentOffset(url) {
+  const response = await fetch(url, { method: "HEAD", headers: TUS_HEADERS });
+  if (!response.ok) {
+    return null;
+  }
+  return Number(response.headers.get("Upload-Offset"));
+}
+
+async function uploadResumable(file) {
+  const key = "tus:" + file.name + ":" + file.size + ":" + file.lastModified;
+  let url = localStorage.getItem(key);
+  let offset = url ? await currentOffset(url).catch(() => null) : null;
+  if (offset === null) {
+    url = await createUpload(file);
+    localStorage.setItem(key, url);
+    offset = 0;
+  }
+
+  let retries = 0;
+  while (offset < file.size) {
+    uploadBar.value = (offset / file.size) * 100;
+    try {
+      const response = await fetch(url, {
+        method: "PATCH",
+        headers: {
+          ...TUS_HEADERS,
+          "Content-Type": "application/offset+octet-stream",
+          "Upload-Offset": String(offset),
+        },
+        body: file.slice(offset, offset + CHUNK_SIZE),
+      });
+      if (response.status === 404 || response.status === 410) {
+        localStorage.removeItem(key);
+        return uploadResumable(file);
+      }
+      if (response.status !== 204) {
+        throw new Error(await response.text());
+      }
+      offset = Number(response.headers.get("Upload-Offset"));
+      retries = 0;
+      uploadStatus.textContent = "";
+    } catch (err) {
+      if (++retries > MAX_RETRIES) {
+        throw err;
+      }
+      uploadStatus.textContent = "Connection lost, retrying\u2026";
+      await sleep(Math.min(1000 * 2 ** retries, 30000));
+      const resumed = await currentOffset(url).catch(() => null);
+      if (resumed !== null) {
+        offset = resumed;
+      }
+    }
+  }
+
+  uploadBar.value = 100;
+  localStorage.removeItem(key);
+  return url.split("/").pop();
+}
+
+// Coming back to the page (e.g. with the back button) starts fresh
+window.addEventListener("pageshow", function () {
+  for (const attachment of attachments) {
+    attachment.input.disabled = false;
+    attachment.uploadId.value = "";
+  }
+  submitButton.disabled = false;
+});
+
+form.addEventListener("submit", async function (event) {
+  const pending = attachments.filter(
+    (a) => a.input.files[0] && !a.uploadId.value,
+  );
+  if (pending.length === 0 || !window.fetch) {
+    return;
+  }
+  event.preventDefault();
+
+  submitButton.disabled = true;
+  uploadProgress.style.display = "block";
+  uploadProgress.classList.remove("error");
+
+  try {
+    for (const attachment of pending) {
+      uploadBar.value = 0;
+      uploadStatus.textContent = "Uploading " + attachment.label + "\u2026";
+      attachment.uploadId.value = await uploadResumable(
+        attachment.input.files[0],
+      );
+      // The file is already on the server; don't send it twice
+      attachment.input.disabled = true;
+    }
+    form.submit();
+  } catch (err) {
+    uploadProgress.classList.add("error");
+    uploadStatus.textContent = "Upload failed, please try again.";
+    submitButton.disabled = false;
+  }
+});
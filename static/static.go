@@ -0,0 +1,9 @@
+// Package static embeds the scripts, styles and images served under /static/.
+package static
+
+import "embed"
+
+// FS holds the static assets
+//
+//go:embed js
+var FS embed.FS
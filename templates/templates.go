@@ -0,0 +1,10 @@
+// Package templates embeds the HTML page and email templates into the binary.
+package templates
+
+import "embed"
+
+// FS holds the page templates, their shared layouts and partials, and the
+// notification email
+//
+//go:embed *.html layouts/*.html partials/*.html
+var FS embed.FS
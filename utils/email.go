@@ -2,37 +2,28 @@ package utils
 
 import (
 	"fmt"
-	"net/smtp"
+	"log/slog"
+	"net/mail"
 
-	"event-messenger.com/config"
+	"event-messenger.com/mailer"
 )
 
 func SendEmailNotification(toEmail, subject, htmlContent string) error {
-	auth := smtp.PlainAuth("", config.App.SMTPUsername, config.App.SMTPPassword, config.App.SMTPServer)
-
-	// Compose email headers and body
-	headers := make(map[string]string)
-	headers["From"] = config.App.FromEmail
-	headers["To"] = toEmail
-	headers["Subject"] = subject
-	headers["MIME-Version"] = "1.0"
-	headers["Content-Type"] = "text/html; charset=\"utf-8\""
-
-	// Build message
-	message := ""
-	for k, v := range headers {
-		message += fmt.Sprintf("%s: %s\r\n", k, v)
-	}
-	message += "\r\n" + htmlContent
-
-	// Send email
-	addr := fmt.Sprintf("%s:%d", config.App.SMTPServer, config.App.SMTPPort)
-	err := smtp.SendMail(addr, auth, config.App.FromEmail, []string{toEmail}, []byte(message))
+	msg := mailer.NewMessage(subject)
+	msg.To = []mail.Address{{Address: toEmail}}
+	msg.HTML = htmlContent
+	return SendEmail(toEmail, msg)
+}
 
+// SendEmail sends one copy of msg to rcpt. The To and Cc headers are left as
+// they are, so sending the same message to each address in turn, Bcc ones
+// included, lets every delivery succeed or fail on its own.
+func SendEmail(rcpt string, msg *mailer.Message) error {
+	err := mailer.Send(msg.From.Address, []string{rcpt}, msg.Bytes())
 	if err != nil {
 		return fmt.Errorf("failed to send email: %w", err)
 	}
 
-	fmt.Printf("Email sent successfully to: %s\n", toEmail)
+	slog.Debug("Email sent", "to", rcpt)
 	return nil
 }
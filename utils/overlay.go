@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+)
+
+// overlayFS serves files from an override directory when present, falling
+// back to the embedded base
+type overlayFS struct {
+	override fs.FS
+	base     fs.FS
+}
+
+// OverlayFS layers dir over base, so a custom theme only needs the files it
+// changes. An empty dir returns base unchanged.
+func OverlayFS(dir string, base fs.FS) fs.FS {
+	if dir == "" {
+		return base
+	}
+	return overlayFS{override: os.DirFS(dir), base: base}
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if info, err := fs.Stat(o.override, name); err == nil && !info.IsDir() {
+		return o.override.Open(name)
+	}
+
+	f, err := o.base.Open(name)
+	if err != nil {
+		// A directory that only exists in the override
+		if info, statErr := fs.Stat(o.override, name); statErr == nil && info.IsDir() {
+			return o.override.Open(name)
+		}
+	}
+	return f, err
+}
+
+// ReadDir merges both listings, with override entries replacing base ones
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries := map[string]fs.DirEntry{}
+
+	baseEntries, baseErr := fs.ReadDir(o.base, name)
+	for _, e := range baseEntries {
+		entries[e.Name()] = e
+	}
+	overrideEntries, overrideErr := fs.ReadDir(o.override, name)
+	for _, e := range overrideEntries {
+		entries[e.Name()] = e
+	}
+
+	if baseErr != nil && overrideErr != nil {
+		if errors.Is(baseErr, fs.ErrNotExist) {
+			return nil, overrideErr
+		}
+		return nil, baseErr
+	}
+
+	merged := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		merged = append(merged, e)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name() < merged[j].Name() })
+	return merged, nil
+}
@@ -36,7 +36,7 @@ func GenerateSlug(input string) string {
 	baseSlug := slug
 	counter := 2
 	for {
-		_, err := models.GetEventBySlug(slug)
+		_, err := models.GetEventBySlugIncludingArchived(slug)
 		if err != nil {
 			break
 		}
@@ -3,6 +3,8 @@ package utils
 import (
 	"net/http"
 	"os"
+
+	"event-messenger.com/config"
 )
 
 // GetBaseURL returns the base URL from env or request
@@ -22,5 +24,22 @@ func GetBaseURL(r *http.Request) string {
 
 // GetEventURL generates the full URL for an event slug
 func GetEventURL(slug string, r *http.Request) string {
-	return GetBaseURL(r) + "/event/" + slug + "/messages"
+	return GetBaseURL(r) + "/events/" + slug + "/messages"
+}
+
+// GetKeepsakeURL returns the event's keepsake page, for links sent outside a
+// request (e.g. from the notification scheduler)
+func GetKeepsakeURL(slug string) string {
+	return config.App.BaseURL + "/events/" + slug + "/messages"
+}
+
+// GetInviteURL returns an invitee's personal link to the event's submission
+// form, which lets the coordinator see that they've sent a message
+func GetInviteURL(slug, token string) string {
+	return config.App.BaseURL + "/events/" + slug + "?invite=" + token
+}
+
+// GetUnsubscribeURL returns the link that stops an invitee's emails
+func GetUnsubscribeURL(token string) string {
+	return config.App.BaseURL + "/unsubscribe/" + token
 }
@@ -0,0 +1,7 @@
+package webhooks
+
+// Unexported names the package's external tests need
+var DeliverDue = deliverDue
+
+// MaxAttempts is how many times a delivery is tried before it's given up on
+var MaxAttempts = len(retryDelays) + 1
@@ -0,0 +1,194 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"event-messenger.com/models"
+)
+
+// Headers sent with every payload
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// retryDelays are the waits after each failed attempt; a delivery that
+// fails once more after the last of them is given up on
+var retryDelays = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	30 * time.Minute,
+	2 * time.Hour,
+	6 * time.Hour,
+}
+
+const (
+	requestTimeout = 10 * time.Second
+	pollInterval   = 15 * time.Second
+	batchSize      = 20
+	keepLog        = 30 * 24 * time.Hour // finished deliveries are pruned after this
+)
+
+// Metrics published on /debug/vars
+var attempts = expvar.NewMap("webhook_attempts")
+
+var (
+	client  = &http.Client{Timeout: requestTimeout}
+	wakeups = make(chan struct{}, 1)
+)
+
+// wake tells the sender there's something new to deliver
+func wake() {
+	select {
+	case wakeups <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the sender, which delivers queued payloads as they're
+// published and retries failed ones when they're due. Deliveries left
+// pending by a previous run are picked up straight away.
+func Start() {
+	go func() {
+		pruned := time.Time{}
+		for {
+			deliverDue()
+
+			if time.Since(pruned) > 24*time.Hour {
+				prune()
+				pruned = time.Now()
+			}
+
+			select {
+			case <-wakeups:
+			case <-time.After(pollInterval):
+			}
+		}
+	}()
+	slog.Debug("Webhook sender started")
+}
+
+func deliverDue() {
+	for {
+		deliveries, err := models.GetDueWebhookDeliveries(batchSize)
+		if err != nil {
+			log.Printf("Could not load webhook deliveries: %v", err)
+			return
+		}
+		for i := range deliveries {
+			deliver(&deliveries[i])
+		}
+		if len(deliveries) < batchSize {
+			return
+		}
+	}
+}
+
+func prune() {
+	n, err := models.DeleteWebhookDeliveriesBefore(time.Now().Add(-keepLog))
+	if err != nil {
+		log.Printf("Could not prune webhook deliveries: %v", err)
+		return
+	}
+	if n > 0 {
+		slog.Debug("Pruned webhook deliveries", "count", n)
+	}
+}
+
+// deliver makes one attempt at a delivery and records how it went
+func deliver(d *models.WebhookDelivery) {
+	webhook, err := models.GetWebhook(d.WebhookID)
+	if err != nil || !webhook.Active {
+		record(d, models.WebhookFailed, 0, "webhook was removed or turned off")
+		return
+	}
+
+	status, err := send(webhook, d)
+	if err == nil {
+		record(d, models.WebhookSucceeded, status, "")
+		return
+	}
+
+	if d.Attempts >= len(retryDelays) {
+		log.Printf("Giving up on webhook delivery %d to %s after %d attempts: %v", d.ID, webhook.URL, d.Attempts+1, err)
+		record(d, models.WebhookFailed, status, err.Error())
+		return
+	}
+	record(d, models.WebhookPending, status, err.Error())
+}
+
+func record(d *models.WebhookDelivery, status string, responseStatus int, errMsg string) {
+	next := time.Now()
+	if status == models.WebhookPending {
+		next = next.Add(retryDelays[d.Attempts])
+		attempts.Add("retried", 1)
+	} else {
+		attempts.Add(status, 1)
+	}
+
+	if err := d.RecordAttempt(status, responseStatus, errMsg, next); err != nil {
+		log.Printf("Could not record webhook delivery %d: %v", d.ID, err)
+	}
+}
+
+// send posts the payload, returning the response status. Anything but a
+// 2xx is an error.
+func send(webhook *models.Webhook, d *models.WebhookDelivery) (int, error) {
+	body := []byte(d.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "EventMessenger-Webhooks/1")
+	req.Header.Set(HeaderEvent, d.EventType)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(d.ID))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return resp.StatusCode, nil
+	}
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+	msg := resp.Status
+	if s := strings.TrimSpace(string(snippet)); s != "" {
+		msg += ": " + s
+	}
+	return resp.StatusCode, fmt.Errorf("%s", msg)
+}
+
+// Sign returns the signature header for a payload: "sha256=" and the hex
+// HMAC-SHA256, keyed with the webhook's secret, of the timestamp header, a
+// dot and the body. Receivers should recompute it, compare in constant time
+// and reject old timestamps.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
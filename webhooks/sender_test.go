@@ -0,0 +1,265 @@
+package webhooks_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"event-messenger.com/db"
+	"event-messenger.com/internal/testutil"
+	"event-messenger.com/models"
+	"event-messenger.com/webhooks"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		secret, timestamp, body string
+		want                    string
+	}{
+		{"whsec_test", "1700000000", `{"id":"evt_1","type":"event.created"}`,
+			"sha256=0739b7339a07945b9fc6b7abe9036bb8ef129295758484ebc8bb52e9af82a680"},
+		{"", "0", "", "sha256=b849d5a581847b281957065739df36df2463d1977ea8d6e1e4e6cf33fadc68c3"},
+	}
+	for _, tt := range tests {
+		if got := webhooks.Sign(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+			t.Errorf("Sign(%q, %q, %q) = %s, want %s", tt.secret, tt.timestamp, tt.body, got, tt.want)
+		}
+	}
+}
+
+// receiver is a webhook endpoint that answers with each of statuses in turn,
+// then with the last of them
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   []string
+}
+
+func newReceiver(t *testing.T, statuses ...int) (*receiver, string) {
+	t.Helper()
+	rcv := &receiver{statuses: statuses}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rcv.mu.Lock()
+		defer rcv.mu.Unlock()
+		rcv.requests = append(rcv.requests, r)
+		rcv.bodies = append(rcv.bodies, string(body))
+		status := rcv.statuses[min(len(rcv.requests), len(rcv.statuses))-1]
+		w.WriteHeader(status)
+		if status >= 300 {
+			io.WriteString(w, "try again later")
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return rcv, srv.URL
+}
+
+func (rcv *receiver) count() int {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return len(rcv.requests)
+}
+
+// publishCreated queues event.created for a new event, returning the
+// delivery queued for webhook
+func publishCreated(t *testing.T, webhook *models.Webhook) *models.WebhookDelivery {
+	t.Helper()
+	event := models.NewEvent("Farewell", "farewell", time.Now().AddDate(0, 0, 7),
+		models.WithRecipient("Alex", "alex@example.com"))
+	if err := event.SaveEvent(); err != nil {
+		t.Fatal(err)
+	}
+	webhooks.PublishEvent(webhooks.EventCreated, event)
+
+	deliveries, err := models.GetRecentWebhookDeliveries(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range deliveries {
+		if d.WebhookID == webhook.ID {
+			return &d
+		}
+	}
+	t.Fatalf("nothing queued for webhook %d", webhook.ID)
+	return nil
+}
+
+// makeDue brings a delivery's next attempt forward to now
+func makeDue(t *testing.T, id int) {
+	t.Helper()
+	_, err := db.DB.Exec(`UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id = ?`, time.Now().UTC().Add(-time.Second), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getDelivery(t *testing.T, id int) *models.WebhookDelivery {
+	t.Helper()
+	d, err := models.GetWebhookDelivery(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDeliveryRetriesUntilAccepted(t *testing.T) {
+	testutil.Setup(t)
+	rcv, url := newReceiver(t, http.StatusInternalServerError, http.StatusOK)
+	webhook, err := models.NewWebhook(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := publishCreated(t, webhook)
+
+	// The first attempt fails and is put off
+	webhooks.DeliverDue()
+	d := getDelivery(t, queued.ID)
+	if d.Status != models.WebhookPending || d.Attempts != 1 || d.ResponseStatus != 500 || !strings.Contains(d.Error, "try again later") {
+		t.Fatalf("after a 500: %s, %d attempts, response %d, error %q", d.Status, d.Attempts, d.ResponseStatus, d.Error)
+	}
+	if wait := time.Until(d.NextAttemptAt); wait < 50*time.Second || wait > time.Minute {
+		t.Errorf("retried in %v, want a minute", wait)
+	}
+	webhooks.DeliverDue()
+	if n := rcv.count(); n != 1 {
+		t.Fatalf("%d requests before the retry was due, want 1", n)
+	}
+
+	// then goes through once it's due
+	makeDue(t, d.ID)
+	webhooks.DeliverDue()
+	d = getDelivery(t, queued.ID)
+	if d.Status != models.WebhookSucceeded || d.Attempts != 2 || d.ResponseStatus != 200 || d.Error != "" || !d.CompletedAt.Valid {
+		t.Errorf("after a 200: %s, %d attempts, response %d, error %q", d.Status, d.Attempts, d.ResponseStatus, d.Error)
+	}
+	if n := rcv.count(); n != 2 {
+		t.Errorf("%d requests, want 2", n)
+	}
+
+	// Every attempt is signed over its own timestamp
+	for i, r := range rcv.requests {
+		body := rcv.bodies[i]
+		if body != d.Payload {
+			t.Errorf("request %d: body %s, want %s", i, body, d.Payload)
+		}
+		if got, want := r.Header.Get(webhooks.HeaderSignature), webhooks.Sign(webhook.Secret, r.Header.Get(webhooks.HeaderTimestamp), []byte(body)); got != want {
+			t.Errorf("request %d: signature %s, want %s", i, got, want)
+		}
+		if r.Header.Get(webhooks.HeaderEvent) != webhooks.EventCreated || r.Header.Get(webhooks.HeaderDelivery) != strconv.Itoa(d.ID) {
+			t.Errorf("request %d: headers %v", i, r.Header)
+		}
+	}
+
+	// Payloads go to other systems, so they don't give out addresses
+	var payload webhooks.Payload
+	if err := json.Unmarshal([]byte(d.Payload), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Type != webhooks.EventCreated || !strings.HasPrefix(payload.ID, "evt_") {
+		t.Errorf("payload %+v", payload)
+	}
+	if strings.Contains(d.Payload, "alex@example.com") || !strings.Contains(d.Payload, `"recipient_name":"Alex"`) {
+		t.Errorf("payload %s", d.Payload)
+	}
+}
+
+func TestDeliveryGivesUp(t *testing.T) {
+	testutil.Setup(t)
+	rcv, url := newReceiver(t, http.StatusServiceUnavailable)
+	webhook, err := models.NewWebhook(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := publishCreated(t, webhook)
+
+	var d *models.WebhookDelivery
+	for attempt := 1; attempt <= webhooks.MaxAttempts; attempt++ {
+		makeDue(t, queued.ID)
+		webhooks.DeliverDue()
+		d = getDelivery(t, queued.ID)
+		if d.Attempts != attempt {
+			t.Fatalf("attempt %d recorded as %d", attempt, d.Attempts)
+		}
+		if attempt < webhooks.MaxAttempts && (d.Status != models.WebhookPending || d.CompletedAt.Valid) {
+			t.Fatalf("attempt %d: %s, want pending", attempt, d.Status)
+		}
+	}
+	if d.Status != models.WebhookFailed || d.ResponseStatus != 503 || d.Error == "" || !d.CompletedAt.Valid {
+		t.Errorf("after %d attempts: %s, response %d, error %q", d.Attempts, d.Status, d.ResponseStatus, d.Error)
+	}
+
+	// Failed deliveries aren't tried again
+	makeDue(t, queued.ID)
+	webhooks.DeliverDue()
+	if n := rcv.count(); n != webhooks.MaxAttempts {
+		t.Errorf("%d requests, want %d", n, webhooks.MaxAttempts)
+	}
+}
+
+func TestDeliveryToTurnedOffWebhookFails(t *testing.T) {
+	testutil.Setup(t)
+	rcv, url := newReceiver(t, http.StatusOK)
+	webhook, err := models.NewWebhook(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := publishCreated(t, webhook)
+	if err := webhook.SetActive(false); err != nil {
+		t.Fatal(err)
+	}
+
+	webhooks.DeliverDue()
+	if d := getDelivery(t, queued.ID); d.Status != models.WebhookFailed || d.ResponseStatus != 0 {
+		t.Errorf("delivery to a turned off webhook: %s, response %d", d.Status, d.ResponseStatus)
+	}
+	if n := rcv.count(); n != 0 {
+		t.Errorf("turned off webhook was sent %d requests", n)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	testutil.Setup(t)
+	rcv, url := newReceiver(t, http.StatusInternalServerError, http.StatusOK)
+	webhook, err := models.NewWebhook(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := publishCreated(t, webhook)
+	webhooks.DeliverDue()
+
+	// A replay is a new delivery of the same payload, sent straight away
+	// whatever became of the original
+	replay, err := webhooks.Replay(original.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replay.ID == original.ID || replay.ReplayOf != original.ID || replay.Payload != original.Payload || replay.Status != models.WebhookPending {
+		t.Fatalf("replay %+v of %d", replay, original.ID)
+	}
+	webhooks.DeliverDue()
+
+	if d := getDelivery(t, replay.ID); d.Status != models.WebhookSucceeded || d.Attempts != 1 {
+		t.Errorf("replay: %s after %d attempts", d.Status, d.Attempts)
+	}
+	if d := getDelivery(t, original.ID); d.Status != models.WebhookPending || d.Attempts != 1 {
+		t.Errorf("original changed by the replay: %s after %d attempts", d.Status, d.Attempts)
+	}
+	if n := rcv.count(); n != 2 || rcv.bodies[0] != rcv.bodies[1] {
+		t.Errorf("%d requests, want the same payload twice", n)
+	}
+
+	// Nothing to replay once the webhook is gone
+	if err := webhook.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := webhooks.Replay(original.ID); err == nil {
+		t.Error("replayed a delivery of a deleted webhook")
+	}
+}
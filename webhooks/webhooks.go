@@ -0,0 +1,180 @@
+// Package webhooks tells other systems about changes to events. Each
+// webhook is sent a JSON payload, signed with its secret, when an event is
+// created, receives a submission, is delivered or is purged. Payloads are
+// queued in the database and retried with backoff until the endpoint
+// accepts them.
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"event-messenger.com/config"
+	"event-messenger.com/models"
+)
+
+// Event types a webhook can subscribe to
+const (
+	EventCreated      = "event.created"
+	SubmissionCreated = "submission.created"
+	EventDelivered    = "event.delivered"
+	EventPurged       = "event.purged"
+)
+
+// EventTypes lists every event type, in the order they happen
+var EventTypes = []string{EventCreated, SubmissionCreated, EventDelivered, EventPurged}
+
+// ValidEventType reports whether t is one of EventTypes
+func ValidEventType(t string) bool {
+	for _, e := range EventTypes {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Payload is the JSON body sent to a webhook
+type Payload struct {
+	ID        string    `json:"id"` // the same for every webhook and replay, for spotting duplicates
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// EventData describes an event in a payload. Webhooks go to other systems,
+// so recipients' addresses are left out.
+type EventData struct {
+	ID            int    `json:"id"`
+	Slug          string `json:"slug"`
+	Name          string `json:"name"`
+	EventDate     string `json:"event_date"`
+	RecipientName string `json:"recipient_name"`
+	Coordinator   string `json:"coordinator"`
+	Moderated     bool   `json:"moderated"`
+	SubmissionURL string `json:"submission_url"`
+	KeepsakeURL   string `json:"keepsake_url"`
+}
+
+// SubmissionData describes a submission in a payload
+type SubmissionData struct {
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`
+	Message      string    `json:"message"`
+	Moderation   string    `json:"moderation"`
+	HasImage     bool      `json:"has_image"`
+	HasRecording bool      `json:"has_recording"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func newEventData(event *models.Event) EventData {
+	return EventData{
+		ID:            event.ID,
+		Slug:          event.Slug,
+		Name:          event.Name,
+		EventDate:     event.EventDate.UTC().Format("2006-01-02"),
+		RecipientName: event.RecipientName,
+		Coordinator:   event.Coordinator,
+		Moderated:     event.Moderated,
+		SubmissionURL: config.App.BaseURL + "/events/" + event.Slug,
+		KeepsakeURL:   config.App.BaseURL + "/events/" + event.Slug + "/messages",
+	}
+}
+
+// PublishEvent queues eventType, one of the event.* types, for event
+func PublishEvent(eventType string, event *models.Event) {
+	publish(eventType, struct {
+		Event EventData `json:"event"`
+	}{newEventData(event)})
+}
+
+// PublishSubmission queues submission.created for a new submission
+func PublishSubmission(event *models.Event, submission *models.Submission) {
+	publish(SubmissionCreated, struct {
+		Event      EventData      `json:"event"`
+		Submission SubmissionData `json:"submission"`
+	}{
+		Event: newEventData(event),
+		Submission: SubmissionData{
+			ID:           submission.ID,
+			Name:         submission.Name,
+			Message:      submission.Message,
+			Moderation:   submission.Moderation,
+			HasImage:     submission.Filename != "" || submission.OriginalFilename != "",
+			HasRecording: submission.MediaFilename != "",
+			CreatedAt:    submission.CreatedAt,
+		},
+	})
+}
+
+// publish queues a payload for every active webhook subscribed to
+// eventType. Failures are only logged; they never hold up the change that
+// caused them.
+func publish(eventType string, data any) {
+	webhooks, err := models.GetWebhooks()
+	if err != nil {
+		log.Printf("Could not load webhooks for %s: %v", eventType, err)
+		return
+	}
+
+	var body []byte
+	queued := 0
+	for _, w := range webhooks {
+		if !w.Active || !w.Subscribes(eventType) {
+			continue
+		}
+
+		if body == nil {
+			body, err = json.Marshal(Payload{ID: newPayloadID(), Type: eventType, CreatedAt: time.Now().UTC(), Data: data})
+			if err != nil {
+				log.Printf("Could not encode %s webhook payload: %v", eventType, err)
+				return
+			}
+		}
+
+		d := &models.WebhookDelivery{WebhookID: w.ID, EventType: eventType, Payload: string(body)}
+		if err := d.Save(); err != nil {
+			log.Printf("Could not queue %s for webhook %d: %v", eventType, w.ID, err)
+			continue
+		}
+		queued++
+	}
+
+	if queued > 0 {
+		wake()
+	}
+}
+
+// Replay queues a delivery's payload to be sent to its webhook again
+func Replay(deliveryID int) (*models.WebhookDelivery, error) {
+	original, err := models.GetWebhookDelivery(deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := models.GetWebhook(original.WebhookID); err != nil {
+		return nil, fmt.Errorf("webhook %d no longer exists", original.WebhookID)
+	}
+
+	d := &models.WebhookDelivery{
+		WebhookID: original.WebhookID,
+		EventType: original.EventType,
+		Payload:   original.Payload,
+		ReplayOf:  original.ID,
+	}
+	if err := d.Save(); err != nil {
+		return nil, err
+	}
+
+	wake()
+	return d, nil
+}
+
+func newPayloadID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "evt_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,176 @@
+package wordfilter
+
+import (
+	"strings"
+	"unicode"
+)
+
+// ignored marks runes that are dropped without splitting a word, such as
+// zero-width spaces slipped between letters
+const ignored = -1
+
+// leet maps digits and symbols people substitute for letters
+var leet = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '9': 'g',
+	'@': 'a', '$': 's', '!': 'i', '|': 'i', '+': 't', '€': 'e',
+}
+
+// leetSymbols only stand for a letter inside a word; "wow!" ends in punctuation
+var leetSymbols = "@$!|+€"
+
+// confusables maps lookalike letters from other scripts, and accented Latin
+// letters, to the plain Latin letter they pass for
+var confusables = map[rune]rune{}
+
+func init() {
+	for from, to := range map[string]rune{
+		// Cyrillic
+		"а": 'a', "в": 'b', "е": 'e', "ё": 'e', "һ": 'h', "і": 'i', "ї": 'i', "ј": 'j',
+		"к": 'k', "м": 'm', "н": 'h', "о": 'o', "р": 'p', "с": 'c', "ѕ": 's', "т": 't',
+		"у": 'y', "х": 'x', "ԁ": 'd', "ԛ": 'q', "ԝ": 'w', "ѡ": 'w', "ү": 'y',
+		// Greek
+		"α": 'a', "β": 'b', "γ": 'y', "ε": 'e', "η": 'n', "ι": 'i', "κ": 'k', "ν": 'v',
+		"ο": 'o', "ρ": 'p', "τ": 't', "υ": 'u', "χ": 'x', "ω": 'w',
+		// Accented Latin
+		"àáâãäåāăą": 'a', "çćĉċč": 'c', "ďđ": 'd', "èéêëēĕėęě": 'e', "ĝğġģ": 'g',
+		"ĥħ": 'h', "ìíîïĩīĭįı": 'i', "ĵ": 'j', "ķ": 'k', "ĺļľŀł": 'l', "ñńņňŉ": 'n',
+		"òóôõöøōŏő": 'o', "ŕŗř": 'r', "śŝşšș": 's', "ţťŧț": 't', "ùúûüũūŭůűų": 'u',
+		"ŵ": 'w', "ýÿŷ": 'y', "źżž": 'z', "ß": 's',
+		// Other lookalikes
+		"ɡ": 'g', "ɩ": 'i', "ℓ": 'l', "ո": 'n', "ս": 'u',
+	} {
+		for _, r := range from {
+			confusables[r] = to
+		}
+	}
+}
+
+// fold reduces a rune to the lowercase ASCII letter or digit it looks like,
+// ' ' for anything that separates words, or ignored
+func fold(r rune) rune {
+	switch {
+	case r == '\u00ad' || r == '\u034f' || (r >= '\u200b' && r <= '\u200f') || r == '\u2060' || r == '\ufeff':
+		return ignored
+	case unicode.Is(unicode.Mn, r):
+		// Combining accents stacked on a letter
+		return ignored
+	case r >= '\uff01' && r <= '\uff5e':
+		// Fullwidth ASCII
+		r -= 0xfee0
+	case r >= 0x1d400 && r <= 0x1d6a3:
+		// Mathematical bold, italic, script, ... letters
+		offset := (r - 0x1d400) % 52
+		if offset < 26 {
+			r = 'a' + offset
+		} else {
+			r = 'a' + offset - 26
+		}
+	case r >= 0x1d7ce && r <= 0x1d7ff:
+		r = '0' + (r-0x1d7ce)%10
+	case r >= 'Ⓐ' && r <= 'Ⓩ':
+		r = 'a' + (r - 'Ⓐ')
+	case r >= 'ⓐ' && r <= 'ⓩ':
+		r = 'a' + (r - 'ⓐ')
+	}
+
+	r = unicode.ToLower(r)
+	if c, ok := confusables[r]; ok {
+		return c
+	}
+	if c, ok := leet[r]; ok {
+		return c
+	}
+	if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || unicode.IsLetter(r) {
+		return r
+	}
+	return ' '
+}
+
+// token is one normalized word and where it came from in the original text
+type token struct {
+	word       string
+	start, end int // byte offsets into the original text
+}
+
+// tokenize splits text into normalized words. Runs of single letters are
+// joined, so "f u n" and "f.u.n" read as "fun".
+func tokenize(text string) []token {
+	runes := []rune(text)
+	offsets := make([]int, 0, len(runes)+1)
+	for i := range text {
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(text))
+
+	var tokens []token
+	var word strings.Builder
+	start := -1
+	flush := func(end int) {
+		if word.Len() > 0 {
+			tokens = append(tokens, token{word: word.String(), start: offsets[start], end: offsets[end]})
+		}
+		word.Reset()
+		start = -1
+	}
+
+	for i, r := range runes {
+		folded := fold(r)
+		if strings.ContainsRune(leetSymbols, r) && (i+1 == len(runes) || !isWordRune(runes[i+1])) {
+			folded = ' '
+		}
+
+		switch folded {
+		case ignored:
+			continue
+		case ' ':
+			flush(i)
+		default:
+			if start < 0 {
+				start = i
+			}
+			word.WriteRune(folded)
+		}
+	}
+	flush(len(runes))
+
+	return joinSingleLetters(tokens)
+}
+
+// isWordRune reports whether r folds to a letter or digit other than a leet symbol
+func isWordRune(r rune) bool {
+	f := fold(r)
+	return f != ' ' && f != ignored && !strings.ContainsRune(leetSymbols, r)
+}
+
+func joinSingleLetters(tokens []token) []token {
+	var joined []token
+	for i := 0; i < len(tokens); {
+		j := i
+		for j < len(tokens) && len([]rune(tokens[j].word)) == 1 {
+			j++
+		}
+		if j-i < 2 {
+			joined = append(joined, tokens[i])
+			i++
+			continue
+		}
+
+		var word strings.Builder
+		for _, t := range tokens[i:j] {
+			word.WriteString(t.word)
+		}
+		joined = append(joined, token{word: word.String(), start: tokens[i].start, end: tokens[j-1].end})
+		i = j
+	}
+	return joined
+}
+
+// Normalize returns text as the filter sees it: lowercase words with
+// lookalike letters, accents and number substitutions undone
+func Normalize(text string) string {
+	var words []string
+	for _, t := range tokenize(text) {
+		words = append(words, t.word)
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,44 @@
+package wordfilter
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain", "Hello, World", "hello world"},
+		{"leetspeak digits", "Sh1t h4pp3ns", "shit happens"},
+		{"leetspeak symbols", "$h!t", "shit"},
+		{"symbol ending a word", "wow! 100%", "wow ioo"},
+		{"cyrillic", "ѕһіт", "shit"},
+		{"cyrillic capitals", "ЅНІТ", "shit"},
+		{"greek", "αsshοlε", "asshole"},
+		{"greek eta is an n", "ηο", "no"},
+		{"mixed scripts", "fuсκ", "fuck"},
+		{"accents", "shît", "shit"},
+		{"combining accent", "shi\u0301t", "shit"},
+		{"combining strikethrough", "s\u0336h\u0336i\u0336t\u0336", "shit"},
+		{"zero-width space", "sh\u200bit", "shit"},
+		{"zero-width joiner", "sh\u200di\u200dt", "shit"},
+		{"soft hyphen", "sh\u00adit", "shit"},
+		{"word joiner and bom", "\ufeffsh\u2060it", "shit"},
+		{"fullwidth", "ｓｈｉｔ", "shit"},
+		{"mathematical bold", "𝐬𝐡𝐢𝐭", "shit"},
+		{"circled", "ⓢⓗⓘⓣ", "shit"},
+		{"spaced out", "s h i t", "shit"},
+		{"dotted", "s.h.i.t", "shit"},
+		{"single letters kept apart from words", "a b cd", "ab cd"},
+		{"stretching kept", "shiiiit", "shiiiit"},
+		{"other scripts kept", "日本 مرحبا", "日本 مرحبا"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Normalize(tt.text); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
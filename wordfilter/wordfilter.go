@@ -0,0 +1,173 @@
+// Package wordfilter catches words a coordinator doesn't want in an event's
+// messages. Text is normalized first, so lookalike letters, accents, number
+// substitutions, spaced-out letters and stretched words don't slip past the
+// list.
+package wordfilter
+
+import (
+	_ "embed"
+	"expvar"
+	"strings"
+	"unicode"
+)
+
+// What happens to a submission that contains a listed word
+const (
+	ActionOff    = ""
+	ActionReject = "reject" // refuse the submission and ask for a rephrase
+	ActionFlag   = "flag"   // accept it but hold it for the coordinator's review
+	ActionMask   = "mask"   // accept it with the words replaced by asterisks
+)
+
+// Actions lists the settings a coordinator can choose from
+var Actions = []string{ActionOff, ActionReject, ActionFlag, ActionMask}
+
+// ValidAction reports whether action is one of Actions
+func ValidAction(action string) bool {
+	for _, a := range Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Metrics published on /debug/vars
+var matches = expvar.NewMap("content_filter_matches")
+
+//go:embed common_words.txt
+var commonWords string
+
+var common = parseList(commonWords)
+
+// entry is one listed word or phrase. With prefix set, its last word also
+// matches anything starting with it.
+type entry struct {
+	words  []string
+	prefix bool
+}
+
+// Filter matches text against the common list plus an event's own words
+type Filter struct {
+	entries []entry
+}
+
+// New builds a filter from the common list and a coordinator's list, which
+// has one word or phrase per line or comma
+func New(words string) *Filter {
+	return &Filter{entries: append(append([]entry{}, common...), parseList(words)...)}
+}
+
+func parseList(list string) []entry {
+	var entries []entry
+	for _, line := range strings.FieldsFunc(list, func(r rune) bool { return r == '\n' || r == ',' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		prefix := strings.HasSuffix(line, "*")
+		var words []string
+		for _, t := range tokenize(strings.TrimSuffix(line, "*")) {
+			words = append(words, t.word)
+		}
+		if len(words) > 0 {
+			entries = append(entries, entry{words: words, prefix: prefix})
+		}
+	}
+	return entries
+}
+
+// Match is a listed word found in text, by byte offsets
+type Match struct {
+	Start, End int
+}
+
+// Find returns where listed words appear in text
+func (f *Filter) Find(text string) []Match {
+	tokens := tokenize(text)
+
+	var found []Match
+	for i := 0; i < len(tokens); i++ {
+		for _, e := range f.entries {
+			if n := e.matchAt(tokens[i:]); n > 0 {
+				found = append(found, Match{Start: tokens[i].start, End: tokens[i+n-1].end})
+				i += n - 1
+				break
+			}
+		}
+	}
+	return found
+}
+
+// matchAt returns how many tokens the entry matches at the start of tokens
+func (e entry) matchAt(tokens []token) int {
+	if len(tokens) < len(e.words) {
+		return 0
+	}
+	last := len(e.words) - 1
+	for i, word := range e.words {
+		if !stretched(tokens[i].word, word, i == last && e.prefix) {
+			return 0
+		}
+	}
+	return len(e.words)
+}
+
+// minStretch is how long a run of one letter has to be before it reads as
+// a listed word stretched out, as in "shiiit"; "poop" isn't a stretched "pop"
+const minStretch = 3
+
+// stretched reports whether got is word, possibly with some of its letters
+// repeated at least minStretch times. With prefix set, got only has to start
+// with word.
+func stretched(got, word string, prefix bool) bool {
+	if got == word || (prefix && strings.HasPrefix(got, word)) {
+		return true
+	}
+
+	g, w := []rune(got), []rune(word)
+	i, j := 0, 0
+	for j < len(w) {
+		if i == len(g) || g[i] != w[j] {
+			return false
+		}
+		r := w[j]
+		gn, wn := 0, 0
+		for ; i < len(g) && g[i] == r; i++ {
+			gn++
+		}
+		for ; j < len(w) && w[j] == r; j++ {
+			wn++
+		}
+		lastRun := j == len(w)
+		if gn < wn || (gn > wn && gn < minStretch && !(prefix && lastRun)) {
+			return false
+		}
+	}
+	return i == len(g) || prefix
+}
+
+// Mask replaces the listed words in text with asterisks, keeping spacing
+func Mask(text string, found []Match) string {
+	var b strings.Builder
+	pos := 0
+	for _, m := range found {
+		b.WriteString(text[pos:m.Start])
+		for _, r := range text[m.Start:m.End] {
+			if unicode.IsSpace(r) {
+				b.WriteRune(r)
+			} else {
+				b.WriteByte('*')
+			}
+		}
+		pos = m.End
+	}
+	b.WriteString(text[pos:])
+	return b.String()
+}
+
+// Record counts a match in the content_filter_matches metric
+func Record(action string) {
+	matches.Add(action, 1)
+}
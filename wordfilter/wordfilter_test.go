@@ -0,0 +1,76 @@
+package wordfilter
+
+import "testing"
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		name  string
+		words string // the coordinator's list
+		text  string
+		want  string // text masked
+	}{
+		{"listed word", "", "oh shit", "oh ****"},
+		{"prefix", "", "fucking hell", "******* hell"},
+		{"leetspeak", "", "sh1t happens", "**** happens"},
+		{"confusables", "", "ѕһіт and fuсκ", "**** and ****"},
+		{"zero-width", "", "sh\u200bit", "*****"},
+		{"combining marks", "", "s\u0336h\u0336i\u0336t\u0336", "********"},
+		{"spaced out", "", "what the s h i t", "what the * * * *"},
+		{"stretched", "", "shiiiit", "*******"},
+		{"stretched double letter", "", "asssshole", "*********"},
+		{"stretched prefix", "", "fuuuuucking", "***********"},
+		{"stretched and disguised", "", "$hііі1t", "*******"},
+		{"coordinator's word", "broccoli", "no BR0CC0LI please", "no ******** please"},
+		{"coordinator's phrase", "pop quiz", "a p0p quiz today", "a *** **** today"},
+		{"coordinator's word stretched", "pop", "pooop", "*****"},
+
+		// Clean words that contain, or nearly spell, a listed one
+		{"scunthorpe", "", "Scunthorpe United", "Scunthorpe United"},
+		{"cocktail", "", "cocktails in the cockpit", "cocktails in the cockpit"},
+		{"peacock", "", "a peacock named Hancock", "a peacock named Hancock"},
+		{"assassin", "", "the assassin's class passed", "the assassin's class passed"},
+		{"arsenal", "", "Arsenal and parsec", "Arsenal and parsec"},
+		{"shiitake", "", "shiitake mushrooms", "shiitake mushrooms"},
+		{"bitcoin", "", "bitcoin", "bitcoin"},
+		{"prickly", "", "a prickly pear", "a prickly pear"},
+		{"dickens", "", "Charles Dickens", "Charles Dickens"},
+		{"sussex", "", "Essex and Sussex", "Essex and Sussex"},
+		{"cook", "", "the cook cooks", "the cook cooks"},
+		{"doubled letter isn't stretching", "pop", "poop", "poop"},
+		{"wow", "", "wow!", "wow!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Mask(tt.text, New(tt.words).Find(tt.text)); got != tt.want {
+				t.Errorf("Mask(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStretched(t *testing.T) {
+	tests := []struct {
+		got, word string
+		prefix    bool
+		want      bool
+	}{
+		{"shit", "shit", false, true},
+		{"shiiit", "shit", false, true},
+		{"shiit", "shit", false, false},
+		{"shhhiiittt", "shit", false, true},
+		{"shitty", "shit", false, false},
+		{"shitty", "shit", true, true},
+		{"shiiitty", "shit", true, true},
+		{"asshole", "asshole", false, true},
+		{"ashole", "asshole", false, false},
+		{"asssshole", "asshole", false, true},
+		{"sht", "shit", false, false},
+		{"shi", "shit", true, false},
+		{"", "shit", true, false},
+	}
+	for _, tt := range tests {
+		if got := stretched(tt.got, tt.word, tt.prefix); got != tt.want {
+			t.Errorf("stretched(%q, %q, %v) = %v, want %v", tt.got, tt.word, tt.prefix, got, tt.want)
+		}
+	}
+}